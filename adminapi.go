@@ -0,0 +1,125 @@
+package caddydhcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/lion7/caddydhcp/handlers"
+)
+
+// adminAPI is a module that serves the DHCP endpoints of the admin API.
+type adminAPI struct {
+	app *App
+}
+
+// CaddyModule returns the Caddy module information.
+func (adminAPI) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.dhcp",
+		New: func() caddy.Module { return new(adminAPI) },
+	}
+}
+
+// Provision sets up the adminAPI module.
+func (a *adminAPI) Provision(ctx caddy.Context) error {
+	// the DHCP app is not necessarily configured
+	app, err := ctx.AppIfConfigured("dhcp")
+	if err == nil {
+		a.app = app.(*App)
+	}
+	return nil
+}
+
+// Routes returns the admin routes for the DHCP app.
+func (a *adminAPI) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{
+		{
+			Pattern: "/dhcp/reconfigure",
+			Handler: caddy.AdminHandlerFunc(a.handleReconfigure),
+		},
+		{
+			Pattern: "/dhcp/pools",
+			Handler: caddy.AdminHandlerFunc(a.handlePools),
+		},
+	}
+}
+
+// handlePools writes the utilization of the pools of the range and prefix handlers as JSON.
+func (a *adminAPI) handlePools(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed: %v", r.Method),
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(handlers.Pools())
+}
+
+// reconfigureRequest is the body of a request to the reconfigure endpoint.
+type reconfigureRequest struct {
+	// The name of the server that sends the Reconfigure message.
+	Server string `json:"server"`
+
+	// The hex encoded DUID of the client.
+	ClientID string `json:"clientId"`
+
+	// The transaction to initiate: `renew` (the default), `rebind` or `information-request`.
+	MessageType string `json:"messageType,omitempty"`
+}
+
+// handleReconfigure sends a Reconfigure message to a DHCPv6 client.
+func (a *adminAPI) handleReconfigure(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed: %v", r.Method),
+		}
+	}
+	if a.app == nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("the DHCP app is not configured"),
+		}
+	}
+
+	var body reconfigureRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("decoding request: %v", err),
+		}
+	}
+	var msgType dhcpv6.MessageType
+	switch body.MessageType {
+	case "", "renew":
+		msgType = dhcpv6.MessageTypeRenew
+	case "rebind":
+		msgType = dhcpv6.MessageTypeRebind
+	case "information-request":
+		msgType = dhcpv6.MessageTypeInformationRequest
+	default:
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        fmt.Errorf("invalid message type %q", body.MessageType),
+		}
+	}
+
+	if err := a.app.Reconfigure(body.Server, body.ClientID, msgType); err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        err,
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// Interfaces guards
+var (
+	_ caddy.AdminRouter = (*adminAPI)(nil)
+	_ caddy.Provisioner = (*adminAPI)(nil)
+)
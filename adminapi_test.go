@@ -0,0 +1,54 @@
+package caddydhcp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testPool is a handlers.PoolReporter returning fixed statistics.
+type testPool struct {
+	stats handlers.PoolStats
+}
+
+func (p *testPool) PoolStats() handlers.PoolStats {
+	return p.stats
+}
+
+func TestAdminAPIPools(t *testing.T) {
+	p := &testPool{handlers.PoolStats{Handler: "prefix", Pool: "2001:db8::/48/56", Total: 256, Used: 3, Free: 253, LargestFreeBlock: 250}}
+	handlers.RegisterPool(p)
+	t.Cleanup(func() { handlers.UnregisterPool(p) })
+
+	a := &adminAPI{}
+	routes := make(map[string]caddy.AdminHandler)
+	for _, route := range a.Routes() {
+		routes[route.Pattern] = route.Handler
+	}
+
+	rec := httptest.NewRecorder()
+	require.NoError(t, routes["/dhcp/pools"].ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/dhcp/pools", nil)))
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var pools []map[string]any
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &pools))
+	require.Len(t, pools, 1)
+	assert.Equal(t, map[string]any{
+		"handler":          "prefix",
+		"pool":             "2001:db8::/48/56",
+		"total":            256.0,
+		"used":             3.0,
+		"free":             253.0,
+		"largestFreeBlock": 250.0,
+	}, pools[0])
+
+	var apiErr caddy.APIError
+	err := routes["/dhcp/pools"].ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/dhcp/pools", nil))
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusMethodNotAllowed, apiErr.HTTPStatus)
+}
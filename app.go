@@ -2,58 +2,121 @@ package caddydhcp
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	rangeplugin "github.com/lion7/caddydhcp/handlers/range"
+	"math/rand/v2"
 	"net"
+	"strconv"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/caddyserver/caddy/v2"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 	"golang.org/x/sync/errgroup"
 	"golang.org/x/sys/unix"
 
 	"github.com/lion7/caddydhcp/handlers"
 	"github.com/lion7/caddydhcp/handlers/autoconfigure"
+	"github.com/lion7/caddydhcp/handlers/blackhole"
+	"github.com/lion7/caddydhcp/handlers/chain"
+	"github.com/lion7/caddydhcp/handlers/classifier"
+	"github.com/lion7/caddydhcp/handlers/ddns"
+	"github.com/lion7/caddydhcp/handlers/denyunknown"
 	"github.com/lion7/caddydhcp/handlers/dns"
 	"github.com/lion7/caddydhcp/handlers/example"
 	"github.com/lion7/caddydhcp/handlers/file"
+	"github.com/lion7/caddydhcp/handlers/fixedreply"
+	"github.com/lion7/caddydhcp/handlers/fqdn"
+	"github.com/lion7/caddydhcp/handlers/giaddr"
+	"github.com/lion7/caddydhcp/handlers/hostnamefromdns"
 	"github.com/lion7/caddydhcp/handlers/ipv6only"
+	"github.com/lion7/caddydhcp/handlers/leaseclamp"
 	"github.com/lion7/caddydhcp/handlers/leasetime"
+	"github.com/lion7/caddydhcp/handlers/limitpool"
+	logplugin "github.com/lion7/caddydhcp/handlers/log"
 	"github.com/lion7/caddydhcp/handlers/messagelog"
+	"github.com/lion7/caddydhcp/handlers/mirror"
 	"github.com/lion7/caddydhcp/handlers/mtu"
 	"github.com/lion7/caddydhcp/handlers/nbp"
 	"github.com/lion7/caddydhcp/handlers/netmask"
+	"github.com/lion7/caddydhcp/handlers/nis"
+	"github.com/lion7/caddydhcp/handlers/pxemenu"
+	"github.com/lion7/caddydhcp/handlers/quota"
+	"github.com/lion7/caddydhcp/handlers/replace"
+	"github.com/lion7/caddydhcp/handlers/resolver"
 	"github.com/lion7/caddydhcp/handlers/router"
+	"github.com/lion7/caddydhcp/handlers/script"
 	"github.com/lion7/caddydhcp/handlers/searchdomains"
 	"github.com/lion7/caddydhcp/handlers/serverid"
+	"github.com/lion7/caddydhcp/handlers/site"
 	"github.com/lion7/caddydhcp/handlers/sleep"
+	"github.com/lion7/caddydhcp/handlers/sntp"
+	"github.com/lion7/caddydhcp/handlers/stateless"
+	"github.com/lion7/caddydhcp/handlers/static"
 	"github.com/lion7/caddydhcp/handlers/staticroute"
+	"github.com/lion7/caddydhcp/handlers/timezone"
+	"github.com/lion7/caddydhcp/handlers/when"
 )
 
 func init() {
 	// register this app module
 	caddy.RegisterModule(App{})
+	caddy.RegisterModule(adminAPI{})
 
 	// register handler modules
 	caddy.RegisterModule(autoconfigure.Module{})
+	caddy.RegisterModule(blackhole.Module{})
+	caddy.RegisterModule(chain.Module{})
+	caddy.RegisterModule(classifier.Module{})
+	caddy.RegisterModule(ddns.Module{})
+	caddy.RegisterModule(denyunknown.Module{})
 	caddy.RegisterModule(dns.Module{})
 	caddy.RegisterModule(example.Module{})
 	caddy.RegisterModule(file.Module{})
+	caddy.RegisterModule(file.AdminAPI{})
+	caddy.RegisterModule(fixedreply.Module{})
+	caddy.RegisterModule(fqdn.Module{})
+	caddy.RegisterModule(giaddr.Module{})
+	caddy.RegisterModule(hostnamefromdns.Module{})
 	caddy.RegisterModule(ipv6only.Module{})
+	caddy.RegisterModule(leaseclamp.Module{})
 	caddy.RegisterModule(leasetime.Module{})
+	caddy.RegisterModule(limitpool.Module{})
+	caddy.RegisterModule(logplugin.Module{})
 	caddy.RegisterModule(messagelog.Module{})
+	caddy.RegisterModule(mirror.Module{})
 	caddy.RegisterModule(mtu.Module{})
 	caddy.RegisterModule(nbp.Module{})
 	caddy.RegisterModule(netmask.Module{})
+	caddy.RegisterModule(nis.Module{})
+	caddy.RegisterModule(pxemenu.Module{})
+	caddy.RegisterModule(quota.Module{})
 	caddy.RegisterModule(rangeplugin.Module{})
+	caddy.RegisterModule(rangeplugin.AdminAPI{})
+	caddy.RegisterModule(new(rangeplugin.MemoryStore))
+	caddy.RegisterModule(new(rangeplugin.RedisStore))
+	caddy.RegisterModule(new(rangeplugin.SQLiteStore))
+	caddy.RegisterModule(replace.Module{})
+	caddy.RegisterModule(resolver.Module{})
 	caddy.RegisterModule(router.Module{})
+	caddy.RegisterModule(script.Module{})
 	caddy.RegisterModule(searchdomains.Module{})
 	caddy.RegisterModule(serverid.Module{})
+	caddy.RegisterModule(site.Module{})
 	caddy.RegisterModule(sleep.Module{})
+	caddy.RegisterModule(sntp.Module{})
+	caddy.RegisterModule(stateless.Module{})
+	caddy.RegisterModule(static.Module{})
 	caddy.RegisterModule(staticroute.Module{})
+	caddy.RegisterModule(timezone.Module{})
+	caddy.RegisterModule(when.Module{})
 }
 
 type App struct {
@@ -69,14 +132,137 @@ type Server struct {
 	Interface string `json:"interface,omitempty"`
 
 	// Socket addresses to which to bind listeners.
-	// Accepts network addresses that may include ports.
+	// Accepts `udp4` and `udp6` network addresses that may include ports. The host of an address must be of
+	// the family of its network, and addresses without a port use the server port of their family (67 or 547).
 	// Listener addresses must be unique; they cannot be repeated across all defined servers.
-	// The default addresses are `udp4/:69`, `udp6/:547`, `udp6/[ff02::1:2]:547` and `udp6/[ff05::1:3]:547`.
+	// The default addresses are `udp4/:67`, `udp6/:547`, `udp6/[ff02::1:2]:547` and `udp6/[ff05::1:3]:547`.
 	Listen []string `json:"listen,omitempty"`
 
+	// Keeps retrying to bind a listener address that is not available yet, instead of failing to start,
+	// like the address of an interface that is still being configured on boot, or the interface itself.
+	// Binds are retried in the background with an increasing delay of up to a minute until they succeed.
+	// Disabled by default.
+	WaitForInterfaces bool `json:"waitForInterfaces,omitempty"`
+
+	// The IP family to serve when using the default listener addresses:
+	// `both` (the default), `ipv4` or `ipv6`.
+	Family string `json:"family,omitempty"`
+
+	// Whether to join the `ff02::1:2` and `ff05::1:3` multicast groups when using the default
+	// listener addresses. Enabled by default; disable it on hosts that only serve DHCPv6 clients
+	// through relay agents or by unicast, which are still served on `udp6/:547`.
+	Multicast *bool `json:"multicast,omitempty"`
+
+	// Serve DHCPv4 clients through relay agents only, e.g. when listening on the unicast management address
+	// of the host instead of `udp4/:67`. Replies are then always unicast: to the relay agent in the giaddr
+	// field of the request, or to the client in its ciaddr field, and never broadcast to the local link.
+	// Requests with neither are not answered.
+	RelayOnly bool `json:"relayOnly,omitempty"`
+
+	// The source address of outgoing replies. An IPv4 address applies to DHCPv4 replies
+	// and an IPv6 address to DHCPv6 replies; by default the kernel picks the source address.
+	// Use `serverid` to send every DHCPv4 reply from the address in its Server Identifier option (54),
+	// as set by the serverid handler, so the source always matches the advertised server identifier.
+	SourceAddress string `json:"sourceAddress,omitempty"`
+
+	// Adds a server identifier to every reply that has none after the handlers ran, for clients that require one
+	// without configuring a serverid handler. DHCPv4 replies get a Server Identifier option (54) with the address
+	// the reply is sent from, and DHCPv6 replies the DUID of the server, see serverDuid. Disabled by default.
+	EnsureServerID bool `json:"ensureServerId,omitempty"`
+
+	// The DUID that ensureServerId adds to DHCPv6 replies, as a type and a value like the duid
+	// of the serverid handler, e.g. `ll 02:00:00:00:00:01`. Defaults to a DUID-LL with the hardware address
+	// of the interface of the server, or else of the first interface that has one, so the server presents
+	// the same DUID on every link, as RFC 8415 section 11 expects.
+	ServerDUID string `json:"serverDuid,omitempty"`
+
+	// The maximum random delay before replying to a DHCPv6 Solicit received on a multicast address,
+	// which spreads out the replies when many clients solicit at the same time, e.g. after a power outage.
+	// Disabled by default.
+	MulticastJitter caddy.Duration `json:"multicastJitter,omitempty"`
+
+	// Whether to answer a DHCPv6 Solicit directly with a Reply: `honor` (the default) does so
+	// when the client includes the Rapid Commit option, `off` always sends an Advertise instead,
+	// and `force` always sends a Reply, even to clients that did not ask for it, which is meant for lab use.
+	RapidCommit string `json:"rapidCommit,omitempty"`
+
+	// What to do with a request that no reply can be built for, like a DHCPv6 Request without a Client Identifier
+	// option: `drop` (the default) ignores it, and `reject` answers a DHCPREQUEST with a DHCPNAK and a DHCPv6 request
+	// with an UnspecFail status, so the client learns of the failure instead of timing out. These replies carry a server
+	// identifier derived like with ensureServerId. Either way the failure is logged and counted in the
+	// `caddy_dhcp_reply_errors_total` metric.
+	ReplyErrors string `json:"replyErrors,omitempty"`
+
+	// The destination port of DHCPv6 replies: `standard` (the default) sends replies to port 546 of clients
+	// and relay-replies to port 547 of relay agents as per RFC 8415, unless a relay agent asks for replies
+	// on its source port with the Relay Source Port option (RFC 8357). `source` sends all replies back to
+	// the source port of the request, for clients and tools that do not listen on the standard ports.
+	ReplyPort6 string `json:"replyPort6,omitempty"`
+
+	// How long the reply to a DHCPv4 request is reused for retransmissions of that request,
+	// which have the same transaction ID, client hardware address and message type.
+	// This avoids running the handlers again for clients that retransmit rapidly. Disabled by default.
+	DedupWindow caddy.Duration `json:"dedupWindow,omitempty"`
+
+	// The maximum number of replies kept for retransmitted requests, 1024 by default.
+	DedupSize int `json:"dedupSize,omitempty"`
+
+	// Default option values, which are set on a reply when the client requested them but none of the handlers set them.
+	// The supported options are `dns` (IPv4 and IPv6 addresses), `domain` (a single domain name),
+	// `ntp` and `router` (IPv4 addresses); the IPv4-only options only apply to DHCPv4.
+	Defaults map[string][]string `json:"defaults,omitempty"`
+
 	// Enables access logging.
 	Logs bool `json:"logs,omitempty"`
 
+	// Adds the local address and network interface on which a request arrived to the access log,
+	// as the `local_ip` and `interface` fields, which helps debugging servers with several addresses or interfaces.
+	LogLocalAddress bool `json:"logLocalAddress,omitempty"`
+
+	// Orders the options in DHCPv4 replies following the client's
+	// Parameter Request List (option 55). Options which were not requested
+	// are written afterward in ascending order of their code.
+	OrderOptions bool `json:"orderOptions,omitempty"`
+
+	// The number of sockets that read packets for each listener address, 1 by default. More than one
+	// spreads the unicast packets, i.e. those of relayed and renewing clients, over as many sockets
+	// bound to the same address using SO_REUSEPORT, which Caddy sets on every listener socket, and which
+	// are read in parallel. Broadcast and multicast packets are only read by the first socket,
+	// since the kernel delivers them to every socket.
+	ReadWorkers int `json:"readWorkers,omitempty"`
+
+	// Sets SO_REUSEADDR on the listener sockets. Caddy always sets SO_REUSEPORT on them, which lets them share
+	// an address with other sockets of the same user that set it as well, like those of a previous config.
+	// SO_REUSEADDR also lets them share it with sockets of other users that set SO_REUSEADDR, like those
+	// of another DHCP server or client on the host. Disabled by default.
+	ReuseAddr bool `json:"reuseAddr,omitempty"`
+
+	// The hop limit of outgoing DHCPv6 packets, both unicast and multicast, from 1 to 255.
+	// By default the hop limit of the operating system is used.
+	HopLimit int `json:"hopLimit,omitempty"`
+
+	// The TTL of outgoing DHCPv4 packets, from 1 to 255, for networks requiring a specific TTL.
+	// By default the TTL of the operating system is used.
+	TTL int `json:"ttl,omitempty"`
+
+	// The size of the buffer used to read a single packet, 4096 bytes by default.
+	// Packets larger than the buffer are truncated.
+	ReadBufferSize int `json:"readBufferSize,omitempty"`
+
+	// Offers a reconfigure key to DHCPv6 clients that accept Reconfigure messages (RFC 8415 section 18.2.11),
+	// which allows triggering a Renew, Rebind or Information-request through the admin API.
+	Reconfigure bool `json:"reconfigure,omitempty"`
+
+	// Authenticates DHCPv6 messages using a key shared with the clients, see Auth6. Requests without
+	// a valid Authentication option (11), or with a replay detection counter that is not higher than that
+	// of the previous request of the client, are dropped before they reach the handlers, and replies carry
+	// an Authentication option of their own. Cannot be combined with reconfigure. Disabled by default.
+	Auth6 *Auth6 `json:"auth6,omitempty"`
+
+	// Records the time spent in each handler in the `caddy_dhcp_handler_duration_seconds`
+	// histogram, labeled by server, handler module and IP family.
+	ProfileHandlers bool `json:"profileHandlers,omitempty"`
+
 	// The list of handlers for this server. They are chained
 	// together in a middleware fashion: requests flow from the first handler to the last
 	// (top of the list to the bottom), with the possibility that any handler could stop
@@ -92,6 +278,38 @@ type Server struct {
 	HandlersRaw []json.RawMessage `json:"handle,omitempty" caddy:"namespace=dhcp.handlers inline_key=handler"`
 }
 
+// maxReplySize6 is the largest DHCPv6 reply that fits in the minimum IPv6 MTU
+// of 1280 bytes (RFC 8200), after subtracting the IPv6 and UDP headers.
+const maxReplySize6 = 1280 - 40 - 8
+
+// defaultReadBufferSize is the default size of the buffer used to read a single packet.
+const defaultReadBufferSize = 4096
+
+// parseErrorLogInterval is the interval in which at most one parse error is logged per IP family.
+const parseErrorLogInterval = 10 * time.Second
+
+const (
+	familyBoth = "both"
+	familyIPv4 = "ipv4"
+	familyIPv6 = "ipv6"
+)
+
+const (
+	rapidCommitOff   = "off"
+	rapidCommitHonor = "honor"
+	rapidCommitForce = "force"
+)
+
+const (
+	replyErrorsDrop   = "drop"
+	replyErrorsReject = "reject"
+)
+
+const (
+	replyPortStandard = "standard"
+	replyPortSource   = "source"
+)
+
 type dhcpServer struct {
 	name      string
 	iface     string
@@ -101,9 +319,107 @@ type dhcpServer struct {
 	logger    *zap.Logger
 	accessLog *zap.Logger
 
+	orderOptions   bool
+	readBufferSize int
+	readWorkers    int
+
+	// logLocal adds the local address and interface of requests to the access log,
+	// using interfaceName to look up the name of an interface, which defaults to interfaceNameByIndex.
+	logLocal      bool
+	interfaceName func(index int) string
+
+	// sourceAddr4 and sourceAddr6 are the source addresses of the replies, if configured.
+	// If sourceFromServerID is set, DHCPv4 replies are sent from their server identifier instead.
+	sourceAddr4        net.IP
+	sourceAddr6        net.IP
+	sourceFromServerID bool
+
+	// ensureServerID adds a server identifier to replies that have none. The DUID of DHCPv6 replies is serverDUID,
+	// which is derived once from the hardware address that hardwareAddr, defaulting to interfaceHardwareAddr,
+	// looks up for the interface of the server if none is configured.
+	ensureServerID bool
+	hardwareAddr   func(name string) net.HardwareAddr
+	serverDUIDLock sync.Mutex
+	serverDUID     dhcpv6.DUID
+
+	// writeFrom writes a reply from the given source address.
+	writeFrom func(conn net.PacketConn, b []byte, dst net.Addr, src net.IP) (int, error)
+
+	// replies holds the replies to recent DHCPv4 requests, if deduplication is enabled.
+	replies *replyCache
+
+	// multicastJitter is the maximum delay of a reply to a Solicit received on a multicast address,
+	// which is slept using sleep.
+	multicastJitter time.Duration
+	sleep           func(time.Duration)
+
+	// rapidCommit is the policy for answering a Solicit directly with a Reply.
+	rapidCommit string
+
+	// relayOnly only sends unicast DHCPv4 replies to relay agents or to clients that have an address.
+	relayOnly bool
+
+	// replyToSource sends DHCPv6 replies to the source port of the request, instead of the standard ports.
+	replyToSource bool
+
+	// parseErrors counts the requests that could not be parsed, by IP family.
+	// Since these are common on noisy networks, they are logged to the sampled parseErrorLog.
+	parseErrors   *prometheus.CounterVec
+	parseErrorLog *zap.Logger
+
+	// replyErrors counts the requests that no reply could be built for, by IP family.
+	// If rejectReplyErrors is set, these requests get a negative reply instead.
+	replyErrors       *prometheus.CounterVec
+	rejectReplyErrors bool
+
+	// requestedOptions counts the requests asking for an option, by IP family and option code.
+	requestedOptions *prometheus.CounterVec
+
+	// reconfigure is nil unless the server supports Reconfigure messages.
+	reconfigure *reconfigureClients
+
+	// auth is nil unless DHCPv6 messages are authenticated.
+	auth *auth6
+
+	// reuseAddr sets SO_REUSEADDR on the listener sockets.
+	reuseAddr bool
+
+	// hopLimit and ttl are the hop limit of DHCPv6 packets and the TTL of DHCPv4 packets, if configured.
+	hopLimit int
+	ttl      int
+
+	// setsockoptInt sets a socket option, which defaults to unix.SetsockoptInt.
+	setsockoptInt func(fd, level, opt, value int) error
+
+	// bindToDevice binds a socket to the given interface, which defaults to unix.BindToDevice.
+	bindToDevice func(fd int, iface string) error
+
+	// arp adds an entry to the ARP cache of the given interface,
+	// which allows unicasting a reply to a client that has no IP address yet.
+	arp func(iface string, ip net.IP, mac net.HardwareAddr) error
+
+	// listen binds a listener socket for an address, which defaults to listening with control.
+	listen func(addr caddy.NetworkAddress) (net.PacketConn, error)
+
+	// waitForInterfaces retries binds failing because the address or interface is not available yet,
+	// starting with a delay of bindRetry, which defaults to minBindRetry.
+	waitForInterfaces bool
+	bindRetry         time.Duration
+
+	// stop is closed when the server stops, which ends the retrying binds.
+	stop chan struct{}
+
+	connMu      sync.Mutex
 	connections []net.PacketConn
+	stopped     bool
 }
 
+// minBindRetry and maxBindRetry are the first and the longest delay between retried binds.
+const (
+	minBindRetry = time.Second
+	maxBindRetry = time.Minute
+)
+
 // CaddyModule returns the Caddy module information.
 func (App) CaddyModule() caddy.ModuleInfo {
 	return caddy.ModuleInfo{
@@ -114,45 +430,125 @@ func (App) CaddyModule() caddy.ModuleInfo {
 
 func (app *App) Provision(ctx caddy.Context) error {
 	for name, srv := range app.Servers {
+		switch srv.Family {
+		case "", familyBoth, familyIPv4, familyIPv6:
+		default:
+			return fmt.Errorf("server %s: invalid family %q, expected one of %q, %q or %q", name, srv.Family, familyBoth, familyIPv4, familyIPv6)
+		}
+
+		switch srv.RapidCommit {
+		case "", rapidCommitOff, rapidCommitHonor, rapidCommitForce:
+		default:
+			return fmt.Errorf("server %s: invalid rapid commit policy %q, expected one of %q, %q or %q", name, srv.RapidCommit, rapidCommitOff, rapidCommitHonor, rapidCommitForce)
+		}
+
+		switch srv.ReplyErrors {
+		case "", replyErrorsDrop, replyErrorsReject:
+		default:
+			return fmt.Errorf("server %s: invalid reply error policy %q, expected one of %q or %q", name, srv.ReplyErrors, replyErrorsDrop, replyErrorsReject)
+		}
+
+		switch srv.ReplyPort6 {
+		case "", replyPortStandard, replyPortSource:
+		default:
+			return fmt.Errorf("server %s: invalid DHCPv6 reply port %q, expected %q or %q", name, srv.ReplyPort6, replyPortStandard, replyPortSource)
+		}
+
+		if srv.MulticastJitter < 0 {
+			return fmt.Errorf("server %s: invalid multicast jitter %s", name, time.Duration(srv.MulticastJitter))
+		}
+
+		if srv.DedupWindow < 0 || srv.DedupSize < 0 {
+			return fmt.Errorf("server %s: invalid dedup window %s or size %d", name, time.Duration(srv.DedupWindow), srv.DedupSize)
+		}
+
+		if srv.ReadBufferSize < 0 {
+			return fmt.Errorf("server %s: invalid read buffer size %d", name, srv.ReadBufferSize)
+		}
+
+		if srv.ReadWorkers < 0 {
+			return fmt.Errorf("server %s: invalid number of read workers %d", name, srv.ReadWorkers)
+		}
+
+		if srv.HopLimit < 0 || srv.HopLimit > 255 {
+			return fmt.Errorf("server %s: invalid hop limit %d", name, srv.HopLimit)
+		}
+
+		if srv.TTL < 0 || srv.TTL > 255 {
+			return fmt.Errorf("server %s: invalid TTL %d", name, srv.TTL)
+		}
+
+		var auth *auth6
+		if srv.Auth6 != nil {
+			if srv.Reconfigure {
+				return fmt.Errorf("server %s: auth6 cannot be combined with reconfigure", name)
+			}
+			var err error
+			if auth, err = newAuth6(srv.Auth6); err != nil {
+				return fmt.Errorf("server %s: auth6: %v", name, err)
+			}
+		}
+
+		var serverDUID dhcpv6.DUID
+		if srv.ServerDUID != "" {
+			duid, err := serverid.ParseDUID(srv.ServerDUID)
+			if err != nil {
+				return fmt.Errorf("server %s: invalid server DUID: %v", name, err)
+			}
+			serverDUID = duid
+		}
+
+		var sourceAddr4, sourceAddr6 net.IP
+		if srv.SourceAddress != "" && srv.SourceAddress != sourceServerID {
+			ip := net.ParseIP(srv.SourceAddress)
+			switch {
+			case ip == nil:
+				return fmt.Errorf("server %s: invalid source address %q", name, srv.SourceAddress)
+			case ip.To4() != nil:
+				sourceAddr4 = ip.To4()
+			default:
+				sourceAddr6 = ip
+			}
+		}
+
 		var addresses []caddy.NetworkAddress
 		for _, address := range srv.Listen {
-			addr, err := caddy.ParseNetworkAddress(address)
+			addr, err := parseListenAddress(address)
 			if err != nil {
-				return err
+				return fmt.Errorf("server %s: %v", name, err)
+			}
+			if (srv.Family == familyIPv4 && addr.Network == "udp6") || (srv.Family == familyIPv6 && addr.Network == "udp4") {
+				return fmt.Errorf("server %s: cannot listen on %s when the family is %s", name, addr, srv.Family)
 			}
-			// todo: set port based on IP family
 			addresses = append(addresses, addr)
 		}
 		if len(addresses) == 0 {
-			addresses = append(addresses, caddy.NetworkAddress{
-				Network:   "udp4",
-				StartPort: dhcpv4.ServerPort,
-				EndPort:   dhcpv4.ServerPort,
-			})
-			addresses = append(addresses, caddy.NetworkAddress{
-				Network:   "udp6",
-				StartPort: dhcpv6.DefaultServerPort,
-				EndPort:   dhcpv6.DefaultServerPort,
-			})
-			addresses = append(addresses, caddy.NetworkAddress{
-				Network:   "udp6",
-				Host:      dhcpv6.AllDHCPRelayAgentsAndServers.String(),
-				StartPort: dhcpv6.DefaultServerPort,
-				EndPort:   dhcpv6.DefaultServerPort,
-			})
-			addresses = append(addresses, caddy.NetworkAddress{
-				Network:   "udp6",
-				Host:      dhcpv6.AllDHCPServers.String(),
-				StartPort: dhcpv6.DefaultServerPort,
-				EndPort:   dhcpv6.DefaultServerPort,
-			})
-		}
-
-		handler, err := compileHandlerChain(ctx, srv)
+			addresses = defaultAddresses(srv.Family, srv.Multicast == nil || *srv.Multicast)
+		}
+
+		defaults, err := newDefaultOptions(srv.Defaults)
+		if err != nil {
+			return fmt.Errorf("server %s: %v", name, err)
+		}
+
+		handler, err := compileHandlerChain(ctx, name, srv, defaults)
 		if err != nil {
 			return err
 		}
 
+		parseErrors, err := newParseErrors(ctx)
+		if err != nil {
+			return fmt.Errorf("registering parse error metrics: %v", err)
+		}
+		requestedOptions, err := newRequestedOptions(ctx)
+		if err != nil {
+			return fmt.Errorf("registering requested option metrics: %v", err)
+		}
+		replyErrors, err := newReplyErrors(ctx)
+		if err != nil {
+			return fmt.Errorf("registering reply error metrics: %v", err)
+		}
+
 		logger := ctx.Logger().Named(name)
 		var accessLog *zap.Logger
 		if srv.Logs {
@@ -166,6 +562,51 @@ func (app *App) Provision(ctx caddy.Context) error {
 			ctx:       ctx,
 			logger:    logger,
 			accessLog: accessLog,
+
+			orderOptions:   srv.OrderOptions,
+			readBufferSize: srv.ReadBufferSize,
+			readWorkers:    srv.ReadWorkers,
+			logLocal:       srv.Logs && srv.LogLocalAddress,
+			reuseAddr:      srv.ReuseAddr,
+			hopLimit:       srv.HopLimit,
+			ttl:            srv.TTL,
+
+			waitForInterfaces: srv.WaitForInterfaces,
+
+			sourceAddr4:        sourceAddr4,
+			sourceAddr6:        sourceAddr6,
+			sourceFromServerID: srv.SourceAddress == sourceServerID,
+			ensureServerID:     srv.EnsureServerID,
+			serverDUID:         serverDUID,
+			writeFrom:          writeFromSource,
+			multicastJitter:    time.Duration(srv.MulticastJitter),
+			sleep:              time.Sleep,
+			rapidCommit:        srv.RapidCommit,
+			relayOnly:          srv.RelayOnly,
+			replyToSource:      srv.ReplyPort6 == replyPortSource,
+
+			parseErrors:       parseErrors.MustCurryWith(prometheus.Labels{"server": name}),
+			parseErrorLog:     sampledLogger(logger, parseErrorLogInterval),
+			requestedOptions:  requestedOptions.MustCurryWith(prometheus.Labels{"server": name}),
+			replyErrors:       replyErrors.MustCurryWith(prometheus.Labels{"server": name}),
+			rejectReplyErrors: srv.ReplyErrors == replyErrorsReject,
+			arp:               setARPEntry,
+			auth:              auth,
+		}
+
+		if srv.DedupWindow > 0 {
+			size := srv.DedupSize
+			if size == 0 {
+				size = defaultDedupSize
+			}
+			s.replies = newReplyCache(time.Duration(srv.DedupWindow), size)
+		}
+
+		if srv.Reconfigure {
+			s.reconfigure = &reconfigureClients{
+				clients: make(map[string]*reconfigureClient),
+				replay:  uint64(time.Now().UnixNano()),
+			}
 		}
 
 		app.servers = append(app.servers, s)
@@ -173,6 +614,67 @@ func (app *App) Provision(ctx caddy.Context) error {
 	return nil
 }
 
+// parseListenAddress parses a listener address, which must be a `udp4` address with an IPv4 host or a `udp6`
+// address with an IPv6 host, if the host is an IP address. An address without a port gets the server port
+// of its family.
+func parseListenAddress(address string) (caddy.NetworkAddress, error) {
+	addr, err := caddy.ParseNetworkAddress(address)
+	if err != nil {
+		return addr, err
+	}
+	var port uint
+	switch addr.Network {
+	case "udp4":
+		port = dhcpv4.ServerPort
+	case "udp6":
+		port = dhcpv6.DefaultServerPort
+	default:
+		return addr, fmt.Errorf("cannot listen on %s: expected a udp4 or udp6 address", address)
+	}
+	if ip := net.ParseIP(addr.Host); ip != nil && (ip.To4() != nil) != (addr.Network == "udp4") {
+		return addr, fmt.Errorf("cannot listen on %s: %s is not an address of network %s", address, addr.Host, addr.Network)
+	}
+	if addr.StartPort == 0 && addr.EndPort == 0 {
+		addr.StartPort, addr.EndPort = port, port
+	}
+	return addr, nil
+}
+
+// defaultAddresses returns the addresses to listen on when none are configured, limited to the given IP family.
+// The DHCPv6 multicast addresses are only included if multicast is set.
+func defaultAddresses(family string, multicast bool) []caddy.NetworkAddress {
+	var addresses []caddy.NetworkAddress
+	if family != familyIPv6 {
+		addresses = append(addresses, caddy.NetworkAddress{
+			Network:   "udp4",
+			StartPort: dhcpv4.ServerPort,
+			EndPort:   dhcpv4.ServerPort,
+		})
+	}
+	if family != familyIPv4 {
+		addresses = append(addresses, caddy.NetworkAddress{
+			Network:   "udp6",
+			StartPort: dhcpv6.DefaultServerPort,
+			EndPort:   dhcpv6.DefaultServerPort,
+		})
+	}
+	if family != familyIPv4 && multicast {
+		addresses = append(addresses, caddy.NetworkAddress{
+			Network:   "udp6",
+			Host:      dhcpv6.AllDHCPRelayAgentsAndServers.String(),
+			StartPort: dhcpv6.DefaultServerPort,
+			EndPort:   dhcpv6.DefaultServerPort,
+		})
+		addresses = append(addresses, caddy.NetworkAddress{
+			Network:   "udp6",
+			Host:      dhcpv6.AllDHCPServers.String(),
+			StartPort: dhcpv6.DefaultServerPort,
+			EndPort:   dhcpv6.DefaultServerPort,
+		})
+	}
+	return addresses
+}
+
 // Start starts the app.
 func (app *App) Start() error {
 	app.errGroup = &errgroup.Group{}
@@ -183,99 +685,251 @@ func (app *App) Start() error {
 			zap.String("interface", s.iface),
 			zap.Stringers("addresses", s.addresses),
 		)
+		s.stop = make(chan struct{})
 		for _, addr := range s.addresses {
-			ln, err := addr.Listen(s.ctx, 0, net.ListenConfig{
-				Control: func(network, address string, c syscall.RawConn) error {
-					if s.iface != "" {
-						var bindErr error
-						controlErr := c.Control(func(fd uintptr) {
-							bindErr = unix.BindToDevice(int(fd), s.iface)
-						})
-						if controlErr != nil {
-							return controlErr
-						}
-						if bindErr != nil {
-							return bindErr
-						}
-					}
-					return nil
-				},
-			})
-			if err != nil {
-				return fmt.Errorf("failed to listen on %s: %v", addr, err)
+			workers := 1
+			// a socket bound to a multicast address only receives multicast packets, which every socket receives
+			if ip := net.ParseIP(addr.Host); s.readWorkers > 1 && (ip == nil || !ip.IsMulticast()) {
+				workers = s.readWorkers
 			}
-			conn := ln.(net.PacketConn)
-			s.connections = append(s.connections, conn)
+			for i := 0; i < workers; i++ {
+				err := s.serve(app.errGroup, addr, i)
+				if err != nil && s.waitForInterfaces && notAvailable(err) {
+					s.logger.Warn("listener address not available yet, retrying in the background",
+						zap.Stringer("address", addr),
+						zap.Error(err),
+					)
+					app.errGroup.Go(func() error { return s.retryServe(app.errGroup, addr, i) })
+					continue
+				}
+				if err != nil {
+					return fmt.Errorf("failed to listen on %s: %v", addr, err)
+				}
+			}
+		}
+	}
+	return nil
+}
 
-			switch {
-			case addr.Network == "udp4":
-				app.errGroup.Go(func() error {
-					defer conn.Close()
-					for {
-						rbuf := make([]byte, 4096) // FIXME this is bad
-						n, peer, err := conn.ReadFrom(rbuf)
-						if err != nil {
-							s.logger.Error("error reading from packet conn", zap.Error(err))
-							return err
-						}
-						s.logger.Info("handling request", zap.Stringer("peer", peer))
-
-						m, err := dhcpv4.FromBytes(rbuf[:n])
-						if err != nil {
-							s.logger.Error("error parsing DHCPv4 request", zap.Error(err))
-							continue
-						}
-
-						upeer, ok := peer.(*net.UDPAddr)
-						if !ok {
-							s.logger.Warn("not a UDP connection?", zap.Stringer("peer", peer))
-							continue
-						}
-
-						// Set peer to broadcast if the client did not have an IP.
-						if upeer.IP == nil || upeer.IP.To4().Equal(net.IPv4zero) {
-							upeer = &net.UDPAddr{
-								IP:   net.IPv4bcast,
-								Port: upeer.Port,
-							}
-						}
-
-						go s.handle4(conn, upeer, m)
-					}
-				})
-			case addr.Network == "udp6":
-				app.errGroup.Go(func() error {
-					defer conn.Close()
-					for {
-						rbuf := make([]byte, 4096) // FIXME this is bad
-						n, peer, err := conn.ReadFrom(rbuf)
-						if err != nil {
-							s.logger.Error("error reading from packet conn", zap.Error(err))
-							return err
-						}
-						s.logger.Info("handling request", zap.Stringer("peer", peer))
-
-						m, err := dhcpv6.FromBytes(rbuf[:n])
-						if err != nil {
-							s.logger.Error("error parsing DHCPv6 request", zap.Error(err))
-							continue
-						}
-
-						upeer, ok := peer.(*net.UDPAddr)
-						if !ok {
-							s.logger.Warn("not a UDP connection?", zap.Stringer("peer", peer))
-							continue
-						}
-
-						go s.handle6(conn, upeer, m)
-					}
-				})
+// serve binds a listener socket for addr and serves the requests read from it in the background.
+// The worker is the index of the socket among those reading packets for addr.
+func (s *dhcpServer) serve(g *errgroup.Group, addr caddy.NetworkAddress, worker int) error {
+	listen := s.listen
+	if listen == nil {
+		listen = func(addr caddy.NetworkAddress) (net.PacketConn, error) {
+			ln, err := addr.Listen(s.ctx, 0, net.ListenConfig{Control: s.control})
+			if err != nil {
+				return nil, err
 			}
+			return ln.(net.PacketConn), nil
 		}
 	}
+	conn, err := listen(addr)
+	if err != nil {
+		return err
+	}
+	s.connMu.Lock()
+	if s.stopped {
+		s.connMu.Unlock()
+		return conn.Close()
+	}
+	s.connections = append(s.connections, conn)
+	s.connMu.Unlock()
+	// the local address and interface of a request are needed to derive the server identifier,
+	// and writing from a source address needs the socket that the listener of Caddy wraps
+	if worker > 0 || s.logLocal || s.ensureServerID || s.sourceAddr4 != nil || s.sourceAddr6 != nil || s.sourceFromServerID {
+		ic, err := newInfoConn(conn, addr.Network)
+		if err != nil {
+			return err
+		}
+		ic.unicastOnly = worker > 0
+		conn = ic
+	}
+
+	switch {
+	case addr.Network == "udp4":
+		g.Go(func() error { return s.serve4(conn) })
+	case addr.Network == "udp6":
+		g.Go(func() error { return s.serve6(conn) })
+	}
 	return nil
 }
 
+// retryServe retries serve with an increasing delay while addr is not available, until the server stops.
+func (s *dhcpServer) retryServe(g *errgroup.Group, addr caddy.NetworkAddress, worker int) error {
+	delay := s.bindRetry
+	if delay == 0 {
+		delay = minBindRetry
+	}
+	for {
+		timer := time.NewTimer(delay)
+		select {
+		case <-s.stop:
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+		err := s.serve(g, addr, worker)
+		if err == nil {
+			s.logger.Info("listener address available", zap.Stringer("address", addr))
+			return nil
+		}
+		if !notAvailable(err) {
+			s.logger.Error("failed to listen", zap.Stringer("address", addr), zap.Error(err))
+			return fmt.Errorf("failed to listen on %s: %v", addr, err)
+		}
+		s.logger.Debug("listener address still not available", zap.Stringer("address", addr), zap.Duration("retry", delay))
+		delay = min(2*delay, maxBindRetry)
+	}
+}
+
+// notAvailable reports whether a bind failed because the address or the interface does not exist yet.
+func notAvailable(err error) bool {
+	return errors.Is(err, syscall.EADDRNOTAVAIL) || errors.Is(err, syscall.ENODEV)
+}
+
+// control sets the socket options of a listener socket. It sets SO_REUSEADDR if enabled,
+// the hop limit of a udp6 socket or the TTL of a udp4 socket if configured,
+// and binds the socket to the network interface of the server, if any, using SO_BINDTODEVICE.
+// A socket bound to an interface only receives the packets arriving on that interface,
+// even when it listens on the wildcard address.
+func (s *dhcpServer) control(network, _ string, c syscall.RawConn) error {
+	if s.iface == "" && !s.reuseAddr && s.hopLimit == 0 && s.ttl == 0 {
+		return nil
+	}
+	setsockoptInt := s.setsockoptInt
+	if setsockoptInt == nil {
+		setsockoptInt = unix.SetsockoptInt
+	}
+	bindToDevice := s.bindToDevice
+	if bindToDevice == nil {
+		bindToDevice = unix.BindToDevice
+	}
+	var sockErr error
+	if err := c.Control(func(fd uintptr) {
+		if s.reuseAddr {
+			if err := setsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
+				sockErr = fmt.Errorf("setting SO_REUSEADDR: %w", err)
+				return
+			}
+		}
+		if s.hopLimit != 0 && network == "udp6" {
+			if err := setsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_UNICAST_HOPS, s.hopLimit); err != nil {
+				sockErr = fmt.Errorf("setting IPV6_UNICAST_HOPS: %w", err)
+				return
+			}
+			if err := setsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_HOPS, s.hopLimit); err != nil {
+				sockErr = fmt.Errorf("setting IPV6_MULTICAST_HOPS: %w", err)
+				return
+			}
+		}
+		if s.ttl != 0 && network == "udp4" {
+			if err := setsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TTL, s.ttl); err != nil {
+				sockErr = fmt.Errorf("setting IP_TTL: %w", err)
+				return
+			}
+		}
+		if s.iface != "" {
+			if err := bindToDevice(int(fd), s.iface); err != nil {
+				sockErr = fmt.Errorf("binding to interface %s: %w", s.iface, err)
+			}
+		}
+	}); err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// read reads a single packet from conn. Since the kernel silently truncates packets
+// that do not fit in the read buffer, a packet filling the entire buffer is reported.
+// The local address and interface on which the packet arrived are only known for an infoConn.
+func (s *dhcpServer) read(conn net.PacketConn) ([]byte, net.Addr, packetInfo, error) {
+	size := s.readBufferSize
+	if size == 0 {
+		size = defaultReadBufferSize
+	}
+	rbuf := make([]byte, size) // FIXME this is bad
+	var (
+		n     int
+		peer  net.Addr
+		local packetInfo
+		err   error
+	)
+	if ic, ok := conn.(*infoConn); ok {
+		n, local, peer, err = ic.readInfo(rbuf)
+	} else {
+		n, peer, err = conn.ReadFrom(rbuf)
+	}
+	if err != nil {
+		return nil, nil, packetInfo{}, err
+	}
+	if n == len(rbuf) {
+		s.logger.Warn("received packet fills the read buffer and may have been truncated, consider increasing readBufferSize",
+			zap.Stringer("peer", peer),
+			zap.Int("readBufferSize", len(rbuf)),
+		)
+	}
+	return rbuf[:n], peer, local, nil
+}
+
+// serve4 reads DHCPv4 requests from conn and handles each of them in a separate goroutine,
+// until reading from conn fails.
+func (s *dhcpServer) serve4(conn net.PacketConn) error {
+	defer conn.Close()
+	for {
+		b, peer, local, err := s.read(conn)
+		if err != nil {
+			s.logger.Error("error reading from packet conn", zap.Error(err))
+			return err
+		}
+		s.logger.Debug("handling request", zap.Stringer("peer", peer))
+
+		m, err := dhcpv4.FromBytes(b)
+		if err != nil {
+			s.parseErrors.WithLabelValues("4").Inc()
+			s.parseErrorLog.Error("error parsing DHCPv4 request", zap.Stringer("peer", peer), zap.Error(err))
+			continue
+		}
+
+		upeer, ok := peer.(*net.UDPAddr)
+		if !ok {
+			s.logger.Warn("not a UDP connection?", zap.Stringer("peer", peer))
+			continue
+		}
+
+		go s.handle4(conn, upeer, local, m)
+	}
+}
+
+// serve6 reads DHCPv6 requests from conn and handles each of them in a separate goroutine,
+// until reading from conn fails.
+func (s *dhcpServer) serve6(conn net.PacketConn) error {
+	defer conn.Close()
+	for {
+		b, peer, local, err := s.read(conn)
+		if err != nil {
+			s.logger.Error("error reading from packet conn", zap.Error(err))
+			return err
+		}
+		s.logger.Debug("handling request", zap.Stringer("peer", peer))
+
+		m, err := dhcpv6.FromBytes(b)
+		if err != nil {
+			s.parseErrors.WithLabelValues("6").Inc()
+			s.parseErrorLog.Error("error parsing DHCPv6 request", zap.Stringer("peer", peer), zap.Error(err))
+			continue
+		}
+
+		upeer, ok := peer.(*net.UDPAddr)
+		if !ok {
+			s.logger.Warn("not a UDP connection?", zap.Stringer("peer", peer))
+			continue
+		}
+
+		go s.handle6(conn, upeer, local, m, b)
+	}
+}
+
 // Stop stops the app.
 func (app *App) Stop() error {
 	for _, s := range app.servers {
@@ -285,14 +939,38 @@ func (app *App) Stop() error {
 			zap.String("interface", s.iface),
 			zap.Stringers("addresses", s.addresses),
 		)
+		if s.stop != nil {
+			close(s.stop)
+		}
+		s.connMu.Lock()
+		s.stopped = true
 		for _, conn := range s.connections {
 			_ = conn.Close()
 		}
+		s.connMu.Unlock()
 	}
 	return app.errGroup.Wait()
 }
 
-func (s *dhcpServer) handle4(conn net.PacketConn, peer *net.UDPAddr, m *dhcpv4.DHCPv4) {
+// optionNames4 returns the names of the DHCPv4 options for the access log.
+func optionNames4(codes dhcpv4.OptionCodeList) []string {
+	names := make([]string, len(codes))
+	for i, code := range codes {
+		names[i] = code.String()
+	}
+	return names
+}
+
+// optionNames6 returns the names of the DHCPv6 options for the access log.
+func optionNames6(codes dhcpv6.OptionCodes) []string {
+	names := make([]string, len(codes))
+	for i, code := range codes {
+		names[i] = code.String()
+	}
+	return names
+}
+
+func (s *dhcpServer) handle4(conn net.PacketConn, peer *net.UDPAddr, local packetInfo, m *dhcpv4.DHCPv4) {
 	var (
 		req, resp *dhcpv4.DHCPv4
 		err       error
@@ -304,23 +982,34 @@ func (s *dhcpServer) handle4(conn net.PacketConn, peer *net.UDPAddr, m *dhcpv4.D
 		defer func() {
 			end := time.Now()
 			d := end.Sub(start)
-			s.accessLog.Info(
-				"handled request",
+			fields := []zap.Field{
 				zap.Stringer("remote_ip", peer.IP),
 				zap.Int("remote_port", peer.Port),
 				zap.Stringer("message_type", m.MessageType()),
+				zap.Strings("requested_options", optionNames4(handlers.DHCPv4{DHCPv4: m}.RequestedOptions())),
 				zap.Int("bytes_written", n),
 				zap.Stringer("duration", d),
-			)
+			}
+			if s.logLocal {
+				fields = append(fields, s.localFields(local)...)
+			}
+			s.accessLog.Info("handled request", fields...)
 		}()
 	}
 
 	req = m
-	s.logger.Debug("received message", zap.String("message", req.Summary()))
+	s.debugSummary("received message", req)
+	if s.requestedOptions != nil {
+		for _, code := range (handlers.DHCPv4{DHCPv4: req}).RequestedOptions() {
+			s.requestedOptions.WithLabelValues("4", strconv.Itoa(int(code.Code()))).Inc()
+		}
+	}
 
 	resp, err = dhcpv4.NewReplyFromRequest(req)
 	if err != nil {
-		s.logger.Error("failed to build reply", zap.Error(err))
+		if resp = s.replyError4(conn, peer, local, req, err); resp != nil {
+			n = s.send4(conn, peer, req, resp)
+		}
 		return
 	}
 	switch mt := req.MessageType(); mt {
@@ -328,27 +1017,155 @@ func (s *dhcpServer) handle4(conn net.PacketConn, peer *net.UDPAddr, m *dhcpv4.D
 		resp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeOffer))
 	case dhcpv4.MessageTypeRequest:
 		resp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeAck))
+	case dhcpv4.MessageTypeDecline, dhcpv4.MessageTypeRelease:
+		// a DHCPDECLINE or DHCPRELEASE is not answered,
+		// but the handlers are run so they can stop using the declined or released address
+		resp.Options.Del(dhcpv4.OptionDHCPMessageType)
+		if err = s.handler.Handle4(handlers.NewDHCPv4(req), handlers.DHCPv4{DHCPv4: resp}, func() error { return nil }); err != nil {
+			s.chainError4(req, resp, err)
+		}
+		return
 	default:
 		s.logger.Error("unhandled message type", zap.Stringer("messageType", mt))
 		return
 	}
 
-	err = s.handler.Handle4(handlers.DHCPv4{DHCPv4: req}, handlers.DHCPv4{DHCPv4: resp}, func() error { return nil })
-	if err != nil {
-		s.logger.Error("handler chain failed", zap.Error(err))
-		return
+	if cached := s.replies.get(req); cached != nil {
+		s.logger.Debug("reusing the reply to a retransmitted request", zap.Stringer("xid", req.TransactionID), zap.Stringer("mac", req.ClientHWAddr))
+		resp = cached
+	} else {
+		err = s.handler.Handle4(handlers.NewDHCPv4(req), handlers.DHCPv4{DHCPv4: resp}, func() error { return nil })
+		if err != nil {
+			if resp = s.chainError4(req, resp, err); resp == nil {
+				return
+			}
+		}
+		if s.ensureServerID {
+			s.ensureServerID4(conn, local, resp)
+		}
+		if resp.MessageType() == dhcpv4.MessageTypeNak {
+			stripNak4(resp)
+		}
+		s.replies.put(req, resp)
 	}
 
 	if resp != nil {
-		n, err = conn.WriteTo(resp.ToBytes(), peer)
-		if err != nil {
-			s.logger.Error(err.Error())
+		n = s.send4(conn, peer, req, resp)
+	}
+}
+
+// send4 encodes a DHCPv4 reply and writes it to the address it should be sent to, if any.
+// It returns the number of bytes written.
+func (s *dhcpServer) send4(conn net.PacketConn, peer *net.UDPAddr, req, resp *dhcpv4.DHCPv4) int {
+	addr := s.replyAddr4(req, resp, peer)
+	if addr == nil {
+		s.logger.Debug("not replying to a request that was not relayed", zap.Stringer("mac", req.ClientHWAddr))
+		return 0
+	}
+	var (
+		b     []byte
+		order dhcpv4.OptionCodeList
+	)
+	if s.orderOptions {
+		order = handlers.DHCPv4{DHCPv4: req}.RequestedOptions()
+		b = handlers.DHCPv4{DHCPv4: resp}.ToBytesOrdered(order)
+	} else {
+		b = resp.ToBytes()
+	}
+	if size := (handlers.DHCPv4{DHCPv4: req}).MaxReplySize(); len(b) > size {
+		// try to fit the reply by overloading the file and sname fields, or else by leaving out options of the
+		// serialized reply only, so resp stays as it was logged, cached and metered
+		var removed dhcpv4.OptionCodeList
+		b, removed = handlers.DHCPv4{DHCPv4: resp}.ToBytesTrimmed(order, handlers.DHCPv4{DHCPv4: req}.RequestedOptions(), size)
+		if len(removed) > 0 {
+			codes := make([]uint8, len(removed))
+			for i, code := range removed {
+				codes[i] = code.Code()
+			}
+			s.logger.Warn("removed options to fit the maximum message size of the client", zap.Uint8s("options", codes), zap.Int("max", size))
+		}
+		s.checkReplySize(len(b), size)
+	}
+	n, err := s.write(conn, b, addr, s.source4(resp))
+	if err != nil {
+		s.logger.Error(err.Error())
+	}
+	s.debugSummary("send message", resp)
+	return n
+}
+
+// replyAddr4 determines where to send a DHCPv4 reply to, following RFC 2131 section 4.1.
+// A relayed request, which has a non-zero giaddr, is answered by unicast to the server port of the relay agent.
+// A client that already has an IP address, i.e. that is renewing or rebinding its lease or sent a DHCPINFORM,
+// puts it in the ciaddr field, and the reply is unicast to that address. It is sent back to the source port
+// of the request if that came from the same address, and to the client port otherwise.
+// If the client has no address in ciaddr but the request has a source address, the reply is sent back to it.
+// Otherwise, the reply is unicast to the offered address when the client did not set the broadcast flag
+// and the client's hardware address could be added to the ARP cache; if not it is broadcast.
+// A DHCPNAK that is not relayed is always broadcast.
+// In relay-only mode, a request that is not relayed is answered by unicast to its ciaddr,
+// and nil is returned if the client has no address.
+func (s *dhcpServer) replyAddr4(req, resp *dhcpv4.DHCPv4, peer *net.UDPAddr) *net.UDPAddr {
+	if req.GatewayIPAddr != nil && !req.GatewayIPAddr.IsUnspecified() {
+		return &net.UDPAddr{IP: req.GatewayIPAddr, Port: dhcpv4.ServerPort}
+	}
+	if s.relayOnly {
+		if req.ClientIPAddr == nil || req.ClientIPAddr.IsUnspecified() {
+			return nil
+		}
+		return &net.UDPAddr{IP: req.ClientIPAddr, Port: dhcpv4.ClientPort}
+	}
+	broadcast := &net.UDPAddr{IP: net.IPv4bcast, Port: peer.Port}
+	if resp.MessageType() == dhcpv4.MessageTypeNak {
+		return broadcast
+	}
+	if req.ClientIPAddr != nil && !req.ClientIPAddr.IsUnspecified() {
+		if peer.IP.Equal(req.ClientIPAddr) {
+			return peer
+		}
+		return &net.UDPAddr{IP: req.ClientIPAddr, Port: dhcpv4.ClientPort}
+	}
+	if peer.IP != nil && !peer.IP.To4().Equal(net.IPv4zero) {
+		return peer
+	}
+	if req.IsBroadcast() || resp.YourIPAddr == nil || resp.YourIPAddr.IsUnspecified() {
+		return broadcast
+	}
+	// without knowing the interface the ARP cache cannot be populated,
+	// so the client would not be reachable by unicast
+	if s.iface == "" || s.arp == nil {
+		return broadcast
+	}
+	if err := s.arp(s.iface, resp.YourIPAddr, req.ClientHWAddr); err != nil {
+		s.logger.Debug("cannot add ARP entry, falling back to broadcast",
+			zap.Stringer("ip", resp.YourIPAddr),
+			zap.Stringer("mac", req.ClientHWAddr),
+			zap.Error(err),
+		)
+		return broadcast
+	}
+	return &net.UDPAddr{IP: resp.YourIPAddr, Port: peer.Port}
+}
+
+// replyAddr6 determines where to send a DHCPv6 reply to. As per RFC 8415 section 7.2, a reply is sent to
+// port 546 of the client, and a relay-reply to port 547 of the relay agent. A relay agent that includes the
+// Relay Source Port option gets the relay-reply on its source port instead (RFC 8357 section 5.2).
+func (s *dhcpServer) replyAddr6(m dhcpv6.DHCPv6, peer *net.UDPAddr) *net.UDPAddr {
+	if s.replyToSource {
+		return peer
+	}
+	port := dhcpv6.DefaultClientPort
+	if relay, ok := m.(*dhcpv6.RelayMessage); ok {
+		port = dhcpv6.DefaultServerPort
+		if relay.Options.GetOne(dhcpv6.OptionRelayPort) != nil {
+			port = peer.Port
 		}
-		s.logger.Debug("send message", zap.String("message", resp.Summary()))
 	}
+	return &net.UDPAddr{IP: peer.IP, Port: port, Zone: peer.Zone}
 }
 
-func (s *dhcpServer) handle6(conn net.PacketConn, peer *net.UDPAddr, m dhcpv6.DHCPv6) {
+// handle6 handles the DHCPv6 message m, which was parsed from the packet b.
+func (s *dhcpServer) handle6(conn net.PacketConn, peer *net.UDPAddr, local packetInfo, m dhcpv6.DHCPv6, b []byte) {
 	var (
 		req, resp *dhcpv6.Message
 		err       error
@@ -360,14 +1177,20 @@ func (s *dhcpServer) handle6(conn net.PacketConn, peer *net.UDPAddr, m dhcpv6.DH
 		defer func() {
 			end := time.Now()
 			d := end.Sub(start)
-			s.accessLog.Info(
-				"handled request",
+			fields := []zap.Field{
 				zap.Stringer("remote_ip", peer.IP),
 				zap.Int("remote_port", peer.Port),
 				zap.Stringer("message_type", m.Type()),
 				zap.Int("bytes_written", n),
 				zap.Stringer("duration", d),
-			)
+			}
+			if req != nil {
+				fields = append(fields, zap.Strings("requested_options", optionNames6(handlers.DHCPv6{Message: req}.RequestedOptions())))
+			}
+			if s.logLocal {
+				fields = append(fields, s.localFields(local)...)
+			}
+			s.accessLog.Info("handled request", fields...)
 		}()
 	}
 
@@ -376,114 +1199,385 @@ func (s *dhcpServer) handle6(conn net.PacketConn, peer *net.UDPAddr, m dhcpv6.DH
 		s.logger.Error("cannot get inner message", zap.Error(err))
 		return
 	}
-	s.logger.Debug("received message", zap.String("message", req.Summary()))
-
-	switch req.Type() {
-	case dhcpv6.MessageTypeSolicit:
-		if req.GetOneOption(dhcpv6.OptionRapidCommit) != nil {
-			resp, err = dhcpv6.NewReplyFromMessage(req)
-		} else {
-			resp, err = dhcpv6.NewAdvertiseFromSolicit(req)
+	s.debugSummary("received message", req)
+	if s.auth != nil {
+		if err := s.auth.verify(b, req); err != nil {
+			s.logger.Warn("dropping unauthenticated request", zap.Stringer("peer", peer), zap.Stringer("messageType", req.MessageType), zap.Error(err))
+			return
 		}
-	case dhcpv6.MessageTypeRequest, dhcpv6.MessageTypeConfirm, dhcpv6.MessageTypeRenew,
-		dhcpv6.MessageTypeRebind, dhcpv6.MessageTypeRelease, dhcpv6.MessageTypeInformationRequest:
-		resp, err = dhcpv6.NewReplyFromMessage(req)
-	default:
-		err = fmt.Errorf("message type %d not supported", req.Type())
 	}
-	if err != nil {
-		s.logger.Error("NewReplyFromDHCPv6Message failed", zap.Error(err))
-		return
+	if s.requestedOptions != nil {
+		for _, code := range (handlers.DHCPv6{Message: req}).RequestedOptions() {
+			s.requestedOptions.WithLabelValues("6", strconv.Itoa(int(code))).Inc()
+		}
 	}
 
-	err = s.handler.Handle6(handlers.DHCPv6{Message: req}, handlers.DHCPv6{Message: resp}, func() error { return nil })
+	resp, err = newReply6(req, s.rapidCommit)
 	if err != nil {
-		s.logger.Error("handler chain failed", zap.Error(err))
-		return
+		if resp = s.replyError6(peer, local, req, err); resp == nil {
+			return
+		}
+	} else {
+		hreq := handlers.NewDHCPv6(req)
+		if relay, ok := m.(*dhcpv6.RelayMessage); ok {
+			hreq = handlers.NewRelayedDHCPv6(relay, req)
+		}
+		err = s.handler.Handle6(hreq, handlers.DHCPv6{Message: resp}, func() error { return nil })
+		if err != nil {
+			if resp = s.chainError6(req, resp, err); resp == nil {
+				return
+			}
+		}
+	}
+	if s.ensureServerID {
+		s.ensureServerID6(resp)
 	}
 
 	if resp != nil {
+		if s.reconfigure != nil && !m.IsRelay() {
+			s.offerReconfigure(conn, peer, req, resp)
+		}
+		if s.auth != nil {
+			s.auth.authenticate(resp)
+		}
+
+		var b []byte
 		if m.IsRelay() {
 			// if the request was relayed, re-encapsulate the response
 			var encapsulated dhcpv6.DHCPv6
-			encapsulated, err = dhcpv6.NewRelayReplFromRelayForw(m.(*dhcpv6.RelayMessage), resp)
+			encapsulated, err = relayReply6(m, resp)
 			if err != nil {
 				s.logger.Error("cannot create relay-repl from relay-forw", zap.Error(err))
 				return
 			}
-			n, err = conn.WriteTo(encapsulated.ToBytes(), peer)
+			b = encapsulated.ToBytes()
 		} else {
-			n, err = conn.WriteTo(resp.ToBytes(), peer)
+			b = resp.ToBytes()
+		}
+		s.checkReplySize(len(b), maxReplySize6)
+		if req.Type() == dhcpv6.MessageTypeSolicit {
+			s.jitter(conn)
 		}
+		n, err = s.write(conn, b, s.replyAddr6(m, peer), s.sourceAddr6)
 		if err != nil {
 			s.logger.Error("cannot write response", zap.Error(err))
 		}
-		s.logger.Debug("send message", zap.String("message", resp.Summary()))
+		s.debugSummary("send message", resp)
 	}
 }
 
-// compileHandlerChain sets up all the handlers by loading the handler modules and compiling them in a chain.
-func compileHandlerChain(ctx caddy.Context, s *Server) (handlers.Handler, error) {
-	handlersRaw, err := ctx.LoadModule(s, "HandlersRaw")
-	if err != nil {
-		return nil, fmt.Errorf("loading handler modules: %v", err)
+// newReply6 creates the reply to a DHCPv6 request: an Advertise in response to a Solicit,
+// unless rapid commit applies according to the given policy, and a Reply otherwise.
+func newReply6(req *dhcpv6.Message, rapidCommit string) (*dhcpv6.Message, error) {
+	switch req.Type() {
+	case dhcpv6.MessageTypeSolicit:
+		switch {
+		case rapidCommit == rapidCommitForce:
+			resp, err := dhcpv6.NewAdvertiseFromSolicit(req, dhcpv6.WithRapidCommit)
+			if err != nil {
+				return nil, err
+			}
+			resp.MessageType = dhcpv6.MessageTypeReply
+			return resp, nil
+		case rapidCommit != rapidCommitOff && req.GetOneOption(dhcpv6.OptionRapidCommit) != nil:
+			return dhcpv6.NewReplyFromMessage(req)
+		}
+		return dhcpv6.NewAdvertiseFromSolicit(req)
+	case dhcpv6.MessageTypeRequest, dhcpv6.MessageTypeConfirm, dhcpv6.MessageTypeRenew,
+		dhcpv6.MessageTypeRebind, dhcpv6.MessageTypeRelease, dhcpv6.MessageTypeInformationRequest:
+		return dhcpv6.NewReplyFromMessage(req)
+	default:
+		return nil, fmt.Errorf("message type %d not supported", req.Type())
 	}
+}
 
-	// type-cast the handlers
-	var handlersTyped []handlers.Handler
-	for _, handler := range handlersRaw.([]any) {
-		handlersTyped = append(handlersTyped, handler.(handlers.Handler))
+// chainError4 handles an error returned by the DHCPv4 handler chain.
+// It returns the reply to send, which is a negative reply if the error asks for one, or nil to drop the request.
+func (s *dhcpServer) chainError4(req, resp *dhcpv4.DHCPv4, err error) *dhcpv4.DHCPv4 {
+	var herr handlers.HandlerError
+	switch {
+	case errors.Is(err, handlers.ErrStopAndReply):
+		return resp
+	case errors.Is(err, handlers.ErrDrop):
+		s.logger.Debug("handler chain dropped request", zap.Error(err))
+		return nil
+	case errors.As(err, &herr) && herr.Nak:
+		if req.MessageType() != dhcpv4.MessageTypeRequest {
+			s.logger.Debug("handler chain rejected request", zap.Stringer("messageType", req.MessageType()), zap.Error(err))
+			return nil
+		}
+		nak, err := dhcpv4.NewReplyFromRequest(req,
+			dhcpv4.WithMessageType(dhcpv4.MessageTypeNak),
+			dhcpv4.WithOption(dhcpv4.OptMessage(herr.Message())),
+		)
+		if err != nil {
+			s.logger.Error("failed to build NAK", zap.Error(err))
+			return nil
+		}
+		if sid := resp.ServerIdentifier(); sid != nil {
+			nak.UpdateOption(dhcpv4.OptServerIdentifier(sid))
+		}
+		s.logger.Info("handler chain rejected request, sending NAK", zap.Error(err))
+		return nak
+	default:
+		s.logger.Error("handler chain failed", zap.Error(err))
+		return nil
 	}
+}
 
-	// create the handler chain
-	return handlerChain{handlers: handlersTyped}, nil
+// nakOptions4 are the options that a DHCPNAK may carry as per RFC 2131 section 4.3.1, table 3,
+// together with the relay agent information that must be echoed to the relay agent (RFC 3046 section 2.2).
+var nakOptions4 = []dhcpv4.OptionCode{
+	dhcpv4.OptionDHCPMessageType,
+	dhcpv4.OptionServerIdentifier,
+	dhcpv4.OptionMessage,
+	dhcpv4.OptionClientIdentifier,
+	dhcpv4.OptionClassIdentifier,
+	dhcpv4.OptionRelayAgentInformation,
 }
 
-// handlerChain calls a chain of handlers in reverse order.
-type handlerChain struct {
-	handlers []handlers.Handler
+// replyError4 handles a DHCPv4 request that no reply could be built for. It counts and logs the failure,
+// and returns a minimal DHCPNAK to send instead if the request is a DHCPREQUEST and such requests are rejected,
+// or else nil to drop the request. The DHCPNAK is built field by field, since building the reply failed,
+// and carries a server identifier derived from the listener, since no handler ran to set one.
+func (s *dhcpServer) replyError4(conn net.PacketConn, peer *net.UDPAddr, local packetInfo, req *dhcpv4.DHCPv4, err error) *dhcpv4.DHCPv4 {
+	if s.replyErrors != nil {
+		s.replyErrors.WithLabelValues("4").Inc()
+	}
+	s.logger.Error("failed to build reply",
+		zap.Stringer("peer", peer),
+		zap.Stringer("mac", req.ClientHWAddr),
+		zap.Stringer("messageType", req.MessageType()),
+		zap.Error(err),
+	)
+	if !s.rejectReplyErrors || req.MessageType() != dhcpv4.MessageTypeRequest {
+		return nil
+	}
+	nak := &dhcpv4.DHCPv4{
+		OpCode:        dhcpv4.OpcodeBootReply,
+		HWType:        req.HWType,
+		TransactionID: req.TransactionID,
+		Flags:         req.Flags,
+		ClientIPAddr:  net.IPv4zero,
+		YourIPAddr:    net.IPv4zero,
+		ServerIPAddr:  net.IPv4zero,
+		GatewayIPAddr: req.GatewayIPAddr,
+		ClientHWAddr:  req.ClientHWAddr,
+		Options:       dhcpv4.Options{},
+	}
+	nak.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeNak))
+	nak.UpdateOption(dhcpv4.OptMessage("failed to build reply"))
+	if opt := req.Options.Get(dhcpv4.OptionRelayAgentInformation); opt != nil {
+		nak.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionRelayAgentInformation, opt))
+	}
+	if opt := req.Options.Get(dhcpv4.OptionClientIdentifier); opt != nil {
+		nak.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionClientIdentifier, opt))
+	}
+	s.ensureServerID4(conn, local, nak)
+	return nak
 }
 
-func (c handlerChain) Handle4(req, resp handlers.DHCPv4, next func() error) error {
-	for i := len(c.handlers) - 1; i >= 0; i-- {
-		// copy the next handler (it's an interface, so it's just
-		// a very lightweight copy of a pointer); this is important
-		// because this is a closure to the func below, which
-		// re-assigns the value as it compiles the handler chain stack;
-		// if we don't make this copy, we'd affect the underlying
-		// pointer for all future request (yikes); we could
-		// alternatively solve this by moving the func below out of
-		// this closure and into a standalone package-level func,
-		// but I just thought this made more sense
-		nextCopy := next
-		next = func() error {
-			return c.handlers[i].Handle4(req, resp, nextCopy)
+// replyError6 handles a DHCPv6 request that no reply could be built for. It counts and logs the failure,
+// and returns a Reply, or an Advertise for a Solicit, with an UnspecFail status to send instead if such requests
+// are rejected, or else nil to drop the request. Messages that are not sent by clients are always dropped.
+// Like the DHCPv4 rejection, the reply carries a server identifier derived from the interface.
+func (s *dhcpServer) replyError6(peer *net.UDPAddr, local packetInfo, req *dhcpv6.Message, err error) *dhcpv6.Message {
+	if s.replyErrors != nil {
+		s.replyErrors.WithLabelValues("6").Inc()
+	}
+	s.logger.Error("failed to build reply",
+		zap.Stringer("peer", peer),
+		zap.Stringer("messageType", req.MessageType),
+		zap.Error(err),
+	)
+	if !s.rejectReplyErrors {
+		return nil
+	}
+	switch req.MessageType {
+	case dhcpv6.MessageTypeSolicit, dhcpv6.MessageTypeRequest, dhcpv6.MessageTypeConfirm, dhcpv6.MessageTypeRenew,
+		dhcpv6.MessageTypeRebind, dhcpv6.MessageTypeRelease, dhcpv6.MessageTypeDecline, dhcpv6.MessageTypeInformationRequest:
+	default:
+		return nil
+	}
+	reply := &dhcpv6.Message{
+		MessageType:   dhcpv6.MessageTypeReply,
+		TransactionID: req.TransactionID,
+	}
+	if req.MessageType == dhcpv6.MessageTypeSolicit {
+		reply.MessageType = dhcpv6.MessageTypeAdvertise
+	}
+	if cid := req.Options.ClientID(); cid != nil {
+		reply.AddOption(dhcpv6.OptClientID(cid))
+	}
+	reply.AddOption(&dhcpv6.OptStatusCode{StatusCode: iana.StatusUnspecFail, StatusMessage: "failed to build reply"})
+	s.ensureServerID6(reply)
+	return reply
+}
+
+// stripNak4 removes the configuration that handlers may have added to a reply before it became a DHCPNAK,
+// since a DHCPNAK carries no configuration and clients may otherwise apply it.
+func stripNak4(resp *dhcpv4.DHCPv4) {
+	options := make(dhcpv4.Options)
+	for _, code := range nakOptions4 {
+		if v := resp.Options.Get(code); v != nil {
+			options[code.Code()] = v
 		}
 	}
-	return next()
+	resp.Options = options
+	resp.ClientIPAddr = net.IPv4zero
+	resp.YourIPAddr = net.IPv4zero
+	resp.ServerIPAddr = net.IPv4zero
+	resp.ServerHostName = ""
+	resp.BootFileName = ""
 }
 
-func (c handlerChain) Handle6(req, resp handlers.DHCPv6, next func() error) error {
-	for i := len(c.handlers) - 1; i >= 0; i-- {
-		// copy the next handler (it's an interface, so it's just
-		// a very lightweight copy of a pointer); this is important
-		// because this is a closure to the func below, which
-		// re-assigns the value as it compiles the handler chain stack;
-		// if we don't make this copy, we'd affect the underlying
-		// pointer for all future request (yikes); we could
-		// alternatively solve this by moving the func below out of
-		// this closure and into a standalone package-level func,
-		// but I just thought this made more sense
-		nextCopy := next
-		next = func() error { return c.handlers[i].Handle6(req, resp, nextCopy) }
+// chainError6 handles an error returned by the DHCPv6 handler chain.
+// It returns the reply to send, which is a negative reply if the error asks for one, or nil to drop the request.
+func (s *dhcpServer) chainError6(req, resp *dhcpv6.Message, err error) *dhcpv6.Message {
+	var herr handlers.HandlerError
+	switch {
+	case errors.Is(err, handlers.ErrStopAndReply):
+		return resp
+	case errors.Is(err, handlers.ErrDrop):
+		s.logger.Debug("handler chain dropped request", zap.Error(err))
+		return nil
+	case errors.As(err, &herr) && herr.Nak:
+		nak, err := newReply6(req, s.rapidCommit)
+		if err != nil {
+			s.logger.Error("failed to build negative reply", zap.Error(err))
+			return nil
+		}
+		if sid := resp.Options.ServerID(); sid != nil {
+			nak.AddOption(dhcpv6.OptServerID(sid))
+		}
+		nak.AddOption(&dhcpv6.OptStatusCode{StatusCode: herr.Status(), StatusMessage: herr.Message()})
+		s.logger.Info("handler chain rejected request, sending negative reply", zap.Error(err))
+		return nak
+	default:
+		s.logger.Error("handler chain failed", zap.Error(err))
+		return nil
 	}
-	return next()
+}
+
+// jitter delays a reply by a random duration up to multicastJitter, if the request was received on a multicast address.
+func (s *dhcpServer) jitter(conn net.PacketConn) {
+	if s.multicastJitter <= 0 || s.sleep == nil {
+		return
+	}
+	local, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok || !local.IP.IsMulticast() {
+		return
+	}
+	s.sleep(rand.N(s.multicastJitter))
+}
+
+// sampledLogger returns a logger that only logs the first entry with a given message and level
+// in every interval, dropping the others.
+func sampledLogger(logger *zap.Logger, interval time.Duration) *zap.Logger {
+	return logger.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return zapcore.NewSamplerWithOptions(core, interval, 1, 0)
+	}))
+}
+
+// checkReplySize warns when a serialized reply exceeds the maximum size accepted by the client,
+// since such a reply is likely to be truncated or dropped on its way to the client.
+func (s *dhcpServer) checkReplySize(size, max int) {
+	if size > max {
+		s.logger.Warn("reply exceeds the maximum message size of the client", zap.Int("size", size), zap.Int("max", max))
+	}
+}
+
+// relayReply6 wraps the reply in a relay-reply message for every relay-forward message wrapped around
+// the request, so the reply traverses the same chain of relay agents back to the client.
+// As per RFC 8415 section 19.3, each relay-reply copies the hop count, link-address, peer-address and
+// Interface-Id option from the corresponding relay-forward message.
+func relayReply6(req dhcpv6.DHCPv6, resp *dhcpv6.Message) (dhcpv6.DHCPv6, error) {
+	var relays []*dhcpv6.RelayMessage
+	for req.IsRelay() {
+		relay := req.(*dhcpv6.RelayMessage)
+		if relay.MessageType != dhcpv6.MessageTypeRelayForward {
+			return nil, fmt.Errorf("expected a relay-forward message, got %s", relay.MessageType)
+		}
+		relays = append(relays, relay)
+		inner, err := dhcpv6.DecapsulateRelay(relay)
+		if err != nil {
+			return nil, err
+		}
+		req = inner
+	}
+
+	var reply dhcpv6.DHCPv6 = resp
+	for i := len(relays) - 1; i >= 0; i-- {
+		relay := relays[i]
+		encapsulated, err := dhcpv6.EncapsulateRelay(reply, dhcpv6.MessageTypeRelayReply, relay.LinkAddr, relay.PeerAddr)
+		if err != nil {
+			return nil, err
+		}
+		encapsulated.HopCount = relay.HopCount
+		if opt := relay.GetOneOption(dhcpv6.OptionInterfaceID); opt != nil {
+			encapsulated.AddOption(opt)
+		}
+		reply = encapsulated
+	}
+	return reply, nil
+}
+
+// summarizer is implemented by both DHCPv4 and DHCPv6 messages.
+type summarizer interface {
+	Summary() string
+}
+
+// debugSummary logs the summary of the given message at debug level.
+// Building a summary is expensive, so it is only done when debug logging is enabled.
+func (s *dhcpServer) debugSummary(msg string, m summarizer) {
+	if ce := s.logger.Check(zap.DebugLevel, msg); ce != nil {
+		ce.Write(zap.String("message", m.Summary()))
+	}
+}
+
+// compileHandlerChain sets up all the handlers by loading the handler modules and compiling them in a chain,
+// which ends with the default options if there are any.
+func compileHandlerChain(ctx caddy.Context, name string, s *Server, defaults *defaultOptions) (handlers.Handler, error) {
+	handlersRaw, err := handlers.LoadModule(ctx, s, "HandlersRaw")
+	if err != nil {
+		return nil, fmt.Errorf("loading handler modules: %v", err)
+	}
+
+	chain, err := newHandlerChain(name, handlersRaw, ctx.Logger())
+	if err != nil {
+		return nil, err
+	}
+
+	if s.ProfileHandlers {
+		durations, err := newHandlerDurations(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("registering handler metrics: %v", err)
+		}
+		chain = profileHandlers(name, chain, durations)
+	}
+
+	if defaults != nil {
+		chain = append(chain, defaults)
+	}
+	return chain, nil
+}
+
+// newHandlerChain returns the chain of the handler modules loaded for the server with the given name.
+// A server without handlers is valid, but a warning is logged since it replies to every request
+// without assigning anything.
+func newHandlerChain(name string, loaded any, logger *zap.Logger) (handlers.Chain, error) {
+	chain, err := handlers.NewChain(loaded)
+	if err != nil {
+		return nil, fmt.Errorf("server %s: %v", name, err)
+	}
+	if len(chain) == 0 {
+		logger.Warn("server has no handlers, it will reply to every request with only the default options", zap.String("server", name))
+	}
+	return chain, nil
 }
 
 // Interfaces guards
 var (
 	_ caddy.App         = (*App)(nil)
 	_ caddy.Provisioner = (*App)(nil)
-
-	_ handlers.Handler = (*handlerChain)(nil)
 )
@@ -0,0 +1,1183 @@
+package caddydhcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"golang.org/x/sys/unix"
+)
+
+// testConn is a net.PacketConn that records all written packets.
+// Reading returns the queued reads, followed by net.ErrClosed.
+type testConn struct {
+	net.PacketConn
+
+	local   net.Addr
+	mu      sync.Mutex
+	reads   [][]byte
+	packets [][]byte
+	addrs   []net.Addr
+}
+
+func (c *testConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.reads) == 0 {
+		return 0, nil, net.ErrClosed
+	}
+	n := copy(p, c.reads[0])
+	c.reads = c.reads[1:]
+	return n, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: dhcpv4.ClientPort}, nil
+}
+
+func (c *testConn) Close() error {
+	return nil
+}
+
+func (c *testConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *testConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.packets = append(c.packets, append([]byte(nil), p...))
+	c.addrs = append(c.addrs, addr)
+	return len(p), nil
+}
+
+func newTestLogger(level zapcore.Level) *zap.Logger {
+	encoder := zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig())
+	return zap.New(zapcore.NewCore(encoder, zapcore.AddSync(io.Discard), level))
+}
+
+// testHandler is a handlers.Handler calling the given functions before continuing the chain.
+type testHandler struct {
+	handle4 func(req, resp handlers.DHCPv4)
+	handle6 func(req, resp handlers.DHCPv6)
+}
+
+func (h testHandler) Handle4(req, resp handlers.DHCPv4, next func() error) error {
+	if h.handle4 != nil {
+		h.handle4(req, resp)
+	}
+	return next()
+}
+
+func (h testHandler) Handle6(req, resp handlers.DHCPv6, next func() error) error {
+	if h.handle6 != nil {
+		h.handle6(req, resp)
+	}
+	return next()
+}
+
+func TestDefaultAddresses(t *testing.T) {
+	for _, tc := range []struct {
+		family    string
+		multicast bool
+		want      []string
+	}{
+		{"", true, []string{"udp4/:67", "udp6/:547", "udp6/[ff02::1:2]:547", "udp6/[ff05::1:3]:547"}},
+		{familyBoth, true, []string{"udp4/:67", "udp6/:547", "udp6/[ff02::1:2]:547", "udp6/[ff05::1:3]:547"}},
+		{familyIPv4, true, []string{"udp4/:67"}},
+		{familyIPv6, true, []string{"udp6/:547", "udp6/[ff02::1:2]:547", "udp6/[ff05::1:3]:547"}},
+		{familyBoth, false, []string{"udp4/:67", "udp6/:547"}},
+		{familyIPv4, false, []string{"udp4/:67"}},
+		{familyIPv6, false, []string{"udp6/:547"}},
+	} {
+		t.Run(fmt.Sprintf("%s multicast=%t", tc.family, tc.multicast), func(t *testing.T) {
+			var got []string
+			for _, addr := range defaultAddresses(tc.family, tc.multicast) {
+				got = append(got, addr.String())
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestProvisionFamily(t *testing.T) {
+	for _, srv := range []*Server{
+		{Family: "ipv5"},
+		{Family: familyIPv4, Listen: []string{"udp6/:547"}},
+		{Family: familyIPv6, Listen: []string{"udp4/:67"}},
+		{Listen: []string{"udp4/[2001:db8::1]:67"}},
+		{Listen: []string{"udp6/10.0.0.1:547"}},
+		{Listen: []string{"udp/10.0.0.1:67"}},
+	} {
+		app := &App{Servers: map[string]*Server{"srv0": srv}}
+		assert.Error(t, app.Provision(caddy.Context{}), srv.Listen)
+	}
+}
+
+func TestCompileHandlerChain(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	// the handlers of a server are configured as raw JSON, like every nested module
+	srv := &Server{HandlersRaw: []json.RawMessage{json.RawMessage(`{"handler": "dns", "servers": ["10.0.0.53"]}`)}}
+	chain, err := compileHandlerChain(ctx, "srv0", srv, nil)
+	require.NoError(t, err)
+
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, dhcpv4.WithRequestedOptions(dhcpv4.OptionDomainNameServer))
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	require.NoError(t, chain.Handle4(handlers.NewDHCPv4(req), handlers.DHCPv4{DHCPv4: resp}, func() error { return nil }))
+	assert.Equal(t, []net.IP{net.IPv4(10, 0, 0, 53).To4()}, resp.DNS())
+}
+
+func TestParseListenAddress(t *testing.T) {
+	for _, tc := range []struct {
+		address string
+		want    string
+	}{
+		{"udp4/10.0.0.1", "udp4/10.0.0.1:67"},
+		{"udp4/", "udp4/:67"},
+		{"udp4/10.0.0.1:6767", "udp4/10.0.0.1:6767"},
+		{"udp4/localhost", "udp4/localhost:67"},
+		{"udp6/2001:db8::1", "udp6/[2001:db8::1]:547"},
+		{"udp6/[ff02::1:2]", "udp6/[ff02::1:2]:547"},
+		{"udp6/[2001:db8::1]:5547", "udp6/[2001:db8::1]:5547"},
+	} {
+		addr, err := parseListenAddress(tc.address)
+		require.NoError(t, err, tc.address)
+		assert.Equal(t, tc.want, addr.String(), tc.address)
+	}
+
+	for _, address := range []string{
+		"udp4/2001:db8::1",
+		"udp4/[::]:67",
+		"udp6/10.0.0.1",
+		"udp6/0.0.0.0:547",
+		"tcp/10.0.0.1:67",
+		"udp/:67",
+	} {
+		_, err := parseListenAddress(address)
+		assert.Error(t, err, address)
+	}
+}
+
+func TestReplyAddr4(t *testing.T) {
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	yiaddr := net.IPv4(10, 0, 0, 10)
+	unspecified := &net.UDPAddr{IP: net.IPv4zero, Port: dhcpv4.ClientPort}
+	broadcast := &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}
+
+	var arpEntries []string
+	s := &dhcpServer{
+		iface:  "eth0",
+		logger: zap.NewNop(),
+		arp: func(iface string, ip net.IP, hwaddr net.HardwareAddr) error {
+			arpEntries = append(arpEntries, iface+" "+ip.String()+" "+hwaddr.String())
+			return nil
+		},
+	}
+
+	newExchange := func(broadcastFlag bool) (*dhcpv4.DHCPv4, *dhcpv4.DHCPv4) {
+		req, err := dhcpv4.NewDiscovery(mac, dhcpv4.WithBroadcast(broadcastFlag))
+		require.NoError(t, err)
+		resp, err := dhcpv4.NewReplyFromRequest(req, dhcpv4.WithYourIP(yiaddr))
+		require.NoError(t, err)
+		return req, resp
+	}
+
+	t.Run("broadcast flag set", func(t *testing.T) {
+		arpEntries = nil
+		req, resp := newExchange(true)
+		assert.Equal(t, broadcast, s.replyAddr4(req, resp, unspecified))
+		assert.Empty(t, arpEntries)
+	})
+
+	t.Run("unicast capable client", func(t *testing.T) {
+		arpEntries = nil
+		req, resp := newExchange(false)
+		assert.Equal(t, &net.UDPAddr{IP: yiaddr, Port: dhcpv4.ClientPort}, s.replyAddr4(req, resp, unspecified))
+		assert.Equal(t, []string{"eth0 10.0.0.10 02:00:00:00:00:01"}, arpEntries)
+	})
+
+	t.Run("unicast capable client without address", func(t *testing.T) {
+		req, resp := newExchange(false)
+		resp.YourIPAddr = net.IPv4zero
+		assert.Equal(t, broadcast, s.replyAddr4(req, resp, unspecified))
+	})
+
+	t.Run("unicast capable client without interface", func(t *testing.T) {
+		req, resp := newExchange(false)
+		s := &dhcpServer{logger: zap.NewNop(), arp: s.arp}
+		assert.Equal(t, broadcast, s.replyAddr4(req, resp, unspecified))
+	})
+
+	t.Run("arp failure", func(t *testing.T) {
+		req, resp := newExchange(false)
+		s := &dhcpServer{iface: "eth0", logger: zap.NewNop(), arp: func(string, net.IP, net.HardwareAddr) error {
+			return errors.New("operation not permitted")
+		}}
+		assert.Equal(t, broadcast, s.replyAddr4(req, resp, unspecified))
+	})
+
+	t.Run("client with address", func(t *testing.T) {
+		req, resp := newExchange(true)
+		peer := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 10), Port: dhcpv4.ClientPort}
+		assert.Equal(t, peer, s.replyAddr4(req, resp, peer))
+	})
+
+	t.Run("renewing client", func(t *testing.T) {
+		ciaddr := net.IPv4(10, 0, 0, 20)
+		for _, tc := range []struct {
+			name  string
+			peer  *net.UDPAddr
+			reply dhcpv4.MessageType
+			want  *net.UDPAddr
+		}{
+			// a renewing client unicasts its request from its address
+			{"unicast from ciaddr", &net.UDPAddr{IP: ciaddr, Port: dhcpv4.ClientPort}, dhcpv4.MessageTypeAck, &net.UDPAddr{IP: ciaddr, Port: dhcpv4.ClientPort}},
+			{"unicast from another port", &net.UDPAddr{IP: ciaddr, Port: 6868}, dhcpv4.MessageTypeAck, &net.UDPAddr{IP: ciaddr, Port: 6868}},
+			{"from another address", &net.UDPAddr{IP: net.IPv4(10, 0, 0, 30), Port: 6868}, dhcpv4.MessageTypeAck, &net.UDPAddr{IP: ciaddr, Port: dhcpv4.ClientPort}},
+			{"unspecified peer", unspecified, dhcpv4.MessageTypeAck, &net.UDPAddr{IP: ciaddr, Port: dhcpv4.ClientPort}},
+			{"nak", &net.UDPAddr{IP: ciaddr, Port: dhcpv4.ClientPort}, dhcpv4.MessageTypeNak, broadcast},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				arpEntries = nil
+				req, resp := newExchange(false)
+				req.ClientIPAddr = ciaddr
+				resp.UpdateOption(dhcpv4.OptMessageType(tc.reply))
+				assert.Equal(t, tc.want, s.replyAddr4(req, resp, tc.peer))
+				assert.Empty(t, arpEntries)
+			})
+		}
+	})
+
+	t.Run("relayed", func(t *testing.T) {
+		giaddr := net.IPv4(10, 0, 1, 1)
+		// the relay agent may forward the request from another address and port than giaddr
+		relay := &net.UDPAddr{IP: net.IPv4(192, 168, 0, 1), Port: 1067}
+		want := &net.UDPAddr{IP: giaddr, Port: dhcpv4.ServerPort}
+		for _, tc := range []struct {
+			name      string
+			broadcast bool
+			peer      *net.UDPAddr
+			reply     dhcpv4.MessageType
+		}{
+			{"broadcast flag set", true, relay, dhcpv4.MessageTypeOffer},
+			{"unicast capable client", false, relay, dhcpv4.MessageTypeOffer},
+			{"unspecified peer", true, unspecified, dhcpv4.MessageTypeOffer},
+			{"nak", true, relay, dhcpv4.MessageTypeNak},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				arpEntries = nil
+				req, resp := newExchange(tc.broadcast)
+				req.GatewayIPAddr = giaddr
+				resp.UpdateOption(dhcpv4.OptMessageType(tc.reply))
+				assert.Equal(t, want, s.replyAddr4(req, resp, tc.peer))
+				assert.Empty(t, arpEntries)
+			})
+		}
+	})
+
+	t.Run("local nak", func(t *testing.T) {
+		req, resp := newExchange(false)
+		resp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeNak))
+		assert.Equal(t, broadcast, s.replyAddr4(req, resp, unspecified))
+	})
+
+	t.Run("relay only", func(t *testing.T) {
+		s := &dhcpServer{iface: "eth0", logger: zap.NewNop(), arp: s.arp, relayOnly: true}
+		giaddr := net.IPv4(10, 0, 1, 1)
+		ciaddr := net.IPv4(10, 0, 0, 20)
+		peer := &net.UDPAddr{IP: ciaddr, Port: dhcpv4.ClientPort}
+		for _, tc := range []struct {
+			name      string
+			broadcast bool
+			giaddr    net.IP
+			ciaddr    net.IP
+			reply     dhcpv4.MessageType
+			want      *net.UDPAddr
+		}{
+			{"relayed", true, giaddr, nil, dhcpv4.MessageTypeOffer, &net.UDPAddr{IP: giaddr, Port: dhcpv4.ServerPort}},
+			{"client with address", true, nil, ciaddr, dhcpv4.MessageTypeAck, peer},
+			{"nak to client with address", false, nil, ciaddr, dhcpv4.MessageTypeNak, peer},
+			{"broadcast flag set", true, nil, nil, dhcpv4.MessageTypeOffer, nil},
+			{"unicast capable client", false, nil, nil, dhcpv4.MessageTypeOffer, nil},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				arpEntries = nil
+				req, resp := newExchange(tc.broadcast)
+				req.GatewayIPAddr = tc.giaddr
+				req.ClientIPAddr = tc.ciaddr
+				resp.UpdateOption(dhcpv4.OptMessageType(tc.reply))
+				assert.Equal(t, tc.want, s.replyAddr4(req, resp, unspecified))
+				assert.Empty(t, arpEntries)
+			})
+		}
+	})
+}
+
+func TestHandle4Renew(t *testing.T) {
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	ciaddr := net.IPv4(10, 0, 0, 20).To4()
+	s := &dhcpServer{
+		handler: handlers.Chain{testHandler{handle4: func(req, resp handlers.DHCPv4) {
+			resp.YourIPAddr = req.ClientIPAddr
+		}}},
+		logger: zap.NewNop(),
+	}
+
+	// a client in the RENEWING state unicasts a DHCPREQUEST from the address in ciaddr,
+	// and a client in the REBINDING state broadcasts it, both get a unicast DHCPACK at ciaddr
+	for name, peer := range map[string]*net.UDPAddr{
+		"renewing":  {IP: ciaddr, Port: dhcpv4.ClientPort},
+		"rebinding": {IP: net.IPv4zero, Port: dhcpv4.ClientPort},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req, err := dhcpv4.New(dhcpv4.WithHwAddr(mac), dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest), dhcpv4.WithClientIP(ciaddr))
+			require.NoError(t, err)
+			conn := &testConn{}
+			s.handle4(conn, peer, packetInfo{}, req)
+			require.Len(t, conn.packets, 1)
+			assert.Equal(t, &net.UDPAddr{IP: ciaddr, Port: dhcpv4.ClientPort}, conn.addrs[0])
+			ack, err := dhcpv4.FromBytes(conn.packets[0])
+			require.NoError(t, err)
+			assert.Equal(t, dhcpv4.MessageTypeAck, ack.MessageType())
+			assert.True(t, ack.YourIPAddr.Equal(ciaddr))
+		})
+	}
+}
+
+func TestARPReqSize(t *testing.T) {
+	// struct arpreq is 68 bytes on Linux
+	assert.Equal(t, uintptr(68), unsafe.Sizeof(arpReq{}))
+}
+
+func TestRelayReply6(t *testing.T) {
+	req, err := dhcpv6.NewMessage(dhcpv6.WithClientID(&dhcpv6.DUIDLL{
+		HWType:        iana.HWTypeEthernet,
+		LinkLayerAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+	}))
+	require.NoError(t, err)
+	req.MessageType = dhcpv6.MessageTypeRequest
+	resp, err := dhcpv6.NewReplyFromMessage(req)
+	require.NoError(t, err)
+
+	// the first relay agent is closest to the client, the second relays to the server
+	first, err := dhcpv6.EncapsulateRelay(req, dhcpv6.MessageTypeRelayForward, net.ParseIP("2001:db8:1::1"), net.ParseIP("fe80::1"))
+	require.NoError(t, err)
+	first.AddOption(dhcpv6.OptInterfaceID([]byte("eth1")))
+	second, err := dhcpv6.EncapsulateRelay(first, dhcpv6.MessageTypeRelayForward, net.ParseIP("2001:db8:2::1"), net.ParseIP("2001:db8:1::1"))
+	require.NoError(t, err)
+	second.AddOption(dhcpv6.OptInterfaceID([]byte("eth2")))
+
+	// round-trip through the wire format like a received request
+	relayed, err := dhcpv6.FromBytes(second.ToBytes())
+	require.NoError(t, err)
+
+	reply, err := relayReply6(relayed, resp)
+	require.NoError(t, err)
+
+	outer, ok := reply.(*dhcpv6.RelayMessage)
+	require.True(t, ok)
+	assert.Equal(t, dhcpv6.MessageTypeRelayReply, outer.MessageType)
+	assert.Equal(t, second.HopCount, outer.HopCount)
+	assert.True(t, second.LinkAddr.Equal(outer.LinkAddr))
+	assert.True(t, second.PeerAddr.Equal(outer.PeerAddr))
+	assert.Equal(t, []byte("eth2"), outer.Options.InterfaceID())
+
+	decapsulated, err := dhcpv6.DecapsulateRelay(outer)
+	require.NoError(t, err)
+	inner, ok := decapsulated.(*dhcpv6.RelayMessage)
+	require.True(t, ok)
+	assert.Equal(t, dhcpv6.MessageTypeRelayReply, inner.MessageType)
+	assert.Equal(t, first.HopCount, inner.HopCount)
+	assert.True(t, first.LinkAddr.Equal(inner.LinkAddr))
+	assert.True(t, first.PeerAddr.Equal(inner.PeerAddr))
+	assert.Equal(t, []byte("eth1"), inner.Options.InterfaceID())
+
+	msg, err := reply.GetInnerMessage()
+	require.NoError(t, err)
+	assert.Equal(t, resp, msg)
+}
+
+func TestReplyAddr6(t *testing.T) {
+	req, err := dhcpv6.NewMessage(dhcpv6.WithClientID(&dhcpv6.DUIDLL{
+		HWType:        iana.HWTypeEthernet,
+		LinkLayerAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+	}))
+	require.NoError(t, err)
+	req.MessageType = dhcpv6.MessageTypeSolicit
+	relayed, err := dhcpv6.EncapsulateRelay(req, dhcpv6.MessageTypeRelayForward, net.ParseIP("2001:db8:1::1"), net.ParseIP("fe80::1"))
+	require.NoError(t, err)
+	relayPort, err := dhcpv6.EncapsulateRelay(req, dhcpv6.MessageTypeRelayForward, net.ParseIP("2001:db8:1::1"), net.ParseIP("fe80::1"))
+	require.NoError(t, err)
+	relayPort.AddOption(dhcpv6.OptRelayPort(10547))
+
+	client := &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: 40000, Zone: "eth0"}
+	relay := &net.UDPAddr{IP: net.ParseIP("2001:db8:1::1"), Port: 10547}
+
+	for _, tc := range []struct {
+		name          string
+		replyToSource bool
+		m             dhcpv6.DHCPv6
+		peer          *net.UDPAddr
+		want          *net.UDPAddr
+	}{
+		{"direct", false, req, client, &net.UDPAddr{IP: client.IP, Port: dhcpv6.DefaultClientPort, Zone: "eth0"}},
+		{"relayed", false, relayed, relay, &net.UDPAddr{IP: relay.IP, Port: dhcpv6.DefaultServerPort}},
+		{"relay source port", false, relayPort, relay, relay},
+		{"direct to source", true, req, client, client},
+		{"relayed to source", true, relayed, relay, relay},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &dhcpServer{replyToSource: tc.replyToSource}
+			assert.Equal(t, tc.want, s.replyAddr6(tc.m, tc.peer))
+		})
+	}
+
+	t.Run("handle6", func(t *testing.T) {
+		s := &dhcpServer{handler: handlers.Chain{}, logger: zap.NewNop()}
+		for _, tc := range []struct {
+			m    dhcpv6.DHCPv6
+			peer *net.UDPAddr
+			port int
+		}{
+			{req, client, dhcpv6.DefaultClientPort},
+			{relayed, relay, dhcpv6.DefaultServerPort},
+		} {
+			conn := &testConn{}
+			s.handle6(conn, tc.peer, packetInfo{}, tc.m, nil)
+			require.Len(t, conn.addrs, 1)
+			assert.Equal(t, tc.port, conn.addrs[0].(*net.UDPAddr).Port)
+		}
+	})
+}
+
+func TestReplySize(t *testing.T) {
+	// a reply carrying 1000 bytes of options exceeds the default maximum message size
+	large := testHandler{
+		handle4: func(req, resp handlers.DHCPv4) {
+			resp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, make([]byte, 1000)))
+		},
+		handle6: func(req, resp handlers.DHCPv6) {
+			resp.AddOption(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionVendorOpts, OptionData: make([]byte, 1300)})
+		},
+	}
+	// a reply carrying a large relay agent information option cannot be made to fit, since that option is critical
+	critical := testHandler{
+		handle4: func(req, resp handlers.DHCPv4) {
+			var subOptions []dhcpv4.Option
+			for code := uint8(1); code < 5; code++ {
+				subOptions = append(subOptions, dhcpv4.OptGeneric(dhcpv4.GenericOptionCode(code), make([]byte, 250)))
+			}
+			resp.UpdateOption(dhcpv4.OptRelayAgentInfo(subOptions...))
+		},
+	}
+	// a reply carrying many smaller options can be made to fit using option overload
+	many := testHandler{
+		handle4: func(req, resp handlers.DHCPv4) {
+			for code := uint8(200); code < 210; code++ {
+				resp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.GenericOptionCode(code), make([]byte, 40)))
+			}
+		},
+	}
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+
+	for _, tc := range []struct {
+		name    string
+		handler handlers.Handler
+		maxSize uint16
+		removed bool
+		warned  bool
+	}{
+		{"small", testHandler{}, 0, false, false},
+		{"large", large, 0, true, false},
+		{"critical", critical, 0, false, true},
+		{"overloaded", many, 0, false, false},
+		{"large with max message size", large, 1500, false, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := observer.New(zapcore.WarnLevel)
+			s := &dhcpServer{handler: handlers.Chain{tc.handler}, logger: zap.New(core)}
+
+			req, err := dhcpv4.NewDiscovery(mac)
+			require.NoError(t, err)
+			if tc.maxSize != 0 {
+				req.UpdateOption(dhcpv4.OptMaxMessageSize(tc.maxSize))
+			}
+			conn := &testConn{}
+			s.handle4(conn, &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}, packetInfo{}, req)
+			require.Len(t, conn.packets, 1)
+			assert.Equal(t, tc.removed, logs.FilterMessage("removed options to fit the maximum message size of the client").Len() == 1)
+			assert.Equal(t, tc.warned, logs.FilterMessage("reply exceeds the maximum message size of the client").Len() == 1)
+			assert.Equal(t, tc.warned, len(conn.packets[0]) > int(max(tc.maxSize, 576))-28)
+		})
+	}
+
+	t.Run("v6", func(t *testing.T) {
+		core, logs := observer.New(zapcore.WarnLevel)
+		s := &dhcpServer{handler: handlers.Chain{large}, logger: zap.New(core)}
+
+		req, err := dhcpv6.NewMessage(dhcpv6.WithClientID(&dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: mac}))
+		require.NoError(t, err)
+		req.MessageType = dhcpv6.MessageTypeRequest
+		conn := &testConn{}
+		s.handle6(conn, &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: dhcpv6.DefaultClientPort}, packetInfo{}, req, nil)
+		require.Len(t, conn.packets, 1)
+		assert.Equal(t, 1, logs.FilterMessage("reply exceeds the maximum message size of the client").Len())
+	})
+}
+
+func TestParseErrors(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	parseErrors, err := newParseErrors(caddy.Context{})
+	require.NoError(t, err)
+	s := &dhcpServer{
+		handler:       handlers.Chain{},
+		logger:        zap.New(core),
+		parseErrors:   parseErrors.MustCurryWith(prometheus.Labels{"server": "srv0"}),
+		parseErrorLog: sampledLogger(zap.New(core), time.Minute),
+	}
+
+	conn := &testConn{}
+	for i := 0; i < 100; i++ {
+		conn.reads = append(conn.reads, []byte("not a DHCP message"))
+	}
+	assert.ErrorIs(t, s.serve4(conn), net.ErrClosed)
+
+	// every error is counted, but only the first one is logged
+	var m dto.Metric
+	require.NoError(t, parseErrors.WithLabelValues("srv0", "4").Write(&m))
+	assert.Equal(t, float64(100), m.GetCounter().GetValue())
+	assert.Equal(t, 1, logs.FilterMessage("error parsing DHCPv4 request").Len())
+}
+
+func TestReadTruncation(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	b := req.ToBytes()
+
+	for _, tc := range []struct {
+		name           string
+		readBufferSize int
+		warned         bool
+	}{
+		{"fits", len(b) + 1, false},
+		{"fills buffer", len(b), true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := observer.New(zapcore.WarnLevel)
+			s := &dhcpServer{logger: zap.New(core), readBufferSize: tc.readBufferSize}
+
+			conn := &testConn{reads: [][]byte{b}}
+			read, _, _, err := s.read(conn)
+			require.NoError(t, err)
+			assert.Equal(t, b, read)
+			assert.Equal(t, tc.warned, logs.Len() == 1)
+		})
+	}
+}
+
+func benchmarkHandle4(b *testing.B, level zapcore.Level) {
+	s := &dhcpServer{
+		handler: handlers.Chain{},
+		logger:  newTestLogger(level),
+	}
+	conn := &testConn{}
+	peer := &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.handle4(conn, peer, packetInfo{}, req)
+		conn.packets, conn.addrs = nil, nil
+	}
+}
+
+func BenchmarkHandle4DebugDisabled(b *testing.B) {
+	benchmarkHandle4(b, zapcore.InfoLevel)
+}
+
+func BenchmarkHandle4DebugEnabled(b *testing.B) {
+	benchmarkHandle4(b, zapcore.DebugLevel)
+}
+
+func TestMulticastJitter(t *testing.T) {
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	peer := &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: dhcpv6.DefaultClientPort}
+	multicast := &net.UDPAddr{IP: dhcpv6.AllDHCPRelayAgentsAndServers, Port: dhcpv6.DefaultServerPort}
+	unicast := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: dhcpv6.DefaultServerPort}
+	jitter := 100 * time.Millisecond
+
+	for _, tc := range []struct {
+		name        string
+		jitter      time.Duration
+		local       net.Addr
+		messageType dhcpv6.MessageType
+		delayed     bool
+	}{
+		{"multicast solicit", jitter, multicast, dhcpv6.MessageTypeSolicit, true},
+		{"multicast request", jitter, multicast, dhcpv6.MessageTypeRequest, false},
+		{"unicast solicit", jitter, unicast, dhcpv6.MessageTypeSolicit, false},
+		{"disabled", 0, multicast, dhcpv6.MessageTypeSolicit, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var delays []time.Duration
+			s := &dhcpServer{
+				handler:         handlers.Chain{},
+				logger:          zap.NewNop(),
+				multicastJitter: tc.jitter,
+				sleep:           func(d time.Duration) { delays = append(delays, d) },
+			}
+			conn := &testConn{local: tc.local}
+			for i := 0; i < 20; i++ {
+				req, err := dhcpv6.NewMessage(dhcpv6.WithClientID(&dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: mac}))
+				require.NoError(t, err)
+				req.MessageType = tc.messageType
+				s.handle6(conn, peer, packetInfo{}, req, nil)
+			}
+			require.Len(t, conn.packets, 20)
+			if !tc.delayed {
+				assert.Empty(t, delays)
+				return
+			}
+			require.Len(t, delays, 20)
+			for _, d := range delays {
+				assert.GreaterOrEqual(t, d, time.Duration(0))
+				assert.Less(t, d, jitter)
+			}
+		})
+	}
+}
+
+func TestRapidCommit(t *testing.T) {
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	peer := &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: dhcpv6.DefaultClientPort}
+
+	for _, tc := range []struct {
+		policy      string
+		rapidCommit bool
+		want        dhcpv6.MessageType
+	}{
+		{"", false, dhcpv6.MessageTypeAdvertise},
+		{"", true, dhcpv6.MessageTypeReply},
+		{rapidCommitHonor, false, dhcpv6.MessageTypeAdvertise},
+		{rapidCommitHonor, true, dhcpv6.MessageTypeReply},
+		{rapidCommitOff, false, dhcpv6.MessageTypeAdvertise},
+		{rapidCommitOff, true, dhcpv6.MessageTypeAdvertise},
+		{rapidCommitForce, false, dhcpv6.MessageTypeReply},
+		{rapidCommitForce, true, dhcpv6.MessageTypeReply},
+	} {
+		t.Run(fmt.Sprintf("%s/%t", tc.policy, tc.rapidCommit), func(t *testing.T) {
+			s := &dhcpServer{
+				handler:     handlers.Chain{},
+				logger:      zap.NewNop(),
+				rapidCommit: tc.policy,
+			}
+			conn := &testConn{}
+			req, err := dhcpv6.NewSolicit(mac)
+			require.NoError(t, err)
+			if tc.rapidCommit {
+				dhcpv6.WithRapidCommit(req)
+			}
+			s.handle6(conn, peer, packetInfo{}, req, nil)
+
+			require.Len(t, conn.packets, 1)
+			resp, err := dhcpv6.MessageFromBytes(conn.packets[0])
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, resp.Type())
+			// a Reply to a Solicit always includes the Rapid Commit option
+			assert.Equal(t, tc.want == dhcpv6.MessageTypeReply, resp.GetOneOption(dhcpv6.OptionRapidCommit) != nil)
+		})
+	}
+}
+
+func TestProvisionRapidCommit(t *testing.T) {
+	app := &App{Servers: map[string]*Server{"srv0": {RapidCommit: "always"}}}
+	assert.Error(t, app.Provision(caddy.Context{}))
+}
+
+func TestProvisionReplyPort6(t *testing.T) {
+	app := &App{Servers: map[string]*Server{"srv0": {ReplyPort6: "client"}}}
+	assert.Error(t, app.Provision(caddy.Context{}))
+}
+
+func TestHandlerError(t *testing.T) {
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	// the server identifier set earlier in the chain is retained in negative replies
+	serverID := testHandler{
+		handle4: func(req, resp handlers.DHCPv4) {
+			resp.UpdateOption(dhcpv4.OptServerIdentifier(net.IPv4(10, 0, 0, 1)))
+			resp.YourIPAddr = net.IPv4(10, 0, 0, 10)
+		},
+		handle6: func(req, resp handlers.DHCPv6) {
+			resp.AddOption(dhcpv6.OptServerID(&dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: mac}))
+		},
+	}
+	chain := func(err error) handlers.Chain {
+		return handlers.Chain{serverID, errorHandler{err: err}}
+	}
+
+	t.Run("drop", func(t *testing.T) {
+		core, logs := observer.New(zapcore.WarnLevel)
+		s := &dhcpServer{handler: chain(handlers.ErrDrop), logger: zap.New(core)}
+		conn := &testConn{}
+
+		req, err := dhcpv4.NewDiscovery(mac)
+		require.NoError(t, err)
+		s.handle4(conn, &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}, packetInfo{}, req)
+		req6, err := dhcpv6.NewSolicit(mac)
+		require.NoError(t, err)
+		s.handle6(conn, &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: dhcpv6.DefaultClientPort}, packetInfo{}, req6, nil)
+
+		assert.Empty(t, conn.packets)
+		assert.Zero(t, logs.Len())
+	})
+
+	t.Run("failure", func(t *testing.T) {
+		core, logs := observer.New(zapcore.WarnLevel)
+		s := &dhcpServer{handler: chain(errors.New("boom")), logger: zap.New(core)}
+		conn := &testConn{}
+
+		req, err := dhcpv4.NewDiscovery(mac)
+		require.NoError(t, err)
+		s.handle4(conn, &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}, packetInfo{}, req)
+
+		assert.Empty(t, conn.packets)
+		assert.Equal(t, 1, logs.FilterMessage("handler chain failed").Len())
+	})
+
+	t.Run("NAK", func(t *testing.T) {
+		s := &dhcpServer{handler: chain(handlers.Nak(errors.New("address not available"))), logger: zap.NewNop()}
+		conn := &testConn{}
+
+		// a DHCPDISCOVER cannot be NAKed, so it is dropped
+		req, err := dhcpv4.NewDiscovery(mac)
+		require.NoError(t, err)
+		s.handle4(conn, &net.UDPAddr{IP: net.IPv4zero, Port: dhcpv4.ClientPort}, packetInfo{}, req)
+		assert.Empty(t, conn.packets)
+
+		// a renewing client gets a broadcast DHCPNAK
+		req, err = dhcpv4.New(dhcpv4.WithHwAddr(mac), dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest))
+		require.NoError(t, err)
+		s.handle4(conn, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 20), Port: dhcpv4.ClientPort}, packetInfo{}, req)
+		require.Len(t, conn.packets, 1)
+		assert.Equal(t, &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}, conn.addrs[0])
+		nak, err := dhcpv4.FromBytes(conn.packets[0])
+		require.NoError(t, err)
+		assert.Equal(t, dhcpv4.MessageTypeNak, nak.MessageType())
+		assert.Equal(t, "address not available", nak.Message())
+		assert.True(t, nak.ServerIdentifier().Equal(net.IPv4(10, 0, 0, 1)))
+		assert.True(t, nak.YourIPAddr.IsUnspecified())
+	})
+
+	t.Run("negative reply v6", func(t *testing.T) {
+		herr := handlers.Nak(errors.New("no addresses available"))
+		herr.StatusCode = iana.StatusNoAddrsAvail
+		s := &dhcpServer{handler: chain(herr), logger: zap.NewNop()}
+		conn := &testConn{}
+
+		req, err := dhcpv6.NewSolicit(mac)
+		require.NoError(t, err)
+		s.handle6(conn, &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: dhcpv6.DefaultClientPort}, packetInfo{}, req, nil)
+		require.Len(t, conn.packets, 1)
+		msg, err := dhcpv6.MessageFromBytes(conn.packets[0])
+		require.NoError(t, err)
+		assert.Equal(t, dhcpv6.MessageTypeAdvertise, msg.Type())
+		assert.NotNil(t, msg.Options.ServerID())
+		status := msg.Options.Status()
+		require.NotNil(t, status)
+		assert.Equal(t, iana.StatusNoAddrsAvail, status.StatusCode)
+		assert.Equal(t, "no addresses available", status.StatusMessage)
+	})
+}
+
+// errorHandler is a handlers.Handler returning the given error.
+type errorHandler struct {
+	err error
+}
+
+func (h errorHandler) Handle4(_, _ handlers.DHCPv4, _ func() error) error {
+	return h.err
+}
+
+func (h errorHandler) Handle6(_, _ handlers.DHCPv6, _ func() error) error {
+	return h.err
+}
+
+func TestDeclineRelease4(t *testing.T) {
+	for _, mt := range []dhcpv4.MessageType{dhcpv4.MessageTypeDecline, dhcpv4.MessageTypeRelease} {
+		t.Run(mt.String(), func(t *testing.T) {
+			var handled []dhcpv4.MessageType
+			record := testHandler{handle4: func(req, resp handlers.DHCPv4) {
+				handled = append(handled, req.MessageType(), resp.MessageType())
+			}}
+			s := &dhcpServer{handler: handlers.Chain{record}, logger: zap.NewNop()}
+			conn := &testConn{}
+
+			req, err := dhcpv4.New(
+				dhcpv4.WithHwAddr(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}),
+				dhcpv4.WithMessageType(mt),
+				dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(net.IPv4(10, 0, 0, 10))),
+			)
+			require.NoError(t, err)
+			s.handle4(conn, &net.UDPAddr{IP: net.IPv4zero, Port: dhcpv4.ClientPort}, packetInfo{}, req)
+
+			// the handlers see the message without a reply type, and nothing is sent
+			assert.Equal(t, []dhcpv4.MessageType{mt, dhcpv4.MessageTypeNone}, handled)
+			assert.Empty(t, conn.packets)
+		})
+	}
+}
+
+func TestStripNak4(t *testing.T) {
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	config := testHandler{handle4: func(req, resp handlers.DHCPv4) {
+		resp.UpdateOption(dhcpv4.OptServerIdentifier(net.IPv4(10, 0, 0, 1)))
+		resp.UpdateOption(dhcpv4.OptRouter(net.IPv4(10, 0, 0, 1)))
+		resp.UpdateOption(dhcpv4.OptDNS(net.IPv4(10, 0, 0, 53)))
+		resp.UpdateOption(dhcpv4.OptIPAddressLeaseTime(time.Hour))
+		resp.UpdateOption(dhcpv4.OptClasslessStaticRoute(&dhcpv4.Route{
+			Dest:   &net.IPNet{IP: net.IPv4(10, 1, 0, 0), Mask: net.CIDRMask(16, 32)},
+			Router: net.IPv4(10, 0, 0, 254),
+		}))
+		resp.YourIPAddr = net.IPv4(10, 0, 0, 10)
+		resp.BootFileName = "pxelinux.0"
+	}}
+	nak := testHandler{handle4: func(req, resp handlers.DHCPv4) {
+		resp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeNak))
+	}}
+
+	for _, tc := range []struct {
+		name    string
+		handler handlers.Handler
+	}{
+		{"set by handler", nak},
+		{"handler error", errorHandler{err: handlers.Nak(errors.New("address not available"))}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &dhcpServer{handler: handlers.Chain{config, tc.handler}, logger: zap.NewNop()}
+			conn := &testConn{}
+
+			req, err := dhcpv4.New(dhcpv4.WithHwAddr(mac), dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest))
+			require.NoError(t, err)
+			s.handle4(conn, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 20), Port: dhcpv4.ClientPort}, packetInfo{}, req)
+			require.Len(t, conn.packets, 1)
+			resp, err := dhcpv4.FromBytes(conn.packets[0])
+			require.NoError(t, err)
+
+			assert.Equal(t, dhcpv4.MessageTypeNak, resp.MessageType())
+			// apart from the message explaining the NAK, only the message type and server identifier remain
+			codes := make([]uint8, 0, len(resp.Options))
+			for code := range resp.Options {
+				if code != dhcpv4.OptionMessage.Code() {
+					codes = append(codes, code)
+				}
+			}
+			assert.ElementsMatch(t, []uint8{dhcpv4.OptionDHCPMessageType.Code(), dhcpv4.OptionServerIdentifier.Code()}, codes)
+			assert.True(t, resp.YourIPAddr.IsUnspecified())
+			assert.Empty(t, resp.BootFileName)
+		})
+	}
+}
+
+func TestNewHandlerChain(t *testing.T) {
+	t.Run("handlers", func(t *testing.T) {
+		core, logs := observer.New(zapcore.WarnLevel)
+		chain, err := newHandlerChain("srv0", []any{testHandler{}, &testHandler{}}, zap.New(core))
+		require.NoError(t, err)
+		assert.Len(t, chain, 2)
+		assert.Zero(t, logs.Len())
+	})
+
+	for name, loaded := range map[string]any{
+		"nil":         nil,
+		"nil slice":   []any(nil),
+		"empty slice": []any{},
+	} {
+		t.Run(name, func(t *testing.T) {
+			core, logs := observer.New(zapcore.WarnLevel)
+			chain, err := newHandlerChain("srv0", loaded, zap.New(core))
+			require.NoError(t, err)
+			assert.Empty(t, chain)
+			assert.Equal(t, 1, logs.FilterField(zap.String("server", "srv0")).Len())
+		})
+	}
+
+	for name, loaded := range map[string]any{
+		"map":         map[string]any{"range": testHandler{}},
+		"not handler": []any{testHandler{}, "range"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := newHandlerChain("srv0", loaded, zap.NewNop())
+			assert.ErrorContains(t, err, "server srv0")
+		})
+	}
+}
+
+func TestStopAndReply(t *testing.T) {
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	for _, tc := range []struct {
+		name    string
+		err     error
+		replied bool
+	}{
+		{"stop and reply", handlers.ErrStopAndReply, true},
+		{"stop and reply wrapped", fmt.Errorf("responder: %w", handlers.ErrStopAndReply), true},
+		{"stop and drop", handlers.ErrDrop, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			called := false
+			s := &dhcpServer{
+				handler: handlers.Chain{
+					testHandler{
+						handle4: func(req, resp handlers.DHCPv4) { resp.YourIPAddr = net.IPv4(10, 0, 0, 10) },
+						handle6: func(req, resp handlers.DHCPv6) {
+							resp.AddOption(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionPreference, OptionData: []byte{255}})
+						},
+					},
+					errorHandler{err: tc.err},
+					testHandler{
+						handle4: func(req, resp handlers.DHCPv4) { called = true },
+						handle6: func(req, resp handlers.DHCPv6) { called = true },
+					},
+				},
+				logger: zap.NewNop(),
+			}
+			conn := &testConn{}
+
+			req, err := dhcpv4.NewDiscovery(mac)
+			require.NoError(t, err)
+			s.handle4(conn, &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}, packetInfo{}, req)
+			req6, err := dhcpv6.NewSolicit(mac)
+			require.NoError(t, err)
+			s.handle6(conn, &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: dhcpv6.DefaultClientPort}, packetInfo{}, req6, nil)
+			assert.False(t, called)
+
+			if !tc.replied {
+				assert.Empty(t, conn.packets)
+				return
+			}
+			require.Len(t, conn.packets, 2)
+			resp, err := dhcpv4.FromBytes(conn.packets[0])
+			require.NoError(t, err)
+			assert.Equal(t, dhcpv4.MessageTypeOffer, resp.MessageType())
+			assert.True(t, resp.YourIPAddr.Equal(net.IPv4(10, 0, 0, 10)))
+			resp6, err := dhcpv6.MessageFromBytes(conn.packets[1])
+			require.NoError(t, err)
+			assert.NotNil(t, resp6.GetOneOption(dhcpv6.OptionPreference))
+		})
+	}
+}
+
+// testRawConn is a syscall.RawConn passing a fixed file descriptor to Control.
+type testRawConn struct {
+	syscall.RawConn
+	fd uintptr
+}
+
+func (c testRawConn) Control(f func(fd uintptr)) error {
+	f(c.fd)
+	return nil
+}
+
+func TestControl(t *testing.T) {
+	var bound []string
+	bindToDevice := func(fd int, iface string) error {
+		bound = append(bound, fmt.Sprintf("%d %s", fd, iface))
+		if iface == "missing0" {
+			return unix.ENODEV
+		}
+		return nil
+	}
+
+	s := &dhcpServer{bindToDevice: bindToDevice}
+	require.NoError(t, s.control("udp4", "0.0.0.0:67", testRawConn{fd: 3}))
+	assert.Empty(t, bound, "no interface configured")
+
+	s.iface = "eth0"
+	require.NoError(t, s.control("udp4", "0.0.0.0:67", testRawConn{fd: 3}))
+	assert.Equal(t, []string{"3 eth0"}, bound)
+
+	s.iface = "missing0"
+	assert.ErrorIs(t, s.control("udp6", "[::]:547", testRawConn{fd: 4}), unix.ENODEV)
+}
+
+func TestControlFiltersInterface(t *testing.T) {
+	// a socket bound to another interface than loopback does not receive packets sent to 127.0.0.1
+	var other string
+	ifaces, err := net.Interfaces()
+	require.NoError(t, err)
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback == 0 && iface.Flags&net.FlagUp != 0 {
+			other = iface.Name
+			break
+		}
+	}
+
+	for _, tc := range []struct {
+		iface    string
+		received bool
+	}{
+		{"", true},
+		{"lo", true},
+		{other, false},
+	} {
+		t.Run(fmt.Sprintf("interface %q", tc.iface), func(t *testing.T) {
+			if !tc.received && other == "" {
+				t.Skip("no other interface available")
+			}
+			s := &dhcpServer{iface: tc.iface}
+			lc := net.ListenConfig{Control: s.control}
+			conn, err := lc.ListenPacket(context.Background(), "udp4", "0.0.0.0:0")
+			if errors.Is(err, unix.EPERM) {
+				t.Skip("binding to an interface is not permitted")
+			}
+			require.NoError(t, err)
+			defer conn.Close()
+
+			port := conn.LocalAddr().(*net.UDPAddr).Port
+			sender, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+			require.NoError(t, err)
+			defer sender.Close()
+			_, err = sender.Write([]byte("request"))
+			require.NoError(t, err)
+
+			require.NoError(t, conn.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+			_, _, err = conn.ReadFrom(make([]byte, 16))
+			if tc.received {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+			}
+		})
+	}
+}
+
+func TestWaitForInterfaces(t *testing.T) {
+	addr, err := caddy.ParseNetworkAddress("udp4/127.0.0.1:0")
+	require.NoError(t, err)
+	var (
+		mu       sync.Mutex
+		attempts int
+	)
+	// the address only becomes available on the third attempt
+	listen := func(addr caddy.NetworkAddress) (net.PacketConn, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts < 3 {
+			return nil, &net.OpError{Op: "listen", Net: addr.Network, Err: os.NewSyscallError("bind", syscall.EADDRNOTAVAIL)}
+		}
+		return net.ListenPacket(addr.Network, addr.JoinHostPort(0))
+	}
+
+	s := &dhcpServer{logger: zap.NewNop(), addresses: []caddy.NetworkAddress{addr}, listen: listen}
+	app := &App{servers: []*dhcpServer{s}}
+	assert.ErrorContains(t, app.Start(), "cannot assign requested address")
+	_ = app.Stop()
+
+	attempts = 0
+	s = &dhcpServer{logger: zap.NewNop(), addresses: []caddy.NetworkAddress{addr}, listen: listen,
+		waitForInterfaces: true, bindRetry: time.Millisecond}
+	app = &App{servers: []*dhcpServer{s}}
+	require.NoError(t, app.Start())
+	assert.Eventually(t, func() bool {
+		s.connMu.Lock()
+		defer s.connMu.Unlock()
+		return len(s.connections) == 1
+	}, time.Second, time.Millisecond)
+	mu.Lock()
+	assert.Equal(t, 3, attempts)
+	mu.Unlock()
+	_ = app.Stop()
+}
+
+func TestReplyErrors(t *testing.T) {
+	for _, reject := range []bool{false, true} {
+		t.Run(fmt.Sprintf("reject=%v", reject), func(t *testing.T) {
+			core, logs := observer.New(zapcore.InfoLevel)
+			replyErrors, err := newReplyErrors(caddy.Context{})
+			require.NoError(t, err)
+			mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 0xfe}
+			s := &dhcpServer{
+				handler:           handlers.Chain{},
+				logger:            zap.New(core),
+				replyErrors:       replyErrors.MustCurryWith(prometheus.Labels{"server": "srv0"}),
+				rejectReplyErrors: reject,
+				hardwareAddr:      func(string) net.HardwareAddr { return mac },
+			}
+
+			// a DHCPREQUEST gets a DHCPNAK when rejecting
+			req4, err := dhcpv4.New(dhcpv4.WithHwAddr(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}), dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest))
+			require.NoError(t, err)
+			local := packetInfo{dst: net.IPv4(10, 0, 0, 1)}
+			nak := s.replyError4(&testConn{}, &net.UDPAddr{IP: net.IPv4zero, Port: dhcpv4.ClientPort}, local, req4, errors.New("no randomness"))
+			if reject {
+				require.NotNil(t, nak)
+				assert.Equal(t, dhcpv4.MessageTypeNak, nak.MessageType())
+				assert.Equal(t, req4.TransactionID, nak.TransactionID)
+				assert.Equal(t, net.IPv4(10, 0, 0, 1).To4(), nak.ServerIdentifier().To4())
+			} else {
+				assert.Nil(t, nak)
+			}
+
+			// a DHCPv6 Request without a Client Identifier option cannot be replied to normally
+			req6, err := dhcpv6.NewMessage()
+			require.NoError(t, err)
+			req6.MessageType = dhcpv6.MessageTypeRequest
+			conn := &testConn{}
+			s.handle6(conn, &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: dhcpv6.DefaultClientPort}, packetInfo{}, req6, nil)
+			if reject {
+				require.Len(t, conn.packets, 1)
+				reply, err := dhcpv6.MessageFromBytes(conn.packets[0])
+				require.NoError(t, err)
+				assert.Equal(t, dhcpv6.MessageTypeReply, reply.MessageType)
+				assert.Equal(t, req6.TransactionID, reply.TransactionID)
+				assert.Equal(t, iana.StatusUnspecFail, reply.Options.Status().StatusCode)
+				assert.Equal(t, &dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: mac}, reply.Options.ServerID())
+			} else {
+				assert.Empty(t, conn.packets)
+			}
+
+			for _, family := range []string{"4", "6"} {
+				var m dto.Metric
+				require.NoError(t, replyErrors.WithLabelValues("srv0", family).Write(&m))
+				assert.Equal(t, float64(1), m.GetCounter().GetValue())
+			}
+			assert.Equal(t, 2, logs.FilterMessage("failed to build reply").Len())
+		})
+	}
+}
+
+func TestProvisionReplyErrors(t *testing.T) {
+	app := &App{Servers: map[string]*Server{"srv0": {ReplyErrors: "retry"}}}
+	assert.Error(t, app.Provision(caddy.Context{}))
+}
+
+func TestProvisionServerDUID(t *testing.T) {
+	app := &App{Servers: map[string]*Server{"srv0": {EnsureServerID: true, ServerDUID: "ll not-a-mac"}}}
+	assert.ErrorContains(t, app.Provision(caddy.Context{}), "invalid server DUID")
+}
@@ -0,0 +1,59 @@
+package caddydhcp
+
+import (
+	"fmt"
+	"net"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// atfCom marks an ARP entry as complete (ATF_COM in <net/if_arp.h>).
+const atfCom = 0x02
+
+// arpReq mirrors the Linux struct arpreq.
+type arpReq struct {
+	protocolAddr unix.RawSockaddr
+	hardwareAddr unix.RawSockaddr
+	flags        int32
+	netmask      unix.RawSockaddr
+	device       [16]byte
+}
+
+// setARPEntry adds a (temporary) entry to the ARP cache of the given interface,
+// mapping the IPv4 address to the hardware address.
+// This requires the CAP_NET_ADMIN capability.
+func setARPEntry(iface string, ip net.IP, mac net.HardwareAddr) error {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return fmt.Errorf("not an IPv4 address: %s", ip)
+	}
+	if len(mac) > len(arpReq{}.hardwareAddr.Data) {
+		return fmt.Errorf("hardware address too long: %s", mac)
+	}
+	if len(iface) >= len(arpReq{}.device) {
+		return fmt.Errorf("interface name too long: %s", iface)
+	}
+
+	var req arpReq
+	pa := (*unix.RawSockaddrInet4)(unsafe.Pointer(&req.protocolAddr))
+	pa.Family = unix.AF_INET
+	copy(pa.Addr[:], ip4)
+	req.hardwareAddr.Family = unix.ARPHRD_ETHER
+	for i, b := range mac {
+		req.hardwareAddr.Data[i] = int8(b)
+	}
+	req.flags = atfCom
+	copy(req.device[:], iface)
+
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open socket: %w", err)
+	}
+	defer unix.Close(fd)
+
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), unix.SIOCSARP, uintptr(unsafe.Pointer(&req))); errno != 0 {
+		return fmt.Errorf("SIOCSARP failed: %w", errno)
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package caddydhcp
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// Auth6 configures the authentication of DHCPv6 messages with the Delayed Authentication Protocol
+// of RFC 3315 section 21.4, using a key that is shared with the clients beforehand.
+type Auth6 struct {
+	// The DHCP realm of the key, which identifies the administrative domain of the server.
+	Realm string `json:"realm"`
+
+	// The key identifier, which selects the key within the realm.
+	KeyID uint32 `json:"keyId"`
+
+	// The shared key, in hex.
+	Key string `json:"key"`
+}
+
+// Fields of the Authentication option (11) as used by the Delayed Authentication Protocol.
+const authProtocolDelayed = 2
+
+// auth6 verifies and authenticates DHCPv6 messages using a shared key.
+type auth6 struct {
+	realm []byte
+	keyID uint32
+	key   []byte
+
+	mu sync.Mutex
+	// replay is the replay detection counter of the replies, which must increase with every reply.
+	// It starts at the current time, so it keeps increasing across restarts and config reloads.
+	replay uint64
+	// clients holds the last replay detection counter of every client by DUID,
+	// which must increase with every request of the client.
+	clients map[string]uint64
+}
+
+// newAuth6 parses and validates the configuration of the authentication.
+func newAuth6(cfg *Auth6) (*auth6, error) {
+	if cfg.Realm == "" {
+		return nil, errors.New("no realm configured")
+	}
+	key, err := hex.DecodeString(cfg.Key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %v", err)
+	}
+	if len(key) == 0 {
+		return nil, errors.New("no key configured")
+	}
+	return &auth6{
+		realm:   []byte(cfg.Realm),
+		keyID:   cfg.KeyID,
+		key:     key,
+		replay:  ntpTime(time.Now()),
+		clients: make(map[string]uint64),
+	}, nil
+}
+
+// ntpEpochOffset is the number of seconds from the NTP epoch (1900) to the Unix epoch (1970).
+const ntpEpochOffset = 2208988800
+
+// ntpTime returns t as a 64-bit NTP timestamp, the format RFC 3315 section 21.3 suggests for the
+// replay detection counter: the seconds since 1900 followed by the fraction of the second.
+func ntpTime(t time.Time) uint64 {
+	return uint64(t.Unix()+ntpEpochOffset)<<32 | uint64(t.Nanosecond())<<32/uint64(time.Second)
+}
+
+// authInfoLen returns the length of the authentication information of the Authentication option:
+// the realm, the key identifier and the HMAC-MD5 digest.
+func (a *auth6) authInfoLen() int {
+	return len(a.realm) + 4 + md5.Size
+}
+
+// verify checks that a client message carries an Authentication option with a valid digest for the configured key,
+// and a replay detection counter that is higher than that of the previous message of the client.
+// b is the received packet, which may be relayed, and m the client message parsed from it.
+// The digest is calculated over the message as it was received, with the digest field of the option set to zero.
+func (a *auth6) verify(b []byte, m *dhcpv6.Message) error {
+	msg, err := clientMessage6(b)
+	if err != nil {
+		return err
+	}
+	// the message type and transaction ID precede the options of a client message
+	opts, err := options6(msg, 1+3)
+	if err != nil {
+		return err
+	}
+	auths := opts[dhcpv6.OptionAuth]
+	if len(auths) == 0 {
+		return errors.New("no authentication option")
+	}
+	if len(auths) > 1 {
+		return errors.New("more than one authentication option")
+	}
+	data := msg[auths[0][0]:auths[0][1]]
+	if len(data) != 3+8+a.authInfoLen() {
+		return fmt.Errorf("authentication option of %d bytes, expected %d", len(data), 3+8+a.authInfoLen())
+	}
+	if data[0] != authProtocolDelayed || data[1] != authAlgorithmHMACMD5 || data[2] != authRDMMonotonicCounter {
+		return fmt.Errorf("unsupported authentication protocol %d, algorithm %d or RDM %d", data[0], data[1], data[2])
+	}
+	info := data[3+8:]
+	if !bytes.Equal(info[:len(a.realm)], a.realm) || binary.BigEndian.Uint32(info[len(a.realm):]) != a.keyID {
+		return errors.New("unknown realm or key identifier")
+	}
+	digest := info[len(a.realm)+4:]
+
+	zeroed := bytes.Clone(msg)
+	clear(zeroed[auths[0][1]-md5.Size : auths[0][1]])
+	if !hmac.Equal(digest, a.digest(zeroed)) {
+		return errors.New("invalid authentication digest")
+	}
+
+	var client string
+	if duid := m.Options.ClientID(); duid != nil {
+		client = string(duid.ToBytes())
+	}
+	replay := binary.BigEndian.Uint64(data[3:])
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if last, ok := a.clients[client]; ok && replay <= last {
+		return fmt.Errorf("replay detection counter %d is not higher than %d of the previous message", replay, last)
+	}
+	a.clients[client] = replay
+	return nil
+}
+
+// clientMessage6 returns the client message of a DHCPv6 packet: the packet itself,
+// or the content of the Relay Message option (9) of the innermost relay message.
+func clientMessage6(b []byte) ([]byte, error) {
+	for len(b) > 0 && (dhcpv6.MessageType(b[0]) == dhcpv6.MessageTypeRelayForward || dhcpv6.MessageType(b[0]) == dhcpv6.MessageTypeRelayReply) {
+		// the message type, hop count, link address and peer address precede the options of a relay message
+		opts, err := options6(b, 1+1+net.IPv6len+net.IPv6len)
+		if err != nil {
+			return nil, err
+		}
+		relayed := opts[dhcpv6.OptionRelayMsg]
+		if len(relayed) != 1 {
+			return nil, errors.New("relay message without a single relay message option")
+		}
+		b = b[relayed[0][0]:relayed[0][1]]
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty message")
+	}
+	return b, nil
+}
+
+// options6 returns the start and end offsets of the data of the options of a DHCPv6 message by option code.
+// The options start at offset off of b.
+func options6(b []byte, off int) (map[dhcpv6.OptionCode][][2]int, error) {
+	if len(b) < off {
+		return nil, fmt.Errorf("message of %d bytes, expected at least %d", len(b), off)
+	}
+	opts := make(map[dhcpv6.OptionCode][][2]int)
+	for off < len(b) {
+		if len(b)-off < 4 {
+			return nil, errors.New("truncated option header")
+		}
+		code := dhcpv6.OptionCode(binary.BigEndian.Uint16(b[off:]))
+		end := off + 4 + int(binary.BigEndian.Uint16(b[off+2:]))
+		if end > len(b) {
+			return nil, fmt.Errorf("truncated option %v", code)
+		}
+		opts[code] = append(opts[code], [2]int{off + 4, end})
+		off = end
+	}
+	return opts, nil
+}
+
+// authenticate adds an Authentication option to a reply, with a digest calculated over the whole reply.
+// It must be called after all other changes to the reply.
+func (a *auth6) authenticate(m *dhcpv6.Message) {
+	a.mu.Lock()
+	a.replay++
+	replay := a.replay
+	a.mu.Unlock()
+
+	data := []byte{authProtocolDelayed, authAlgorithmHMACMD5, authRDMMonotonicCounter}
+	data = binary.BigEndian.AppendUint64(data, replay)
+	data = append(data, a.realm...)
+	data = binary.BigEndian.AppendUint32(data, a.keyID)
+	data = append(data, make([]byte, md5.Size)...)
+	auth := &dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionAuth, OptionData: data}
+	m.UpdateOption(auth)
+	copy(data[len(data)-md5.Size:], a.digest(m.ToBytes()))
+}
+
+// digest returns the HMAC-MD5 digest of a message using the key.
+func (a *auth6) digest(b []byte) []byte {
+	mac := hmac.New(md5.New, a.key)
+	mac.Write(b)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,160 @@
+package caddydhcp
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestNewAuth6(t *testing.T) {
+	_, err := newAuth6(&Auth6{Realm: "example.com", KeyID: 1, Key: "000102030405060708090a0b0c0d0e0f"})
+	assert.NoError(t, err)
+	for name, cfg := range map[string]*Auth6{
+		"no realm":    {Key: "00"},
+		"no key":      {Realm: "example.com"},
+		"invalid key": {Realm: "example.com", Key: "zz"},
+	} {
+		_, err := newAuth6(cfg)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestAuth6(t *testing.T) {
+	auth, err := newAuth6(&Auth6{Realm: "example.com", KeyID: 7, Key: "000102030405060708090a0b0c0d0e0f"})
+	require.NoError(t, err)
+
+	newRequest := func() *dhcpv6.Message {
+		req, err := dhcpv6.NewMessage(dhcpv6.WithClientID(&dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 1}}))
+		require.NoError(t, err)
+		req.MessageType = dhcpv6.MessageTypeInformationRequest
+		return req
+	}
+	// handle6 returns whether the handlers were run and the reply that was sent, if any
+	handle6 := func(m dhcpv6.DHCPv6) (bool, *dhcpv6.Message) {
+		called := false
+		s := &dhcpServer{
+			handler: handlers.Chain{testHandler{handle6: func(_, _ handlers.DHCPv6) { called = true }}},
+			logger:  zap.NewNop(),
+			auth:    auth,
+		}
+		conn := &testConn{}
+		s.handle6(conn, &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: dhcpv6.DefaultClientPort}, packetInfo{}, m, m.ToBytes())
+		if len(conn.packets) == 0 {
+			return called, nil
+		}
+		reply, err := dhcpv6.FromBytes(conn.packets[0])
+		require.NoError(t, err)
+		resp, err := reply.GetInnerMessage()
+		require.NoError(t, err)
+		return called, resp
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		req := newRequest()
+		auth.authenticate(req)
+		called, resp := handle6(req)
+		assert.True(t, called)
+		require.NotNil(t, resp)
+		// the reply is authenticated with the same key
+		assert.NoError(t, auth.verify(resp.ToBytes(), resp))
+	})
+
+	t.Run("relayed", func(t *testing.T) {
+		req := newRequest()
+		auth.authenticate(req)
+		relayed, err := dhcpv6.EncapsulateRelay(req, dhcpv6.MessageTypeRelayForward, net.ParseIP("2001:db8::1"), net.ParseIP("fe80::1"))
+		require.NoError(t, err)
+		called, resp := handle6(relayed)
+		assert.True(t, called)
+		assert.NotNil(t, resp)
+	})
+
+	t.Run("replayed", func(t *testing.T) {
+		req := newRequest()
+		auth.authenticate(req)
+		called, _ := handle6(req)
+		assert.True(t, called)
+		// the same message is rejected the second time
+		called, resp := handle6(req)
+		assert.False(t, called)
+		assert.Nil(t, resp)
+		assert.ErrorContains(t, auth.verify(req.ToBytes(), req), "replay detection counter")
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		req := newRequest()
+		assert.Error(t, auth.verify(req.ToBytes(), req))
+		called, resp := handle6(req)
+		assert.False(t, called)
+		assert.Nil(t, resp)
+	})
+
+	t.Run("bad digest", func(t *testing.T) {
+		req := newRequest()
+		auth.authenticate(req)
+		data := req.GetOneOption(dhcpv6.OptionAuth).(*dhcpv6.OptionGeneric).OptionData
+		data[len(data)-1] ^= 0xff
+		assert.Error(t, auth.verify(req.ToBytes(), req))
+		called, resp := handle6(req)
+		assert.False(t, called)
+		assert.Nil(t, resp)
+	})
+
+	t.Run("other key", func(t *testing.T) {
+		other, err := newAuth6(&Auth6{Realm: "example.com", KeyID: 7, Key: "ffffffffffffffffffffffffffffffff"})
+		require.NoError(t, err)
+		req := newRequest()
+		other.authenticate(req)
+		assert.Error(t, auth.verify(req.ToBytes(), req))
+	})
+
+	t.Run("other key identifier", func(t *testing.T) {
+		other, err := newAuth6(&Auth6{Realm: "example.com", KeyID: 8, Key: "000102030405060708090a0b0c0d0e0f"})
+		require.NoError(t, err)
+		req := newRequest()
+		other.authenticate(req)
+		assert.Error(t, auth.verify(req.ToBytes(), req))
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		req := newRequest()
+		auth.authenticate(req)
+		b := req.ToBytes()
+		assert.Error(t, auth.verify(b[:len(b)-1], req))
+	})
+}
+
+func TestAuth6ReplayAcrossRestarts(t *testing.T) {
+	cfg := &Auth6{Realm: "example.com", KeyID: 7, Key: "000102030405060708090a0b0c0d0e0f"}
+	// replay returns the replay detection counter of a reply authenticated by auth
+	replay := func(auth *auth6) uint64 {
+		resp, err := dhcpv6.NewMessage()
+		require.NoError(t, err)
+		auth.authenticate(resp)
+		return binary.BigEndian.Uint64(resp.GetOneOption(dhcpv6.OptionAuth).ToBytes()[3:])
+	}
+
+	before, err := newAuth6(cfg)
+	require.NoError(t, err)
+	first := replay(before)
+	second := replay(before)
+	assert.Greater(t, second, first)
+
+	// a restarted server continues above the counters of the replies of the previous one
+	time.Sleep(time.Millisecond)
+	after, err := newAuth6(cfg)
+	require.NoError(t, err)
+	assert.Greater(t, replay(after), second)
+}
+
+func TestNTPTime(t *testing.T) {
+	assert.Equal(t, uint64(ntpEpochOffset)<<32|1<<31, ntpTime(time.Unix(0, int64(time.Second/2))))
+}
@@ -0,0 +1,101 @@
+package caddydhcp
+
+import (
+	"sync"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// defaultDedupSize is the default maximum number of replies kept for retransmitted requests.
+const defaultDedupSize = 1024
+
+// replyKey identifies a DHCPv4 request and its retransmissions, which only differ in the secs field.
+type replyKey struct {
+	xid         dhcpv4.TransactionID
+	chaddr      string
+	messageType dhcpv4.MessageType
+}
+
+// cachedReply is a reply in the replyCache.
+type cachedReply struct {
+	resp    []byte
+	expires time.Time
+}
+
+// queuedKey is a key in the eviction queue of the replyCache, along with the expiry of the reply it was queued for.
+type queuedKey struct {
+	key     replyKey
+	expires time.Time
+}
+
+// replyCache holds the replies to recent DHCPv4 requests, so a retransmitted request is answered
+// with the same reply instead of running the handler chain again.
+// A nil replyCache caches nothing.
+type replyCache struct {
+	window time.Duration
+	size   int
+	now    func() time.Time
+
+	mu      sync.Mutex
+	replies map[replyKey]cachedReply
+	// queue holds the keys in the order in which they were added, which is also the order in which they expire
+	queue []queuedKey
+}
+
+func newReplyCache(window time.Duration, size int) *replyCache {
+	return &replyCache{
+		window:  window,
+		size:    size,
+		now:     time.Now,
+		replies: make(map[replyKey]cachedReply),
+	}
+}
+
+func keyOf(req *dhcpv4.DHCPv4) replyKey {
+	return replyKey{xid: req.TransactionID, chaddr: req.ClientHWAddr.String(), messageType: req.MessageType()}
+}
+
+// get returns a copy of the reply to an earlier transmission of req, or nil if there is none.
+func (c *replyCache) get(req *dhcpv4.DHCPv4) *dhcpv4.DHCPv4 {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	r, ok := c.replies[keyOf(req)]
+	c.mu.Unlock()
+	if !ok || !c.now().Before(r.expires) {
+		return nil
+	}
+	resp, err := dhcpv4.FromBytes(r.resp)
+	if err != nil {
+		return nil
+	}
+	return resp
+}
+
+// put adds the reply to req, evicting the expired replies and, if the cache is full, the oldest ones.
+func (c *replyCache) put(req, resp *dhcpv4.DHCPv4) {
+	if c == nil {
+		return
+	}
+	key := keyOf(req)
+	now := c.now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.queue) > 0 {
+		q := c.queue[0]
+		if now.Before(q.expires) && len(c.replies) < c.size {
+			break
+		}
+		c.queue = c.queue[1:]
+		// the key may have been added again since, in which case it is queued once more
+		if r, ok := c.replies[q.key]; ok && r.expires.Equal(q.expires) {
+			delete(c.replies, q.key)
+		}
+	}
+	expires := now.Add(c.window)
+	c.replies[key] = cachedReply{resp: resp.ToBytes(), expires: expires}
+	c.queue = append(c.queue, queuedKey{key: key, expires: expires})
+}
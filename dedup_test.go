@@ -0,0 +1,118 @@
+package caddydhcp
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/lion7/caddydhcp/handlers"
+	rangeplugin "github.com/lion7/caddydhcp/handlers/range"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestDedup(t *testing.T) {
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	peer := &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}
+	now := time.Unix(1700000000, 0)
+	calls := 0
+	s := &dhcpServer{
+		handler: handlers.Chain{testHandler{
+			handle4: func(req, resp handlers.DHCPv4) {
+				calls++
+				resp.YourIPAddr = net.IPv4(10, 0, 0, byte(calls))
+			},
+		}},
+		logger:  zap.NewNop(),
+		replies: newReplyCache(time.Second, 2),
+	}
+	s.replies.now = func() time.Time { return now }
+	conn := &testConn{}
+
+	req, err := dhcpv4.NewDiscovery(mac)
+	require.NoError(t, err)
+	s.handle4(conn, peer, packetInfo{}, req)
+
+	// a retransmission only differs in the secs field
+	req.NumSeconds = 3
+	s.handle4(conn, peer, packetInfo{}, req)
+	assert.Equal(t, 1, calls)
+	require.Len(t, conn.packets, 2)
+	assert.Equal(t, conn.packets[0], conn.packets[1])
+
+	// a request in the same transaction is a different message
+	request, err := dhcpv4.NewRequestFromOffer(req)
+	require.NoError(t, err)
+	request.TransactionID = req.TransactionID
+	s.handle4(conn, peer, packetInfo{}, request)
+	assert.Equal(t, 2, calls)
+
+	// the reply expires after the window
+	now = now.Add(time.Second)
+	s.handle4(conn, peer, packetInfo{}, req)
+	assert.Equal(t, 3, calls)
+	require.Len(t, conn.packets, 4)
+	resp, err := dhcpv4.FromBytes(conn.packets[3])
+	require.NoError(t, err)
+	assert.True(t, resp.YourIPAddr.Equal(net.IPv4(10, 0, 0, 3)))
+}
+
+func TestDedupSize(t *testing.T) {
+	c := newReplyCache(time.Minute, 2)
+	var reqs []*dhcpv4.DHCPv4
+	for i := byte(1); i <= 3; i++ {
+		req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, i})
+		require.NoError(t, err)
+		resp, err := dhcpv4.NewReplyFromRequest(req)
+		require.NoError(t, err)
+		c.put(req, resp)
+		reqs = append(reqs, req)
+	}
+	assert.Len(t, c.replies, 2)
+	assert.Nil(t, c.get(reqs[0]))
+	assert.NotNil(t, c.get(reqs[1]))
+	assert.NotNil(t, c.get(reqs[2]))
+
+	// adding a key again does not grow the cache beyond its size
+	resp, err := dhcpv4.NewReplyFromRequest(reqs[1])
+	require.NoError(t, err)
+	c.put(reqs[1], resp)
+	assert.Len(t, c.replies, 2)
+	assert.NotNil(t, c.get(reqs[1]))
+}
+
+func TestDedupRange(t *testing.T) {
+	m := &rangeplugin.Module{
+		Filename:  filepath.Join(t.TempDir(), "leases.sqlite3"),
+		StartIP:   "10.0.0.1",
+		EndIP:     "10.0.0.100",
+		LeaseTime: caddy.Duration(time.Hour),
+	}
+	require.NoError(t, m.Provision(caddy.Context{}))
+	t.Cleanup(func() { _ = m.Cleanup() })
+
+	calls := 0
+	s := &dhcpServer{
+		handler: handlers.Chain{testHandler{handle4: func(_, _ handlers.DHCPv4) { calls++ }}, m},
+		logger:  zap.NewNop(),
+		replies: newReplyCache(time.Second, defaultDedupSize),
+	}
+	conn := &testConn{}
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	for i := uint16(0); i < 3; i++ {
+		req.NumSeconds = i
+		s.handle4(conn, &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}, packetInfo{}, req)
+	}
+
+	assert.Equal(t, 1, calls)
+	require.Len(t, conn.packets, 3)
+	assert.Equal(t, conn.packets[0], conn.packets[1])
+	assert.Equal(t, conn.packets[0], conn.packets[2])
+	assert.Len(t, m.Leases(), 1)
+	assert.Equal(t, 99, m.Available())
+}
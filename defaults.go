@@ -0,0 +1,120 @@
+package caddydhcp
+
+import (
+	"fmt"
+	"net"
+	"slices"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/lion7/caddydhcp/handlers"
+)
+
+// The names of the options that can be configured in Server.Defaults.
+const (
+	defaultDNS    = "dns"
+	defaultDomain = "domain"
+	defaultNTP    = "ntp"
+	defaultRouter = "router"
+)
+
+// defaultOptions is the terminal handler of a server's chain, which sets the default options
+// requested by the client that no earlier handler has set.
+type defaultOptions struct {
+	options4 []dhcpv4.Option
+	options6 []dhcpv6.Option
+}
+
+// newDefaultOptions parses the defaults configured on a server.
+// It returns nil if there are none.
+func newDefaultOptions(defaults map[string][]string) (*defaultOptions, error) {
+	if len(defaults) == 0 {
+		return nil, nil
+	}
+	d := &defaultOptions{}
+	// sort the names, so the options are always added in the same order
+	names := make([]string, 0, len(defaults))
+	for name := range defaults {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		values := defaults[name]
+		if len(values) == 0 {
+			return nil, fmt.Errorf("default %s: no values", name)
+		}
+		switch name {
+		case defaultDNS:
+			ips4, ips6, err := parseIPs(values)
+			if err != nil {
+				return nil, fmt.Errorf("default %s: %v", name, err)
+			}
+			if len(ips4) > 0 {
+				d.options4 = append(d.options4, dhcpv4.OptDNS(ips4...))
+			}
+			if len(ips6) > 0 {
+				d.options6 = append(d.options6, dhcpv6.OptDNS(ips6...))
+			}
+		case defaultDomain:
+			if len(values) != 1 {
+				return nil, fmt.Errorf("default %s: expected a single domain, got %d", name, len(values))
+			}
+			d.options4 = append(d.options4, dhcpv4.OptDomainName(values[0]))
+		case defaultNTP, defaultRouter:
+			ips4, ips6, err := parseIPs(values)
+			if err != nil {
+				return nil, fmt.Errorf("default %s: %v", name, err)
+			}
+			if len(ips6) > 0 {
+				return nil, fmt.Errorf("default %s: expected IPv4 addresses, got %s", name, ips6[0])
+			}
+			if name == defaultNTP {
+				d.options4 = append(d.options4, dhcpv4.OptNTPServers(ips4...))
+			} else {
+				d.options4 = append(d.options4, dhcpv4.OptRouter(ips4...))
+			}
+		default:
+			return nil, fmt.Errorf("unknown default %q, expected one of %q, %q, %q or %q", name, defaultDNS, defaultDomain, defaultNTP, defaultRouter)
+		}
+	}
+	return d, nil
+}
+
+// parseIPs parses a list of IP addresses and splits them by IP family.
+func parseIPs(values []string) (ips4, ips6 []net.IP, err error) {
+	for _, value := range values {
+		ip := net.ParseIP(value)
+		switch {
+		case ip == nil:
+			return nil, nil, fmt.Errorf("invalid IP address %q", value)
+		case ip.To4() != nil:
+			ips4 = append(ips4, ip.To4())
+		default:
+			ips6 = append(ips6, ip)
+		}
+	}
+	return ips4, ips6, nil
+}
+
+func (d *defaultOptions) Handle4(req, resp handlers.DHCPv4, next func() error) error {
+	for _, opt := range d.options4 {
+		if req.IsOptionRequested(opt.Code) && !resp.Options.Has(opt.Code) {
+			resp.UpdateOption(opt)
+		}
+	}
+	return next()
+}
+
+func (d *defaultOptions) Handle6(req, resp handlers.DHCPv6, next func() error) error {
+	for _, opt := range d.options6 {
+		if req.IsOptionRequested(opt.Code()) && resp.GetOneOption(opt.Code()) == nil {
+			resp.UpdateOption(opt)
+		}
+	}
+	return next()
+}
+
+// Interfaces guards
+var (
+	_ handlers.Handler = (*defaultOptions)(nil)
+)
@@ -0,0 +1,97 @@
+package caddydhcp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaults4(t *testing.T) {
+	defaults, err := newDefaultOptions(map[string][]string{
+		defaultDNS:    {"10.0.0.53", "2001:db8::53"},
+		defaultDomain: {"example.com"},
+		defaultRouter: {"10.0.0.1"},
+	})
+	require.NoError(t, err)
+
+	// an earlier handler sets the DNS servers, so only the domain and router defaults apply
+	chain := handlers.Chain{
+		testHandler{handle4: func(req, resp handlers.DHCPv4) {
+			resp.UpdateOption(dhcpv4.OptDNS(net.IPv4(10, 0, 0, 2)))
+		}},
+		defaults,
+	}
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, dhcpv4.WithRequestedOptions(
+		dhcpv4.OptionDomainNameServer, dhcpv4.OptionDomainName, dhcpv4.OptionRouter,
+	))
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	require.NoError(t, chain.Handle4(handlers.DHCPv4{DHCPv4: req}, handlers.DHCPv4{DHCPv4: resp}, func() error { return nil }))
+
+	assert.Equal(t, []net.IP{net.IPv4(10, 0, 0, 2).To4()}, resp.DNS())
+	assert.Equal(t, "example.com", resp.DomainName())
+	assert.Equal(t, []net.IP{net.IPv4(10, 0, 0, 1).To4()}, resp.Router())
+	assert.Nil(t, resp.NTPServers())
+}
+
+func TestDefaults6(t *testing.T) {
+	defaults, err := newDefaultOptions(map[string][]string{defaultDNS: {"10.0.0.53", "2001:db8::53"}})
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name string
+		set  []net.IP
+		want []net.IP
+	}{
+		{"absent", nil, []net.IP{net.ParseIP("2001:db8::53")}},
+		{"set", []net.IP{net.ParseIP("2001:db8::1")}, []net.IP{net.ParseIP("2001:db8::1")}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			chain := handlers.Chain{
+				testHandler{handle6: func(req, resp handlers.DHCPv6) {
+					if tc.set != nil {
+						resp.UpdateOption(dhcpv6.OptDNS(tc.set...))
+					}
+				}},
+				defaults,
+			}
+			req, err := dhcpv6.NewSolicit(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, dhcpv6.WithRequestedOptions(dhcpv6.OptionDNSRecursiveNameServer))
+			require.NoError(t, err)
+			resp, err := dhcpv6.NewAdvertiseFromSolicit(req)
+			require.NoError(t, err)
+			require.NoError(t, chain.Handle6(handlers.DHCPv6{Message: req}, handlers.DHCPv6{Message: resp}, func() error { return nil }))
+			assert.Equal(t, tc.want, resp.Options.DNS())
+		})
+	}
+}
+
+func TestDefaultsNotRequested(t *testing.T) {
+	defaults, err := newDefaultOptions(map[string][]string{defaultDomain: {"example.com"}})
+	require.NoError(t, err)
+	req, err := dhcpv4.New(dhcpv4.WithMessageType(dhcpv4.MessageTypeDiscover), dhcpv4.WithRequestedOptions(dhcpv4.OptionRouter))
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	require.NoError(t, defaults.Handle4(handlers.DHCPv4{DHCPv4: req}, handlers.DHCPv4{DHCPv4: resp}, func() error { return nil }))
+	assert.False(t, resp.Options.Has(dhcpv4.OptionDomainName))
+}
+
+func TestProvisionDefaults(t *testing.T) {
+	for _, defaults := range []map[string][]string{
+		{"tftp": {"10.0.0.1"}},
+		{defaultDNS: {}},
+		{defaultDNS: {"not an ip"}},
+		{defaultRouter: {"2001:db8::1"}},
+		{defaultDomain: {"example.com", "example.org"}},
+	} {
+		app := &App{Servers: map[string]*Server{"srv0": {Defaults: defaults}}}
+		assert.Error(t, app.Provision(caddy.Context{}))
+	}
+}
@@ -0,0 +1,150 @@
+// Package dhcptest provides utilities for end-to-end testing of DHCP handlers.
+// It runs the dhcp app in-process on ephemeral loopback ports, so crafted
+// requests can be sent through the complete server loop and handler chain.
+package dhcptest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+
+	"github.com/lion7/caddydhcp"
+)
+
+// Timeout is the maximum amount of time to wait for a reply.
+var Timeout = 5 * time.Second
+
+// Server is a DHCP server running in-process, listening on the IPv4 and IPv6 loopback addresses.
+type Server struct {
+	// Addr4 is the address the server accepts DHCPv4 requests on.
+	Addr4 *net.UDPAddr
+	// Addr6 is the address the server accepts DHCPv6 requests on.
+	Addr6 *net.UDPAddr
+}
+
+// NewServer provisions and starts a dhcp app with a single server running the given handlers.
+// Each handler is the JSON of a handler module including its "handler" key, e.g. as created with
+// caddyconfig.JSONModuleObject. The server is stopped when the test finishes.
+func NewServer(t testing.TB, handlers ...json.RawMessage) *Server {
+	t.Helper()
+	return NewServerWithConfig(t, &caddydhcp.Server{HandlersRaw: handlers})
+}
+
+// NewServerWithConfig behaves like NewServer, but allows specifying the full server configuration.
+// The listen addresses of the configuration are overwritten with ephemeral loopback addresses.
+// DHCPv6 replies are sent back to the source port of the requests, unless the configuration sets ReplyPort6.
+func NewServerWithConfig(t testing.TB, srv *caddydhcp.Server) *Server {
+	t.Helper()
+	s := &Server{
+		Addr4: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: freePort(t, "udp4", "127.0.0.1")},
+		Addr6: &net.UDPAddr{IP: net.IPv6loopback, Port: freePort(t, "udp6", "::1")},
+	}
+	srv.Listen = []string{
+		"udp4/" + s.Addr4.String(),
+		"udp6/" + s.Addr6.String(),
+	}
+	if srv.ReplyPort6 == "" {
+		// the test clients listen on ephemeral ports instead of the standard client port
+		srv.ReplyPort6 = "source"
+	}
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	app := &caddydhcp.App{Servers: map[string]*caddydhcp.Server{"test": srv}}
+	if err := app.Provision(ctx); err != nil {
+		cancel()
+		t.Fatalf("failed to provision dhcp app: %v", err)
+	}
+	if err := app.Start(); err != nil {
+		cancel()
+		t.Fatalf("failed to start dhcp app: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := app.Stop(); err != nil && !errors.Is(err, net.ErrClosed) {
+			t.Errorf("failed to stop dhcp app: %v", err)
+		}
+		cancel()
+	})
+	return s
+}
+
+// Exchange4 sends the DHCPv4 request to the server and returns the parsed reply.
+func (s *Server) Exchange4(t testing.TB, req *dhcpv4.DHCPv4) *dhcpv4.DHCPv4 {
+	t.Helper()
+	b, err := exchange(s.Addr4, req.ToBytes())
+	if err != nil {
+		t.Fatalf("DHCPv4 exchange failed: %v", err)
+	}
+	resp, err := dhcpv4.FromBytes(b)
+	if err != nil {
+		t.Fatalf("failed to parse DHCPv4 reply: %v", err)
+	}
+	return resp
+}
+
+// Exchange6 sends the DHCPv6 request to the server and returns the parsed reply.
+func (s *Server) Exchange6(t testing.TB, req dhcpv6.DHCPv6) dhcpv6.DHCPv6 {
+	t.Helper()
+	b, err := exchange(s.Addr6, req.ToBytes())
+	if err != nil {
+		t.Fatalf("DHCPv6 exchange failed: %v", err)
+	}
+	resp, err := dhcpv6.FromBytes(b)
+	if err != nil {
+		t.Fatalf("failed to parse DHCPv6 reply: %v", err)
+	}
+	return resp
+}
+
+// exchange sends a packet to addr from an ephemeral port and waits for a single reply.
+func exchange(addr *net.UDPAddr, packet []byte) ([]byte, error) {
+	network := "udp4"
+	if addr.IP.To4() == nil {
+		network = "udp6"
+	}
+	conn, err := net.ListenUDP(network, &net.UDPAddr{IP: addr.IP})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(time.Now().Add(Timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := conn.WriteTo(packet, addr); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// freePort asks the kernel for a free port on the given host.
+func freePort(t testing.TB, network, host string) int {
+	t.Helper()
+	conn, err := net.ListenPacket(network, net.JoinHostPort(host, "0"))
+	if err != nil {
+		t.Fatalf("failed to find a free port: %v", err)
+	}
+	defer conn.Close()
+	_, port, err := net.SplitHostPort(conn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("failed to find a free port: %v", err)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(fmt.Errorf("failed to find a free port: %w", err))
+	}
+	return p
+}
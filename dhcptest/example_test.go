@@ -0,0 +1,50 @@
+package dhcptest_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/lion7/caddydhcp/dhcptest"
+	"github.com/lion7/caddydhcp/handlers/dns"
+	"github.com/lion7/caddydhcp/handlers/router"
+)
+
+func TestDNSAndRouter(t *testing.T) {
+	srv := dhcptest.NewServer(t,
+		caddyconfig.JSONModuleObject(dns.Module{Servers: []string{"10.0.0.53", "2001:db8::53"}}, "handler", "dns", nil),
+		caddyconfig.JSONModuleObject(router.Module{Routers: []string{"10.0.0.1"}}, "handler", "router", nil),
+	)
+
+	t.Run("DHCPv4", func(t *testing.T) {
+		req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+		require.NoError(t, err)
+
+		resp := srv.Exchange4(t, req)
+		assert.Equal(t, dhcpv4.MessageTypeOffer, resp.MessageType())
+		assert.Equal(t, req.TransactionID, resp.TransactionID)
+		assert.Equal(t, []net.IP{net.IPv4(10, 0, 0, 53).To4()}, resp.DNS())
+		assert.Equal(t, []net.IP{net.IPv4(10, 0, 0, 1).To4()}, resp.Router())
+	})
+
+	t.Run("DHCPv6", func(t *testing.T) {
+		req, err := dhcpv6.NewMessage(
+			dhcpv6.WithClientID(&dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 1}}),
+			dhcpv6.WithRequestedOptions(dhcpv6.OptionDNSRecursiveNameServer),
+		)
+		require.NoError(t, err)
+		req.MessageType = dhcpv6.MessageTypeInformationRequest
+
+		resp, err := srv.Exchange6(t, req).GetInnerMessage()
+		require.NoError(t, err)
+		assert.Equal(t, dhcpv6.MessageTypeReply, resp.MessageType)
+		assert.Equal(t, req.TransactionID, resp.TransactionID)
+		assert.Equal(t, []net.IP{net.ParseIP("2001:db8::53")}, resp.Options.DNS())
+	})
+}
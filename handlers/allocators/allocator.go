@@ -31,6 +31,16 @@ type Allocator interface {
 	// Free may return a DoubleFreeError if the prefix being returned was not
 	// previously allocated
 	Free(net.IPNet) error
+
+	// Available returns the number of prefixes that can still be allocated
+	Available() int
+
+	// Total returns the number of prefixes in the pool, whether allocated or not
+	Total() int
+
+	// LargestFree returns the number of prefixes in the largest contiguous block
+	// of the pool that is not allocated, as a measure of its fragmentation
+	LargestFree() int
 }
 
 // ErrDoubleFree is an error type returned by Allocator.Free() when a
@@ -102,6 +102,39 @@ func (a *Allocator) Free(prefix net.IPNet) error {
 	return nil
 }
 
+// Available returns the number of prefixes that are not allocated.
+func (a *Allocator) Available() int {
+	a.l.Lock()
+	defer a.l.Unlock()
+	return int(a.bitmap.Len() - a.bitmap.Count())
+}
+
+// Total returns the number of prefixes in the pool.
+func (a *Allocator) Total() int {
+	return int(a.bitmap.Len())
+}
+
+// LargestFree returns the number of prefixes in the largest contiguous block that is not allocated.
+func (a *Allocator) LargestFree() int {
+	a.l.Lock()
+	defer a.l.Unlock()
+	return largestClear(a.bitmap)
+}
+
+// largestClear returns the length of the longest run of clear bits in the bitset
+func largestClear(b *bitset.BitSet) int {
+	largest := uint(0)
+	for start, ok := b.NextClear(0); ok; start, ok = b.NextClear(start) {
+		end, found := b.NextSet(start)
+		if !found {
+			end = b.Len()
+		}
+		largest = max(largest, end-start)
+		start = end
+	}
+	return int(largest)
+}
+
 // NewBitmapAllocator creates a new allocator, allocating /`size` prefixes
 // carved out of the given `pool` prefix
 func NewBitmapAllocator(pool net.IPNet, size int) (*Allocator, error) {
@@ -102,6 +102,25 @@ func (a *IPv4Allocator) Free(n net.IPNet) error {
 	return nil
 }
 
+// Available returns the number of IPs that are not allocated
+func (a *IPv4Allocator) Available() int {
+	a.l.Lock()
+	defer a.l.Unlock()
+	return int(a.bitmap.Len() - a.bitmap.Count())
+}
+
+// Total returns the number of IPs in the range
+func (a *IPv4Allocator) Total() int {
+	return int(a.bitmap.Len())
+}
+
+// LargestFree returns the number of IPs in the largest contiguous block that is not allocated
+func (a *IPv4Allocator) LargestFree() int {
+	a.l.Lock()
+	defer a.l.Unlock()
+	return largestClear(a.bitmap)
+}
+
 // NewIPv4Allocator creates a new allocator suitable for giving out IPv4 addresses
 func NewIPv4Allocator(start, end net.IP) (*IPv4Allocator, error) {
 	if start.To4() == nil || end.To4() == nil {
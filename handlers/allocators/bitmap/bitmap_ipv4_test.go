@@ -61,3 +61,71 @@ func Test4OutOfPool(t *testing.T) {
 		t.Fatalf("Prefixes have wrong size %d/%d", prefLen, totalLen)
 	}
 }
+
+func Test4Counts(t *testing.T) {
+	alloc, err := NewIPv4Allocator(net.IPv4(192, 0, 2, 10), net.IPv4(192, 0, 2, 13))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alloc.Total() != 4 || alloc.Available() != 4 {
+		t.Fatalf("expected 4 of 4 IPs available, got %d of %d", alloc.Available(), alloc.Total())
+	}
+
+	// exhaust the pool, including both of its boundaries
+	var allocated []net.IPNet
+	for i := 0; i < 4; i++ {
+		n, err := alloc.Allocate(net.IPNet{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		allocated = append(allocated, n)
+		if alloc.Available() != 3-i {
+			t.Fatalf("expected %d available IPs, got %d", 3-i, alloc.Available())
+		}
+	}
+	if _, err := alloc.Allocate(net.IPNet{}); err == nil {
+		t.Fatal("expected the pool to be exhausted")
+	}
+	if alloc.Available() != 0 {
+		t.Fatalf("expected no available IPs, got %d", alloc.Available())
+	}
+
+	for i, n := range []net.IPNet{allocated[0], allocated[3]} {
+		if err := alloc.Free(n); err != nil {
+			t.Fatal(err)
+		}
+		if alloc.Available() != i+1 {
+			t.Fatalf("expected %d available IPs, got %d", i+1, alloc.Available())
+		}
+	}
+
+	// a double free does not change the counts
+	if err := alloc.Free(allocated[0]); err == nil {
+		t.Fatal("expected DoubleFree error")
+	}
+	if alloc.Available() != 2 || alloc.Total() != 4 {
+		t.Fatalf("expected 2 of 4 IPs available, got %d of %d", alloc.Available(), alloc.Total())
+	}
+}
+
+func Test4LargestFree(t *testing.T) {
+	alloc, err := NewIPv4Allocator(net.IPv4(192, 0, 2, 10), net.IPv4(192, 0, 2, 15))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ip := range []net.IP{net.IPv4(192, 0, 2, 10), net.IPv4(192, 0, 2, 13)} {
+		if _, err := alloc.Allocate(net.IPNet{IP: ip}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// .11-.12 and .14-.15 are free
+	if alloc.LargestFree() != 2 {
+		t.Fatalf("expected a free block of 2 IPs, got %d", alloc.LargestFree())
+	}
+	if err := alloc.Free(net.IPNet{IP: net.IPv4(192, 0, 2, 13)}); err != nil {
+		t.Fatal(err)
+	}
+	if alloc.LargestFree() != 5 {
+		t.Fatalf("expected a free block of 5 IPs, got %d", alloc.LargestFree())
+	}
+}
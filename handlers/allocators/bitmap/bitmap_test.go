@@ -135,3 +135,75 @@ func BenchmarkParallelAllocPartiallyFilled(b *testing.B) {
 		}
 	})
 }
+
+func TestCounts(t *testing.T) {
+	alloc := getAllocator(2)
+	if alloc.Total() != 4 || alloc.Available() != 4 {
+		t.Fatalf("expected 4 of 4 prefixes available, got %d of %d", alloc.Available(), alloc.Total())
+	}
+
+	var allocated []net.IPNet
+	for i := 0; i < 4; i++ {
+		n, err := alloc.Allocate(net.IPNet{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		allocated = append(allocated, n)
+	}
+	if alloc.Available() != 0 {
+		t.Fatalf("expected no available prefixes, got %d", alloc.Available())
+	}
+	if _, err := alloc.Allocate(net.IPNet{}); err == nil {
+		t.Fatal("expected the pool to be exhausted")
+	}
+
+	if err := alloc.Free(allocated[3]); err != nil {
+		t.Fatal(err)
+	}
+	if alloc.Available() != 1 || alloc.Total() != 4 {
+		t.Fatalf("expected 1 of 4 prefixes available, got %d of %d", alloc.Available(), alloc.Total())
+	}
+}
+
+func TestLargestFree(t *testing.T) {
+	alloc := getAllocator(3)
+	if alloc.LargestFree() != 8 {
+		t.Fatalf("expected a free block of 8 prefixes, got %d", alloc.LargestFree())
+	}
+
+	var allocated []net.IPNet
+	for i := 0; i < 8; i++ {
+		n, err := alloc.Allocate(net.IPNet{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		allocated = append(allocated, n)
+	}
+	if alloc.LargestFree() != 0 {
+		t.Fatalf("expected no free block, got %d", alloc.LargestFree())
+	}
+
+	// free prefixes 1, 3-5 and 7, leaving the blocks of 1, 3 and 1 prefixes
+	for _, i := range []int{1, 3, 4, 5, 7} {
+		if err := alloc.Free(allocated[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if alloc.LargestFree() != 3 || alloc.Available() != 5 {
+		t.Fatalf("expected a free block of 3 of 5 available prefixes, got %d of %d", alloc.LargestFree(), alloc.Available())
+	}
+
+	// freeing the prefixes in between joins the blocks
+	if err := alloc.Free(allocated[6]); err != nil {
+		t.Fatal(err)
+	}
+	if alloc.LargestFree() != 5 {
+		t.Fatalf("expected a free block of 5 prefixes, got %d", alloc.LargestFree())
+	}
+	if err := alloc.Free(allocated[2]); err != nil {
+		t.Fatal(err)
+	}
+	if alloc.LargestFree() != 7 {
+		t.Fatalf("expected a free block of 7 prefixes, got %d", alloc.LargestFree())
+	}
+}
@@ -26,6 +26,8 @@ import (
 // "Module" (or "0" or "1" respectively).  The default
 // is DoNotAutoConfigure.
 type Module struct {
+	handlers.Base
+
 	AutoConfigure bool `json:"autoconfigure"`
 
 	autoConfigure dhcpv4.AutoConfiguration
@@ -76,11 +78,6 @@ func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
 	return nil
 }
 
-func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
-	// autoconfigure does not apply to DHCPv6, so just continue the chain
-	return next()
-}
-
 // Interfaces guards
 var (
 	_ handlers.HandlerModule = (*Module)(nil)
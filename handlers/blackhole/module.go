@@ -0,0 +1,114 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package blackhole
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/lion7/caddydhcp/handlers"
+	"go.uber.org/zap"
+)
+
+// Module drops requests without a reply, to test how clients fail over to another server or handle timeouts.
+// Unlike a rate limit, the requests to drop are selected deterministically: by their message type,
+// and optionally a fixed share of those, e.g. every other Request with a percentage of 50:
+//
+//	{
+//	  "handler": "blackhole",
+//	  "messageTypes": ["Request", "Renew"],
+//	  "percent": 50
+//	}
+//
+// The module can be placed anywhere in the chain; requests that are not dropped continue with the next handler.
+type Module struct {
+	// The DHCPv4 and DHCPv6 message types of the requests to drop, like "Discover", "Request" or "Solicit",
+	// see the when handler. By default requests of every message type are dropped.
+	MessageTypes []string `json:"messageTypes,omitempty"`
+
+	// The percentage of the requests with a matching message type to drop, from 1 to 100, 100 by default.
+	// Of every 100 matching requests exactly this many are dropped, spread evenly.
+	Percent int `json:"percent,omitempty"`
+
+	types4 map[dhcpv4.MessageType]bool
+	types6 map[dhcpv6.MessageType]bool
+	// matched counts the requests with a matching message type
+	matched *atomic.Uint64
+	logger  *zap.Logger
+}
+
+// CaddyModule returns the Caddy module information.
+func (Module) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "dhcp.handlers.blackhole",
+		New: func() caddy.Module { return new(Module) },
+	}
+}
+
+// Provision is run immediately after this handler is being loaded.
+func (m *Module) Provision(ctx caddy.Context) error {
+	m.logger = ctx.Logger()
+	if m.Percent == 0 {
+		m.Percent = 100
+	}
+	if m.Percent < 0 || m.Percent > 100 {
+		return fmt.Errorf("invalid percentage %d", m.Percent)
+	}
+	m.matched = new(atomic.Uint64)
+	m.types4, m.types6 = nil, nil
+	if len(m.MessageTypes) > 0 {
+		m.types4 = make(map[dhcpv4.MessageType]bool)
+		m.types6 = make(map[dhcpv6.MessageType]bool)
+	}
+	for _, name := range m.MessageTypes {
+		found := false
+		if mt, ok := handlers.ParseMessageType4(name); ok {
+			m.types4[mt] = true
+			found = true
+		}
+		if mt, ok := handlers.ParseMessageType6(name); ok {
+			m.types6[mt] = true
+			found = true
+		}
+		if !found {
+			return fmt.Errorf("unknown message type %q", name)
+		}
+	}
+	return nil
+}
+
+// drop returns whether to drop the next request with a matching message type.
+// The n-th request is dropped when it brings the number of requests to drop, n * percent / 100, up by one.
+func (m *Module) drop() bool {
+	n := m.matched.Add(1)
+	p := uint64(m.Percent)
+	return n*p/100 > (n-1)*p/100
+}
+
+// Handle4 handles DHCPv4 packets for this plugin.
+func (m *Module) Handle4(req, _ handlers.DHCPv4, next func() error) error {
+	if m.types4 != nil && !m.types4[req.MessageType()] || !m.drop() {
+		return next()
+	}
+	m.logger.Info("dropping request", zap.Stringer("message_type", req.MessageType()), zap.Stringer("mac", req.ClientHWAddr))
+	return handlers.ErrDrop
+}
+
+// Handle6 handles DHCPv6 packets for this plugin.
+func (m *Module) Handle6(req, _ handlers.DHCPv6, next func() error) error {
+	if m.types6 != nil && !m.types6[req.MessageType] || !m.drop() {
+		return next()
+	}
+	m.logger.Info("dropping request", zap.Stringer("message_type", req.MessageType), zap.Stringer("client_id", req.Options.ClientID()))
+	return handlers.ErrDrop
+}
+
+// Interfaces guards
+var (
+	_ handlers.HandlerModule = (*Module)(nil)
+)
@@ -0,0 +1,78 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package blackhole
+
+import (
+	"net"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/lion7/caddydhcp/handlers/handlertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dropped4 returns whether the module drops a DHCPv4 request of the given message type.
+func dropped4(t *testing.T, m *Module, mt dhcpv4.MessageType) bool {
+	req, err := dhcpv4.New(dhcpv4.WithHwAddr(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}), dhcpv4.WithMessageType(mt))
+	require.NoError(t, err)
+	_, err = handlertest.Handle4(t, m, handlers.NewDHCPv4(req), nil)
+	return dropped(t, err)
+}
+
+// dropped returns whether the handler dropped the request, failing the test if it returned another error.
+func dropped(t *testing.T, err error) bool {
+	if err != nil {
+		require.ErrorIs(t, err, handlers.ErrDrop)
+	}
+	return err != nil
+}
+
+func TestHandle4(t *testing.T) {
+	m := handlertest.Provision(t, &Module{MessageTypes: []string{"Request"}})
+	assert.True(t, dropped4(t, m, dhcpv4.MessageTypeRequest))
+	assert.True(t, dropped4(t, m, dhcpv4.MessageTypeRequest))
+	assert.False(t, dropped4(t, m, dhcpv4.MessageTypeDiscover))
+	assert.False(t, dropped4(t, m, dhcpv4.MessageTypeRelease))
+
+	// without message types every request is dropped
+	m = handlertest.Provision(t, &Module{})
+	assert.True(t, dropped4(t, m, dhcpv4.MessageTypeDiscover))
+	assert.True(t, dropped4(t, m, dhcpv4.MessageTypeInform))
+}
+
+func TestHandle4Percent(t *testing.T) {
+	m := handlertest.Provision(t, &Module{MessageTypes: []string{"Discover"}, Percent: 25})
+	var dropped []bool
+	for i := 0; i < 8; i++ {
+		dropped = append(dropped, dropped4(t, m, dhcpv4.MessageTypeDiscover))
+		// requests of other message types are not counted
+		assert.False(t, dropped4(t, m, dhcpv4.MessageTypeRequest))
+	}
+	assert.Equal(t, []bool{false, false, false, true, false, false, false, true}, dropped)
+}
+
+func TestHandle6(t *testing.T) {
+	m := handlertest.Provision(t, &Module{MessageTypes: []string{"Solicit"}})
+	solicit, err := dhcpv6.NewSolicit(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	_, err = handlertest.Handle6(t, m, handlers.NewDHCPv6(solicit), nil)
+	assert.True(t, dropped(t, err))
+
+	request, err := dhcpv6.NewMessage(dhcpv6.WithClientID(solicit.Options.ClientID()))
+	require.NoError(t, err)
+	request.MessageType = dhcpv6.MessageTypeRequest
+	_, err = handlertest.Handle6(t, m, handlers.NewDHCPv6(request), nil)
+	assert.False(t, dropped(t, err))
+}
+
+func TestProvision(t *testing.T) {
+	assert.Error(t, (&Module{Percent: 101}).Provision(caddy.Context{}))
+	assert.Error(t, (&Module{Percent: -1}).Provision(caddy.Context{}))
+	assert.Error(t, (&Module{MessageTypes: []string{"Bogus"}}).Provision(caddy.Context{}))
+}
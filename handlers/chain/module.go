@@ -0,0 +1,78 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package chain
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/lion7/caddydhcp/handlers"
+	"go.uber.org/zap"
+)
+
+// Module groups a list of handlers into a single handler, so a common bundle of handlers can be written once,
+// e.g. in a config template, and included in the handlers of several servers. The handlers run in order,
+// like those of a server, after which the chain continues with the handlers following this module:
+//
+//	{
+//	  "handler": "chain",
+//	  "name": "site-options",
+//	  "handle": [
+//	    {"handler": "router", "routers": ["10.0.0.1"]},
+//	    {"handler": "dns", "servers": ["10.0.0.53"]}
+//	  ]
+//	}
+//
+// A handler in the group that stops the chain also skips the handlers following this module.
+type Module struct {
+	// The name of the group, which identifies it in the logs.
+	Name string `json:"name,omitempty"`
+
+	// The handlers of the group.
+	HandlersRaw []json.RawMessage `json:"handle,omitempty" caddy:"namespace=dhcp.handlers inline_key=handler"`
+
+	chain  handlers.Chain
+	logger *zap.Logger
+}
+
+// CaddyModule returns the Caddy module information.
+func (Module) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "dhcp.handlers.chain",
+		New: func() caddy.Module { return new(Module) },
+	}
+}
+
+// Provision is run immediately after this handler is being loaded.
+func (m *Module) Provision(ctx caddy.Context) error {
+	m.logger = ctx.Logger()
+	if len(m.HandlersRaw) == 0 {
+		return fmt.Errorf("no handlers configured")
+	}
+	mods, err := handlers.LoadModule(ctx, m, "HandlersRaw")
+	if err != nil {
+		return fmt.Errorf("loading handler modules: %v", err)
+	}
+	m.chain, err = handlers.NewChain(mods)
+	return err
+}
+
+// Handle4 handles DHCPv4 packets for this plugin.
+func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
+	m.logger.Debug("running handlers", zap.String("name", m.Name))
+	return m.chain.Handle4(req, resp, next)
+}
+
+// Handle6 handles DHCPv6 packets for this plugin.
+func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
+	m.logger.Debug("running handlers", zap.String("name", m.Name))
+	return m.chain.Handle6(req, resp, next)
+}
+
+// Interfaces guards
+var (
+	_ handlers.HandlerModule = (*Module)(nil)
+)
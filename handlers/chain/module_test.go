@@ -0,0 +1,77 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package chain
+
+import (
+	"net"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// recorder is a handler appending its name to calls, and returning err instead of continuing the chain if set.
+type recorder struct {
+	name  string
+	calls *[]string
+	err   error
+}
+
+func (r recorder) Handle4(_, _ handlers.DHCPv4, next func() error) error {
+	*r.calls = append(*r.calls, r.name)
+	if r.err != nil {
+		return r.err
+	}
+	return next()
+}
+
+func (r recorder) Handle6(_, _ handlers.DHCPv6, next func() error) error {
+	*r.calls = append(*r.calls, r.name)
+	if r.err != nil {
+		return r.err
+	}
+	return next()
+}
+
+func TestHandle(t *testing.T) {
+	var calls []string
+	m := &Module{
+		Name:   "bundle",
+		chain:  handlers.Chain{recorder{name: "dns", calls: &calls}, recorder{name: "router", calls: &calls}},
+		logger: zap.NewNop(),
+	}
+	parent := handlers.Chain{recorder{name: "before", calls: &calls}, m, recorder{name: "after", calls: &calls}}
+
+	req4, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	resp4, err := dhcpv4.NewReplyFromRequest(req4)
+	require.NoError(t, err)
+	require.NoError(t, parent.Handle4(handlers.DHCPv4{DHCPv4: req4}, handlers.DHCPv4{DHCPv4: resp4}, func() error { return nil }))
+	assert.Equal(t, []string{"before", "dns", "router", "after"}, calls)
+
+	calls = nil
+	req6, err := dhcpv6.NewSolicit(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	resp6, err := dhcpv6.NewAdvertiseFromSolicit(req6)
+	require.NoError(t, err)
+	require.NoError(t, parent.Handle6(handlers.DHCPv6{Message: req6}, handlers.DHCPv6{Message: resp6}, func() error { return nil }))
+	assert.Equal(t, []string{"before", "dns", "router", "after"}, calls)
+
+	// a handler in the group stopping the chain skips the rest of the parent chain
+	calls = nil
+	m.chain = handlers.Chain{recorder{name: "stop", calls: &calls, err: handlers.ErrStopAndReply}, recorder{name: "router", calls: &calls}}
+	err = parent.Handle4(handlers.DHCPv4{DHCPv4: req4}, handlers.DHCPv4{DHCPv4: resp4}, func() error { return nil })
+	assert.ErrorIs(t, err, handlers.ErrStopAndReply)
+	assert.Equal(t, []string{"before", "stop"}, calls)
+}
+
+func TestProvision(t *testing.T) {
+	assert.Error(t, (&Module{Name: "empty"}).Provision(caddy.Context{}))
+}
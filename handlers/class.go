@@ -0,0 +1,35 @@
+package handlers
+
+// Class returns the class of this request as set by an earlier handler, e.g. the classifier,
+// or an empty string if the request was not classified.
+func (d DHCPv4) Class() string {
+	if d.state == nil {
+		return ""
+	}
+	return d.state.class
+}
+
+// SetClass sets the class of this request for the handlers later in the chain.
+// It has no effect on a request that was not wrapped using NewDHCPv4.
+func (d DHCPv4) SetClass(class string) {
+	if d.state != nil {
+		d.state.class = class
+	}
+}
+
+// Class returns the class of this request as set by an earlier handler, e.g. the classifier,
+// or an empty string if the request was not classified.
+func (d DHCPv6) Class() string {
+	if d.state == nil {
+		return ""
+	}
+	return d.state.class
+}
+
+// SetClass sets the class of this request for the handlers later in the chain.
+// It has no effect on a request that was not wrapped using NewDHCPv6.
+func (d DHCPv6) SetClass(class string) {
+	if d.state != nil {
+		d.state.class = class
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package classifier
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/lion7/caddydhcp/handlers"
+	"go.uber.org/zap"
+)
+
+// Module classifies requests using a list of rules, and sets the class of the first matching rule on the request.
+// The handlers later in the chain read it using Class, so they do not each have to inspect the vendor and user
+// classes of the request. Requests that match no rule keep the class set by an earlier handler, if any.
+type Module struct {
+	// The rules, in order of precedence.
+	Rules []Rule `json:"rules"`
+
+	logger *zap.Logger
+}
+
+// Rule assigns a class to the requests that match all of its criteria. A rule without any criteria matches every request.
+type Rule struct {
+	// The class assigned to matching requests.
+	Class string `json:"class"`
+
+	// Matches requests with a vendor class (option 60 for DHCPv4, option 16 for DHCPv6) starting with this prefix.
+	VendorClass string `json:"vendorClass,omitempty"`
+
+	// Matches requests with this user class (option 77 for DHCPv4, option 15 for DHCPv6).
+	UserClass string `json:"userClass,omitempty"`
+
+	// Matches clients whose MAC address starts with this OUI, e.g. `00:11:22`.
+	// For DHCPv6 the MAC address is taken from the DUID, if it contains one.
+	OUI string `json:"oui,omitempty"`
+
+	// Matches clients with one of these client system architecture types (option 93 for DHCPv4, option 61 for DHCPv6).
+	Arch []uint16 `json:"arch,omitempty"`
+
+	oui []byte
+}
+
+// CaddyModule returns the Caddy module information.
+func (Module) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "dhcp.handlers.classifier",
+		New: func() caddy.Module { return new(Module) },
+	}
+}
+
+// Provision is run immediately after this handler is being loaded.
+func (m *Module) Provision(ctx caddy.Context) error {
+	m.logger = ctx.Logger()
+	if len(m.Rules) == 0 {
+		return fmt.Errorf("no rules configured")
+	}
+	for i := range m.Rules {
+		rule := &m.Rules[i]
+		if rule.Class == "" {
+			return fmt.Errorf("rule %d: no class configured", i)
+		}
+		if rule.OUI != "" {
+			oui, err := parseOUI(rule.OUI)
+			if err != nil {
+				return fmt.Errorf("rule %d: %v", i, err)
+			}
+			rule.oui = oui
+		}
+	}
+	return nil
+}
+
+// parseOUI parses an OUI of three colon separated hex bytes.
+func parseOUI(s string) ([]byte, error) {
+	// pad the OUI to a MAC address, so the standard parser can be used
+	mac, err := net.ParseMAC(s + ":00:00:00")
+	if err != nil || len(mac) != 6 {
+		return nil, fmt.Errorf("invalid OUI %q", s)
+	}
+	return mac[:3], nil
+}
+
+// Handle4 handles DHCPv4 packets for this plugin.
+func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
+	vendorClasses := []string{req.ClassIdentifier()}
+	userClasses := req.UserClass()
+	for _, rule := range m.Rules {
+		if rule.matches(vendorClasses, userClasses, req.ClientHWAddr, req.ClientArch()) {
+			req.SetClass(rule.Class)
+			m.logger.Debug("classified request", zap.Stringer("mac", req.ClientHWAddr), zap.String("class", rule.Class))
+			break
+		}
+	}
+	return next()
+}
+
+// Handle6 handles DHCPv6 packets for this plugin.
+func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
+	var vendorClasses, userClasses []string
+	for _, vendorClass := range req.Options.VendorClasses() {
+		for _, data := range vendorClass.Data {
+			vendorClasses = append(vendorClasses, string(data))
+		}
+	}
+	for _, data := range req.Options.UserClasses() {
+		userClasses = append(userClasses, string(data))
+	}
+	mac, _ := dhcpv6.ExtractMAC(req.Message)
+	for _, rule := range m.Rules {
+		if rule.matches(vendorClasses, userClasses, mac, req.Options.ArchTypes()) {
+			req.SetClass(rule.Class)
+			m.logger.Debug("classified request", zap.Stringer("duid", req.Options.ClientID()), zap.String("class", rule.Class))
+			break
+		}
+	}
+	return next()
+}
+
+// matches returns whether a request with the given properties matches all criteria of the rule.
+func (r Rule) matches(vendorClasses, userClasses []string, mac net.HardwareAddr, archs []iana.Arch) bool {
+	if r.VendorClass != "" && !slices.ContainsFunc(vendorClasses, func(c string) bool { return strings.HasPrefix(c, r.VendorClass) }) {
+		return false
+	}
+	if r.UserClass != "" && !slices.Contains(userClasses, r.UserClass) {
+		return false
+	}
+	if r.oui != nil && (len(mac) < len(r.oui) || !bytes.Equal(mac[:len(r.oui)], r.oui)) {
+		return false
+	}
+	if len(r.Arch) > 0 && !slices.ContainsFunc(archs, func(a iana.Arch) bool { return slices.Contains(r.Arch, uint16(a)) }) {
+		return false
+	}
+	return true
+}
+
+// Interfaces guards
+var (
+	_ handlers.HandlerModule = (*Module)(nil)
+)
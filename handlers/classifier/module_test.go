@@ -0,0 +1,123 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package classifier
+
+import (
+	"net"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/lion7/caddydhcp/handlers/handlertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// classOf4 passes a DHCPv4 request through the module followed by a handler recording the class it sees.
+func classOf4(t *testing.T, m *Module, req *dhcpv4.DHCPv4) string {
+	var class string
+	_, err := handlertest.Handle4(t, handlers.Chain{m, recorder{class: &class}}, handlers.NewDHCPv4(req), nil)
+	require.NoError(t, err)
+	return class
+}
+
+// recorder is a handler recording the class of the request.
+type recorder struct {
+	class *string
+}
+
+func (r recorder) Handle4(req, _ handlers.DHCPv4, next func() error) error {
+	*r.class = req.Class()
+	return next()
+}
+
+func (r recorder) Handle6(req, _ handlers.DHCPv6, next func() error) error {
+	*r.class = req.Class()
+	return next()
+}
+
+// testRules returns the rules of the tests, from the most to the least specific.
+func testRules() []Rule {
+	return []Rule{
+		{Class: "ipxe", UserClass: "iPXE"},
+		{Class: "pxe-efi", VendorClass: "PXEClient", Arch: []uint16{uint16(iana.EFI_X86_64), uint16(iana.EFI_BC)}},
+		{Class: "pxe", VendorClass: "PXEClient"},
+		{Class: "phone", OUI: "00:11:22"},
+		{Class: "default"},
+	}
+}
+
+func TestClassify4(t *testing.T) {
+	m := handlertest.Provision(t, &Module{Rules: testRules()})
+	other := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+
+	for _, tc := range []struct {
+		name      string
+		mac       net.HardwareAddr
+		modifiers []dhcpv4.Modifier
+		want      string
+	}{
+		{"user class takes precedence", other, []dhcpv4.Modifier{
+			dhcpv4.WithUserClass("iPXE", false),
+			dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXEClient:Arch:00007")),
+			dhcpv4.WithOption(dhcpv4.OptClientArch(iana.EFI_X86_64)),
+		}, "ipxe"},
+		{"vendor class and arch", other, []dhcpv4.Modifier{
+			dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXEClient:Arch:00007")),
+			dhcpv4.WithOption(dhcpv4.OptClientArch(iana.EFI_X86_64)),
+		}, "pxe-efi"},
+		{"vendor class with another arch", other, []dhcpv4.Modifier{
+			dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXEClient:Arch:00000")),
+			dhcpv4.WithOption(dhcpv4.OptClientArch(iana.INTEL_X86PC)),
+		}, "pxe"},
+		{"oui", net.HardwareAddr{0x00, 0x11, 0x22, 0, 0, 1}, nil, "phone"},
+		{"fallback", other, []dhcpv4.Modifier{dhcpv4.WithOption(dhcpv4.OptClassIdentifier("MSFT 5.0"))}, "default"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := dhcpv4.NewDiscovery(tc.mac, tc.modifiers...)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, classOf4(t, m, req))
+		})
+	}
+}
+
+func TestClassifyNoMatch(t *testing.T) {
+	m := handlertest.Provision(t, &Module{Rules: []Rule{{Class: "pxe", VendorClass: "PXEClient"}}})
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	assert.Empty(t, classOf4(t, m, req))
+}
+
+func TestClassify6(t *testing.T) {
+	m := handlertest.Provision(t, &Module{Rules: testRules()})
+
+	req, err := dhcpv6.NewSolicit(net.HardwareAddr{0x00, 0x11, 0x22, 0, 0, 1})
+	require.NoError(t, err)
+	var class string
+	chain := handlers.Chain{m, recorder{class: &class}}
+	_, err = handlertest.Handle6(t, chain, handlers.NewDHCPv6(req), nil)
+	require.NoError(t, err)
+	assert.Equal(t, "phone", class)
+
+	req.AddOption(&dhcpv6.OptVendorClass{EnterpriseNumber: 343, Data: [][]byte{[]byte("PXEClient:Arch:00007")}})
+	dhcpv6.WithArchType(iana.EFI_BC)(req)
+	_, err = handlertest.Handle6(t, chain, handlers.NewDHCPv6(req), nil)
+	require.NoError(t, err)
+	assert.Equal(t, "pxe-efi", class)
+}
+
+func TestProvision(t *testing.T) {
+	for _, m := range []*Module{
+		{},
+		{Rules: []Rule{{VendorClass: "PXEClient"}}},
+		{Rules: []Rule{{Class: "phone", OUI: "00:11"}}},
+		{Rules: []Rule{{Class: "phone", OUI: "zz:11:22"}}},
+	} {
+		assert.Error(t, m.Provision(caddy.Context{}))
+	}
+}
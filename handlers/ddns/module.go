@@ -0,0 +1,341 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package ddns
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/lion7/caddydhcp/handlers/fqdn"
+	"github.com/miekg/dns"
+	"go.uber.org/zap"
+)
+
+// Module is an updater of the fqdn handler that performs RFC 2136 dynamic DNS updates.
+// For every address assigned to a client, the forward (A or AAAA) record of its name is replaced
+// in the configured zone and the PTR record is replaced in the most specific matching reverse zone, if any.
+// The records of an address are removed again when the client releases it.
+//
+// The updates are sent in the background by a single worker, in the order in which they were made,
+// so the replies never wait for the DNS server. Updates are dropped while the queue is full.
+//
+// The module keeps no state: the name registered for an address is read back from its PTR record.
+// Removing the forward record of a released address therefore requires a reverse zone for it.
+// The records of a lease that expired without a release are replaced when the address is assigned again.
+type Module struct {
+	// The authoritative DNS server to send the updates to, as host:port. The port defaults to 53.
+	Server string `json:"server"`
+
+	// The zone in which the forward records are updated.
+	Zone string `json:"zone"`
+
+	// The zones in which the PTR records are updated, e.g. `0.0.10.in-addr.arpa`.
+	ReverseZones []string `json:"reverseZones,omitempty"`
+
+	// The TTL of the records. Defaults to 1 hour.
+	TTL caddy.Duration `json:"ttl,omitempty"`
+
+	// The timeout of a single update. Defaults to 5 seconds.
+	Timeout caddy.Duration `json:"timeout,omitempty"`
+
+	// The number of updates that can wait to be sent. Defaults to 256.
+	QueueSize int `json:"queueSize,omitempty"`
+
+	// The TSIG key used to sign the updates.
+	TSIG *TSIG `json:"tsig,omitempty"`
+
+	logger *zap.Logger
+	client *dns.Client
+
+	// mu guards closing the queue against concurrent sends
+	mu     *sync.RWMutex
+	queue  chan func()
+	closed bool
+}
+
+// TSIG holds a TSIG key as described in RFC 8945.
+type TSIG struct {
+	// The name of the key.
+	Name string `json:"name"`
+
+	// The base64 encoded secret of the key.
+	Secret string `json:"secret"`
+
+	// The algorithm of the key. Defaults to `hmac-sha256`.
+	Algorithm string `json:"algorithm,omitempty"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (Module) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "dhcp.fqdn.updaters.ddns",
+		New: func() caddy.Module { return new(Module) },
+	}
+}
+
+// Provision is run immediately after this handler is being loaded.
+func (m *Module) Provision(ctx caddy.Context) error {
+	m.logger = ctx.Logger()
+
+	if m.Server == "" {
+		return fmt.Errorf("no DNS server configured")
+	}
+	if _, _, err := net.SplitHostPort(m.Server); err != nil {
+		m.Server = net.JoinHostPort(m.Server, "53")
+	}
+	if m.Zone == "" {
+		return fmt.Errorf("no zone configured")
+	}
+	m.Zone = dns.Fqdn(m.Zone)
+	for i, zone := range m.ReverseZones {
+		m.ReverseZones[i] = dns.Fqdn(zone)
+	}
+	if m.TTL == 0 {
+		m.TTL = caddy.Duration(time.Hour)
+	}
+	if m.Timeout == 0 {
+		m.Timeout = caddy.Duration(5 * time.Second)
+	}
+	if m.QueueSize < 0 {
+		return fmt.Errorf("invalid queue size %d", m.QueueSize)
+	}
+	if m.QueueSize == 0 {
+		m.QueueSize = 256
+	}
+
+	m.client = &dns.Client{Timeout: time.Duration(m.Timeout)}
+	if m.TSIG != nil {
+		if m.TSIG.Name == "" {
+			return fmt.Errorf("no TSIG key name configured")
+		}
+		if _, err := base64.StdEncoding.DecodeString(m.TSIG.Secret); err != nil {
+			return fmt.Errorf("invalid TSIG secret: %w", err)
+		}
+		m.TSIG.Name = dns.Fqdn(m.TSIG.Name)
+		if m.TSIG.Algorithm == "" {
+			m.TSIG.Algorithm = dns.HmacSHA256
+		}
+		m.TSIG.Algorithm = dns.Fqdn(m.TSIG.Algorithm)
+		m.client.TsigSecret = map[string]string{m.TSIG.Name: m.TSIG.Secret}
+	}
+	m.mu = &sync.RWMutex{}
+	m.queue = make(chan func(), m.QueueSize)
+	go m.work(m.queue)
+	return nil
+}
+
+// Cleanup stops accepting updates. The updates that were already queued are still sent.
+func (m *Module) Cleanup() error {
+	if m.mu == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.closed {
+		close(m.queue)
+		m.closed = true
+	}
+	return nil
+}
+
+// Update queues the update of the records of fqdn and addr.
+func (m *Module) Update(fqdn string, addr net.IP, forward bool) error {
+	if fqdn == "" {
+		return nil
+	}
+	// the address may be part of a reply that is reused after this call returns
+	ip := append(net.IP(nil), addr...)
+	return m.enqueue(func() { m.add(fqdn, ip, forward) })
+}
+
+// Remove queues the removal of the records of addr.
+func (m *Module) Remove(addr net.IP) error {
+	ip := append(net.IP(nil), addr...)
+	return m.enqueue(func() { m.remove(ip) })
+}
+
+func (m *Module) enqueue(update func()) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.closed {
+		return fmt.Errorf("DNS updater is stopped")
+	}
+	select {
+	case m.queue <- update:
+		return nil
+	default:
+		return fmt.Errorf("DNS update queue is full")
+	}
+}
+
+// work sends the queued updates one by one, until the queue is closed.
+func (m *Module) work(queue <-chan func()) {
+	for update := range queue {
+		update()
+	}
+}
+
+// add replaces the records of name and ip. The forward record is only updated if forward is true.
+func (m *Module) add(name string, ip net.IP, forward bool) {
+	if !strings.Contains(strings.TrimSuffix(name, "."), ".") {
+		name += "." + m.Zone
+	}
+	name = dns.Fqdn(name)
+	hdr := dns.RR_Header{Name: name, Class: dns.ClassINET, Ttl: uint32(time.Duration(m.TTL).Seconds())}
+	ptr, zone := m.reverse(ip)
+
+	// the previous client of the address may not have released it
+	if zone != "" {
+		if prev, err := m.lookupPTR(ptr); err != nil {
+			m.logger.Warn("failed to look up reverse record", zap.Stringer("ip", ip), zap.Error(err))
+		} else if prev != "" && !strings.EqualFold(prev, name) {
+			m.removeForward(prev, ip)
+		}
+	}
+
+	if forward {
+		if !dns.IsSubDomain(m.Zone, name) {
+			m.logger.Warn("name is not part of the zone, skipping forward update", zap.String("name", name), zap.String("zone", m.Zone))
+		} else if err := m.update(m.Zone, func(msg *dns.Msg) {
+			rr := addressRecord(hdr, ip)
+			msg.RemoveRRset([]dns.RR{rr})
+			msg.Insert([]dns.RR{rr})
+		}); err != nil {
+			m.logger.Warn("failed to update forward record", zap.String("name", name), zap.Stringer("ip", ip), zap.Error(err))
+		}
+	}
+
+	if zone != "" {
+		if err := m.update(zone, func(msg *dns.Msg) {
+			rr := &dns.PTR{Hdr: hdr, Ptr: name}
+			rr.Hdr.Name, rr.Hdr.Rrtype = ptr, dns.TypePTR
+			msg.RemoveRRset([]dns.RR{rr})
+			msg.Insert([]dns.RR{rr})
+		}); err != nil {
+			m.logger.Warn("failed to update reverse record", zap.String("name", name), zap.Stringer("ip", ip), zap.Error(err))
+		}
+	}
+}
+
+// remove deletes the records of ip, finding the name of its forward record through its PTR record.
+func (m *Module) remove(ip net.IP) {
+	if ip == nil || ip.IsUnspecified() {
+		return
+	}
+	ptr, zone := m.reverse(ip)
+	if zone == "" {
+		m.logger.Debug("no reverse zone for the released address, keeping its records", zap.Stringer("ip", ip))
+		return
+	}
+	name, err := m.lookupPTR(ptr)
+	if err != nil {
+		m.logger.Warn("failed to look up reverse record", zap.Stringer("ip", ip), zap.Error(err))
+		return
+	}
+	if name == "" {
+		return
+	}
+	m.removeForward(name, ip)
+	if err := m.update(zone, func(msg *dns.Msg) {
+		msg.RemoveRRset([]dns.RR{&dns.PTR{Hdr: dns.RR_Header{Name: ptr, Rrtype: dns.TypePTR, Class: dns.ClassINET}}})
+	}); err != nil {
+		m.logger.Warn("failed to remove reverse record", zap.Stringer("ip", ip), zap.Error(err))
+	}
+}
+
+// removeForward deletes the forward record of name pointing to ip, leaving its other addresses alone.
+func (m *Module) removeForward(name string, ip net.IP) {
+	if !dns.IsSubDomain(m.Zone, name) {
+		return
+	}
+	if err := m.update(m.Zone, func(msg *dns.Msg) {
+		msg.Remove([]dns.RR{addressRecord(dns.RR_Header{Name: name, Class: dns.ClassINET}, ip)})
+	}); err != nil {
+		m.logger.Warn("failed to remove forward record", zap.String("name", name), zap.Stringer("ip", ip), zap.Error(err))
+	}
+}
+
+// lookupPTR queries the server for the name in the PTR record ptr. It returns an empty string if there is none.
+func (m *Module) lookupPTR(ptr string) (string, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(ptr, dns.TypePTR)
+	r, err := m.exchange(msg)
+	if err != nil {
+		return "", err
+	}
+	if r.Rcode == dns.RcodeNameError {
+		return "", nil
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return "", fmt.Errorf("server responded with %s", dns.RcodeToString[r.Rcode])
+	}
+	for _, rr := range r.Answer {
+		if rec, ok := rr.(*dns.PTR); ok {
+			return rec.Ptr, nil
+		}
+	}
+	return "", nil
+}
+
+// update sends a single update message for zone, filled in by build.
+func (m *Module) update(zone string, build func(msg *dns.Msg)) error {
+	msg := new(dns.Msg)
+	msg.SetUpdate(zone)
+	build(msg)
+	r, err := m.exchange(msg)
+	if err != nil {
+		return err
+	}
+	if r.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("server responded with %s", dns.RcodeToString[r.Rcode])
+	}
+	return nil
+}
+
+// exchange signs msg with the TSIG key, if any, and sends it to the server.
+func (m *Module) exchange(msg *dns.Msg) (*dns.Msg, error) {
+	if m.TSIG != nil {
+		msg.SetTsig(m.TSIG.Name, m.TSIG.Algorithm, 300, time.Now().Unix())
+	}
+	r, _, err := m.client.Exchange(msg, m.Server)
+	return r, err
+}
+
+// reverse returns the PTR name of ip and the most specific configured reverse zone containing it.
+func (m *Module) reverse(ip net.IP) (string, string) {
+	ptr, err := dns.ReverseAddr(ip.String())
+	if err != nil {
+		return "", ""
+	}
+	var zone string
+	for _, z := range m.ReverseZones {
+		if dns.IsSubDomain(z, ptr) && len(z) > len(zone) {
+			zone = z
+		}
+	}
+	return ptr, zone
+}
+
+// addressRecord returns an A or AAAA record for ip, depending on its family.
+func addressRecord(hdr dns.RR_Header, ip net.IP) dns.RR {
+	if ip4 := ip.To4(); ip4 != nil {
+		hdr.Rrtype = dns.TypeA
+		return &dns.A{Hdr: hdr, A: ip4}
+	}
+	hdr.Rrtype = dns.TypeAAAA
+	return &dns.AAAA{Hdr: hdr, AAAA: ip}
+}
+
+// Interfaces guards
+var (
+	_ fqdn.Updater       = (*Module)(nil)
+	_ caddy.Provisioner  = (*Module)(nil)
+	_ caddy.CleanerUpper = (*Module)(nil)
+)
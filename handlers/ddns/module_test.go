@@ -0,0 +1,242 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package ddns
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	keyName = "dhcp-key."
+	secret  = "c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0" // "secretsecretsecretsecret"
+)
+
+// updateServer is a mock authoritative DNS server recording the update messages it receives.
+// It keeps the PTR records, which the module reads back to find the records of an address.
+type updateServer struct {
+	addr string
+
+	mu      sync.Mutex
+	updates []*dns.Msg
+	ptrs    map[string]string
+}
+
+func newUpdateServer(t *testing.T) *updateServer {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := &updateServer{addr: pc.LocalAddr().String(), ptrs: make(map[string]string)}
+	started := make(chan struct{})
+	srv := &dns.Server{
+		PacketConn:        pc,
+		TsigSecret:        map[string]string{keyName: secret},
+		NotifyStartedFunc: func() { close(started) },
+		// the default accept func rejects update messages
+		MsgAcceptFunc: func(dns.Header) dns.MsgAcceptAction { return dns.MsgAccept },
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			resp := new(dns.Msg)
+			resp.SetReply(r)
+			if r.IsTsig() == nil || w.TsigStatus() != nil {
+				resp.Rcode = dns.RcodeNotAuth
+			} else {
+				s.serve(r, resp)
+				resp.SetTsig(keyName, dns.HmacSHA256, 300, time.Now().Unix())
+			}
+			_ = w.WriteMsg(resp)
+		}),
+	}
+	go func() { _ = srv.ActivateAndServe() }()
+	t.Cleanup(func() { _ = srv.Shutdown() })
+	<-started
+	return s
+}
+
+// serve records an update and applies it to the PTR records, or answers a PTR query.
+func (s *updateServer) serve(r, resp *dns.Msg) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.Opcode != dns.OpcodeUpdate {
+		target, ok := s.ptrs[r.Question[0].Name]
+		if !ok {
+			resp.Rcode = dns.RcodeNameError
+			return
+		}
+		resp.Answer = append(resp.Answer, &dns.PTR{
+			Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypePTR, Class: dns.ClassINET},
+			Ptr: target,
+		})
+		return
+	}
+	s.updates = append(s.updates, r)
+	for _, rr := range r.Ns {
+		if ptr, ok := rr.(*dns.PTR); ok && rr.Header().Class == dns.ClassINET {
+			s.ptrs[rr.Header().Name] = ptr.Ptr
+		} else if rr.Header().Rrtype == dns.TypePTR {
+			delete(s.ptrs, rr.Header().Name)
+		}
+	}
+}
+
+// wait waits until the server received n updates, and returns them.
+func (s *updateServer) wait(t *testing.T, n int) []*dns.Msg {
+	require.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.updates) >= n
+	}, 5*time.Second, 10*time.Millisecond)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	updates := s.updates
+	s.updates = nil
+	return updates
+}
+
+func newModule(t *testing.T, server string) *Module {
+	m := &Module{
+		Server:       server,
+		Zone:         "example.org",
+		ReverseZones: []string{"in-addr.arpa", "0.0.10.in-addr.arpa", "8.b.d.0.1.0.0.2.ip6.arpa"},
+		TTL:          caddy.Duration(10 * time.Minute),
+		TSIG:         &TSIG{Name: "dhcp-key", Secret: secret},
+	}
+	require.NoError(t, m.Provision(caddy.Context{}))
+	t.Cleanup(func() { _ = m.Cleanup() })
+	return m
+}
+
+// assertUpdate asserts that msg is an update of zone consisting of exactly the given records.
+func assertUpdate(t *testing.T, msg *dns.Msg, zone string, records ...string) {
+	require.Len(t, msg.Question, 1)
+	assert.Equal(t, dns.OpcodeUpdate, msg.Opcode)
+	assert.Equal(t, zone, msg.Question[0].Name)
+	var got []string
+	for _, rr := range msg.Ns {
+		got = append(got, rr.String())
+	}
+	assert.Equal(t, records, got)
+}
+
+func TestUpdateAndRemove(t *testing.T) {
+	server := newUpdateServer(t)
+	m := newModule(t, server.addr)
+
+	require.NoError(t, m.Update("laptop.example.org", net.IPv4(10, 0, 0, 10), true))
+	updates := server.wait(t, 2)
+	require.Len(t, updates, 2)
+	assertUpdate(t, updates[0], "example.org.",
+		"laptop.example.org.\t0\tCLASS255\tA\t",
+		"laptop.example.org.\t600\tIN\tA\t10.0.0.10",
+	)
+	assertUpdate(t, updates[1], "0.0.10.in-addr.arpa.",
+		"10.0.0.10.in-addr.arpa.\t0\tCLASS255\tPTR\t",
+		"10.0.0.10.in-addr.arpa.\t600\tIN\tPTR\tlaptop.example.org.",
+	)
+
+	// the name of the forward record is read back from the PTR record
+	require.NoError(t, m.Remove(net.IPv4(10, 0, 0, 10)))
+	updates = server.wait(t, 2)
+	require.Len(t, updates, 2)
+	assertUpdate(t, updates[0], "example.org.",
+		"laptop.example.org.\t0\tNONE\tA\t10.0.0.10",
+	)
+	assertUpdate(t, updates[1], "0.0.10.in-addr.arpa.",
+		"10.0.0.10.in-addr.arpa.\t0\tCLASS255\tPTR\t",
+	)
+}
+
+func TestUpdateClientUpdatesForward(t *testing.T) {
+	server := newUpdateServer(t)
+	m := newModule(t, server.addr)
+
+	// the client performs the forward update itself, so only the PTR record is updated
+	require.NoError(t, m.Update("laptop", net.IPv4(10, 0, 0, 10), false))
+	updates := server.wait(t, 1)
+	require.Len(t, updates, 1)
+	assertUpdate(t, updates[0], "0.0.10.in-addr.arpa.",
+		"10.0.0.10.in-addr.arpa.\t0\tCLASS255\tPTR\t",
+		"10.0.0.10.in-addr.arpa.\t600\tIN\tPTR\tlaptop.example.org.",
+	)
+}
+
+func TestUpdateReassigned(t *testing.T) {
+	server := newUpdateServer(t)
+	m := newModule(t, server.addr)
+
+	require.NoError(t, m.Update("laptop.example.org", net.IPv4(10, 0, 0, 10), true))
+	server.wait(t, 2)
+
+	// the lease of the laptop expired without a release, so its forward record is removed
+	require.NoError(t, m.Update("phone.example.org", net.IPv4(10, 0, 0, 10), true))
+	updates := server.wait(t, 3)
+	require.Len(t, updates, 3)
+	assertUpdate(t, updates[0], "example.org.",
+		"laptop.example.org.\t0\tNONE\tA\t10.0.0.10",
+	)
+	assertUpdate(t, updates[1], "example.org.",
+		"phone.example.org.\t0\tCLASS255\tA\t",
+		"phone.example.org.\t600\tIN\tA\t10.0.0.10",
+	)
+	assertUpdate(t, updates[2], "0.0.10.in-addr.arpa.",
+		"10.0.0.10.in-addr.arpa.\t0\tCLASS255\tPTR\t",
+		"10.0.0.10.in-addr.arpa.\t600\tIN\tPTR\tphone.example.org.",
+	)
+}
+
+func TestUpdate6(t *testing.T) {
+	server := newUpdateServer(t)
+	m := newModule(t, server.addr)
+
+	require.NoError(t, m.Update("laptop.example.org", net.ParseIP("2001:db8::10"), true))
+	updates := server.wait(t, 2)
+	require.Len(t, updates, 2)
+	assertUpdate(t, updates[0], "example.org.",
+		"laptop.example.org.\t0\tCLASS255\tAAAA\t",
+		"laptop.example.org.\t600\tIN\tAAAA\t2001:db8::10",
+	)
+	assert.Equal(t, "8.b.d.0.1.0.0.2.ip6.arpa.", updates[1].Question[0].Name)
+}
+
+func TestQueue(t *testing.T) {
+	// an unreachable server, so the worker blocks on the first update until it times out
+	m := &Module{Server: "192.0.2.1", Zone: "example.org", Timeout: caddy.Duration(time.Second), QueueSize: 1}
+	require.NoError(t, m.Provision(caddy.Context{}))
+
+	start := time.Now()
+	var err error
+	for i := 0; i < 3 && err == nil; i++ {
+		err = m.Update("laptop.example.org", net.IPv4(10, 0, 0, 10), true)
+	}
+	assert.ErrorContains(t, err, "queue is full")
+	// queueing never waits for the DNS server
+	assert.Less(t, time.Since(start), time.Second)
+
+	require.NoError(t, m.Cleanup())
+	assert.ErrorContains(t, m.Remove(net.IPv4(10, 0, 0, 10)), "stopped")
+}
+
+func TestProvision(t *testing.T) {
+	for _, m := range []*Module{
+		{Zone: "example.org"},
+		{Server: "127.0.0.1"},
+		{Server: "127.0.0.1", Zone: "example.org", QueueSize: -1},
+		{Server: "127.0.0.1", Zone: "example.org", TSIG: &TSIG{Secret: secret}},
+		{Server: "127.0.0.1", Zone: "example.org", TSIG: &TSIG{Name: "key", Secret: "not base64!"}},
+	} {
+		assert.Error(t, m.Provision(caddy.Context{}))
+	}
+
+	m := &Module{Server: "127.0.0.1", Zone: "example.org"}
+	require.NoError(t, m.Provision(caddy.Context{}))
+	assert.Equal(t, "127.0.0.1:53", m.Server)
+	require.NoError(t, m.Cleanup())
+}
@@ -0,0 +1,101 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package denyunknown
+
+import (
+	"net"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/lion7/caddydhcp/handlers"
+	"go.uber.org/zap"
+)
+
+// Module rejects clients for which none of the preceding handlers allocated an address.
+// A DHCPv4 Ack without a client address is turned into a Nak, and every IA_NA requested
+// in a DHCPv6 Solicit or Request that did not get an address is answered with a NoAddrsAvail status code.
+// The server itself sends the IA_NAs as the handlers left them, so servers that assign addresses
+// use this handler to signal exhaustion as RFC 8415 sections 18.3.1 and 18.3.2 require.
+//
+// It is a terminal handler: once it rejected a client, the rest of the chain is skipped, so no later handler
+// decorates the rejection. Other replies are passed on. Since it only sees the addresses allocated by the
+// preceding handlers, it belongs after all handlers that allocate addresses.
+type Module struct {
+	logger *zap.Logger
+}
+
+// CaddyModule returns the Caddy module information.
+func (Module) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "dhcp.handlers.denyunknown",
+		New: func() caddy.Module { return new(Module) },
+	}
+}
+
+// Provision is run immediately after this handler is being loaded.
+func (m *Module) Provision(ctx caddy.Context) error {
+	m.logger = ctx.Logger()
+	return nil
+}
+
+// Handle4 handles DHCPv4 packets for this plugin.
+func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
+	if resp.MessageType() != dhcpv4.MessageTypeAck {
+		return next()
+	}
+	if resp.YourIPAddr != nil && !resp.YourIPAddr.IsUnspecified() {
+		return next()
+	}
+	m.logger.Info("no address allocated, sending NAK", zap.Stringer("mac", req.ClientHWAddr))
+	resp.YourIPAddr = net.IPv4zero
+	resp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeNak))
+	resp.UpdateOption(dhcpv4.OptMessage("no address available"))
+	return nil
+}
+
+// Handle6 handles DHCPv6 packets for this plugin.
+func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
+	// only a Solicit or Request asks for new addresses, other messages merely refer to the IA_NAs of the client
+	if req.MessageType != dhcpv6.MessageTypeSolicit && req.MessageType != dhcpv6.MessageTypeRequest {
+		return next()
+	}
+	rejected := false
+	for _, reqIANA := range req.Options.IANA() {
+		respIANA := findIANA(resp.Options.IANA(), reqIANA.IaId)
+		if respIANA == nil {
+			respIANA = &dhcpv6.OptIANA{IaId: reqIANA.IaId}
+			resp.AddOption(respIANA)
+		}
+		if len(respIANA.Options.Addresses()) > 0 || respIANA.Options.Status() != nil {
+			continue
+		}
+		m.logger.Info("no address allocated, sending NoAddrsAvail", zap.Binary("iaid", reqIANA.IaId[:]))
+		respIANA.Options.Add(&dhcpv6.OptStatusCode{
+			StatusCode:    iana.StatusNoAddrsAvail,
+			StatusMessage: "no address available",
+		})
+		rejected = true
+	}
+	if rejected {
+		return nil
+	}
+	return next()
+}
+
+func findIANA(ianas []*dhcpv6.OptIANA, iaid [4]byte) *dhcpv6.OptIANA {
+	for _, o := range ianas {
+		if o.IaId == iaid {
+			return o
+		}
+	}
+	return nil
+}
+
+// Interfaces guards
+var (
+	_ handlers.HandlerModule = (*Module)(nil)
+)
@@ -0,0 +1,147 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package denyunknown
+
+import (
+	"net"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandle4(t *testing.T) {
+	m := &Module{}
+	require.NoError(t, m.Provision(caddy.Context{}))
+
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest))
+	require.NoError(t, err)
+
+	t.Run("unleased", func(t *testing.T) {
+		resp, err := dhcpv4.NewReplyFromRequest(req, dhcpv4.WithMessageType(dhcpv4.MessageTypeAck))
+		require.NoError(t, err)
+
+		called := false
+		err = m.Handle4(handlers.DHCPv4{DHCPv4: req}, handlers.DHCPv4{DHCPv4: resp}, func() error {
+			called = true
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, dhcpv4.MessageTypeNak, resp.MessageType())
+		assert.True(t, resp.YourIPAddr.IsUnspecified())
+		assert.False(t, called, "the rest of the chain is skipped")
+	})
+
+	t.Run("leased", func(t *testing.T) {
+		resp, err := dhcpv4.NewReplyFromRequest(req,
+			dhcpv4.WithMessageType(dhcpv4.MessageTypeAck),
+			dhcpv4.WithYourIP(net.IPv4(10, 0, 0, 10)),
+		)
+		require.NoError(t, err)
+
+		called := false
+		err = m.Handle4(handlers.DHCPv4{DHCPv4: req}, handlers.DHCPv4{DHCPv4: resp}, func() error {
+			called = true
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, dhcpv4.MessageTypeAck, resp.MessageType())
+		assert.True(t, called, "the chain is continued")
+	})
+}
+
+func TestHandle6(t *testing.T) {
+	m := &Module{}
+	require.NoError(t, m.Provision(caddy.Context{}))
+
+	req, err := dhcpv6.NewMessage(dhcpv6.WithClientID(&dhcpv6.DUIDLL{
+		HWType:        iana.HWTypeEthernet,
+		LinkLayerAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+	}))
+	require.NoError(t, err)
+	req.MessageType = dhcpv6.MessageTypeRequest
+	req.AddOption(&dhcpv6.OptIANA{IaId: [4]byte{0, 0, 0, 1}})
+	req.AddOption(&dhcpv6.OptIANA{IaId: [4]byte{0, 0, 0, 2}})
+
+	resp, err := dhcpv6.NewReplyFromMessage(req)
+	require.NoError(t, err)
+	resp.AddOption(&dhcpv6.OptIANA{
+		IaId: [4]byte{0, 0, 0, 1},
+		Options: dhcpv6.IdentityOptions{Options: dhcpv6.Options{
+			&dhcpv6.OptIAAddress{IPv6Addr: net.ParseIP("2001:db8::1")},
+		}},
+	})
+
+	called := false
+	err = m.Handle6(handlers.DHCPv6{Message: req}, handlers.DHCPv6{Message: resp}, func() error {
+		called = true
+		return nil
+	})
+	require.NoError(t, err)
+	assert.False(t, called, "the rest of the chain is skipped")
+
+	ianas := resp.Options.IANA()
+	require.Len(t, ianas, 2)
+	assert.Nil(t, ianas[0].Options.Status())
+	assert.Equal(t, [4]byte{0, 0, 0, 2}, ianas[1].IaId)
+	require.NotNil(t, ianas[1].Options.Status())
+	assert.Equal(t, iana.StatusNoAddrsAvail, ianas[1].Options.Status().StatusCode)
+}
+
+func TestHandle6Assigned(t *testing.T) {
+	m := &Module{}
+	require.NoError(t, m.Provision(caddy.Context{}))
+
+	req, err := dhcpv6.NewMessage(dhcpv6.WithClientID(&dhcpv6.DUIDLL{
+		HWType:        iana.HWTypeEthernet,
+		LinkLayerAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+	}))
+	require.NoError(t, err)
+	req.MessageType = dhcpv6.MessageTypeRequest
+	req.AddOption(&dhcpv6.OptIANA{IaId: [4]byte{0, 0, 0, 1}})
+	resp, err := dhcpv6.NewReplyFromMessage(req)
+	require.NoError(t, err)
+	resp.AddOption(&dhcpv6.OptIANA{
+		IaId: [4]byte{0, 0, 0, 1},
+		Options: dhcpv6.IdentityOptions{Options: dhcpv6.Options{
+			&dhcpv6.OptIAAddress{IPv6Addr: net.ParseIP("2001:db8::1")},
+		}},
+	})
+
+	called := false
+	err = m.Handle6(handlers.DHCPv6{Message: req}, handlers.DHCPv6{Message: resp}, func() error {
+		called = true
+		return nil
+	})
+	require.NoError(t, err)
+	assert.True(t, called, "the chain is continued")
+	assert.Nil(t, resp.Options.OneIANA().Options.Status())
+}
+
+func TestHandle6NotRequestingAddresses(t *testing.T) {
+	m := &Module{}
+	require.NoError(t, m.Provision(caddy.Context{}))
+
+	for _, mt := range []dhcpv6.MessageType{dhcpv6.MessageTypeRenew, dhcpv6.MessageTypeRebind, dhcpv6.MessageTypeRelease, dhcpv6.MessageTypeInformationRequest} {
+		req, err := dhcpv6.NewMessage(dhcpv6.WithClientID(&dhcpv6.DUIDLL{
+			HWType:        iana.HWTypeEthernet,
+			LinkLayerAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+		}))
+		require.NoError(t, err)
+		req.MessageType = mt
+		req.AddOption(&dhcpv6.OptIANA{IaId: [4]byte{0, 0, 0, 1}})
+		resp, err := dhcpv6.NewReplyFromMessage(req)
+		require.NoError(t, err)
+
+		err = m.Handle6(handlers.DHCPv6{Message: req}, handlers.DHCPv6{Message: resp}, func() error { return nil })
+		require.NoError(t, err)
+		assert.Empty(t, resp.Options.IANA(), mt.String())
+	}
+}
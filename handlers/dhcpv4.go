@@ -0,0 +1,361 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"net"
+	"sort"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+const (
+	// optionsOffset is the length of the fixed BOOTP header plus the magic cookie,
+	// i.e. the offset at which the options start in a serialized DHCPv4 message.
+	optionsOffset = 240
+
+	// bootpMinLen is the minimum length of a BOOTP message as per RFC 951.
+	bootpMinLen = 300
+
+	// snameOffset and snameLen describe the location of the sname field in a serialized message.
+	snameOffset = 44
+	snameLen    = 64
+
+	// fileOffset and fileLen describe the location of the file field in a serialized message.
+	fileOffset = 108
+	fileLen    = 128
+
+	// Values of the Option Overload option (52), see RFC 2132 section 9.3.
+	overloadFile  = 1
+	overloadSname = 2
+
+	// minMaxMessageSize is the size of the largest message that every client must accept,
+	// including the IP and UDP headers, as per RFC 2131 section 2.
+	minMaxMessageSize = 576
+
+	// ipUDPHeaderLen is the length of the IPv4 and UDP headers without any IP options.
+	ipUDPHeaderLen = 20 + 8
+)
+
+// criticalOptions are the options that ToBytesTrimmed never removes, since a client cannot use a reply without them.
+var criticalOptions = map[uint8]bool{
+	dhcpv4.OptionDHCPMessageType.Code():       true,
+	dhcpv4.OptionServerIdentifier.Code():      true,
+	dhcpv4.OptionIPAddressLeaseTime.Code():    true,
+	dhcpv4.OptionRenewTimeValue.Code():        true,
+	dhcpv4.OptionRebindingTimeValue.Code():    true,
+	dhcpv4.OptionSubnetMask.Code():            true,
+	dhcpv4.OptionRelayAgentInformation.Code(): true,
+}
+
+// ClientIdentifierPrefix is the prefix of reservation keys that are based on the
+// Client Identifier option (61) instead of the client hardware address.
+const ClientIdentifierPrefix = "cid:"
+
+// RequestedOptions returns the parsed Parameter Request List (option 55) of this message,
+// in the order in which the client sent it. Duplicate codes are only returned once.
+// It returns nil if the client did not send a Parameter Request List.
+func (d DHCPv4) RequestedOptions() dhcpv4.OptionCodeList {
+	prl := d.ParameterRequestList()
+	if prl == nil {
+		return nil
+	}
+	var codes dhcpv4.OptionCodeList
+	codes.Add(prl...)
+	return codes
+}
+
+// optionSet4 is the set of DHCPv4 option codes in a Parameter Request List.
+type optionSet4 struct {
+	// all is true if the request has no Parameter Request List, in which case every option is requested
+	all  bool
+	bits [4]uint64
+}
+
+// newOptionSet4 parses the Parameter Request List of m.
+func newOptionSet4(m *dhcpv4.DHCPv4) *optionSet4 {
+	prl := m.ParameterRequestList()
+	if prl == nil {
+		return &optionSet4{all: true}
+	}
+	s := &optionSet4{}
+	for _, code := range prl {
+		c := code.Code()
+		s.bits[c/64] |= 1 << (c % 64)
+	}
+	return s
+}
+
+// has reports whether the option with the given code is in the set.
+func (s *optionSet4) has(c uint8) bool {
+	return s.all || s.bits[c/64]&(1<<(c%64)) != 0
+}
+
+// IsOptionRequested reports whether the client requested the option in its Parameter Request List (option 55),
+// which is true for every option if the client did not send one, like dhcpv4.DHCPv4.IsOptionRequested.
+// For a request wrapped using NewDHCPv4 the list is parsed once when it is wrapped, instead of on every call
+// by every handler in the chain.
+func (d DHCPv4) IsOptionRequested(code dhcpv4.OptionCode) bool {
+	if d.state == nil || d.state.requested4 == nil {
+		return d.DHCPv4.IsOptionRequested(code)
+	}
+	return d.state.requested4.has(code.Code())
+}
+
+// UserClasses returns the user classes in the User Class option (77) of this message, which RFC 3004 defines
+// as a sequence of entries that are each prefixed with their length. Many clients, like iPXE, send a single
+// class as a plain string instead, so a value that is not a valid sequence of non-empty entries is returned
+// as a single class. It returns nil if the client did not send a User Class option.
+func (d DHCPv4) UserClasses() []string {
+	data := d.Options.Get(dhcpv4.OptionUserClassInformation)
+	if len(data) == 0 {
+		return nil
+	}
+	var classes []string
+	for rest := data; len(rest) > 0; {
+		n := int(rest[0])
+		if n == 0 || n >= len(rest) {
+			return []string{string(data)}
+		}
+		classes = append(classes, string(rest[1:1+n]))
+		rest = rest[1+n:]
+	}
+	return classes
+}
+
+// MaxReplySize returns the size of the largest DHCP message, excluding the IP and UDP headers,
+// that the sender of this message accepts. It is based on the Maximum DHCP Message Size option (57),
+// which cannot be smaller than 576 bytes, and defaults to that minimum when the option is absent.
+func (d DHCPv4) MaxReplySize() int {
+	size, err := d.MaxMessageSize()
+	if err != nil || size < minMaxMessageSize {
+		size = minMaxMessageSize
+	}
+	return int(size) - ipUDPHeaderLen
+}
+
+// ClientIdentifierKey returns the Client Identifier option (61) of this message as a reservation key,
+// which is ClientIdentifierPrefix followed by the identifier in lowercase hex.
+// It returns an empty string if the client did not send a Client Identifier.
+func (d DHCPv4) ClientIdentifierKey() string {
+	cid := d.Options.Get(dhcpv4.OptionClientIdentifier)
+	if len(cid) == 0 {
+		return ""
+	}
+	return ClientIdentifierPrefix + hex.EncodeToString(cid)
+}
+
+// RelayAddress returns the gateway IP address (giaddr) of this message, i.e. the address of the relay agent
+// that forwarded it on the subnet of the client. It returns nil if the message was not relayed.
+func (d DHCPv4) RelayAddress() net.IP {
+	if d.GatewayIPAddr == nil || d.GatewayIPAddr.IsUnspecified() {
+		return nil
+	}
+	return d.GatewayIPAddr.To4()
+}
+
+// LinkAddress returns an address on the subnet of the client, which selects the subnet to serve it from.
+// In order of precedence, this is the Subnet Selection option (118, RFC 3011), the Link Selection
+// sub-option of the Relay Agent Information option (RFC 3527) and the gateway IP address.
+// It returns nil if the client is on a network the server is directly attached to.
+func (d DHCPv4) LinkAddress() net.IP {
+	if ip := net.IP(d.Options.Get(dhcpv4.OptionSubnetSelection)); len(ip) == net.IPv4len {
+		return ip
+	}
+	if rai := d.RelayAgentInfo(); rai != nil {
+		if ip := net.IP(rai.Get(dhcpv4.LinkSelectionSubOption)); len(ip) == net.IPv4len {
+			return ip
+		}
+	}
+	return d.RelayAddress()
+}
+
+// ToBytesOrdered serializes the message like ToBytes, but emits the options listed in order first,
+// in that same order. The remaining options follow in ascending order of their code, except for the
+// Relay Agent Information option (82) which is always written last as required by RFC 3046.
+func (d DHCPv4) ToBytesOrdered(order dhcpv4.OptionCodeList) []byte {
+	options, agentInfo := d.serializeOptions(order)
+	buf := bytes.NewBuffer(d.serializeHeader())
+	for _, opt := range options {
+		buf.Write(opt)
+	}
+	for _, opt := range agentInfo {
+		buf.Write(opt)
+	}
+	return finish(buf)
+}
+
+// ToBytesLimited serializes the message like ToBytesOrdered. If the result exceeds size bytes,
+// the options that do not fit are moved into the unused file and sname fields, which is signaled
+// using the Option Overload option (52) as described in RFC 2132 section 9.3.
+// The message is returned as serialized by ToBytesOrdered if it does not fit even then.
+func (d DHCPv4) ToBytesLimited(order dhcpv4.OptionCodeList, size int) []byte {
+	b := d.ToBytesOrdered(order)
+	if len(b) <= size {
+		return b
+	}
+
+	options, agentInfo := d.serializeOptions(order)
+	// the options field holds the Option Overload option, the Relay Agent Information option and End
+	capacity := [3]int{size - optionsOffset - 3 - 1, 0, 0}
+	for _, opt := range agentInfo {
+		capacity[0] -= len(opt)
+	}
+	if capacity[0] < 0 {
+		return b
+	}
+	// the file and sname fields can only be used if they are empty, and must be terminated with End
+	if d.BootFileName == "" {
+		capacity[1] = fileLen - 1
+	}
+	if d.ServerHostName == "" {
+		capacity[2] = snameLen - 1
+	}
+
+	// fill the fields in order, so options split as per RFC 3396 are reassembled in the right order
+	var fields [3]bytes.Buffer
+	field := 0
+	for _, opt := range options {
+		for field < len(fields) && fields[field].Len()+len(opt) > capacity[field] {
+			field++
+		}
+		if field == len(fields) {
+			return b
+		}
+		fields[field].Write(opt)
+	}
+
+	header := d.serializeHeader()
+	var overload uint8
+	if fields[1].Len() > 0 {
+		overload |= overloadFile
+		fields[1].WriteByte(dhcpv4.OptionEnd.Code())
+		copy(header[fileOffset:fileOffset+fileLen], fields[1].Bytes())
+	}
+	if fields[2].Len() > 0 {
+		overload |= overloadSname
+		fields[2].WriteByte(dhcpv4.OptionEnd.Code())
+		copy(header[snameOffset:snameOffset+snameLen], fields[2].Bytes())
+	}
+
+	buf := bytes.NewBuffer(header)
+	buf.Write([]byte{dhcpv4.OptionOptionOverload.Code(), 1, overload})
+	buf.Write(fields[0].Bytes())
+	for _, opt := range agentInfo {
+		buf.Write(opt)
+	}
+	return finish(buf)
+}
+
+// ToBytesTrimmed serializes the message like ToBytesLimited. If the result still exceeds size bytes,
+// options are removed from a copy of the message until it fits, leaving the message itself unchanged: first the options that were not requested,
+// from the highest code down, and then the requested options in reverse order of the request.
+// The critical options are never removed, so the result may still exceed size bytes.
+// It returns the serialized message and the codes of the removed options.
+func (d DHCPv4) ToBytesTrimmed(order, requested dhcpv4.OptionCodeList, size int) ([]byte, dhcpv4.OptionCodeList) {
+	b := d.ToBytesLimited(order, size)
+	if len(b) <= size {
+		return b, nil
+	}
+
+	var candidates dhcpv4.OptionCodeList
+	for code := range d.Options {
+		if !criticalOptions[code] && !requested.Has(dhcpv4.GenericOptionCode(code)) {
+			candidates = append(candidates, dhcpv4.GenericOptionCode(code))
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Code() > candidates[j].Code() })
+	for i := len(requested) - 1; i >= 0; i-- {
+		if !criticalOptions[requested[i].Code()] && d.Options.Has(requested[i]) {
+			candidates = append(candidates, requested[i])
+		}
+	}
+
+	trimmed := *d.DHCPv4
+	trimmed.Options = make(dhcpv4.Options, len(d.Options))
+	for code, value := range d.Options {
+		trimmed.Options[code] = value
+	}
+	var removed dhcpv4.OptionCodeList
+	for _, code := range candidates {
+		trimmed.Options.Del(code)
+		removed = append(removed, code)
+		if b = (DHCPv4{DHCPv4: &trimmed}).ToBytesLimited(order, size); len(b) <= size {
+			break
+		}
+	}
+	return b, removed
+}
+
+// serializeHeader serializes the fixed header of the message, including the magic cookie.
+func (d DHCPv4) serializeHeader() []byte {
+	header := *d.DHCPv4
+	header.Options = nil
+	return header.ToBytes()[:optionsOffset]
+}
+
+// serializeOptions serializes the options of the message in the order described by ToBytesOrdered.
+// The instances of the Relay Agent Information option are returned separately, since they must come last.
+func (d DHCPv4) serializeOptions(order dhcpv4.OptionCodeList) (options, agentInfo [][]byte) {
+	written := make(map[uint8]bool)
+	write := func(code uint8) {
+		data, ok := d.Options[code]
+		if !ok || written[code] {
+			return
+		}
+		written[code] = true
+		options = appendOption(options, code, data)
+	}
+
+	for _, c := range order {
+		switch code := c.Code(); code {
+		case dhcpv4.OptionPad.Code(), dhcpv4.OptionEnd.Code(), dhcpv4.OptionRelayAgentInformation.Code():
+			continue
+		default:
+			write(code)
+		}
+	}
+
+	var remaining []int
+	for code := range d.Options {
+		switch code {
+		case dhcpv4.OptionPad.Code(), dhcpv4.OptionEnd.Code(), dhcpv4.OptionRelayAgentInformation.Code():
+			continue
+		default:
+			remaining = append(remaining, int(code))
+		}
+	}
+	sort.Ints(remaining)
+	for _, code := range remaining {
+		write(uint8(code))
+	}
+
+	if data, ok := d.Options[dhcpv4.OptionRelayAgentInformation.Code()]; ok {
+		agentInfo = appendOption(nil, dhcpv4.OptionRelayAgentInformation.Code(), data)
+	}
+	return options, agentInfo
+}
+
+// finish terminates the options of a serialized message and pads it to the minimum BOOTP length.
+func finish(buf *bytes.Buffer) []byte {
+	buf.WriteByte(dhcpv4.OptionEnd.Code())
+	if buf.Len() < bootpMinLen {
+		buf.Write(make([]byte, bootpMinLen-buf.Len()))
+	}
+	return buf.Bytes()
+}
+
+// appendOption appends a single serialized option to options, splitting it into
+// multiple instances if the data exceeds 255 bytes as described in RFC 3396.
+func appendOption(options [][]byte, code uint8, data []byte) [][]byte {
+	if len(data) == 0 {
+		return append(options, []byte{code, 0})
+	}
+	for len(data) > 0 {
+		n := min(len(data), math.MaxUint8)
+		options = append(options, append([]byte{code, uint8(n)}, data[:n]...))
+		data = data[n:]
+	}
+	return options
+}
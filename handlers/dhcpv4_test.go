@@ -0,0 +1,357 @@
+package handlers
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestedOptions(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+
+	req.UpdateOption(dhcpv4.OptParameterRequestList(
+		dhcpv4.OptionDomainNameServer,
+		dhcpv4.OptionRouter,
+		dhcpv4.OptionSubnetMask,
+		dhcpv4.OptionRouter,
+	))
+	assert.Equal(t, dhcpv4.OptionCodeList{
+		dhcpv4.OptionDomainNameServer,
+		dhcpv4.OptionRouter,
+		dhcpv4.OptionSubnetMask,
+	}, DHCPv4{DHCPv4: req}.RequestedOptions())
+
+	req.DeleteOption(dhcpv4.OptionParameterRequestList)
+	assert.Nil(t, DHCPv4{DHCPv4: req}.RequestedOptions())
+}
+
+func TestIsOptionRequested(t *testing.T) {
+	for name, prl := range map[string]dhcpv4.Option{
+		"no list":    {},
+		"empty list": dhcpv4.OptParameterRequestList(),
+		"list": dhcpv4.OptParameterRequestList(
+			dhcpv4.OptionSubnetMask,
+			dhcpv4.OptionRouter,
+			dhcpv4.OptionDomainNameServer,
+			dhcpv4.OptionClasslessStaticRoute,
+			dhcpv4.GenericOptionCode(224),
+			dhcpv4.GenericOptionCode(255),
+		),
+	} {
+		t.Run(name, func(t *testing.T) {
+			req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+			require.NoError(t, err)
+			req.Options.Del(dhcpv4.OptionParameterRequestList)
+			if prl.Code != nil {
+				req.UpdateOption(prl)
+			}
+			wrapped := NewDHCPv4(req)
+			for c := 0; c <= 255; c++ {
+				code := dhcpv4.GenericOptionCode(c)
+				assert.Equal(t, req.IsOptionRequested(code), wrapped.IsOptionRequested(code), c)
+				assert.Equal(t, req.IsOptionRequested(code), DHCPv4{DHCPv4: req}.IsOptionRequested(code), c)
+			}
+		})
+	}
+}
+
+// BenchmarkIsOptionRequested runs a chain of 10 handlers that each check whether two options are requested,
+// like the handlers adding options do, on a request with a typical Parameter Request List.
+func BenchmarkIsOptionRequested(b *testing.B) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, dhcpv4.WithRequestedOptions(
+		dhcpv4.OptionSubnetMask, dhcpv4.OptionRouter, dhcpv4.OptionDomainNameServer, dhcpv4.OptionHostName,
+		dhcpv4.OptionDomainName, dhcpv4.OptionBroadcastAddress, dhcpv4.OptionNTPServers,
+		dhcpv4.OptionDNSDomainSearchList, dhcpv4.OptionClasslessStaticRoute, dhcpv4.OptionInterfaceMTU,
+	))
+	require.NoError(b, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(b, err)
+
+	var chain Chain
+	for i := 0; i < 10; i++ {
+		chain = append(chain, requestedHandler{
+			dhcpv4.GenericOptionCode(uint8(1 + i)),
+			dhcpv4.GenericOptionCode(uint8(100 + i)),
+		})
+	}
+	done := func() error { return nil }
+
+	b.Run("parsed per call", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_ = chain.Handle4(DHCPv4{DHCPv4: req}, DHCPv4{DHCPv4: resp}, done)
+		}
+	})
+	b.Run("parsed once", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_ = chain.Handle4(NewDHCPv4(req), DHCPv4{DHCPv4: resp}, done)
+		}
+	})
+}
+
+// requestedHandler is a Handler checking whether its options are requested.
+type requestedHandler []dhcpv4.OptionCode
+
+func (h requestedHandler) Handle4(req, _ DHCPv4, next func() error) error {
+	for _, code := range h {
+		_ = req.IsOptionRequested(code)
+	}
+	return next()
+}
+
+func (h requestedHandler) Handle6(_, _ DHCPv6, next func() error) error {
+	return next()
+}
+
+func TestUserClasses(t *testing.T) {
+	for name, tc := range map[string]struct {
+		value    []byte
+		expected []string
+	}{
+		"absent":   {nil, nil},
+		"single":   {[]byte("\x04iPXE"), []string{"iPXE"}},
+		"multiple": {[]byte("\x04iPXE\x07gPXE-v2"), []string{"iPXE", "gPXE-v2"}},
+		// a class sent as a plain string is not length-prefixed
+		"plain string": {[]byte("iPXE"), []string{"iPXE"}},
+		"truncated":    {[]byte("\x04iPXE\x09short"), []string{"\x04iPXE\x09short"}},
+		"empty entry":  {[]byte("\x00\x04iPXE"), []string{"\x00\x04iPXE"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+			require.NoError(t, err)
+			if tc.value != nil {
+				req.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionUserClassInformation, tc.value))
+			}
+			assert.Equal(t, tc.expected, DHCPv4{DHCPv4: req}.UserClasses())
+		})
+	}
+}
+
+func TestToBytesOrdered(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+
+	resp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeOffer))
+	resp.UpdateOption(dhcpv4.OptSubnetMask(net.IPv4Mask(255, 255, 255, 0)))
+	resp.UpdateOption(dhcpv4.OptRouter(net.IPv4(10, 0, 0, 1)))
+	resp.UpdateOption(dhcpv4.OptDNS(net.IPv4(10, 0, 0, 2)))
+	resp.UpdateOption(dhcpv4.OptRelayAgentInfo(dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte("eth0"))))
+
+	b := DHCPv4{DHCPv4: resp}.ToBytesOrdered(dhcpv4.OptionCodeList{
+		dhcpv4.OptionDomainNameServer,
+		dhcpv4.OptionRelayAgentInformation,
+		dhcpv4.OptionRouter,
+		dhcpv4.OptionHostName,
+	})
+
+	var codes []uint8
+	for i := optionsOffset; i < len(b) && b[i] != dhcpv4.OptionEnd.Code(); i += 2 + int(b[i+1]) {
+		codes = append(codes, b[i])
+	}
+	assert.Equal(t, []uint8{
+		dhcpv4.OptionDomainNameServer.Code(),
+		dhcpv4.OptionRouter.Code(),
+		dhcpv4.OptionSubnetMask.Code(),
+		dhcpv4.OptionDHCPMessageType.Code(),
+		dhcpv4.OptionRelayAgentInformation.Code(),
+	}, codes)
+	assert.GreaterOrEqual(t, len(b), bootpMinLen)
+
+	// the ordered serialization must decode to the same message
+	parsed, err := dhcpv4.FromBytes(b)
+	require.NoError(t, err)
+	assert.Equal(t, resp.Options, parsed.Options)
+	assert.Equal(t, resp.ClientHWAddr, parsed.ClientHWAddr)
+	assert.Equal(t, resp.TransactionID, parsed.TransactionID)
+}
+
+func TestMaxReplySize(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	assert.Equal(t, 548, DHCPv4{DHCPv4: req}.MaxReplySize())
+
+	req.UpdateOption(dhcpv4.OptMaxMessageSize(1500))
+	assert.Equal(t, 1472, DHCPv4{DHCPv4: req}.MaxReplySize())
+
+	// values below the minimum of 576 are ignored
+	req.UpdateOption(dhcpv4.OptMaxMessageSize(300))
+	assert.Equal(t, 548, DHCPv4{DHCPv4: req}.MaxReplySize())
+}
+
+func TestClientIdentifierKey(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	assert.Equal(t, "", DHCPv4{DHCPv4: req}.ClientIdentifierKey())
+
+	req.UpdateOption(dhcpv4.OptClientIdentifier([]byte{0x01, 0x02, 0xAB, 0, 0, 0, 0, 1}))
+	assert.Equal(t, "cid:0102ab0000000001", DHCPv4{DHCPv4: req}.ClientIdentifierKey())
+}
+
+func TestLinkAddress(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	d := DHCPv4{DHCPv4: req}
+	assert.Nil(t, d.RelayAddress())
+	assert.Nil(t, d.LinkAddress())
+
+	req.GatewayIPAddr = net.IPv4(10, 0, 1, 1)
+	assert.Equal(t, net.IPv4(10, 0, 1, 1).To4(), d.RelayAddress())
+	assert.Equal(t, net.IPv4(10, 0, 1, 1).To4(), d.LinkAddress())
+
+	req.UpdateOption(dhcpv4.OptRelayAgentInfo(dhcpv4.OptGeneric(dhcpv4.LinkSelectionSubOption, net.IPv4(10, 0, 2, 0).To4())))
+	assert.Equal(t, net.IPv4(10, 0, 2, 0).To4(), d.LinkAddress())
+
+	req.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionSubnetSelection, net.IPv4(10, 0, 3, 0).To4()))
+	assert.Equal(t, net.IPv4(10, 0, 3, 0).To4(), d.LinkAddress())
+	assert.Equal(t, net.IPv4(10, 0, 1, 1).To4(), d.RelayAddress())
+}
+
+// reassemble parses the options of a serialized message, including any options
+// in the file and sname fields as indicated by the Option Overload option.
+func reassemble(t *testing.T, b []byte) dhcpv4.Options {
+	msg, err := dhcpv4.FromBytes(b)
+	require.NoError(t, err)
+
+	options := dhcpv4.Options{}
+	for code, data := range msg.Options {
+		options[code] = data
+	}
+	var overload uint8
+	if data := msg.Options.Get(dhcpv4.OptionOptionOverload); len(data) == 1 {
+		overload = data[0]
+	}
+	for _, field := range []struct {
+		flag        uint8
+		offset, len int
+	}{
+		{overloadFile, fileOffset, fileLen},
+		{overloadSname, snameOffset, snameLen},
+	} {
+		if overload&field.flag == 0 {
+			continue
+		}
+		o := dhcpv4.Options{}
+		require.NoError(t, o.FromBytes(b[field.offset:field.offset+field.len]))
+		for code, data := range o {
+			options[code] = append(options[code], data...)
+		}
+	}
+	delete(options, dhcpv4.OptionOptionOverload.Code())
+	return options
+}
+
+func TestToBytesLimited(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+
+	resp.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeOffer))
+	resp.UpdateOption(dhcpv4.OptRelayAgentInfo(dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte("eth0"))))
+	for code := uint8(200); code < 210; code++ {
+		resp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.GenericOptionCode(code), make([]byte, 40)))
+	}
+	size := DHCPv4{DHCPv4: req}.MaxReplySize()
+	require.Greater(t, len(resp.ToBytes()), size)
+
+	b := DHCPv4{DHCPv4: resp}.ToBytesLimited(nil, size)
+	assert.LessOrEqual(t, len(b), size)
+
+	parsed, err := dhcpv4.FromBytes(b)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{overloadFile | overloadSname}, parsed.Options.Get(dhcpv4.OptionOptionOverload))
+	assert.Equal(t, resp.Options, reassemble(t, b))
+
+	// the Relay Agent Information option stays last in the options field
+	end := bytes.IndexByte(b[optionsOffset:], dhcpv4.OptionEnd.Code())
+	agentInfo := resp.Options.Get(dhcpv4.OptionRelayAgentInformation)
+	assert.Equal(t, agentInfo, b[optionsOffset+end-len(agentInfo):optionsOffset+end])
+}
+
+func TestToBytesLimitedFits(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+
+	// messages that fit are not overloaded
+	b := DHCPv4{DHCPv4: req}.ToBytesLimited(nil, 548)
+	assert.Equal(t, req.ToBytes(), b)
+
+	// messages that do not fit even when overloaded are returned as is
+	req.UpdateOption(dhcpv4.OptGeneric(dhcpv4.GenericOptionCode(200), make([]byte, 1000)))
+	b = DHCPv4{DHCPv4: req}.ToBytesLimited(nil, 548)
+	parsed, err := dhcpv4.FromBytes(b)
+	require.NoError(t, err)
+	assert.False(t, parsed.Options.Has(dhcpv4.OptionOptionOverload))
+	assert.Equal(t, req.Options, reassemble(t, b))
+}
+
+func TestToBytesTrimmed(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+		dhcpv4.WithOption(dhcpv4.OptMaxMessageSize(576)),
+		dhcpv4.WithRequestedOptions(dhcpv4.OptionDomainName, dhcpv4.OptionVendorSpecificInformation, dhcpv4.OptionBootfileName),
+	)
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req,
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeOffer),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(net.IPv4(10, 0, 0, 1))),
+		dhcpv4.WithNetmask(net.CIDRMask(24, 32)),
+		dhcpv4.WithLeaseTime(3600),
+	)
+	require.NoError(t, err)
+	// neither option 67 nor option 43 fit along with any of the others
+	resp.UpdateOption(dhcpv4.OptBootFileName(string(make([]byte, 250))))
+	resp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, make([]byte, 250)))
+	resp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionURL, make([]byte, 250)))
+	resp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.GenericOptionCode(200), make([]byte, 250)))
+	resp.UpdateOption(dhcpv4.OptDomainName("example.com"))
+	requested := DHCPv4{DHCPv4: req}.RequestedOptions()
+	size := DHCPv4{DHCPv4: req}.MaxReplySize()
+
+	options := resp.Options.ToBytes()
+	b, removed := DHCPv4{DHCPv4: resp}.ToBytesTrimmed(nil, requested, size)
+	assert.LessOrEqual(t, len(b), size)
+	// the reply itself keeps its options, since it may be logged or cached after it was sent
+	assert.Equal(t, options, resp.Options.ToBytes())
+	// the unrequested options are removed first, then the requested ones starting at the end of the list
+	var codes []uint8
+	for _, code := range removed {
+		codes = append(codes, code.Code())
+	}
+	assert.Equal(t, []uint8{200, dhcpv4.OptionURL.Code(), dhcpv4.OptionBootfileName.Code()}, codes)
+
+	parsed, err := dhcpv4.FromBytes(b)
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", parsed.DomainName())
+	assert.True(t, parsed.Options.Has(dhcpv4.OptionVendorSpecificInformation))
+	assert.Equal(t, dhcpv4.MessageTypeOffer, parsed.MessageType())
+	assert.NotNil(t, parsed.SubnetMask())
+	assert.NotNil(t, parsed.ServerIdentifier())
+}
+
+func TestToBytesTrimmedCritical(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req, dhcpv4.WithMessageType(dhcpv4.MessageTypeOffer))
+	require.NoError(t, err)
+	var subOptions []dhcpv4.Option
+	for code := uint8(1); code < 5; code++ {
+		subOptions = append(subOptions, dhcpv4.OptGeneric(dhcpv4.GenericOptionCode(code), make([]byte, 250)))
+	}
+	resp.UpdateOption(dhcpv4.OptRelayAgentInfo(subOptions...))
+	resp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.GenericOptionCode(200), make([]byte, 10)))
+
+	// the critical options are kept even when the message does not fit
+	b, removed := DHCPv4{DHCPv4: resp}.ToBytesTrimmed(nil, nil, 548)
+	assert.Greater(t, len(b), 548)
+	assert.Len(t, removed, 1)
+	parsed, err := dhcpv4.FromBytes(b)
+	require.NoError(t, err)
+	assert.Equal(t, dhcpv4.MessageTypeOffer, parsed.MessageType())
+	assert.True(t, parsed.Options.Has(dhcpv4.OptionRelayAgentInformation))
+}
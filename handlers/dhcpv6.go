@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// LinkAddress returns the link-address of the relay agent closest to the client, which is an address
+// on the link of the client that selects the subnet to serve it from. Relay agents further from the client
+// may leave their link-address unspecified, so the innermost specified link-address is returned.
+// It returns nil if the request was not relayed, or was not wrapped using NewRelayedDHCPv6.
+func (d DHCPv6) LinkAddress() net.IP {
+	if d.state == nil || d.state.relay == nil {
+		return nil
+	}
+	var linkAddr net.IP
+	var msg dhcpv6.DHCPv6 = d.state.relay
+	for msg.IsRelay() {
+		relay := msg.(*dhcpv6.RelayMessage)
+		if relay.LinkAddr != nil && !relay.LinkAddr.IsUnspecified() {
+			linkAddr = relay.LinkAddr
+		}
+		inner, err := dhcpv6.DecapsulateRelay(relay)
+		if err != nil {
+			break
+		}
+		msg = inner
+	}
+	return linkAddr
+}
+
+// InterfaceID returns the Interface-Id option (18) of the relay agent closest to the client that included one,
+// which identifies the interface on which the relay agent received the request, like the circuit ID for DHCPv4.
+// It returns nil if no relay agent included one, or the request was not wrapped using NewRelayedDHCPv6.
+func (d DHCPv6) InterfaceID() []byte {
+	if d.state == nil || d.state.relay == nil {
+		return nil
+	}
+	var interfaceID []byte
+	var msg dhcpv6.DHCPv6 = d.state.relay
+	for msg.IsRelay() {
+		relay := msg.(*dhcpv6.RelayMessage)
+		if id := relay.Options.InterfaceID(); id != nil {
+			interfaceID = id
+		}
+		inner, err := dhcpv6.DecapsulateRelay(relay)
+		if err != nil {
+			break
+		}
+		msg = inner
+	}
+	return interfaceID
+}
+
+// ClientLinkLayerAddress returns the hardware address from the Client Link-Layer Address option (79) that the
+// relay agent closest to the client included (RFC 6939). This identifies the client by its MAC address,
+// independently of its DUID. It returns nil if no relay agent included one, or the request was not wrapped
+// using NewRelayedDHCPv6.
+func (d DHCPv6) ClientLinkLayerAddress() net.HardwareAddr {
+	if d.state == nil || d.state.relay == nil {
+		return nil
+	}
+	var lla net.HardwareAddr
+	var msg dhcpv6.DHCPv6 = d.state.relay
+	for msg.IsRelay() {
+		relay := msg.(*dhcpv6.RelayMessage)
+		if _, addr := relay.Options.ClientLinkLayerAddress(); addr != nil {
+			lla = addr
+		}
+		inner, err := dhcpv6.DecapsulateRelay(relay)
+		if err != nil {
+			break
+		}
+		msg = inner
+	}
+	return lla
+}
+
+// RequestedOptions returns the parsed Option Request option (6) of this message, in the order in which
+// the client sent it, like DHCPv4.RequestedOptions. Duplicate codes are only returned once.
+// It returns nil if the client did not send an Option Request option.
+func (d DHCPv6) RequestedOptions() dhcpv6.OptionCodes {
+	var codes dhcpv6.OptionCodes
+	for _, code := range d.Options.RequestedOptions() {
+		if !codes.Contains(code) {
+			codes = append(codes, code)
+		}
+	}
+	return codes
+}
+
+// UserClasses returns the user classes in the User Class options (15) of this message, which RFC 8415
+// section 21.15 defines as a sequence of entries that are each prefixed with their length in two bytes.
+// Like DHCPv4.UserClasses, an option that is not a valid sequence of non-empty entries is returned as a single class.
+// It returns nil if the client did not send a User Class option.
+func (d DHCPv6) UserClasses() []string {
+	var classes []string
+	for _, opt := range d.Options.Get(dhcpv6.OptionUserClass) {
+		if uc, ok := opt.(*dhcpv6.OptUserClass); ok {
+			for _, class := range uc.UserClasses {
+				classes = append(classes, string(class))
+			}
+			continue
+		}
+		data := opt.ToBytes()
+		var parsed []string
+		for rest := data; len(rest) > 0; {
+			if len(rest) < 2 {
+				parsed = nil
+				break
+			}
+			n := int(binary.BigEndian.Uint16(rest))
+			if n == 0 || n > len(rest)-2 {
+				parsed = nil
+				break
+			}
+			parsed = append(parsed, string(rest[2:2+n]))
+			rest = rest[2+n:]
+		}
+		if parsed == nil && len(data) > 0 {
+			parsed = []string{string(data)}
+		}
+		classes = append(classes, parsed...)
+	}
+	return classes
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLinkAddress6(t *testing.T) {
+	msg, err := dhcpv6.NewSolicit(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	assert.Nil(t, NewDHCPv6(msg).LinkAddress())
+	assert.Nil(t, DHCPv6{Message: msg}.LinkAddress())
+
+	// the relay agent closest to the client sets the link-address, the next one leaves it unspecified
+	first, err := dhcpv6.EncapsulateRelay(msg, dhcpv6.MessageTypeRelayForward, net.ParseIP("2001:db8:1::1"), net.ParseIP("fe80::1"))
+	require.NoError(t, err)
+	assert.Equal(t, net.ParseIP("2001:db8:1::1"), NewRelayedDHCPv6(first, msg).LinkAddress())
+	second, err := dhcpv6.EncapsulateRelay(first, dhcpv6.MessageTypeRelayForward, net.IPv6unspecified, net.ParseIP("fe80::2"))
+	require.NoError(t, err)
+	assert.Equal(t, net.ParseIP("2001:db8:1::1"), NewRelayedDHCPv6(second, msg).LinkAddress())
+}
+
+func TestInterfaceID(t *testing.T) {
+	msg, err := dhcpv6.NewSolicit(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	assert.Nil(t, NewDHCPv6(msg).InterfaceID())
+
+	first, err := dhcpv6.EncapsulateRelay(msg, dhcpv6.MessageTypeRelayForward, net.ParseIP("2001:db8:1::1"), net.ParseIP("fe80::1"))
+	require.NoError(t, err)
+	assert.Nil(t, NewRelayedDHCPv6(first, msg).InterfaceID())
+	first.Options.Add(dhcpv6.OptInterfaceID([]byte("eth0")))
+	assert.Equal(t, []byte("eth0"), NewRelayedDHCPv6(first, msg).InterfaceID())
+
+	// the relay agent closest to the client wins
+	second, err := dhcpv6.EncapsulateRelay(first, dhcpv6.MessageTypeRelayForward, net.IPv6unspecified, net.ParseIP("fe80::2"))
+	require.NoError(t, err)
+	second.Options.Add(dhcpv6.OptInterfaceID([]byte("uplink")))
+	assert.Equal(t, []byte("eth0"), NewRelayedDHCPv6(second, msg).InterfaceID())
+}
+
+func TestClientLinkLayerAddress(t *testing.T) {
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	msg, err := dhcpv6.NewSolicit(net.HardwareAddr{0x02, 0, 0, 0, 0, 2})
+	require.NoError(t, err)
+	assert.Nil(t, NewDHCPv6(msg).ClientLinkLayerAddress())
+
+	first, err := dhcpv6.EncapsulateRelay(msg, dhcpv6.MessageTypeRelayForward, net.ParseIP("2001:db8:1::1"), net.ParseIP("fe80::1"))
+	require.NoError(t, err)
+	assert.Nil(t, NewRelayedDHCPv6(first, msg).ClientLinkLayerAddress())
+	first.Options.Add(dhcpv6.OptClientLinkLayerAddress(iana.HWTypeEthernet, mac))
+	assert.Equal(t, mac, NewRelayedDHCPv6(first, msg).ClientLinkLayerAddress())
+
+	second, err := dhcpv6.EncapsulateRelay(first, dhcpv6.MessageTypeRelayForward, net.IPv6unspecified, net.ParseIP("fe80::2"))
+	require.NoError(t, err)
+	assert.Equal(t, mac, NewRelayedDHCPv6(second, msg).ClientLinkLayerAddress())
+}
+
+func TestRequestedOptions6(t *testing.T) {
+	msg, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+	assert.Nil(t, DHCPv6{Message: msg}.RequestedOptions())
+
+	msg.UpdateOption(dhcpv6.OptRequestedOption(
+		dhcpv6.OptionDNSRecursiveNameServer,
+		dhcpv6.OptionDomainSearchList,
+		dhcpv6.OptionDNSRecursiveNameServer,
+		dhcpv6.OptionNTPServer,
+	))
+	assert.Equal(t, dhcpv6.OptionCodes{
+		dhcpv6.OptionDNSRecursiveNameServer,
+		dhcpv6.OptionDomainSearchList,
+		dhcpv6.OptionNTPServer,
+	}, DHCPv6{Message: msg}.RequestedOptions())
+}
+
+func TestUserClasses6(t *testing.T) {
+	msg, err := dhcpv6.NewSolicit(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	assert.Nil(t, DHCPv6{Message: msg}.UserClasses())
+
+	msg.AddOption(&dhcpv6.OptUserClass{UserClasses: [][]byte{[]byte("iPXE")}})
+	assert.Equal(t, []string{"iPXE"}, DHCPv6{Message: msg}.UserClasses())
+
+	// multiple entries survive a round trip through the wire format
+	msg.Options.Del(dhcpv6.OptionUserClass)
+	msg.AddOption(&dhcpv6.OptUserClass{UserClasses: [][]byte{[]byte("iPXE"), []byte("lab")}})
+	parsed, err := dhcpv6.MessageFromBytes(msg.ToBytes())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"iPXE", "lab"}, DHCPv6{Message: parsed}.UserClasses())
+
+	// options that were not parsed are decoded, or returned as a single class if malformed
+	for name, tc := range map[string]struct {
+		value    []byte
+		expected []string
+	}{
+		"multiple":     {[]byte("\x00\x04iPXE\x00\x03lab"), []string{"iPXE", "lab"}},
+		"plain string": {[]byte("iPXE"), []string{"iPXE"}},
+		"truncated":    {[]byte("\x00\x09iPXE"), []string{"\x00\x09iPXE"}},
+		"odd length":   {[]byte("\x00\x04iPXE\x00"), []string{"\x00\x04iPXE\x00"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			msg.Options.Del(dhcpv6.OptionUserClass)
+			msg.AddOption(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionUserClass, OptionData: tc.value})
+			assert.Equal(t, tc.expected, DHCPv6{Message: msg}.UserClasses())
+		})
+	}
+}
@@ -5,6 +5,7 @@
 package dns
 
 import (
+	"fmt"
 	"net"
 
 	"github.com/caddyserver/caddy/v2"
@@ -17,11 +18,26 @@ import (
 type Module struct {
 	Servers []string `json:"servers,omitempty"`
 
+	// Servers for clients on specific subnets, keyed by subnet in CIDR notation, e.g. `10.1.0.0/16`.
+	// The subnet of a client is determined by the link address of its relay agent, see LinkAddress.
+	// The most specific subnet containing it wins; clients on other subnets, or that are not relayed,
+	// get the global servers. Like those, the servers of a subnet are split by IP family,
+	// and the global servers are used for a family that the subnet has no servers for.
+	Subnets map[string][]string `json:"subnets,omitempty"`
+
 	servers4 []net.IP
 	servers6 []net.IP
+	subnets  []subnet
 	logger   *zap.Logger
 }
 
+// subnet holds the servers for the clients on a subnet.
+type subnet struct {
+	prefix   *net.IPNet
+	servers4 []net.IP
+	servers6 []net.IP
+}
+
 // CaddyModule returns the Caddy module information.
 func (Module) CaddyModule() caddy.ModuleInfo {
 	return caddy.ModuleInfo{
@@ -33,8 +49,27 @@ func (Module) CaddyModule() caddy.ModuleInfo {
 // Provision is run immediately after this handler is being loaded.
 func (m *Module) Provision(ctx caddy.Context) error {
 	m.logger = ctx.Logger()
-	var servers4, servers6 []net.IP
-	for _, server := range m.Servers {
+	m.servers4, m.servers6 = splitServers(m.Servers)
+	for cidr, servers := range m.Subnets {
+		_, prefix, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid subnet: %v", err)
+		}
+		for _, server := range servers {
+			if net.ParseIP(server) == nil {
+				return fmt.Errorf("subnet %s: invalid server %q", cidr, server)
+			}
+		}
+		s := subnet{prefix: prefix}
+		s.servers4, s.servers6 = splitServers(servers)
+		m.subnets = append(m.subnets, s)
+	}
+	return nil
+}
+
+// splitServers parses the servers and splits them by IP family.
+func splitServers(servers []string) (servers4, servers6 []net.IP) {
+	for _, server := range servers {
 		ip := net.ParseIP(server)
 		isIPv6 := ip.To4() == nil
 		if isIPv6 {
@@ -43,15 +78,33 @@ func (m *Module) Provision(ctx caddy.Context) error {
 			servers4 = append(servers4, ip)
 		}
 	}
-	m.servers4 = servers4
-	m.servers6 = servers6
-	return nil
+	return servers4, servers6
+}
+
+// lookup returns the most specific subnet containing the link address, or nil if there is none.
+func (m *Module) lookup(linkAddr net.IP) *subnet {
+	if linkAddr == nil {
+		return nil
+	}
+	var best *subnet
+	bestLen := -1
+	for i := range m.subnets {
+		s := &m.subnets[i]
+		if ones, _ := s.prefix.Mask.Size(); ones > bestLen && s.prefix.Contains(linkAddr) {
+			best, bestLen = s, ones
+		}
+	}
+	return best
 }
 
 // Handle4 handles DHCPv4 packets for this plugin.
 func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
 	if req.IsOptionRequested(dhcpv4.OptionDomainNameServer) {
-		resp.UpdateOption(dhcpv4.OptDNS(m.servers4...))
+		servers := m.servers4
+		if s := m.lookup(req.LinkAddress()); s != nil && len(s.servers4) > 0 {
+			servers = s.servers4
+		}
+		resp.UpdateOption(dhcpv4.OptDNS(servers...))
 	}
 	return next()
 }
@@ -59,7 +112,11 @@ func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
 // Handle6 handles DHCPv6 packets for this plugin.
 func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
 	if req.IsOptionRequested(dhcpv6.OptionDNSRecursiveNameServer) {
-		resp.UpdateOption(dhcpv6.OptDNS(m.servers6...))
+		servers := m.servers6
+		if s := m.lookup(req.LinkAddress()); s != nil && len(s.servers6) > 0 {
+			servers = s.servers6
+		}
+		resp.UpdateOption(dhcpv6.OptDNS(servers...))
 	}
 	return next()
 }
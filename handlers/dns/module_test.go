@@ -0,0 +1,98 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package dns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/lion7/caddydhcp/handlers/handlertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubnets4(t *testing.T) {
+	m := handlertest.Provision(t, &Module{
+		Servers: []string{"10.0.0.53", "2001:db8::53"},
+		Subnets: map[string][]string{
+			"10.1.0.0/16": {"10.1.0.53"},
+			"10.1.2.0/24": {"10.1.2.53"},
+			"10.2.0.0/16": {"10.2.0.53", "10.2.0.54"},
+			"10.3.0.0/16": {"2001:db8:3::53"},
+		},
+	})
+	for _, tc := range []struct {
+		name   string
+		giaddr net.IP
+		want   []net.IP
+	}{
+		{"not relayed", nil, []net.IP{net.ParseIP("10.0.0.53")}},
+		{"first subnet", net.IPv4(10, 1, 0, 1), []net.IP{net.ParseIP("10.1.0.53")}},
+		{"most specific subnet", net.IPv4(10, 1, 2, 1), []net.IP{net.ParseIP("10.1.2.53")}},
+		{"second subnet", net.IPv4(10, 2, 0, 1), []net.IP{net.ParseIP("10.2.0.53"), net.ParseIP("10.2.0.54")}},
+		{"subnet without IPv4 servers", net.IPv4(10, 3, 0, 1), []net.IP{net.ParseIP("10.0.0.53")}},
+		{"unknown subnet", net.IPv4(10, 9, 0, 1), []net.IP{net.ParseIP("10.0.0.53")}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, dhcpv4.WithRequestedOptions(dhcpv4.OptionDomainNameServer))
+			require.NoError(t, err)
+			req.GatewayIPAddr = tc.giaddr
+			resp, err := handlertest.Handle4(t, m, handlers.NewDHCPv4(req), nil)
+			require.NoError(t, err)
+
+			parsed, err := dhcpv4.FromBytes(resp.ToBytes())
+			require.NoError(t, err)
+			want := make([]net.IP, len(tc.want))
+			for i, ip := range tc.want {
+				want[i] = ip.To4()
+			}
+			assert.Equal(t, want, parsed.DNS())
+		})
+	}
+}
+
+func TestSubnets6(t *testing.T) {
+	m := handlertest.Provision(t, &Module{
+		Servers: []string{"10.0.0.53", "2001:db8::53"},
+		Subnets: map[string][]string{"2001:db8:1::/48": {"2001:db8:1::53"}},
+	})
+	for _, tc := range []struct {
+		name     string
+		linkAddr net.IP
+		want     net.IP
+	}{
+		{"not relayed", nil, net.ParseIP("2001:db8::53")},
+		{"subnet", net.ParseIP("2001:db8:1::1"), net.ParseIP("2001:db8:1::53")},
+		{"unknown subnet", net.ParseIP("2001:db8:2::1"), net.ParseIP("2001:db8::53")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, err := dhcpv6.NewSolicit(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, dhcpv6.WithRequestedOptions(dhcpv6.OptionDNSRecursiveNameServer))
+			require.NoError(t, err)
+			req := handlers.NewDHCPv6(msg)
+			if tc.linkAddr != nil {
+				relay, err := dhcpv6.EncapsulateRelay(msg, dhcpv6.MessageTypeRelayForward, tc.linkAddr, net.ParseIP("fe80::1"))
+				require.NoError(t, err)
+				req = handlers.NewRelayedDHCPv6(relay, msg)
+			}
+			resp, err := handlertest.Handle6(t, m, req, nil)
+			require.NoError(t, err)
+			assert.Equal(t, []net.IP{tc.want}, resp.Options.DNS())
+		})
+	}
+}
+
+func TestProvisionSubnets(t *testing.T) {
+	for _, subnets := range []map[string][]string{
+		{"10.1.0.0": {"10.1.0.53"}},
+		{"10.1.0.0/16": {"dns.example.com"}},
+	} {
+		m := &Module{Subnets: subnets}
+		assert.Error(t, m.Provision(caddy.Context{}))
+	}
+}
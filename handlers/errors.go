@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/insomniacslk/dhcp/iana"
+)
+
+// ErrDrop can be returned by a handler to stop the chain and drop the request without sending a reply.
+// Unlike other errors, it is not logged as a failure.
+var ErrDrop = errors.New("request dropped")
+
+// ErrStopAndReply can be returned by a handler to stop the chain and send the reply as built so far.
+var ErrStopAndReply = errors.New("stop and reply")
+
+// HandlerError is an error returned by a handler that tells the server how to respond to the request.
+// Use Nak to create one.
+type HandlerError struct {
+	// The underlying error, whose message is sent to the client in a negative reply.
+	Err error
+
+	// Sends a negative reply instead of dropping the request:
+	// a DHCPNAK in response to a DHCPREQUEST, and an Advertise or Reply
+	// carrying StatusCode for DHCPv6. Other DHCPv4 requests are dropped,
+	// since they cannot be answered with a DHCPNAK.
+	Nak bool
+
+	// The status code of a negative DHCPv6 reply. Defaults to UnspecFail.
+	StatusCode iana.StatusCode
+}
+
+// Nak returns a HandlerError that answers the request with a negative reply carrying the message of err.
+func Nak(err error) HandlerError {
+	return HandlerError{Err: err, Nak: true}
+}
+
+// Error implements the error interface.
+func (e HandlerError) Error() string {
+	if e.Err == nil {
+		return "handler error"
+	}
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e HandlerError) Unwrap() error {
+	return e.Err
+}
+
+// Message returns the message to send to the client in a negative reply.
+func (e HandlerError) Message() string {
+	if e.Err == nil {
+		return ""
+	}
+	return e.Err.Error()
+}
+
+// Status returns the status code of a negative DHCPv6 reply.
+func (e HandlerError) Status() iana.StatusCode {
+	if e.StatusCode == iana.StatusSuccess {
+		return iana.StatusUnspecFail
+	}
+	return e.StatusCode
+}
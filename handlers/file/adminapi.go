@@ -0,0 +1,229 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package file
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// the provisioned file handlers, which are served by the admin API
+var (
+	modulesMu sync.Mutex
+	modules   = make(map[*Module]struct{})
+)
+
+func register(m *Module) {
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+	modules[m] = struct{}{}
+}
+
+func unregister(m *Module) {
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+	delete(modules, m)
+}
+
+// registered returns the provisioned file handlers, optionally limited to those using the given file.
+func registered(filename string) []*Module {
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+	var ms []*Module
+	for m := range modules {
+		if filename == "" || m.Filename == filename {
+			ms = append(ms, m)
+		}
+	}
+	return ms
+}
+
+// AdminAPI is a module that serves the endpoints of the file handlers in the admin API:
+//
+//   - `POST /dhcp/file/reload` re-reads the reservations of every file handler from its file.
+//   - `PUT /dhcp/file/reservations` adds or replaces a reservation, given as `{"id": "<mac>", "ip": "<ip>"}`.
+//   - `DELETE /dhcp/file/reservations?id=<mac>` removes a reservation.
+//   - `GET /dhcp/file/seen` lists when the clients with a reservation were last seen, for the file handlers with a seen file.
+//
+// The id of a reservation can also be a client identifier key or a hex encoded DUID, like in the file.
+// All endpoints accept a `filename` query parameter to select a single file handler.
+type AdminAPI struct{}
+
+// reservation is the request body of `PUT /dhcp/file/reservations`.
+type reservation struct {
+	ID string `json:"id"`
+	IP string `json:"ip"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (AdminAPI) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.dhcp_file",
+		New: func() caddy.Module { return new(AdminAPI) },
+	}
+}
+
+// Routes returns the admin routes for the file handlers.
+func (a *AdminAPI) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{
+		{
+			Pattern: "/dhcp/file/reload",
+			Handler: caddy.AdminHandlerFunc(a.handleReload),
+		},
+		{
+			Pattern: "/dhcp/file/reservations",
+			Handler: caddy.AdminHandlerFunc(a.handleReservations),
+		},
+		{
+			Pattern: "/dhcp/file/seen",
+			Handler: caddy.AdminHandlerFunc(a.handleSeen),
+		},
+	}
+}
+
+// selected returns the file handlers selected by the request, or an error if there are none.
+func selected(r *http.Request) ([]*Module, error) {
+	ms := registered(r.URL.Query().Get("filename"))
+	if len(ms) == 0 {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("no file handler found"),
+		}
+	}
+	return ms, nil
+}
+
+// handleReload reloads the reservations of the selected file handlers from their files.
+func (a *AdminAPI) handleReload(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed: %v", r.Method),
+		}
+	}
+
+	ms, err := selected(r)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, m := range ms {
+		if err := m.loadRecords(m.KeepOverridesOnReload); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", m.Filename, err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusInternalServerError,
+			Err:        err,
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// handleReservations adds, replaces or removes a reservation of the selected file handlers.
+func (a *AdminAPI) handleReservations(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case http.MethodPut:
+		var res reservation
+		if err := json.NewDecoder(r.Body).Decode(&res); err != nil {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        fmt.Errorf("decoding request: %w", err),
+			}
+		}
+		if res.ID == "" {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        fmt.Errorf("no id given"),
+			}
+		}
+		ip := net.ParseIP(res.IP)
+		if ip == nil {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        fmt.Errorf("invalid IP address: %s", res.IP),
+			}
+		}
+		ms, err := selected(r)
+		if err != nil {
+			return err
+		}
+		for _, m := range ms {
+			if err := m.SetReservation(res.ID, ip); err != nil {
+				return caddy.APIError{
+					HTTPStatus: http.StatusBadRequest,
+					Err:        err,
+				}
+			}
+		}
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        fmt.Errorf("no id given"),
+			}
+		}
+		ms, err := selected(r)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, m := range ms {
+			if m.DeleteReservation(id) {
+				found = true
+			}
+		}
+		if !found {
+			return caddy.APIError{
+				HTTPStatus: http.StatusNotFound,
+				Err:        fmt.Errorf("no reservation found for %s", id),
+			}
+		}
+	default:
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed: %v", r.Method),
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// handleSeen lists the seen records of the selected file handlers, keyed by the filename of their reservations.
+func (a *AdminAPI) handleSeen(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed: %v", r.Method),
+		}
+	}
+
+	ms, err := selected(r)
+	if err != nil {
+		return err
+	}
+	seen := make(map[string][]Seen)
+	for _, m := range ms {
+		if m.seen != nil {
+			seen[m.Filename] = m.seen.list()
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(seen)
+}
+
+// Interfaces guards
+var (
+	_ caddy.AdminRouter = (*AdminAPI)(nil)
+)
@@ -7,16 +7,21 @@ package file
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyevents"
 	"github.com/fsnotify/fsnotify"
+	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
 	"github.com/lion7/caddydhcp/handlers"
 	"go.uber.org/zap"
 )
@@ -39,48 +44,253 @@ func (Module) CaddyModule() caddy.ModuleInfo {
 //	02:34:56:78:9a:bc 2001:db8::1
 //	03:45:67:89:ab:cd 2001:db8:3333:4444:5555:6666:7777:8888
 //
+// A DHCPv4 reservation can also be keyed on the Client Identifier option (61) instead of the MAC address,
+// by writing the identifier in hex with a 'cid:' prefix, for example:
+//
+//	cid:01001122334455 10.0.0.2
+//
+// These are only used when the 'clientIdentifier' argument is true. The lookup then uses the
+// client identifier when the client sends one, and falls back to the MAC address otherwise.
+//
+// A DHCPv6 reservation is keyed on the DUID of the client in hex, or on its MAC address. The MAC address
+// is only known when a relay agent includes the Client Link-Layer Address option (79) in the request,
+// in which case it takes precedence over the DUID. A client sending several IA_NA options gets the reserved
+// address in one of them, and the NoAddrsAvail status code in the others.
+//
+// Reservations can also be added and removed at runtime through the admin API, see AdminAPI.
+// These only live in memory and are never written to the file, so they are lost when caddydhcp restarts.
+// A config reload reads the file again and keeps them, but they are discarded when the file is reloaded
+// after a change, unless the 'keepOverridesOnReload' argument is true.
+//
 // If the file path is not absolute, it is relative to the cwd where caddydhcp is run.
 //
 // Optionally, when the 'autoRefresh' argument is true, the plugin will try to refresh
 // the lease mapping during runtime whenever the lease file is updated.
 type Module struct {
-	Filename    string `json:"filename"`
-	AutoRefresh bool   `json:"autoRefresh"`
+	Filename              string `json:"filename"`
+	AutoRefresh           bool   `json:"autoRefresh"`
+	ClientIdentifier      bool   `json:"clientIdentifier,omitempty"`
+	KeepOverridesOnReload bool   `json:"keepOverridesOnReload,omitempty"`
+	// Jitter perturbs the DHCPv6 address lifetimes of every client by up to plus or minus this percentage,
+	// so clients do not all renew at the same time. A client always gets the same lifetimes. Disabled by default.
+	Jitter int `json:"jitter,omitempty"`
+	// GratuitousARP announces the address assigned in a DHCPv4 Ack with a gratuitous ARP on the interface
+	// with a subnet containing it, which requires the CAP_NET_RAW capability. Disabled by default.
+	GratuitousARP bool `json:"gratuitousARP,omitempty"`
+	// DeclineEvents emits a `dhcp_declined` event through the events app when a client declines its
+	// reserved address, in addition to logging it, so the conflict can be alerted on. Disabled by default.
+	DeclineEvents bool `json:"declineEvents,omitempty"`
+	// OnUnknown is what to do with a request from a client without a reservation: `continue` (the default)
+	// with the rest of the chain, e.g. to allocate it an address from a range, `drop` the request, or `nak` it,
+	// which answers a DHCPREQUEST with a DHCPNAK and a DHCPv6 request with the NoAddrsAvail status code.
+	// A DHCPDISCOVER cannot be answered with a DHCPNAK, so it is dropped instead.
+	OnUnknown string `json:"onUnknown,omitempty"`
+	// SeenFile is the path of a JSON file in which the last time each client with a reservation was seen
+	// and the hostname it sent are recorded, see Seen. They can be queried through the admin API.
+	// The reservation file itself is never written. Disabled by default.
+	SeenFile string `json:"seenFile,omitempty"`
+
+	logger  *zap.Logger
+	garp    *handlers.GratuitousARP
+	watcher *fsnotify.Watcher
+	emit    func(eventName string, data map[string]any)
+	seen    *seenStore
+	*reservations
+}
 
-	logger   *zap.Logger
-	recLock  *sync.RWMutex
-	records4 map[string]net.IP
-	records6 map[string]net.IP
+// reservations holds the reservations of a file. It is shared by all file handlers with the same file,
+// so a handler provisioned by a config reload takes over the reservations of the handler it replaces,
+// including those that were added through the admin API.
+type reservations struct {
+	recLock   *sync.RWMutex
+	records4  map[string]net.IP
+	records6  map[string]net.IP
+	overrides map[string]net.IP
 }
 
+// Destruct implements caddy.Destructor; the reservations only live in memory, so there is nothing to release.
+func (r *reservations) Destruct() error {
+	return nil
+}
+
+// pool holds the reservations of the provisioned file handlers, keyed by filename.
+var pool = caddy.NewUsagePool()
+
+// Values of Module.OnUnknown.
+const (
+	onUnknownContinue = "continue"
+	onUnknownDrop     = "drop"
+	onUnknownNak      = "nak"
+)
+
 func (m *Module) Provision(ctx caddy.Context) error {
 	m.logger = ctx.Logger()
-	m.recLock = &sync.RWMutex{}
+	if err := handlers.CheckJitter(m.Jitter); err != nil {
+		return err
+	}
+	switch m.OnUnknown {
+	case "", onUnknownContinue, onUnknownDrop, onUnknownNak:
+	default:
+		return fmt.Errorf("invalid onUnknown policy %q, expected one of %q, %q or %q", m.OnUnknown, onUnknownContinue, onUnknownDrop, onUnknownNak)
+	}
+	if m.GratuitousARP {
+		m.garp = handlers.NewGratuitousARP(m.logger)
+	}
+	if m.DeclineEvents {
+		app, err := ctx.App("events")
+		if err != nil {
+			return fmt.Errorf("loading the events app: %v", err)
+		}
+		events := app.(*caddyevents.App)
+		m.emit = func(eventName string, data map[string]any) {
+			events.Emit(ctx, eventName, data)
+		}
+	}
+	if m.SeenFile != "" {
+		if filepath.Clean(m.SeenFile) == filepath.Clean(m.Filename) {
+			return fmt.Errorf("the seen file cannot be the reservation file %s", m.Filename)
+		}
+		seen, err := loadSeen(m.SeenFile)
+		if err != nil {
+			return err
+		}
+		m.seen = seen
+	}
+	val, loaded, err := pool.LoadOrNew(m.Filename, func() (caddy.Destructor, error) {
+		m.reservations = &reservations{recLock: &sync.RWMutex{}, overrides: make(map[string]net.IP)}
+		if err := m.loadRecords(false); err != nil {
+			return nil, err
+		}
+		return m.reservations, nil
+	})
+	if err != nil {
+		m.reservations = nil
+		if m.seen != nil {
+			_, _ = seenPool.Delete(m.SeenFile)
+			m.seen = nil
+		}
+		return err
+	}
+	m.reservations = val.(*reservations)
+	if loaded {
+		m.logger.Info("taking over the reservations of the previous file handler", zap.String("filename", m.Filename))
+		// the file may have changed since the previous handler read it,
+		// but the reservations made through the admin API survive a config reload
+		if err := m.loadRecords(true); err != nil {
+			_, _ = pool.Delete(m.Filename)
+			m.reservations = nil
+			if m.seen != nil {
+				_, _ = seenPool.Delete(m.SeenFile)
+				m.seen = nil
+			}
+			return err
+		}
+	}
 	// when auto refresh is enabled, watch the lease file for
 	// changes and reload the lease mapping on any event
 	if m.AutoRefresh {
-		return m.watchRecords()
-	} else {
-		return m.loadRecords()
+		if err := m.watchRecords(); err != nil {
+			return err
+		}
+	}
+	register(m)
+	return nil
+}
+
+// Cleanup stops watching the lease file and releases the reservations.
+func (m *Module) Cleanup() error {
+	unregister(m)
+	var err error
+	if m.watcher != nil {
+		err = m.watcher.Close()
+	}
+	if m.reservations != nil {
+		if _, poolErr := pool.Delete(m.Filename); err == nil {
+			err = poolErr
+		}
 	}
+	if m.seen != nil {
+		if _, poolErr := seenPool.Delete(m.SeenFile); err == nil {
+			err = poolErr
+		}
+	}
+	return err
 }
 
 func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
+	if req.MessageType() == dhcpv4.MessageTypeRelease {
+		return next()
+	}
 
-	m.logger.Debug("looking up an IP address for MAC", zap.Stringer("mac", req.ClientHWAddr))
-	ip, ok := m.lookup4(req.ClientHWAddr)
+	var cid string
+	if m.ClientIdentifier {
+		cid = req.ClientIdentifierKey()
+	}
+	m.logger.Debug("looking up an IP address for MAC", zap.Stringer("mac", req.ClientHWAddr), zap.String("client_id", cid))
+	ip, ok := m.lookup4(req.ClientHWAddr, cid)
 	if !ok {
 		m.logger.Warn("MAC address is unknown", zap.Stringer("mac", req.ClientHWAddr))
+		if req.MessageType() == dhcpv4.MessageTypeDecline {
+			return next()
+		}
+		return m.unknown(next)
+	}
+	if req.MessageType() == dhcpv4.MessageTypeDecline {
+		if declined := req.RequestedIPAddress(); declined != nil && declined.Equal(ip) {
+			m.decline4(req.ClientHWAddr, ip)
+		}
 		return next()
 	}
 
 	resp.YourIPAddr = ip
 	m.logger.Info("found IP address for MAC", zap.Stringer("mac", req.ClientHWAddr), zap.Stringer("ip", ip))
-	return next()
+	m.recordSeen(req.ClientHWAddr.String(), ip, req.HostName())
+	return m.garp.Next(req, resp, next)
+}
+
+// recordSeen records that the client with the given id and reserved address was seen, if SeenFile is set.
+// Failing to write the seen file is logged, since it does not affect the reply.
+func (m *Module) recordSeen(id string, ip net.IP, hostname string) {
+	if m.seen == nil {
+		return
+	}
+	if err := m.seen.record(Seen{ID: id, IP: ip.String(), Hostname: hostname, LastSeen: time.Now()}); err != nil {
+		m.logger.Error("failed to record the client as seen", zap.String("id", id), zap.String("filename", m.SeenFile), zap.Error(err))
+	}
+}
+
+// unknown applies the OnUnknown policy to a request from a client without a reservation.
+func (m *Module) unknown(next func() error) error {
+	switch m.OnUnknown {
+	case onUnknownDrop:
+		return handlers.ErrDrop
+	case onUnknownNak:
+		return handlers.HandlerError{Err: errors.New("no reservation for the client"), Nak: true, StatusCode: iana.StatusNoAddrsAvail}
+	default:
+		return next()
+	}
+}
+
+// decline4 reports a client declining its reserved address. The address is in use by another host,
+// which is a misconfiguration that cannot be resolved by handing out another address.
+func (m *Module) decline4(mac net.HardwareAddr, ip net.IP) {
+	m.logger.Error("client declined its reserved address, which is in use by another host",
+		zap.Stringer("mac", mac),
+		zap.Stringer("ip", ip),
+		zap.String("filename", m.Filename),
+	)
+	if m.emit != nil {
+		m.emit("dhcp_declined", map[string]any{
+			"mac":      mac.String(),
+			"ip":       ip.String(),
+			"filename": m.Filename,
+		})
+	}
 }
 
 func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
-	if req.Options.OneIANA() == nil {
+	ianas := req.Options.IANA()
+	if len(ianas) == 0 {
 		m.logger.Debug("no address requested")
 		return next()
 	}
@@ -88,44 +298,97 @@ func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
 	duidOpt := req.Options.ClientID()
 	duid := hex.EncodeToString(duidOpt.ToBytes())
 
-	m.logger.Info("looking up an IP address for DUID", zap.String("duid", duid))
-	ip, ok := m.lookup6(duid)
+	mac := req.ClientLinkLayerAddress()
+	m.logger.Info("looking up an IP address for DUID", zap.String("duid", duid), zap.Stringer("mac", mac))
+	ip, ok := m.lookup6(mac, duid)
 	if !ok {
-		m.logger.Warn("DUID is unknown", zap.String("duid", duid))
-		return next()
+		m.logger.Warn("DUID is unknown", zap.String("duid", duid), zap.Stringer("mac", mac))
+		return m.unknown(next)
 	}
 
-	resp.AddOption(&dhcpv6.OptIANA{
-		IaId: req.Options.OneIANA().IaId,
-		Options: dhcpv6.IdentityOptions{Options: []dhcpv6.Option{
-			&dhcpv6.OptIAAddress{
-				IPv6Addr:          ip,
-				PreferredLifetime: 3600 * time.Second,
-				ValidLifetime:     3600 * time.Second,
-			},
-		}},
-	})
-	m.logger.Info("found IP address for DUID", zap.String("duid", duid), zap.Stringer("ip", ip))
+	// an address can only be bound to one IA, so a client sending several IA_NAs gets the reserved address
+	// in the IA_NA that already holds it, or else in the first one, and NoAddrsAvail in the others
+	assigned := ianas[0]
+	for _, ia := range ianas {
+		if holdsAddress(ia, ip) {
+			assigned = ia
+			break
+		}
+	}
+	for _, ia := range ianas {
+		if ia != assigned {
+			m.logger.Info("no address for additional IA_NA", zap.String("duid", duid), zap.Binary("iaid", ia.IaId[:]))
+			resp.AddOption(&dhcpv6.OptIANA{
+				IaId: ia.IaId,
+				Options: dhcpv6.IdentityOptions{Options: []dhcpv6.Option{
+					&dhcpv6.OptStatusCode{StatusCode: iana.StatusNoAddrsAvail, StatusMessage: "reserved address assigned to another IA_NA"},
+				}},
+			})
+			continue
+		}
+		resp.AddOption(&dhcpv6.OptIANA{
+			IaId: ia.IaId,
+			Options: dhcpv6.IdentityOptions{Options: []dhcpv6.Option{
+				&dhcpv6.OptIAAddress{
+					IPv6Addr:          ip,
+					PreferredLifetime: handlers.Jitter(3600*time.Second, m.Jitter, duid),
+					ValidLifetime:     handlers.Jitter(3600*time.Second, m.Jitter, duid),
+				},
+			}},
+		})
+	}
+	m.logger.Info("found IP address for DUID", zap.String("duid", duid), zap.Stringer("ip", ip), zap.Binary("iaid", assigned.IaId[:]))
+	var hostname string
+	if fqdn := req.Options.FQDN(); fqdn != nil && fqdn.DomainName != nil && len(fqdn.DomainName.Labels) > 0 {
+		hostname = fqdn.DomainName.Labels[0]
+	}
+	m.recordSeen(duid, ip, hostname)
 	return next()
 }
 
-func (m *Module) lookup4(addr net.HardwareAddr) (net.IP, bool) {
+// holdsAddress returns whether the IA_NA of a request contains the given address, e.g. when renewing it.
+func holdsAddress(ia *dhcpv6.OptIANA, ip net.IP) bool {
+	for _, addr := range ia.Options.Addresses() {
+		if addr.IPv6Addr.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup4 looks up the reserved address of a client by its client identifier key, if any,
+// and falls back to its MAC address.
+func (m *Module) lookup4(addr net.HardwareAddr, cid string) (net.IP, bool) {
 	m.recLock.RLock()
 	defer m.recLock.RUnlock()
+	if cid != "" {
+		if ip, ok := m.records4[cid]; ok {
+			return ip, true
+		}
+	}
 	ip, ok := m.records4[addr.String()]
 	return ip, ok
 }
 
-func (m *Module) lookup6(encodedDuid string) (net.IP, bool) {
+// lookup6 looks up the reserved address of a client by the MAC address that a relay agent included
+// in the request, if any, and falls back to its DUID.
+func (m *Module) lookup6(addr net.HardwareAddr, encodedDuid string) (net.IP, bool) {
 	m.recLock.RLock()
 	defer m.recLock.RUnlock()
+	if addr != nil {
+		if ip, ok := m.records6[addr.String()]; ok {
+			return ip, true
+		}
+	}
 	ip, ok := m.records6[encodedDuid]
 	return ip, ok
 }
 
 // loadRecords loads the records map with records stored in the specified file.
 // The records have to be one per line, a mac address and an IP address.
-func (m *Module) loadRecords() error {
+// The reservations made through the admin API are applied on top of them if keepOverrides is set,
+// and discarded otherwise.
+func (m *Module) loadRecords(keepOverrides bool) error {
 	m.logger.Debug("reading leases", zap.String("filename", m.Filename))
 	data, err := os.ReadFile(m.Filename)
 	if err != nil {
@@ -145,37 +408,111 @@ func (m *Module) loadRecords() error {
 		if len(tokens) != 2 {
 			return fmt.Errorf("malformed line, want 2 fields, got %d: %s", len(tokens), line)
 		}
-		id := tokens[0]
-		ip := net.ParseIP(tokens[1])
-		if ip.To4() != nil {
-			records4[id] = ip
-		}
-		if ip.To16() != nil {
-			records6[id] = ip
+		id, ip, err := parseRecord(tokens[0], tokens[1])
+		if err != nil {
+			return err
 		}
+		addRecord(records4, records6, id, ip)
 	}
 	m.logger.Info(fmt.Sprintf("loaded %d DHCPv4 leases and %d DHCPv6 leases", len(records4), len(records6)), zap.String("filename", m.Filename))
 
 	m.recLock.Lock()
 	defer m.recLock.Unlock()
+	if keepOverrides {
+		for id, ip := range m.overrides {
+			delete(records4, id)
+			delete(records6, id)
+			if ip != nil {
+				addRecord(records4, records6, id, ip)
+			}
+		}
+	} else {
+		m.overrides = make(map[string]net.IP)
+	}
 	m.records4 = records4
 	m.records6 = records6
 	return nil
 }
 
-func (m *Module) watchRecords() error {
-	// initially load the records
-	err := m.loadRecords()
+// parseRecord parses a single reservation, normalizing client identifier keys.
+func parseRecord(id, addr string) (string, net.IP, error) {
+	ip := net.ParseIP(addr)
+	if strings.HasPrefix(id, handlers.ClientIdentifierPrefix) {
+		cid, err := hex.DecodeString(strings.TrimPrefix(id, handlers.ClientIdentifierPrefix))
+		if err != nil || len(cid) == 0 {
+			return "", nil, fmt.Errorf("malformed client identifier: %s", id)
+		}
+		if ip.To4() == nil {
+			return "", nil, fmt.Errorf("expected an IPv4 address for client identifier %s, got: %s", id, addr)
+		}
+		return handlers.ClientIdentifierPrefix + hex.EncodeToString(cid), ip, nil
+	}
+	return id, ip, nil
+}
+
+// addRecord adds a reservation to the records maps of the address families that it applies to.
+func addRecord(records4, records6 map[string]net.IP, id string, ip net.IP) {
+	if strings.HasPrefix(id, handlers.ClientIdentifierPrefix) {
+		records4[id] = ip
+		return
+	}
+	if ip.To4() != nil {
+		records4[id] = ip
+	} else if ip.To16() != nil {
+		records6[id] = ip
+	}
+}
+
+// SetReservation adds or replaces the reservation of id, which is a MAC address, a client identifier key or a hex encoded DUID.
+// The reservation only lives in memory: it is discarded when the file is reloaded, unless KeepOverridesOnReload is set.
+func (m *Module) SetReservation(id string, ip net.IP) error {
+	if ip.To16() == nil {
+		return fmt.Errorf("invalid IP address: %v", ip)
+	}
+	if mac, err := net.ParseMAC(id); err == nil {
+		id = mac.String()
+	}
+	id, _, err := parseRecord(id, ip.String())
 	if err != nil {
 		return err
 	}
 
+	m.recLock.Lock()
+	defer m.recLock.Unlock()
+	delete(m.records4, id)
+	delete(m.records6, id)
+	addRecord(m.records4, m.records6, id, ip)
+	m.overrides[id] = ip
+	return nil
+}
+
+// DeleteReservation removes the reservation of id, see SetReservation. It reports whether a reservation existed.
+func (m *Module) DeleteReservation(id string) bool {
+	if mac, err := net.ParseMAC(id); err == nil {
+		id = mac.String()
+	} else if strings.HasPrefix(id, handlers.ClientIdentifierPrefix) {
+		id = strings.ToLower(id)
+	}
+
+	m.recLock.Lock()
+	defer m.recLock.Unlock()
+	_, ok4 := m.records4[id]
+	_, ok6 := m.records6[id]
+	delete(m.records4, id)
+	delete(m.records6, id)
+	m.overrides[id] = nil
+	return ok4 || ok6
+}
+
+func (m *Module) watchRecords() error {
 	// creates a new file watcher
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		return fmt.Errorf("failed to create watcher: %w", err)
 	}
 
+	m.watcher = watcher
+
 	// have file watcher watch over lease file
 	if err = watcher.Add(m.Filename); err != nil {
 		return fmt.Errorf("failed to watch %s: %w", m.Filename, err)
@@ -187,7 +524,7 @@ func (m *Module) watchRecords() error {
 		for event := range watcher.Events {
 			if event.Op&fsnotify.Write == fsnotify.Write {
 				m.logger.Info("file changed", zap.String("filename", m.Filename))
-				if err := m.loadRecords(); err != nil {
+				if err := m.loadRecords(m.KeepOverridesOnReload); err != nil {
 					m.logger.Error("failed to refresh records", zap.Error(err))
 				}
 			}
@@ -199,4 +536,5 @@ func (m *Module) watchRecords() error {
 // Interfaces guards
 var (
 	_ handlers.HandlerModule = (*Module)(nil)
+	_ caddy.CleanerUpper     = (*Module)(nil)
 )
@@ -0,0 +1,486 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package file
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/lion7/caddydhcp/handlers/handlertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// testModule returns the configuration of a module reading the given reservations from a new file.
+func testModule(t *testing.T, leases string) *Module {
+	filename := filepath.Join(t.TempDir(), "leases.txt")
+	require.NoError(t, os.WriteFile(filename, []byte(leases), 0o644))
+	return &Module{Filename: filename}
+}
+
+// assigned4 returns the address the module assigns to the DHCPv4 client with the given MAC address and client identifier.
+func assigned4(t *testing.T, m *Module, mac net.HardwareAddr, cid []byte) net.IP {
+	req, err := dhcpv4.NewDiscovery(mac)
+	require.NoError(t, err)
+	if cid != nil {
+		req.UpdateOption(dhcpv4.OptClientIdentifier(cid))
+	}
+	resp, err := handlertest.Handle4(t, m, handlers.NewDHCPv4(req), nil)
+	require.NoError(t, err)
+	return resp.YourIPAddr
+}
+
+func TestHandle4ClientIdentifier(t *testing.T) {
+	leases := "00:11:22:33:44:55 10.0.0.1\n" +
+		"cid:01AABBCCDDEEFF 10.0.0.2\n"
+	config := testModule(t, leases)
+	config.ClientIdentifier = true
+	m := handlertest.Provision(t, config)
+	mac, _ := net.ParseMAC("00:11:22:33:44:55")
+	other, _ := net.ParseMAC("02:00:00:00:00:01")
+
+	// the client identifier takes precedence over the MAC address
+	assert.Equal(t, "10.0.0.2", assigned4(t, m, mac, []byte{0x01, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}).String())
+	assert.Equal(t, "10.0.0.2", assigned4(t, m, other, []byte{0x01, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}).String())
+
+	// fall back to the MAC address for an unknown or absent client identifier
+	assert.Equal(t, "10.0.0.1", assigned4(t, m, mac, []byte{0x01, 0x02}).String())
+	assert.Equal(t, "10.0.0.1", assigned4(t, m, mac, nil).String())
+	assert.True(t, assigned4(t, m, other, nil).IsUnspecified())
+}
+
+func TestHandle4Decline(t *testing.T) {
+	m := handlertest.Provision(t, testModule(t, "02:00:00:00:00:01 10.0.0.10\n"))
+	core, logs := observer.New(zapcore.WarnLevel)
+	m.logger = zap.New(core)
+	var events []map[string]any
+	m.emit = func(eventName string, data map[string]any) {
+		assert.Equal(t, "dhcp_declined", eventName)
+		events = append(events, data)
+	}
+
+	decline := func(mac net.HardwareAddr, ip net.IP) *dhcpv4.DHCPv4 {
+		req, err := dhcpv4.New(
+			dhcpv4.WithHwAddr(mac),
+			dhcpv4.WithMessageType(dhcpv4.MessageTypeDecline),
+			dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(ip)),
+		)
+		require.NoError(t, err)
+		resp, err := handlertest.Handle4(t, m, handlers.NewDHCPv4(req), nil)
+		require.NoError(t, err)
+		return resp
+	}
+
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	resp := decline(mac, net.IPv4(10, 0, 0, 10))
+	assert.True(t, resp.YourIPAddr.IsUnspecified())
+	entries := logs.FilterMessage("client declined its reserved address, which is in use by another host").AllUntimed()
+	require.Len(t, entries, 1)
+	assert.Equal(t, zapcore.ErrorLevel, entries[0].Level)
+	assert.Equal(t, "10.0.0.10", entries[0].ContextMap()["ip"])
+	assert.Equal(t, []map[string]any{{"mac": "02:00:00:00:00:01", "ip": "10.0.0.10", "filename": m.Filename}}, events)
+
+	// declining another address than the reserved one is not a conflict of the reservation
+	decline(mac, net.IPv4(10, 0, 0, 11))
+	assert.Len(t, events, 1)
+}
+
+func TestHandle4ClientIdentifierDisabled(t *testing.T) {
+	m := handlertest.Provision(t, testModule(t, "00:11:22:33:44:55 10.0.0.1\ncid:01aabbccddeeff 10.0.0.2\n"))
+	mac, _ := net.ParseMAC("00:11:22:33:44:55")
+	assert.Equal(t, "10.0.0.1", assigned4(t, m, mac, []byte{0x01, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}).String())
+}
+
+func TestLoadRecordsClientIdentifier(t *testing.T) {
+	for _, leases := range []string{
+		"cid:zz 10.0.0.1\n",
+		"cid: 10.0.0.1\n",
+		"cid:01aa 2001:db8::1\n",
+	} {
+		filename := filepath.Join(t.TempDir(), "leases.txt")
+		require.NoError(t, os.WriteFile(filename, []byte(leases), 0o644))
+		m := &Module{Filename: filename}
+		assert.Error(t, m.Provision(caddy.Context{}), leases)
+	}
+}
+
+func TestReservations(t *testing.T) {
+	m := handlertest.Provision(t, testModule(t, "00:11:22:33:44:55 10.0.0.1\n"))
+	mac, _ := net.ParseMAC("00:11:22:33:44:55")
+	other, _ := net.ParseMAC("02:00:00:00:00:01")
+
+	// add
+	require.NoError(t, m.SetReservation("02-00-00-00-00-01", net.IPv4(10, 0, 0, 2)))
+	assert.Equal(t, "10.0.0.2", assigned4(t, m, other, nil).String())
+
+	// override a reservation from the file
+	require.NoError(t, m.SetReservation("00:11:22:33:44:55", net.IPv4(10, 0, 0, 3)))
+	assert.Equal(t, "10.0.0.3", assigned4(t, m, mac, nil).String())
+
+	// delete
+	assert.True(t, m.DeleteReservation("02:00:00:00:00:01"))
+	assert.True(t, assigned4(t, m, other, nil).IsUnspecified())
+	assert.False(t, m.DeleteReservation("02:00:00:00:00:01"))
+
+	assert.Error(t, m.SetReservation("cid:zz", net.IPv4(10, 0, 0, 4)))
+	assert.Error(t, m.SetReservation("02:00:00:00:00:01", nil))
+
+	// without keeping the overrides a reload restores the file
+	require.NoError(t, m.loadRecords(m.KeepOverridesOnReload))
+	assert.Equal(t, "10.0.0.1", assigned4(t, m, mac, nil).String())
+	assert.True(t, assigned4(t, m, other, nil).IsUnspecified())
+}
+
+func TestConfigReload(t *testing.T) {
+	old := handlertest.Provision(t, testModule(t, "00:11:22:33:44:55 10.0.0.1\n"))
+	other, _ := net.ParseMAC("02:00:00:00:00:01")
+	require.NoError(t, old.SetReservation(other.String(), net.IPv4(10, 0, 0, 2)))
+
+	// a config reload provisions the new handler before the old one is cleaned up
+	require.NoError(t, os.WriteFile(old.Filename, []byte("00:11:22:33:44:55 10.0.0.10\n"), 0o644))
+	m := handlertest.Provision(t, &Module{Filename: old.Filename})
+	require.NoError(t, old.Cleanup())
+
+	// the file is read again, and the reservation added through the admin API is kept
+	mac, _ := net.ParseMAC("00:11:22:33:44:55")
+	assert.Equal(t, "10.0.0.10", assigned4(t, m, mac, nil).String())
+	assert.Equal(t, "10.0.0.2", assigned4(t, m, other, nil).String())
+
+	// a config reload fails while the file is invalid
+	require.NoError(t, os.WriteFile(old.Filename, []byte("invalid\n"), 0o644))
+	invalid := &Module{Filename: old.Filename}
+	assert.Error(t, invalid.Provision(caddy.Context{}))
+}
+
+func TestGratuitousARP(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "leases.txt")
+	require.NoError(t, os.WriteFile(filename, []byte("00:11:22:33:44:55 10.0.0.1\n"), 0o644))
+	m := handlertest.Provision(t, &Module{Filename: filename, GratuitousARP: true})
+	var targets []string
+	m.garp.Send = func(ip net.IP, mac net.HardwareAddr) error {
+		targets = append(targets, ip.String()+" "+mac.String())
+		return nil
+	}
+
+	for _, mac := range []string{"00:11:22:33:44:55", "02:00:00:00:00:01"} {
+		hwaddr, _ := net.ParseMAC(mac)
+		req, err := dhcpv4.NewDiscovery(hwaddr)
+		require.NoError(t, err)
+		_, err = handlertest.Handle4(t, m, handlers.NewDHCPv4(req), nil, dhcpv4.WithMessageType(dhcpv4.MessageTypeAck))
+		require.NoError(t, err)
+	}
+	// clients without a reservation get no address to announce
+	assert.Equal(t, []string{"10.0.0.1 00:11:22:33:44:55"}, targets)
+}
+
+func TestReservationsKeepOverridesOnReload(t *testing.T) {
+	m := handlertest.Provision(t, testModule(t, "00:11:22:33:44:55 10.0.0.1\n02:00:00:00:00:02 10.0.0.5\n"))
+	m.KeepOverridesOnReload = true
+	mac, _ := net.ParseMAC("00:11:22:33:44:55")
+	other, _ := net.ParseMAC("02:00:00:00:00:01")
+	deleted, _ := net.ParseMAC("02:00:00:00:00:02")
+
+	require.NoError(t, m.SetReservation("02:00:00:00:00:01", net.IPv4(10, 0, 0, 2)))
+	require.NoError(t, m.SetReservation("00:11:22:33:44:55", net.IPv4(10, 0, 0, 3)))
+	assert.True(t, m.DeleteReservation("02:00:00:00:00:02"))
+
+	// a reload does not clobber the reservations made through the API, even when the file changed
+	require.NoError(t, os.WriteFile(m.Filename, []byte("00:11:22:33:44:55 10.0.0.10\n02:00:00:00:00:02 10.0.0.5\n02:00:00:00:00:03 10.0.0.6\n"), 0o644))
+	require.NoError(t, m.loadRecords(m.KeepOverridesOnReload))
+	assert.Equal(t, "10.0.0.3", assigned4(t, m, mac, nil).String())
+	assert.Equal(t, "10.0.0.2", assigned4(t, m, other, nil).String())
+	assert.True(t, assigned4(t, m, deleted, nil).IsUnspecified())
+	assert.Equal(t, "10.0.0.6", assigned4(t, m, net.HardwareAddr{0x02, 0, 0, 0, 0, 3}, nil).String())
+}
+
+func TestAdminAPI(t *testing.T) {
+	m := handlertest.Provision(t, testModule(t, "00:11:22:33:44:55 10.0.0.1\n"))
+	other, _ := net.ParseMAC("02:00:00:00:00:01")
+
+	a := &AdminAPI{}
+	routes := make(map[string]caddy.AdminHandler)
+	for _, route := range a.Routes() {
+		routes[route.Pattern] = route.Handler
+	}
+	serve := func(method, target, body string) (*httptest.ResponseRecorder, error) {
+		rec := httptest.NewRecorder()
+		path, _, _ := strings.Cut(target, "?")
+		err := routes[path].ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rec, err
+	}
+	query := "?filename=" + m.Filename
+
+	rec, err := serve(http.MethodPut, "/dhcp/file/reservations"+query, `{"id": "02:00:00:00:00:01", "ip": "10.0.0.2"}`)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "10.0.0.2", assigned4(t, m, other, nil).String())
+
+	rec, err = serve(http.MethodDelete, "/dhcp/file/reservations"+query+"&id=02:00:00:00:00:01", "")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+	assert.True(t, assigned4(t, m, other, nil).IsUnspecified())
+
+	rec, err = serve(http.MethodPost, "/dhcp/file/reload"+query, "")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	var apiErr caddy.APIError
+	for _, tc := range []struct {
+		method, target, body string
+		status               int
+	}{
+		{http.MethodPut, "/dhcp/file/reservations" + query, `{"id": "02:00:00:00:00:01", "ip": "invalid"}`, http.StatusBadRequest},
+		{http.MethodPut, "/dhcp/file/reservations" + query, `{"ip": "10.0.0.2"}`, http.StatusBadRequest},
+		{http.MethodDelete, "/dhcp/file/reservations" + query + "&id=02:00:00:00:00:01", "", http.StatusNotFound},
+		{http.MethodPost, "/dhcp/file/reload?filename=unknown", "", http.StatusNotFound},
+		{http.MethodGet, "/dhcp/file/reservations", "", http.StatusMethodNotAllowed},
+	} {
+		_, err := serve(tc.method, tc.target, tc.body)
+		require.ErrorAs(t, err, &apiErr, tc.target)
+		assert.Equal(t, tc.status, apiErr.HTTPStatus, tc.target)
+	}
+}
+
+func TestHandle6Jitter(t *testing.T) {
+	m := handlertest.Provision(t, testModule(t, ""))
+	m.Jitter = 10
+
+	for i := byte(1); i <= 20; i++ {
+		req, err := dhcpv6.NewSolicit(net.HardwareAddr{0x02, 0, 0, 0, 0, i}, dhcpv6.WithIAID([4]byte{0, 0, 0, 1}))
+		require.NoError(t, err)
+		duid := hex.EncodeToString(req.Options.ClientID().ToBytes())
+		require.NoError(t, m.SetReservation(duid, net.ParseIP(fmt.Sprintf("2001:db8::%d", i))))
+
+		lifetime := func() time.Duration {
+			resp, err := handlertest.Handle6(t, m, handlers.NewDHCPv6(req), nil)
+			require.NoError(t, err)
+			addrs := resp.Options.OneIANA().Options.Addresses()
+			require.Len(t, addrs, 1)
+			assert.Equal(t, addrs[0].PreferredLifetime, addrs[0].ValidLifetime)
+			return addrs[0].ValidLifetime
+		}
+		lt := lifetime()
+		assert.GreaterOrEqual(t, lt, 54*time.Minute)
+		assert.LessOrEqual(t, lt, 66*time.Minute)
+		assert.Equal(t, lt, lifetime())
+	}
+}
+
+func TestHandle6ClientLinkLayerAddress(t *testing.T) {
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	msg, err := dhcpv6.NewSolicit(net.HardwareAddr{0x02, 0, 0, 0, 0, 9}, dhcpv6.WithIAID([4]byte{0, 0, 0, 1}))
+	require.NoError(t, err)
+	duid := hex.EncodeToString(msg.Options.ClientID().ToBytes())
+	m := handlertest.Provision(t, testModule(t, fmt.Sprintf("%s 2001:db8::1\n%s 10.0.0.1\n%s 2001:db8::2\n", mac, mac, duid)))
+
+	handle6 := func(req handlers.DHCPv6) net.IP {
+		resp, err := handlertest.Handle6(t, m, req, nil)
+		require.NoError(t, err)
+		if resp.Options.OneIANA() == nil {
+			return nil
+		}
+		return resp.Options.OneIANA().Options.OneAddress().IPv6Addr
+	}
+
+	// without option 79 the DUID is used
+	assert.Equal(t, "2001:db8::2", handle6(handlers.NewDHCPv6(msg)).String())
+
+	// the MAC address of option 79 takes precedence, and only matches IPv6 reservations
+	relay, err := dhcpv6.EncapsulateRelay(msg, dhcpv6.MessageTypeRelayForward, net.ParseIP("2001:db8::ff"), net.ParseIP("fe80::1"))
+	require.NoError(t, err)
+	relay.Options.Add(dhcpv6.OptClientLinkLayerAddress(iana.HWTypeEthernet, mac))
+	assert.Equal(t, "2001:db8::1", handle6(handlers.NewRelayedDHCPv6(relay, msg)).String())
+
+	// an unknown MAC address falls back to the DUID
+	relay.Options.Update(dhcpv6.OptClientLinkLayerAddress(iana.HWTypeEthernet, net.HardwareAddr{0x02, 0, 0, 0, 0, 2}))
+	assert.Equal(t, "2001:db8::2", handle6(handlers.NewRelayedDHCPv6(relay, msg)).String())
+}
+
+func TestHandle6MultipleIANA(t *testing.T) {
+	req, err := dhcpv6.NewSolicit(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, dhcpv6.WithIAID([4]byte{0, 0, 0, 1}))
+	require.NoError(t, err)
+	req.AddOption(&dhcpv6.OptIANA{IaId: [4]byte{0, 0, 0, 2}})
+	duid := hex.EncodeToString(req.Options.ClientID().ToBytes())
+	m := handlertest.Provision(t, testModule(t, fmt.Sprintf("%s 2001:db8::1\n", duid)))
+
+	handle6 := func() map[[4]byte]*dhcpv6.OptIANA {
+		resp, err := handlertest.Handle6(t, m, handlers.NewDHCPv6(req), nil)
+		require.NoError(t, err)
+		msg, err := dhcpv6.MessageFromBytes(resp.ToBytes())
+		require.NoError(t, err)
+		ianas := make(map[[4]byte]*dhcpv6.OptIANA)
+		for _, ia := range msg.Options.IANA() {
+			ianas[ia.IaId] = ia
+		}
+		require.Len(t, ianas, 2)
+		return ianas
+	}
+
+	// the reserved address is assigned to the first IA_NA, and every IAID is echoed
+	ianas := handle6()
+	require.NotNil(t, ianas[[4]byte{0, 0, 0, 1}].Options.OneAddress())
+	assert.Equal(t, "2001:db8::1", ianas[[4]byte{0, 0, 0, 1}].Options.OneAddress().IPv6Addr.String())
+	assert.Empty(t, ianas[[4]byte{0, 0, 0, 2}].Options.Addresses())
+	require.NotNil(t, ianas[[4]byte{0, 0, 0, 2}].Options.Status())
+	assert.Equal(t, iana.StatusNoAddrsAvail, ianas[[4]byte{0, 0, 0, 2}].Options.Status().StatusCode)
+
+	// an IA_NA that already holds the reserved address keeps it
+	req.Options.Del(dhcpv6.OptionIANA)
+	req.AddOption(&dhcpv6.OptIANA{IaId: [4]byte{0, 0, 0, 1}})
+	req.AddOption(&dhcpv6.OptIANA{IaId: [4]byte{0, 0, 0, 2}, Options: dhcpv6.IdentityOptions{Options: []dhcpv6.Option{
+		&dhcpv6.OptIAAddress{IPv6Addr: net.ParseIP("2001:db8::1")},
+	}}})
+	ianas = handle6()
+	assert.Empty(t, ianas[[4]byte{0, 0, 0, 1}].Options.Addresses())
+	assert.Equal(t, iana.StatusNoAddrsAvail, ianas[[4]byte{0, 0, 0, 1}].Options.Status().StatusCode)
+	require.NotNil(t, ianas[[4]byte{0, 0, 0, 2}].Options.OneAddress())
+	assert.Equal(t, "2001:db8::1", ianas[[4]byte{0, 0, 0, 2}].Options.OneAddress().IPv6Addr.String())
+}
+
+func TestOnUnknown(t *testing.T) {
+	known := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	unknown := net.HardwareAddr{0x02, 0, 0, 0, 0, 2}
+
+	handle4 := func(m *Module, mac net.HardwareAddr, mt dhcpv4.MessageType) (bool, error) {
+		req, err := dhcpv4.NewDiscovery(mac, dhcpv4.WithMessageType(mt))
+		require.NoError(t, err)
+		called := false
+		_, err = handlertest.Handle4(t, m, handlers.NewDHCPv4(req), func(*dhcpv4.DHCPv4) error { called = true; return nil })
+		return called, err
+	}
+	handle6 := func(m *Module, mac net.HardwareAddr) (bool, error) {
+		req, err := dhcpv6.NewSolicit(mac)
+		require.NoError(t, err)
+		called := false
+		_, err = handlertest.Handle6(t, m, handlers.NewDHCPv6(req), func(*dhcpv6.Message) error { called = true; return nil })
+		return called, err
+	}
+
+	for _, policy := range []string{"", onUnknownContinue, onUnknownDrop, onUnknownNak} {
+		t.Run(policy, func(t *testing.T) {
+			config := testModule(t, fmt.Sprintf("%s 10.0.0.1\n%s 2001:db8::1\n", known, known))
+			config.OnUnknown = policy
+			m := handlertest.Provision(t, config)
+
+			// a known client always continues the chain
+			called, err := handle4(m, known, dhcpv4.MessageTypeRequest)
+			require.NoError(t, err)
+			assert.True(t, called)
+
+			// as does a decline, so other handlers can act on it
+			called, err = handle4(m, unknown, dhcpv4.MessageTypeDecline)
+			require.NoError(t, err)
+			assert.True(t, called)
+
+			called4, err4 := handle4(m, unknown, dhcpv4.MessageTypeRequest)
+			called6, err6 := handle6(m, unknown)
+			switch policy {
+			case "", onUnknownContinue:
+				assert.NoError(t, err4)
+				assert.True(t, called4)
+				assert.NoError(t, err6)
+				assert.True(t, called6)
+			case onUnknownDrop:
+				assert.ErrorIs(t, err4, handlers.ErrDrop)
+				assert.False(t, called4)
+				assert.ErrorIs(t, err6, handlers.ErrDrop)
+				assert.False(t, called6)
+			case onUnknownNak:
+				var herr handlers.HandlerError
+				require.ErrorAs(t, err4, &herr)
+				assert.True(t, herr.Nak)
+				assert.False(t, called4)
+				require.ErrorAs(t, err6, &herr)
+				assert.True(t, herr.Nak)
+				assert.Equal(t, iana.StatusNoAddrsAvail, herr.Status())
+				assert.False(t, called6)
+			}
+		})
+	}
+
+	assert.Error(t, (&Module{OnUnknown: "reject"}).Provision(caddy.Context{}))
+}
+
+func TestSeenFile(t *testing.T) {
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	leases := fmt.Sprintf("%s 10.0.0.1\n", mac)
+	filename := filepath.Join(t.TempDir(), "leases.txt")
+	require.NoError(t, os.WriteFile(filename, []byte(leases), 0o644))
+	seenFile := filepath.Join(t.TempDir(), "seen.json")
+	m := handlertest.Provision(t, &Module{Filename: filename, SeenFile: seenFile})
+
+	handle := func(hostname string) {
+		req, err := dhcpv4.NewDiscovery(mac)
+		require.NoError(t, err)
+		if hostname != "" {
+			req.UpdateOption(dhcpv4.OptHostName(hostname))
+		}
+		_, err = handlertest.Handle4(t, m, handlers.NewDHCPv4(req), nil)
+		require.NoError(t, err)
+	}
+	readSeen := func() []Seen {
+		data, err := os.ReadFile(seenFile)
+		require.NoError(t, err)
+		var records []Seen
+		require.NoError(t, json.Unmarshal(data, &records))
+		return records
+	}
+
+	before := time.Now()
+	handle("laptop")
+	records := readSeen()
+	require.Len(t, records, 1)
+	assert.Equal(t, mac.String(), records[0].ID)
+	assert.Equal(t, "10.0.0.1", records[0].IP)
+	assert.Equal(t, "laptop", records[0].Hostname)
+	assert.False(t, records[0].LastSeen.Before(before.Truncate(time.Second)))
+
+	// a request without a hostname updates the last-seen time, and keeps the hostname
+	handle("")
+	records = readSeen()
+	require.Len(t, records, 1)
+	assert.Equal(t, "laptop", records[0].Hostname)
+	assert.False(t, records[0].LastSeen.Before(before.Truncate(time.Second)))
+
+	// unknown clients are not recorded
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 2})
+	require.NoError(t, err)
+	_, err = handlertest.Handle4(t, m, handlers.NewDHCPv4(req), nil)
+	require.NoError(t, err)
+	assert.Len(t, readSeen(), 1)
+
+	// the reservation file is never written
+	data, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, leases, string(data))
+
+	// the records are served by the admin API
+	rec := httptest.NewRecorder()
+	require.NoError(t, (&AdminAPI{}).handleSeen(rec, httptest.NewRequest(http.MethodGet, "/dhcp/file/seen?filename="+filename, nil)))
+	var seen map[string][]Seen
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &seen))
+	require.Len(t, seen[filename], 1)
+	assert.Equal(t, "laptop", seen[filename][0].Hostname)
+
+	// the seen file cannot be the reservation file
+	assert.Error(t, (&Module{Filename: filename, SeenFile: filename}).Provision(caddy.Context{}))
+}
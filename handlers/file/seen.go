@@ -0,0 +1,128 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package file
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// Seen is the last time a client with a reservation was seen, as recorded in the seen file.
+type Seen struct {
+	// The MAC address of a DHCPv4 client, or the hex encoded DUID of a DHCPv6 client.
+	ID string `json:"id"`
+
+	// The reserved address of the client.
+	IP string `json:"ip"`
+
+	// The hostname the client sent in its last request, if any.
+	Hostname string `json:"hostname,omitempty"`
+
+	// The time of the last request of the client.
+	LastSeen time.Time `json:"lastSeen"`
+}
+
+// seenStore holds the records of a seen file. Like the reservations, it is shared by all file handlers
+// with the same seen file, so the records survive a config reload without re-reading the file.
+type seenStore struct {
+	filename string
+
+	mu      sync.Mutex
+	records map[string]Seen
+}
+
+// Destruct implements caddy.Destructor; the records are written on every update, so there is nothing to flush.
+func (s *seenStore) Destruct() error {
+	return nil
+}
+
+// seenPool holds the seen stores of the provisioned file handlers, keyed by filename.
+var seenPool = caddy.NewUsagePool()
+
+// loadSeen returns the seen store of filename, reading the records of the file if it exists.
+func loadSeen(filename string) (*seenStore, error) {
+	val, _, err := seenPool.LoadOrNew(filename, func() (caddy.Destructor, error) {
+		s := &seenStore{filename: filename, records: make(map[string]Seen)}
+		data, err := os.ReadFile(filename)
+		if errors.Is(err, fs.ErrNotExist) {
+			return s, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var records []Seen
+		if err := json.Unmarshal(data, &records); err != nil {
+			return nil, fmt.Errorf("malformed seen file %s: %v", filename, err)
+		}
+		for _, r := range records {
+			s.records[r.ID] = r
+		}
+		return s, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return val.(*seenStore), nil
+}
+
+// record updates the record of a client and writes all records to the seen file.
+// A hostname that is empty keeps the hostname of the previous record.
+func (s *seenStore) record(r Seen) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.Hostname == "" {
+		r.Hostname = s.records[r.ID].Hostname
+	}
+	s.records[r.ID] = r
+	return s.write()
+}
+
+// list returns the records ordered by id.
+func (s *seenStore) list() []Seen {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sorted()
+}
+
+// sorted returns the records ordered by id. The caller must hold the lock.
+func (s *seenStore) sorted() []Seen {
+	records := make([]Seen, 0, len(s.records))
+	for _, r := range s.records {
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	return records
+}
+
+// write replaces the seen file with the records, through a temporary file so a crash never leaves it half written.
+// The caller must hold the lock.
+func (s *seenStore) write() error {
+	data, err := json.MarshalIndent(s.sorted(), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.filename), filepath.Base(s.filename)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.filename)
+}
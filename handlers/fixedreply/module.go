@@ -0,0 +1,108 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package fixedreply
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/lion7/caddydhcp/handlers"
+	"go.uber.org/zap"
+)
+
+// Module answers every DHCPv4 request with the same canned reply, regardless of the content of the request,
+// for lab setups and protocol testing, like reproducing a client bug with a specific reply.
+// The options of the reply built so far are replaced by the configured ones, and the chain is stopped,
+// so the handlers following this module are not run. Only the fields that tie the reply to the request,
+// like the transaction ID and the client hardware address, are kept. DHCPv6 requests are passed on unchanged.
+//
+//	{
+//	  "handler": "fixedreply",
+//	  "messageType": "Offer",
+//	  "yourIp": "192.0.2.10",
+//	  "options": {"1": "ffffff00", "3": "c0000201"}
+//	}
+type Module struct {
+	handlers.Base
+
+	// The DHCPv4 message type of the reply, like "Offer", "Ack" or "Nak".
+	// The name is case-insensitive, and may be prefixed with "DHCP".
+	MessageType string `json:"messageType"`
+
+	// The address assigned to the client (yiaddr). Defaults to no address.
+	YourIP string `json:"yourIp,omitempty"`
+
+	// The options of the reply, keyed by option code, with their values in hex.
+	// The message type option (53) is set using MessageType.
+	Options map[uint8]string `json:"options,omitempty"`
+
+	messageType dhcpv4.MessageType
+	yourIP      net.IP
+	options     []dhcpv4.Option
+	logger      *zap.Logger
+}
+
+// CaddyModule returns the Caddy module information.
+func (Module) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "dhcp.handlers.fixedreply",
+		New: func() caddy.Module { return new(Module) },
+	}
+}
+
+// Provision is run immediately after this handler is being loaded.
+func (m *Module) Provision(ctx caddy.Context) error {
+	m.logger = ctx.Logger()
+	if m.MessageType == "" {
+		return fmt.Errorf("no message type configured")
+	}
+	mt, ok := handlers.ParseMessageType4(m.MessageType)
+	if !ok {
+		return fmt.Errorf("unknown message type %q", m.MessageType)
+	}
+	m.messageType = mt
+	m.yourIP = nil
+	if m.YourIP != "" {
+		m.yourIP = net.ParseIP(m.YourIP).To4()
+		if m.yourIP == nil {
+			return fmt.Errorf("invalid IPv4 address %q", m.YourIP)
+		}
+	}
+	m.options = nil
+	for code, value := range m.Options {
+		if code == dhcpv4.OptionPad.Code() || code == dhcpv4.OptionEnd.Code() || code == dhcpv4.OptionDHCPMessageType.Code() {
+			return fmt.Errorf("invalid DHCPv4 option code %d", code)
+		}
+		data, err := hex.DecodeString(value)
+		if err != nil {
+			return fmt.Errorf("invalid value of DHCPv4 option %d: %v", code, err)
+		}
+		m.options = append(m.options, dhcpv4.OptGeneric(dhcpv4.GenericOptionCode(code), data))
+	}
+	return nil
+}
+
+// Handle4 handles DHCPv4 packets for this plugin.
+func (m *Module) Handle4(req, resp handlers.DHCPv4, _ func() error) error {
+	resp.Options = dhcpv4.Options{}
+	resp.UpdateOption(dhcpv4.OptMessageType(m.messageType))
+	for _, opt := range m.options {
+		resp.UpdateOption(opt)
+	}
+	resp.YourIPAddr = net.IPv4zero
+	if m.yourIP != nil {
+		resp.YourIPAddr = m.yourIP
+	}
+	m.logger.Debug("sending canned reply", zap.Stringer("mac", req.ClientHWAddr), zap.Stringer("reply_type", m.messageType))
+	return handlers.ErrStopAndReply
+}
+
+// Interfaces guards
+var (
+	_ handlers.HandlerModule = (*Module)(nil)
+)
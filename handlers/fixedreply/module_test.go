@@ -0,0 +1,64 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package fixedreply
+
+import (
+	"net"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProvision(t *testing.T) {
+	for name, m := range map[string]*Module{
+		"no message type":      {},
+		"unknown message type": {MessageType: "Bogus"},
+		"invalid address":      {MessageType: "Offer", YourIP: "2001:db8::1"},
+		"message type option":  {MessageType: "Offer", Options: map[uint8]string{53: "05"}},
+		"invalid value":        {MessageType: "Offer", Options: map[uint8]string{1: "zz"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Error(t, m.Provision(caddy.Context{}))
+		})
+	}
+}
+
+func TestHandle4(t *testing.T) {
+	m := &Module{
+		MessageType: "DHCPOFFER",
+		YourIP:      "192.0.2.10",
+		Options:     map[uint8]string{1: "ffffff00", 3: "c0000201", 51: "00000e10"},
+	}
+	require.NoError(t, m.Provision(caddy.Context{}))
+
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, dhcpv4.WithRequestedOptions(dhcpv4.OptionDomainNameServer))
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req, dhcpv4.WithMessageType(dhcpv4.MessageTypeOffer))
+	require.NoError(t, err)
+	// an option set by an earlier handler is not part of the canned reply
+	resp.UpdateOption(dhcpv4.OptDNS(net.IPv4(192, 0, 2, 53)))
+
+	called := false
+	err = m.Handle4(handlers.DHCPv4{DHCPv4: req}, handlers.DHCPv4{DHCPv4: resp}, func() error {
+		called = true
+		return nil
+	})
+	assert.ErrorIs(t, err, handlers.ErrStopAndReply)
+	assert.False(t, called)
+
+	expected, err := dhcpv4.NewReplyFromRequest(req,
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeOffer),
+		dhcpv4.WithYourIP(net.IPv4(192, 0, 2, 10)),
+		dhcpv4.WithNetmask(net.IPv4Mask(255, 255, 255, 0)),
+		dhcpv4.WithRouter(net.IPv4(192, 0, 2, 1)),
+		dhcpv4.WithGeneric(dhcpv4.OptionIPAddressLeaseTime, []byte{0, 0, 0x0e, 0x10}),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, expected.ToBytes(), resp.ToBytes())
+}
@@ -0,0 +1,338 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package fqdn
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/rfc1035label"
+	"github.com/lion7/caddydhcp/handlers"
+	"go.uber.org/zap"
+)
+
+// Flags of the DHCPv4 Client FQDN option as defined in RFC 4702, section 2.1.
+const (
+	FlagS4 uint8 = 1 << 0 // the server should perform the A RR update
+	FlagO4 uint8 = 1 << 1 // the server has overridden the client's preference for the S bit
+	FlagE4 uint8 = 1 << 2 // the domain name is in canonical wire format
+	FlagN4 uint8 = 1 << 3 // the server should not perform any DNS updates
+)
+
+// Flags of the DHCPv6 Client FQDN option as defined in RFC 4704, section 4.1.
+const (
+	FlagS6 uint8 = 1 << 0 // the server should perform the AAAA RR update
+	FlagO6 uint8 = 1 << 1 // the server has overridden the client's preference for the S bit
+	FlagN6 uint8 = 1 << 2 // the server should not perform any DNS updates
+)
+
+// Values for Module.Updates.
+const (
+	UpdatesClient = "client"
+	UpdatesServer = "server"
+	UpdatesNone   = "none"
+)
+
+// Updater performs the DNS updates for a client FQDN once an address has been assigned to the client.
+// The calls are made while handling the request, so an updater that talks to a DNS server
+// should queue the updates instead of making the client wait for them.
+type Updater interface {
+	// Update registers fqdn for addr. The forward (A/AAAA) record must only be
+	// updated if forward is true, the reverse (PTR) record is always updated.
+	Update(fqdn string, addr net.IP, forward bool) error
+
+	// Remove deletes the records registered for addr, after the client released it.
+	Remove(addr net.IP) error
+}
+
+// Module processes the Client FQDN option (option 81 for DHCPv4, option 39 for DHCPv6).
+// The name sent by the client is qualified with the configured domain or replaced altogether,
+// after which the option is echoed in the reply with the flags set according to the update policy.
+// Once the rest of the chain has assigned an address, the configured updater is invoked.
+// A DHCPv4 client without the Client FQDN option is registered under its Host Name option (12),
+// with the server performing both updates unless the policy is `none`.
+// The records of released addresses are removed again.
+type Module struct {
+	// The domain appended to names that are not fully qualified.
+	Domain string `json:"domain,omitempty"`
+
+	// Overrides the name sent by the client. The placeholder `{mac}` is replaced
+	// by the hardware address of the client, e.g. `host-{mac}`.
+	Name string `json:"name,omitempty"`
+
+	// Who performs the forward DNS updates: `client` honors the preference of the client (the default),
+	// `server` always performs them and `none` never performs any DNS updates.
+	Updates string `json:"updates,omitempty"`
+
+	// The module that performs the DNS updates. The default does nothing.
+	UpdaterRaw json.RawMessage `json:"updater,omitempty" caddy:"namespace=dhcp.fqdn.updaters inline_key=updater"`
+
+	updater Updater
+	logger  *zap.Logger
+}
+
+// CaddyModule returns the Caddy module information.
+func (Module) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "dhcp.handlers.fqdn",
+		New: func() caddy.Module { return new(Module) },
+	}
+}
+
+// Provision is run immediately after this handler is being loaded.
+func (m *Module) Provision(ctx caddy.Context) error {
+	m.logger = ctx.Logger()
+
+	switch m.Updates {
+	case "":
+		m.Updates = UpdatesClient
+	case UpdatesClient, UpdatesServer, UpdatesNone:
+	default:
+		return fmt.Errorf("invalid updates policy %q, expected one of %q, %q or %q", m.Updates, UpdatesClient, UpdatesServer, UpdatesNone)
+	}
+	m.Domain = strings.Trim(m.Domain, ".")
+
+	m.updater = nopUpdater{}
+	if m.UpdaterRaw != nil {
+		mod, err := handlers.LoadModule(ctx, m, "UpdaterRaw")
+		if err != nil {
+			return fmt.Errorf("loading updater module: %v", err)
+		}
+		updater, ok := mod.(Updater)
+		if !ok {
+			return fmt.Errorf("module %T is not an FQDN updater", mod)
+		}
+		m.updater = updater
+	}
+	return nil
+}
+
+// Handle4 handles DHCPv4 packets for this plugin.
+func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
+	if req.MessageType() == dhcpv4.MessageTypeRelease {
+		nextErr := next()
+		if nextErr != nil && !errors.Is(nextErr, handlers.ErrStopAndReply) {
+			return nextErr
+		}
+		if m.Updates != UpdatesNone && req.ClientIPAddr != nil && !req.ClientIPAddr.IsUnspecified() {
+			m.remove(req.ClientIPAddr)
+		}
+		return nextErr
+	}
+
+	data := req.Options.Get(dhcpv4.OptionFQDN)
+	if data == nil {
+		return m.handleHostName4(req, resp, next)
+	}
+	flags, name, err := Parse4(data)
+	if err != nil {
+		m.logger.Warn("invalid client FQDN option", zap.Error(err))
+		return next()
+	}
+	name = m.resolve(name, req.ClientHWAddr)
+	if name == "" {
+		return next()
+	}
+
+	s, o, n := m.negotiate(flags&FlagS4 != 0, flags&FlagN4 != 0)
+	replyFlags := flags & FlagE4
+	if s {
+		replyFlags |= FlagS4
+	}
+	if o {
+		replyFlags |= FlagO4
+	}
+	if n {
+		replyFlags |= FlagN4
+	}
+	// RFC 4702, section 3.3: servers set both RCODE fields to 255
+	reply := []byte{replyFlags, 255, 255}
+	if flags&FlagE4 != 0 {
+		reply = append(reply, (&rfc1035label.Labels{Labels: []string{name}}).ToBytes()...)
+	} else {
+		reply = append(reply, name...)
+	}
+	resp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionFQDN, reply))
+
+	nextErr := next()
+	if nextErr != nil && !errors.Is(nextErr, handlers.ErrStopAndReply) {
+		return nextErr
+	}
+
+	if !n && isAssigned4(resp) {
+		m.update(name, resp.YourIPAddr, s)
+	}
+	return nextErr
+}
+
+// handleHostName4 registers the name in the Host Name option of a client that did not send the
+// Client FQDN option. Such a client performs no DNS updates itself, so the server performs both.
+func (m *Module) handleHostName4(req, resp handlers.DHCPv4, next func() error) error {
+	name := m.resolve(req.HostName(), req.ClientHWAddr)
+	if name == "" || m.Updates == UpdatesNone {
+		return next()
+	}
+
+	nextErr := next()
+	if nextErr != nil && !errors.Is(nextErr, handlers.ErrStopAndReply) {
+		return nextErr
+	}
+
+	if isAssigned4(resp) {
+		m.update(name, resp.YourIPAddr, true)
+	}
+	return nextErr
+}
+
+// isAssigned4 returns whether the reply is an Ack assigning an address to the client.
+func isAssigned4(resp handlers.DHCPv4) bool {
+	return resp.MessageType() == dhcpv4.MessageTypeAck && resp.YourIPAddr != nil && !resp.YourIPAddr.IsUnspecified()
+}
+
+// Handle6 handles DHCPv6 packets for this plugin.
+func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
+	if req.MessageType == dhcpv6.MessageTypeRelease {
+		nextErr := next()
+		if nextErr != nil && !errors.Is(nextErr, handlers.ErrStopAndReply) {
+			return nextErr
+		}
+		if m.Updates != UpdatesNone {
+			for _, iana := range req.Options.IANA() {
+				for _, addr := range iana.Options.Addresses() {
+					m.remove(addr.IPv6Addr)
+				}
+			}
+		}
+		return nextErr
+	}
+
+	opt := req.Options.FQDN()
+	if opt == nil || opt.DomainName == nil || len(opt.DomainName.Labels) == 0 {
+		return next()
+	}
+	var mac net.HardwareAddr
+	if duid, ok := req.Options.ClientID().(*dhcpv6.DUIDLL); ok {
+		mac = duid.LinkLayerAddr
+	} else if duid, ok := req.Options.ClientID().(*dhcpv6.DUIDLLT); ok {
+		mac = duid.LinkLayerAddr
+	}
+	name := m.resolve(opt.DomainName.Labels[0], mac)
+	if name == "" {
+		return next()
+	}
+
+	s, o, n := m.negotiate(opt.Flags&FlagS6 != 0, opt.Flags&FlagN6 != 0)
+	var replyFlags uint8
+	if s {
+		replyFlags |= FlagS6
+	}
+	if o {
+		replyFlags |= FlagO6
+	}
+	if n {
+		replyFlags |= FlagN6
+	}
+	resp.UpdateOption(&dhcpv6.OptFQDN{
+		Flags:      replyFlags,
+		DomainName: &rfc1035label.Labels{Labels: []string{name}},
+	})
+
+	nextErr := next()
+	if nextErr != nil && !errors.Is(nextErr, handlers.ErrStopAndReply) {
+		return nextErr
+	}
+
+	if !n && resp.MessageType == dhcpv6.MessageTypeReply {
+		for _, iana := range resp.Options.IANA() {
+			for _, addr := range iana.Options.Addresses() {
+				m.update(name, addr.IPv6Addr, s)
+			}
+		}
+	}
+	return nextErr
+}
+
+// resolve determines the name to use for the client, qualified with the configured domain.
+// It returns an empty string if no name could be determined.
+func (m *Module) resolve(name string, mac net.HardwareAddr) string {
+	if m.Name != "" {
+		name = strings.ReplaceAll(m.Name, "{mac}", strings.ReplaceAll(mac.String(), ":", ""))
+	}
+	name = strings.TrimSuffix(name, ".")
+	if name != "" && m.Domain != "" && !strings.Contains(name, ".") {
+		name += "." + m.Domain
+	}
+	return name
+}
+
+// negotiate determines the reply flags based on the flags sent by the client and the update policy,
+// following RFC 4702, section 4 and RFC 4704, section 5.
+func (m *Module) negotiate(clientS, clientN bool) (s, o, n bool) {
+	switch m.Updates {
+	case UpdatesServer:
+		return true, !clientS, false
+	case UpdatesNone:
+		return false, clientS, true
+	default:
+		if clientN {
+			return false, false, true
+		}
+		return clientS, false, false
+	}
+}
+
+func (m *Module) update(name string, addr net.IP, forward bool) {
+	if err := m.updater.Update(name, addr, forward); err != nil {
+		m.logger.Warn("failed to update DNS", zap.String("fqdn", name), zap.Stringer("ip", addr), zap.Error(err))
+	}
+}
+
+func (m *Module) remove(addr net.IP) {
+	if err := m.updater.Remove(addr); err != nil {
+		m.logger.Warn("failed to remove DNS records", zap.Stringer("ip", addr), zap.Error(err))
+	}
+}
+
+// Parse4 parses the data of a DHCPv4 Client FQDN option and returns its flags and domain name.
+// The name is returned as a dot-separated string, regardless of its encoding.
+func Parse4(data []byte) (uint8, string, error) {
+	if len(data) < 3 {
+		return 0, "", fmt.Errorf("option too short: %d bytes", len(data))
+	}
+	flags, data := data[0], data[3:]
+	if flags&FlagE4 == 0 {
+		return flags, string(data), nil
+	}
+
+	// the wire format may contain a partial name without the terminating root label
+	var labels []string
+	for len(data) > 0 && data[0] != 0 {
+		n := int(data[0])
+		if n > 63 || n+1 > len(data) {
+			return 0, "", fmt.Errorf("invalid label length %d", n)
+		}
+		labels = append(labels, string(data[1:n+1]))
+		data = data[n+1:]
+	}
+	return flags, strings.Join(labels, "."), nil
+}
+
+type nopUpdater struct{}
+
+func (nopUpdater) Update(string, net.IP, bool) error { return nil }
+
+func (nopUpdater) Remove(net.IP) error { return nil }
+
+// Interfaces guards
+var (
+	_ handlers.HandlerModule = (*Module)(nil)
+	_ Updater                = nopUpdater{}
+)
@@ -0,0 +1,279 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package fqdn
+
+import (
+	"net"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/insomniacslk/dhcp/rfc1035label"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type update struct {
+	fqdn    string
+	addr    string
+	forward bool
+}
+
+type recordingUpdater struct {
+	updates []update
+	removed []string
+}
+
+func (r *recordingUpdater) Update(fqdn string, addr net.IP, forward bool) error {
+	r.updates = append(r.updates, update{fqdn, addr.String(), forward})
+	return nil
+}
+
+func (r *recordingUpdater) Remove(addr net.IP) error {
+	r.removed = append(r.removed, addr.String())
+	return nil
+}
+
+func TestHandle4(t *testing.T) {
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	wire := (&rfc1035label.Labels{Labels: []string{"laptop"}}).ToBytes()
+
+	for _, tc := range []struct {
+		name        string
+		module      Module
+		clientFlags uint8
+		clientName  []byte
+		wantFlags   uint8
+		wantName    []byte
+		wantUpdates []update
+	}{
+		{
+			name:        "client performs forward update",
+			module:      Module{Domain: "example.org"},
+			clientFlags: 0,
+			clientName:  []byte("laptop"),
+			wantFlags:   0,
+			wantName:    []byte("laptop.example.org"),
+			wantUpdates: []update{{"laptop.example.org", "10.0.0.10", false}},
+		},
+		{
+			name:        "server performs forward update",
+			module:      Module{Domain: "example.org"},
+			clientFlags: FlagS4 | FlagE4,
+			clientName:  wire,
+			wantFlags:   FlagS4 | FlagE4,
+			wantName:    (&rfc1035label.Labels{Labels: []string{"laptop.example.org"}}).ToBytes(),
+			wantUpdates: []update{{"laptop.example.org", "10.0.0.10", true}},
+		},
+		{
+			name:        "client requests no updates",
+			module:      Module{},
+			clientFlags: FlagN4,
+			clientName:  []byte("laptop.example.org"),
+			wantFlags:   FlagN4,
+			wantName:    []byte("laptop.example.org"),
+		},
+		{
+			name:        "server overrides client",
+			module:      Module{Updates: UpdatesServer},
+			clientFlags: 0,
+			clientName:  []byte("laptop.example.org"),
+			wantFlags:   FlagS4 | FlagO4,
+			wantName:    []byte("laptop.example.org"),
+			wantUpdates: []update{{"laptop.example.org", "10.0.0.10", true}},
+		},
+		{
+			name:        "server refuses updates",
+			module:      Module{Updates: UpdatesNone},
+			clientFlags: FlagS4,
+			clientName:  []byte("laptop.example.org"),
+			wantFlags:   FlagN4 | FlagO4,
+			wantName:    []byte("laptop.example.org"),
+		},
+		{
+			name:        "name override",
+			module:      Module{Domain: "example.org", Name: "host-{mac}"},
+			clientFlags: FlagS4,
+			clientName:  []byte("laptop"),
+			wantFlags:   FlagS4,
+			wantName:    []byte("host-020000000001.example.org"),
+			wantUpdates: []update{{"host-020000000001.example.org", "10.0.0.10", true}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := tc.module
+			require.NoError(t, m.Provision(caddy.Context{}))
+			updater := &recordingUpdater{}
+			m.updater = updater
+
+			req, err := dhcpv4.NewDiscovery(mac, dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest))
+			require.NoError(t, err)
+			req.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionFQDN, append([]byte{tc.clientFlags, 0, 0}, tc.clientName...)))
+			resp, err := dhcpv4.NewReplyFromRequest(req,
+				dhcpv4.WithMessageType(dhcpv4.MessageTypeAck),
+				dhcpv4.WithYourIP(net.IPv4(10, 0, 0, 10)),
+			)
+			require.NoError(t, err)
+
+			err = m.Handle4(handlers.DHCPv4{DHCPv4: req}, handlers.DHCPv4{DHCPv4: resp}, func() error { return nil })
+			require.NoError(t, err)
+
+			data := resp.Options.Get(dhcpv4.OptionFQDN)
+			require.NotNil(t, data)
+			assert.Equal(t, tc.wantFlags, data[0])
+			assert.Equal(t, []byte{255, 255}, data[1:3])
+			assert.Equal(t, tc.wantName, data[3:])
+			assert.Equal(t, tc.wantUpdates, updater.updates)
+		})
+	}
+}
+
+func TestHandle4StopAndReply(t *testing.T) {
+	m := Module{Domain: "example.org"}
+	require.NoError(t, m.Provision(caddy.Context{}))
+	updater := &recordingUpdater{}
+	m.updater = updater
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest))
+	require.NoError(t, err)
+	req.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionFQDN, append([]byte{FlagS4, 0, 0}, "laptop"...)))
+	resp, err := dhcpv4.NewReplyFromRequest(req,
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeAck),
+		dhcpv4.WithYourIP(net.IPv4(10, 0, 0, 10)),
+	)
+	require.NoError(t, err)
+
+	// the update is still made when a later handler stops the chain, and the sentinel is passed on
+	err = m.Handle4(handlers.DHCPv4{DHCPv4: req}, handlers.DHCPv4{DHCPv4: resp}, func() error { return handlers.ErrStopAndReply })
+	assert.ErrorIs(t, err, handlers.ErrStopAndReply)
+	assert.Equal(t, []update{{"laptop.example.org", "10.0.0.10", true}}, updater.updates)
+}
+
+func TestHandle4HostName(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		module      Module
+		wantUpdates []update
+	}{
+		{"server performs both updates", Module{Domain: "example.org"}, []update{{"laptop.example.org", "10.0.0.10", true}}},
+		{"server refuses updates", Module{Domain: "example.org", Updates: UpdatesNone}, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := tc.module
+			require.NoError(t, m.Provision(caddy.Context{}))
+			updater := &recordingUpdater{}
+			m.updater = updater
+
+			req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+				dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+				dhcpv4.WithOption(dhcpv4.OptHostName("laptop")),
+			)
+			require.NoError(t, err)
+			resp, err := dhcpv4.NewReplyFromRequest(req,
+				dhcpv4.WithMessageType(dhcpv4.MessageTypeAck),
+				dhcpv4.WithYourIP(net.IPv4(10, 0, 0, 10)),
+			)
+			require.NoError(t, err)
+
+			err = m.Handle4(handlers.DHCPv4{DHCPv4: req}, handlers.DHCPv4{DHCPv4: resp}, func() error { return nil })
+			require.NoError(t, err)
+			// the client did not send the option, so it is not added to the reply
+			assert.Nil(t, resp.Options.Get(dhcpv4.OptionFQDN))
+			assert.Equal(t, tc.wantUpdates, updater.updates)
+		})
+	}
+}
+
+func TestHandle4Release(t *testing.T) {
+	m := Module{}
+	require.NoError(t, m.Provision(caddy.Context{}))
+	updater := &recordingUpdater{}
+	m.updater = updater
+
+	release, err := dhcpv4.New(
+		dhcpv4.WithHwAddr(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}),
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeRelease),
+		dhcpv4.WithClientIP(net.IPv4(10, 0, 0, 10)),
+	)
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(release)
+	require.NoError(t, err)
+
+	err = m.Handle4(handlers.DHCPv4{DHCPv4: release}, handlers.DHCPv4{DHCPv4: resp}, func() error { return nil })
+	require.NoError(t, err)
+	assert.Empty(t, updater.updates)
+	assert.Equal(t, []string{"10.0.0.10"}, updater.removed)
+}
+
+func TestHandle6(t *testing.T) {
+	m := &Module{Domain: "example.org", Updates: UpdatesServer}
+	require.NoError(t, m.Provision(caddy.Context{}))
+	updater := &recordingUpdater{}
+	m.updater = updater
+
+	req, err := dhcpv6.NewMessage(dhcpv6.WithClientID(&dhcpv6.DUIDLL{
+		HWType:        iana.HWTypeEthernet,
+		LinkLayerAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+	}))
+	require.NoError(t, err)
+	req.MessageType = dhcpv6.MessageTypeRequest
+	req.AddOption(&dhcpv6.OptFQDN{DomainName: &rfc1035label.Labels{Labels: []string{"laptop"}}})
+	resp, err := dhcpv6.NewReplyFromMessage(req)
+	require.NoError(t, err)
+	resp.AddOption(&dhcpv6.OptIANA{
+		Options: dhcpv6.IdentityOptions{Options: dhcpv6.Options{
+			&dhcpv6.OptIAAddress{IPv6Addr: net.ParseIP("2001:db8::10")},
+		}},
+	})
+
+	err = m.Handle6(handlers.DHCPv6{Message: req}, handlers.DHCPv6{Message: resp}, func() error { return nil })
+	require.NoError(t, err)
+
+	opt := resp.Options.FQDN()
+	require.NotNil(t, opt)
+	assert.Equal(t, FlagS6|FlagO6, opt.Flags)
+	assert.Equal(t, []string{"laptop.example.org"}, opt.DomainName.Labels)
+	assert.Equal(t, []update{{"laptop.example.org", "2001:db8::10", true}}, updater.updates)
+}
+
+func TestHandle6Release(t *testing.T) {
+	m := &Module{}
+	require.NoError(t, m.Provision(caddy.Context{}))
+	updater := &recordingUpdater{}
+	m.updater = updater
+
+	req, err := dhcpv6.NewMessage(dhcpv6.WithClientID(&dhcpv6.DUIDLL{
+		HWType:        iana.HWTypeEthernet,
+		LinkLayerAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+	}))
+	require.NoError(t, err)
+	req.MessageType = dhcpv6.MessageTypeRelease
+	req.AddOption(&dhcpv6.OptIANA{
+		Options: dhcpv6.IdentityOptions{Options: dhcpv6.Options{
+			&dhcpv6.OptIAAddress{IPv6Addr: net.ParseIP("2001:db8::10")},
+		}},
+	})
+	resp, err := dhcpv6.NewReplyFromMessage(req)
+	require.NoError(t, err)
+
+	err = m.Handle6(handlers.DHCPv6{Message: req}, handlers.DHCPv6{Message: resp}, func() error { return nil })
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2001:db8::10"}, updater.removed)
+}
+
+func TestParse4(t *testing.T) {
+	// partial name in wire format, without the terminating root label
+	flags, name, err := Parse4([]byte{FlagE4, 0, 0, 6, 'l', 'a', 'p', 't', 'o', 'p'})
+	require.NoError(t, err)
+	assert.Equal(t, FlagE4, flags)
+	assert.Equal(t, "laptop", name)
+
+	_, _, err = Parse4([]byte{FlagE4, 0})
+	assert.Error(t, err)
+	_, _, err = Parse4([]byte{FlagE4, 0, 0, 10, 'a'})
+	assert.Error(t, err)
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+// GratuitousARP announces the addresses assigned to DHCPv4 clients with a gratuitous ARP, which updates the
+// ARP caches and switch tables on the link of the client, and makes another host using the address notice the conflict.
+// Sending it requires the CAP_NET_RAW capability. If that is missing, a warning is logged once and no more
+// announcements are sent, so the handlers keep working without it.
+type GratuitousARP struct {
+	// Send sends a gratuitous ARP for the given address of the client with the given hardware address.
+	Send func(ip net.IP, mac net.HardwareAddr) error
+
+	logger   *zap.Logger
+	disabled atomic.Bool
+}
+
+// NewGratuitousARP returns a GratuitousARP sending its announcements using SendGratuitousARP.
+func NewGratuitousARP(logger *zap.Logger) *GratuitousARP {
+	return &GratuitousARP{Send: SendGratuitousARP, logger: logger}
+}
+
+// Next continues the chain, and then announces the address of the reply if it is an Ack assigning an address.
+// It only continues the chain if g is nil, so handlers can call it whether announcements are enabled or not.
+func (g *GratuitousARP) Next(req, resp DHCPv4, next func() error) error {
+	err := next()
+	if g == nil || (err != nil && !errors.Is(err, ErrStopAndReply)) {
+		return err
+	}
+	ip := resp.YourIPAddr
+	if resp.MessageType() != dhcpv4.MessageTypeAck || ip == nil || ip.IsUnspecified() || g.disabled.Load() {
+		return err
+	}
+	if sendErr := g.Send(ip, req.ClientHWAddr); sendErr != nil {
+		if errors.Is(sendErr, unix.EPERM) {
+			if !g.disabled.Swap(true) {
+				g.logger.Warn("not permitted to send gratuitous ARPs, disabling them", zap.Error(sendErr))
+			}
+		} else {
+			g.logger.Warn("failed to send gratuitous ARP", zap.Stringer("ip", ip), zap.Error(sendErr))
+		}
+	}
+	return err
+}
+
+// SendGratuitousARP broadcasts a gratuitous ARP request for the IPv4 address of the client with the given
+// hardware address, on the interface of this host with a subnet containing the address.
+func SendGratuitousARP(ip net.IP, mac net.HardwareAddr) error {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return fmt.Errorf("not an IPv4 address: %s", ip)
+	}
+	iface, err := interfaceFor(ip4)
+	if err != nil {
+		return err
+	}
+
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, int(htons(unix.ETH_P_ARP)))
+	if err != nil {
+		return fmt.Errorf("failed to open socket: %w", err)
+	}
+	defer unix.Close(fd)
+
+	addr := &unix.SockaddrLinklayer{
+		Protocol: htons(unix.ETH_P_ARP),
+		Ifindex:  iface.Index,
+		Halen:    uint8(len(iface.HardwareAddr)),
+	}
+	for i := range iface.HardwareAddr {
+		addr.Addr[i] = 0xff
+	}
+	if err := unix.Sendto(fd, gratuitousARP(ip4, mac), 0, addr); err != nil {
+		return fmt.Errorf("failed to send gratuitous ARP on %s: %w", iface.Name, err)
+	}
+	return nil
+}
+
+// gratuitousARP returns an ARP request for ip from the host with the given hardware address,
+// which has ip as both the sender and the target protocol address (RFC 5227 section 3).
+func gratuitousARP(ip net.IP, mac net.HardwareAddr) []byte {
+	b := make([]byte, 8, 8+2*len(mac)+2*net.IPv4len)
+	binary.BigEndian.PutUint16(b[0:], 1) // Ethernet
+	binary.BigEndian.PutUint16(b[2:], unix.ETH_P_IP)
+	b[4] = byte(len(mac))
+	b[5] = net.IPv4len
+	binary.BigEndian.PutUint16(b[6:], 1) // request
+	b = append(b, mac...)
+	b = append(b, ip.To4()...)
+	b = append(b, make([]byte, len(mac))...)
+	return append(b, ip.To4()...)
+}
+
+// interfaceFor returns the interface that is up with an IPv4 subnet containing ip.
+func interfaceFor(ip net.IP) (*net.Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 || len(iface.HardwareAddr) == 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil && ipNet.Contains(ip) {
+				return &iface, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no interface with a subnet containing %s", ip)
+}
+
+// htons converts a short from host to network byte order.
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
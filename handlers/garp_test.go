@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"golang.org/x/sys/unix"
+)
+
+func TestGratuitousARPPacket(t *testing.T) {
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	assert.Equal(t, []byte{
+		0x00, 0x01, 0x08, 0x00, 6, 4, 0x00, 0x01,
+		0x02, 0, 0, 0, 0, 1, 10, 0, 0, 10,
+		0, 0, 0, 0, 0, 0, 10, 0, 0, 10,
+	}, gratuitousARP(net.IPv4(10, 0, 0, 10), mac))
+}
+
+func TestGratuitousARPNext(t *testing.T) {
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	type sent struct {
+		ip  net.IP
+		mac net.HardwareAddr
+	}
+	handle := func(g *GratuitousARP, mt dhcpv4.MessageType, chainErr error) error {
+		req, err := dhcpv4.NewDiscovery(mac)
+		require.NoError(t, err)
+		resp, err := dhcpv4.NewReplyFromRequest(req, dhcpv4.WithMessageType(mt), dhcpv4.WithYourIP(net.IPv4(10, 0, 0, 10)))
+		require.NoError(t, err)
+		return g.Next(DHCPv4{DHCPv4: req}, DHCPv4{DHCPv4: resp}, func() error { return chainErr })
+	}
+
+	var announced []sent
+	g := NewGratuitousARP(zap.NewNop())
+	g.Send = func(ip net.IP, mac net.HardwareAddr) error {
+		announced = append(announced, sent{ip, mac})
+		return nil
+	}
+
+	// only an Ack assigns the address
+	require.NoError(t, handle(g, dhcpv4.MessageTypeOffer, nil))
+	assert.Empty(t, announced)
+	require.NoError(t, handle(g, dhcpv4.MessageTypeAck, nil))
+	assert.Equal(t, []sent{{net.IPv4(10, 0, 0, 10), mac}}, announced)
+	assert.ErrorIs(t, handle(g, dhcpv4.MessageTypeAck, ErrStopAndReply), ErrStopAndReply)
+	assert.Len(t, announced, 2)
+	assert.ErrorIs(t, handle(g, dhcpv4.MessageTypeAck, ErrDrop), ErrDrop)
+	assert.Len(t, announced, 2)
+
+	// without a GratuitousARP the chain just continues
+	chainErr := errors.New("boom")
+	assert.ErrorIs(t, handle(nil, dhcpv4.MessageTypeAck, chainErr), chainErr)
+}
+
+func TestGratuitousARPNotPermitted(t *testing.T) {
+	core, logs := observer.New(zapcore.WarnLevel)
+	g := NewGratuitousARP(zap.New(core))
+	calls := 0
+	g.Send = func(net.IP, net.HardwareAddr) error {
+		calls++
+		return unix.EPERM
+	}
+
+	for i := 0; i < 3; i++ {
+		req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+		require.NoError(t, err)
+		resp, err := dhcpv4.NewReplyFromRequest(req, dhcpv4.WithMessageType(dhcpv4.MessageTypeAck), dhcpv4.WithYourIP(net.IPv4(10, 0, 0, 10)))
+		require.NoError(t, err)
+		require.NoError(t, g.Next(DHCPv4{DHCPv4: req}, DHCPv4{DHCPv4: resp}, func() error { return nil }))
+	}
+	// the replies are still sent, but announcements are disabled after the first failure
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, 1, logs.Len())
+}
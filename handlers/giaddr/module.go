@@ -0,0 +1,114 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package giaddr
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/lion7/caddydhcp/handlers"
+	"go.uber.org/zap"
+)
+
+// Module branches the handler chain on the subnet of a DHCPv4 client, as identified by the relay agent
+// that forwarded its request (see handlers.DHCPv4.LinkAddress). The handlers of the first route matching
+// the request are run, after which the chain continues with the handlers following this module.
+// When no route matches, the chain just continues.
+//
+// This allows e.g. the router, dns and netmask handlers to hand out different values per subnet:
+//
+//	{
+//	  "handler": "giaddr",
+//	  "routes": [
+//	    {"subnets": ["10.1.0.0/24"], "handle": [{"handler": "router", "routers": ["10.1.0.1"]}]},
+//	    {"subnets": ["10.2.0.0/24"], "handle": [{"handler": "router", "routers": ["10.2.0.1"]}]}
+//	  ]
+//	}
+type Module struct {
+	handlers.Base
+
+	// The routes, of which the first match is taken.
+	Routes []Route `json:"routes,omitempty"`
+
+	logger *zap.Logger
+}
+
+// Route runs a list of handlers for the clients on a set of subnets.
+type Route struct {
+	// The subnets in CIDR notation matched against the link address of the request.
+	// A route without subnets matches the requests that were not relayed.
+	Subnets []string `json:"subnets,omitempty"`
+
+	// The handlers to run for a matching request.
+	HandlersRaw []json.RawMessage `json:"handle,omitempty" caddy:"namespace=dhcp.handlers inline_key=handler"`
+
+	subnets []*net.IPNet
+	chain   handlers.Chain
+}
+
+// CaddyModule returns the Caddy module information.
+func (Module) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "dhcp.handlers.giaddr",
+		New: func() caddy.Module { return new(Module) },
+	}
+}
+
+// Provision is run immediately after this handler is being loaded.
+func (m *Module) Provision(ctx caddy.Context) error {
+	m.logger = ctx.Logger()
+	for i := range m.Routes {
+		r := &m.Routes[i]
+		r.subnets = nil
+		for _, s := range r.Subnets {
+			_, subnet, err := net.ParseCIDR(s)
+			if err != nil || subnet.IP.To4() == nil {
+				return fmt.Errorf("route %d: invalid IPv4 subnet %q", i, s)
+			}
+			r.subnets = append(r.subnets, subnet)
+		}
+
+		mods, err := handlers.LoadModule(ctx, r, "HandlersRaw")
+		if err != nil {
+			return fmt.Errorf("route %d: loading handler modules: %v", i, err)
+		}
+		if r.chain, err = handlers.NewChain(mods); err != nil {
+			return fmt.Errorf("route %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// Handle4 handles DHCPv4 packets for this plugin.
+func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
+	link := req.LinkAddress()
+	for i := range m.Routes {
+		if m.Routes[i].matches(link) {
+			m.logger.Debug("request matches route", zap.Int("route", i), zap.Stringer("link", link))
+			return m.Routes[i].chain.Handle4(req, resp, next)
+		}
+	}
+	return next()
+}
+
+// matches reports whether the link address of a request is on one of the subnets of the route.
+func (r *Route) matches(link net.IP) bool {
+	if len(r.subnets) == 0 {
+		return link == nil
+	}
+	for _, subnet := range r.subnets {
+		if link != nil && subnet.Contains(link) {
+			return true
+		}
+	}
+	return false
+}
+
+// Interfaces guards
+var (
+	_ handlers.HandlerModule = (*Module)(nil)
+)
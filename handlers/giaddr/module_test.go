@@ -0,0 +1,71 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package giaddr
+
+import (
+	"net"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/lion7/caddydhcp/handlers/handlertest"
+	"github.com/lion7/caddydhcp/handlers/router"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandle4(t *testing.T) {
+	m := handlertest.Provision(t, &Module{Routes: []Route{
+		{Subnets: []string{"10.1.0.0/24"}},
+		{Subnets: []string{"10.2.0.0/24", "10.3.0.0/24"}},
+		{},
+	}})
+	m.Routes[0].chain = handlers.Chain{handlertest.Provision(t, &router.Module{Routers: []string{"10.1.0.1"}})}
+	m.Routes[1].chain = handlers.Chain{handlertest.Provision(t, &router.Module{Routers: []string{"10.2.0.1"}})}
+	m.Routes[2].chain = handlers.Chain{handlertest.Provision(t, &router.Module{Routers: []string{"192.168.0.1"}})}
+
+	for _, tc := range []struct {
+		name   string
+		modify dhcpv4.Modifier
+		router string
+	}{
+		{"first subnet", dhcpv4.WithGatewayIP(net.IPv4(10, 1, 0, 254)), "10.1.0.1"},
+		{"second subnet", dhcpv4.WithGatewayIP(net.IPv4(10, 2, 0, 254)), "10.2.0.1"},
+		{"second subnet alternative", dhcpv4.WithGatewayIP(net.IPv4(10, 3, 0, 254)), "10.2.0.1"},
+		{"not relayed", func(*dhcpv4.DHCPv4) {}, "192.168.0.1"},
+		{"unknown subnet", dhcpv4.WithGatewayIP(net.IPv4(10, 9, 0, 254)), ""},
+		{"subnet selection", func(d *dhcpv4.DHCPv4) {
+			d.GatewayIPAddr = net.IPv4(10, 1, 0, 254)
+			d.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionSubnetSelection, net.IPv4(10, 2, 0, 0).To4()))
+		}, "10.2.0.1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, tc.modify)
+			require.NoError(t, err)
+
+			called := false
+			resp, err := handlertest.Handle4(t, m, handlers.NewDHCPv4(req), func(*dhcpv4.DHCPv4) error {
+				called = true
+				return nil
+			})
+			require.NoError(t, err)
+			assert.True(t, called, "the chain continues after the route")
+			if tc.router == "" {
+				assert.Empty(t, resp.Router())
+				return
+			}
+			require.Len(t, resp.Router(), 1)
+			assert.Equal(t, tc.router, resp.Router()[0].String())
+		})
+	}
+}
+
+func TestProvisionInvalid(t *testing.T) {
+	for _, subnet := range []string{"10.1.0.0", "2001:db8::/64"} {
+		m := &Module{Routes: []Route{{Subnets: []string{subnet}}}}
+		assert.Error(t, m.Provision(caddy.Context{}), subnet)
+	}
+}
@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"fmt"
+
 	"github.com/caddyserver/caddy/v2"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
@@ -8,10 +10,44 @@ import (
 
 type DHCPv4 struct {
 	*dhcpv4.DHCPv4
+
+	// state is shared by all copies of the wrapper as the request passes through the chain
+	state *requestState
 }
 
 type DHCPv6 struct {
 	*dhcpv6.Message
+
+	// state is shared by all copies of the wrapper as the request passes through the chain
+	state *requestState
+}
+
+// requestState holds what the handlers learned about a request, for the handlers later in the chain.
+type requestState struct {
+	class string
+
+	// requested4 is the parsed Parameter Request List of a DHCPv4 request
+	requested4 *optionSet4
+
+	// relay is the outermost relay-forward message wrapping a relayed DHCPv6 request
+	relay *dhcpv6.RelayMessage
+}
+
+// NewDHCPv4 wraps a DHCPv4 request, so the handlers in the chain can share state about it, such as its class.
+// It parses the Parameter Request List of the request once for all handlers, see IsOptionRequested.
+func NewDHCPv4(m *dhcpv4.DHCPv4) DHCPv4 {
+	return DHCPv4{DHCPv4: m, state: &requestState{requested4: newOptionSet4(m)}}
+}
+
+// NewDHCPv6 wraps a DHCPv6 request, so the handlers in the chain can share state about it, such as its class.
+func NewDHCPv6(m *dhcpv6.Message) DHCPv6 {
+	return DHCPv6{Message: m, state: &requestState{}}
+}
+
+// NewRelayedDHCPv6 wraps a DHCPv6 request like NewDHCPv6, where relay is the outermost relay-forward message
+// that wraps it. This makes the relay information available to the handlers, e.g. using LinkAddress.
+func NewRelayedDHCPv6(relay *dhcpv6.RelayMessage, m *dhcpv6.Message) DHCPv6 {
+	return DHCPv6{Message: m, state: &requestState{relay: relay}}
 }
 
 // A Handler that responds to an DHCPv4 or DHCPv6 request.
@@ -25,11 +61,77 @@ type DHCPv6 struct {
 // handling. Return values should be propagated down the middleware chain
 // by returning it unchanged. Returned errors should not be re-wrapped
 // if they are already HandlerError values.
+//
+// A handler controls the rest of the chain with its return value:
+//   - calling next and returning its result continues the chain;
+//   - returning ErrStopAndReply without calling next stops the chain, and the reply
+//     as built so far is sent. Returning nil without calling next has the same effect,
+//     but ErrStopAndReply makes the intent explicit to the handlers earlier in the chain;
+//   - returning ErrDrop stops the chain and drops the request without sending a reply;
+//   - returning a HandlerError requesting a negative reply stops the chain and sends a DHCPNAK
+//     or a DHCPv6 status code instead of the reply.
+//
+// Any other error is logged as a failure and the request is dropped.
+// Middleware that post-process the reply after calling next should do so
+// when next returns ErrStopAndReply, and return it unchanged afterwards.
 type Handler interface {
 	Handle4(req, resp DHCPv4, next func() error) error
 	Handle6(req, resp DHCPv6, next func() error) error
 }
 
+// Chain is a Handler calling a list of handlers in order, like the handlers of a server.
+// The next handler passed to the chain is called after the last handler in the list,
+// so a Chain can be nested in another chain.
+type Chain []Handler
+
+// Handle4 calls the handlers of the chain for a DHCPv4 request.
+func (c Chain) Handle4(req, resp DHCPv4, next func() error) error {
+	if len(c) == 0 {
+		return next()
+	}
+	return c[0].Handle4(req, resp, func() error { return c[1:].Handle4(req, resp, next) })
+}
+
+// Handle6 calls the handlers of the chain for a DHCPv6 request.
+func (c Chain) Handle6(req, resp DHCPv6, next func() error) error {
+	if len(c) == 0 {
+		return next()
+	}
+	return c[0].Handle6(req, resp, func() error { return c[1:].Handle6(req, resp, next) })
+}
+
+// NewChain returns a Chain of the handler modules that LoadModule loaded for a list of handlers.
+// It fails if one of the modules is not a Handler.
+func NewChain(mods any) (Chain, error) {
+	list, ok := mods.([]any)
+	if !ok && mods != nil {
+		return nil, fmt.Errorf("unexpected handler modules of type %T", mods)
+	}
+	var c Chain
+	for i, mod := range list {
+		h, ok := mod.(Handler)
+		if !ok {
+			return nil, fmt.Errorf("handler %d: module of type %T is not a DHCP handler", i, mod)
+		}
+		c = append(c, h)
+	}
+	return c, nil
+}
+
+// Base implements the Handle4 and Handle6 methods of a Handler by just continuing the chain.
+// A handler that only serves one IP family embeds it, so it only implements the method of that family.
+type Base struct{}
+
+// Handle4 continues the chain with the next handler.
+func (Base) Handle4(_, _ DHCPv4, next func() error) error {
+	return next()
+}
+
+// Handle6 continues the chain with the next handler.
+func (Base) Handle6(_, _ DHCPv6, next func() error) error {
+	return next()
+}
+
 // A HandlerModule is a Handler that also implements
 // the caddy.Module and caddy.Provisioner interfaces.
 type HandlerModule interface {
@@ -41,4 +143,6 @@ type HandlerModule interface {
 // Interface guards
 var (
 	_ dhcpv6.DHCPv6 = (*DHCPv6)(nil)
+	_ Handler       = Chain(nil)
+	_ Handler       = Base{}
 )
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingHandler appends its name to calls before continuing the chain.
+type recordingHandler struct {
+	name  string
+	calls *[]string
+}
+
+func (h recordingHandler) Handle4(_, _ DHCPv4, next func() error) error {
+	*h.calls = append(*h.calls, h.name)
+	return next()
+}
+
+func (h recordingHandler) Handle6(_, _ DHCPv6, next func() error) error {
+	*h.calls = append(*h.calls, h.name)
+	return next()
+}
+
+func TestChain(t *testing.T) {
+	var calls []string
+	inner := Chain{recordingHandler{"b", &calls}, recordingHandler{"c", &calls}}
+	outer := Chain{recordingHandler{"a", &calls}, inner, recordingHandler{"d", &calls}}
+	next := func() error {
+		calls = append(calls, "next")
+		return nil
+	}
+
+	require.NoError(t, outer.Handle4(DHCPv4{}, DHCPv4{}, next))
+	assert.Equal(t, []string{"a", "b", "c", "d", "next"}, calls)
+
+	calls = nil
+	require.NoError(t, outer.Handle6(DHCPv6{}, DHCPv6{}, next))
+	assert.Equal(t, []string{"a", "b", "c", "d", "next"}, calls)
+
+	calls = nil
+	require.NoError(t, Chain(nil).Handle4(DHCPv4{}, DHCPv4{}, next))
+	assert.Equal(t, []string{"next"}, calls)
+}
+
+// handler4 only serves DHCPv4, relying on Base for DHCPv6.
+type handler4 struct {
+	Base
+	calls *[]string
+}
+
+func (h handler4) Handle4(_, _ DHCPv4, next func() error) error {
+	*h.calls = append(*h.calls, "handler4")
+	return next()
+}
+
+func TestBase(t *testing.T) {
+	var calls []string
+	chain := Chain{handler4{calls: &calls}, recordingHandler{name: "last", calls: &calls}}
+
+	require.NoError(t, chain.Handle4(DHCPv4{}, DHCPv4{}, func() error { return nil }))
+	assert.Equal(t, []string{"handler4", "last"}, calls)
+
+	// the embedded Handle6 just continues the chain
+	calls = nil
+	require.NoError(t, chain.Handle6(DHCPv6{}, DHCPv6{}, func() error { return nil }))
+	assert.Equal(t, []string{"last"}, calls)
+
+	// and returns the result of the rest of the chain
+	assert.ErrorIs(t, Base{}.Handle4(DHCPv4{}, DHCPv4{}, func() error { return ErrDrop }), ErrDrop)
+	assert.ErrorIs(t, Base{}.Handle6(DHCPv6{}, DHCPv6{}, func() error { return ErrDrop }), ErrDrop)
+}
+
+func TestNewChain(t *testing.T) {
+	var calls []string
+	chain, err := NewChain([]any{recordingHandler{name: "first", calls: &calls}, handler4{calls: &calls}})
+	require.NoError(t, err)
+	require.NoError(t, chain.Handle4(DHCPv4{}, DHCPv4{}, func() error { return nil }))
+	assert.Equal(t, []string{"first", "handler4"}, calls)
+
+	chain, err = NewChain(nil)
+	require.NoError(t, err)
+	assert.Empty(t, chain)
+
+	_, err = NewChain([]any{recordingHandler{name: "first", calls: &calls}, "not a handler"})
+	assert.ErrorContains(t, err, "handler 1")
+	_, err = NewChain(map[string]any{"first": recordingHandler{name: "first", calls: &calls}})
+	assert.Error(t, err)
+}
+
+func TestClass(t *testing.T) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+
+	// the class is shared by all copies of a wrapped request
+	wrapped := NewDHCPv4(req)
+	copied := wrapped
+	copied.SetClass("pxe")
+	assert.Equal(t, "pxe", wrapped.Class())
+
+	// a request that was not wrapped using NewDHCPv4 cannot be classified
+	bare := DHCPv4{DHCPv4: req}
+	bare.SetClass("pxe")
+	assert.Empty(t, bare.Class())
+
+	req6, err := dhcpv6.NewSolicit(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	wrapped6 := NewDHCPv6(req6)
+	copied6 := wrapped6
+	copied6.SetClass("pxe")
+	assert.Equal(t, "pxe", wrapped6.Class())
+	assert.Empty(t, DHCPv6{Message: req6}.Class())
+}
@@ -0,0 +1,62 @@
+// Package handlertest provides utilities for unit testing DHCP handlers.
+// It runs a single handler for a crafted request, without the server loop and the rest of the chain.
+package handlertest
+
+import (
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+
+	"github.com/lion7/caddydhcp/handlers"
+)
+
+// Provision provisions the module with an empty context and returns it. If the module is a caddy.CleanerUpper,
+// it is cleaned up when the test finishes.
+func Provision[M caddy.Provisioner](t testing.TB, m M) M {
+	t.Helper()
+	if err := m.Provision(caddy.Context{}); err != nil {
+		t.Fatalf("failed to provision module: %v", err)
+	}
+	if c, ok := any(m).(caddy.CleanerUpper); ok {
+		t.Cleanup(func() { _ = c.Cleanup() })
+	}
+	return m
+}
+
+// Handle4 runs the handler for the request with a reply created from it using the modifiers, and returns the reply
+// and the error returned by the handler. next is the rest of the chain, which may update the reply; nil does nothing.
+func Handle4(t testing.TB, h handlers.Handler, req handlers.DHCPv4, next func(resp *dhcpv4.DHCPv4) error, modifiers ...dhcpv4.Modifier) (*dhcpv4.DHCPv4, error) {
+	t.Helper()
+	resp, err := dhcpv4.NewReplyFromRequest(req.DHCPv4, modifiers...)
+	if err != nil {
+		t.Fatalf("failed to create DHCPv4 reply: %v", err)
+	}
+	return resp, h.Handle4(req, handlers.DHCPv4{DHCPv4: resp}, func() error {
+		if next == nil {
+			return nil
+		}
+		return next(resp)
+	})
+}
+
+// Handle6 behaves like Handle4 for a DHCPv6 request. The reply to a Solicit without the Rapid Commit option
+// is an Advertise, and a Reply otherwise.
+func Handle6(t testing.TB, h handlers.Handler, req handlers.DHCPv6, next func(resp *dhcpv6.Message) error, modifiers ...dhcpv6.Modifier) (*dhcpv6.Message, error) {
+	t.Helper()
+	newReply := dhcpv6.NewReplyFromMessage
+	if req.MessageType == dhcpv6.MessageTypeSolicit && req.GetOneOption(dhcpv6.OptionRapidCommit) == nil {
+		newReply = dhcpv6.NewAdvertiseFromSolicit
+	}
+	resp, err := newReply(req.Message, modifiers...)
+	if err != nil {
+		t.Fatalf("failed to create DHCPv6 reply: %v", err)
+	}
+	return resp, h.Handle6(req, handlers.DHCPv6{Message: resp}, func() error {
+		if next == nil {
+			return nil
+		}
+		return next(resp)
+	})
+}
@@ -0,0 +1,150 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package hostnamefromdns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/lion7/caddydhcp/handlers"
+	"go.uber.org/zap"
+)
+
+// Module sets the Host Name option (12) to the name found in the DNS PTR record of the assigned address,
+// if the client requested it and no other handler has set a host name already.
+// The lookup takes place after the rest of the chain has run, so this module
+// should be placed before the handlers that assign the addresses.
+// The results of the lookups, including the addresses without a PTR record, are cached.
+type Module struct {
+	handlers.Base
+
+	// The DNS server used for the lookups, as host:port. The port defaults to 53.
+	// Defaults to the resolver of the system.
+	Resolver string `json:"resolver,omitempty"`
+
+	// The timeout of a single lookup. Defaults to 1 second.
+	Timeout caddy.Duration `json:"timeout,omitempty"`
+
+	// How long the result of a lookup is cached. Defaults to 5 minutes.
+	CacheTTL caddy.Duration `json:"cacheTTL,omitempty"`
+
+	logger     *zap.Logger
+	lookupAddr func(ctx context.Context, addr string) ([]string, error)
+	mu         *sync.Mutex
+	cache      map[string]cacheEntry
+}
+
+// cacheEntry holds the result of a single lookup, where an empty name means that no PTR record exists.
+type cacheEntry struct {
+	name    string
+	expires time.Time
+}
+
+// CaddyModule returns the Caddy module information.
+func (Module) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "dhcp.handlers.hostnamefromdns",
+		New: func() caddy.Module { return new(Module) },
+	}
+}
+
+// Provision is run immediately after this handler is being loaded.
+func (m *Module) Provision(ctx caddy.Context) error {
+	m.logger = ctx.Logger()
+
+	if m.Timeout == 0 {
+		m.Timeout = caddy.Duration(time.Second)
+	}
+	if m.CacheTTL == 0 {
+		m.CacheTTL = caddy.Duration(5 * time.Minute)
+	}
+
+	resolver := net.DefaultResolver
+	if m.Resolver != "" {
+		if _, _, err := net.SplitHostPort(m.Resolver); err != nil {
+			m.Resolver = net.JoinHostPort(m.Resolver, "53")
+		}
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, m.Resolver)
+			},
+		}
+	}
+	m.lookupAddr = resolver.LookupAddr
+	m.mu = &sync.Mutex{}
+	m.cache = make(map[string]cacheEntry)
+	return nil
+}
+
+// Handle4 handles DHCPv4 packets for this plugin.
+func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
+	nextErr := next()
+	if nextErr != nil && !errors.Is(nextErr, handlers.ErrStopAndReply) {
+		return nextErr
+	}
+
+	if !req.IsOptionRequested(dhcpv4.OptionHostName) || resp.Options.Has(dhcpv4.OptionHostName) {
+		return nextErr
+	}
+	if resp.YourIPAddr == nil || resp.YourIPAddr.IsUnspecified() {
+		return nextErr
+	}
+
+	name, err := m.lookup(resp.YourIPAddr)
+	if err != nil {
+		m.logger.Warn("reverse lookup failed", zap.Stringer("ip", resp.YourIPAddr), zap.Error(err))
+		return nextErr
+	}
+	if name == "" {
+		m.logger.Debug("no PTR record found", zap.Stringer("ip", resp.YourIPAddr))
+		return nextErr
+	}
+	resp.UpdateOption(dhcpv4.OptHostName(name))
+	return nextErr
+}
+
+// lookup returns the name in the PTR record of ip, or an empty string if there is none.
+// Failed lookups, e.g. due to a timeout, are not cached.
+func (m *Module) lookup(ip net.IP) (string, error) {
+	key := ip.String()
+	now := time.Now()
+
+	m.mu.Lock()
+	entry, ok := m.cache[key]
+	m.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.name, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(m.Timeout))
+	defer cancel()
+	names, err := m.lookupAddr(ctx, key)
+	var dnsErr *net.DNSError
+	if err != nil && !(errors.As(err, &dnsErr) && dnsErr.IsNotFound) {
+		return "", err
+	}
+	entry = cacheEntry{expires: now.Add(time.Duration(m.CacheTTL))}
+	if len(names) > 0 {
+		entry.name = strings.TrimSuffix(names[0], ".")
+	}
+
+	m.mu.Lock()
+	m.cache[key] = entry
+	m.mu.Unlock()
+	return entry.name, nil
+}
+
+// Interfaces guards
+var (
+	_ handlers.HandlerModule = (*Module)(nil)
+)
@@ -0,0 +1,131 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package hostnamefromdns
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/lion7/caddydhcp/handlers/handlertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockResolver answers reverse lookups from a fixed set of records and counts the lookups.
+type mockResolver struct {
+	records map[string]string
+	delay   time.Duration
+	lookups int
+}
+
+func (r *mockResolver) lookupAddr(ctx context.Context, addr string) ([]string, error) {
+	r.lookups++
+	select {
+	case <-time.After(r.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	name, ok := r.records[addr]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+	}
+	return []string{name}, nil
+}
+
+// offer4 runs the module for a DHCPv4 request, where the rest of the chain offers the given address.
+func offer4(t *testing.T, m *Module, yiaddr net.IP, requested bool) *dhcpv4.DHCPv4 {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	if requested {
+		req.UpdateOption(dhcpv4.OptParameterRequestList(dhcpv4.OptionHostName))
+	}
+	resp, err := handlertest.Handle4(t, m, handlers.NewDHCPv4(req), func(resp *dhcpv4.DHCPv4) error {
+		resp.YourIPAddr = yiaddr
+		return nil
+	})
+	require.NoError(t, err)
+	return resp
+}
+
+func TestHandle4Hit(t *testing.T) {
+	r := &mockResolver{records: map[string]string{"10.0.0.1": "host1.example.com."}}
+	m := handlertest.Provision(t, &Module{Timeout: caddy.Duration(50 * time.Millisecond)})
+	m.lookupAddr = r.lookupAddr
+
+	resp := offer4(t, m, net.IPv4(10, 0, 0, 1), true)
+	assert.Equal(t, "host1.example.com", resp.HostName())
+
+	// the second lookup is served from the cache
+	resp = offer4(t, m, net.IPv4(10, 0, 0, 1), true)
+	assert.Equal(t, "host1.example.com", resp.HostName())
+	assert.Equal(t, 1, r.lookups)
+}
+
+func TestHandle4Miss(t *testing.T) {
+	r := &mockResolver{records: map[string]string{}}
+	m := handlertest.Provision(t, &Module{Timeout: caddy.Duration(50 * time.Millisecond)})
+	m.lookupAddr = r.lookupAddr
+
+	resp := offer4(t, m, net.IPv4(10, 0, 0, 2), true)
+	assert.False(t, resp.Options.Has(dhcpv4.OptionHostName))
+
+	// misses are cached as well
+	offer4(t, m, net.IPv4(10, 0, 0, 2), true)
+	assert.Equal(t, 1, r.lookups)
+}
+
+func TestHandle4Timeout(t *testing.T) {
+	r := &mockResolver{records: map[string]string{"10.0.0.3": "host3.example.com."}, delay: time.Second}
+	m := handlertest.Provision(t, &Module{Timeout: caddy.Duration(50 * time.Millisecond)})
+	m.lookupAddr = r.lookupAddr
+
+	start := time.Now()
+	resp := offer4(t, m, net.IPv4(10, 0, 0, 3), true)
+	assert.Less(t, time.Since(start), r.delay)
+	assert.False(t, resp.Options.Has(dhcpv4.OptionHostName))
+
+	// a timeout is not cached, so the next request tries again
+	r.delay = 0
+	resp = offer4(t, m, net.IPv4(10, 0, 0, 3), true)
+	assert.Equal(t, "host3.example.com", resp.HostName())
+	assert.Equal(t, 2, r.lookups)
+}
+
+func TestHandle4NotApplicable(t *testing.T) {
+	r := &mockResolver{records: map[string]string{"10.0.0.1": "host1.example.com."}}
+	m := handlertest.Provision(t, &Module{Timeout: caddy.Duration(50 * time.Millisecond)})
+	m.lookupAddr = r.lookupAddr
+
+	// not requested by the client
+	resp := offer4(t, m, net.IPv4(10, 0, 0, 1), false)
+	assert.False(t, resp.Options.Has(dhcpv4.OptionHostName))
+
+	// no address assigned
+	resp = offer4(t, m, nil, true)
+	assert.False(t, resp.Options.Has(dhcpv4.OptionHostName))
+	assert.Equal(t, 0, r.lookups)
+}
+
+func TestHandle4StopAndReply(t *testing.T) {
+	r := &mockResolver{records: map[string]string{"10.0.0.1": "host1.example.com."}}
+	m := handlertest.Provision(t, &Module{Timeout: caddy.Duration(50 * time.Millisecond)})
+	m.lookupAddr = r.lookupAddr
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	req.UpdateOption(dhcpv4.OptParameterRequestList(dhcpv4.OptionHostName))
+
+	// the host name is still set when a later handler stops the chain, and the sentinel is passed on
+	resp, err := handlertest.Handle4(t, m, handlers.NewDHCPv4(req), func(resp *dhcpv4.DHCPv4) error {
+		resp.YourIPAddr = net.IPv4(10, 0, 0, 1)
+		return handlers.ErrStopAndReply
+	})
+	assert.ErrorIs(t, err, handlers.ErrStopAndReply)
+	assert.Equal(t, "host1.example.com", resp.HostName())
+}
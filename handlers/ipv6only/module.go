@@ -24,6 +24,8 @@ import (
 // The optional argument is the V6ONLY_WAIT configuration variable,
 // described in RFC8925 section 3.2.
 type Module struct {
+	handlers.Base
+
 	Wait caddy.Duration `json:"wait,omitempty"`
 
 	logger *zap.Logger
@@ -49,11 +51,6 @@ func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
 	return next()
 }
 
-func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
-	// ipv6-only does not apply to DHCPv6, so just continue the chain
-	return next()
-}
-
 // Interfaces guards
 var (
 	_ handlers.HandlerModule = (*Module)(nil)
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// CheckJitter returns an error if percent is not a valid lease time jitter percentage, which is in [0, 100).
+func CheckJitter(percent int) error {
+	if percent < 0 || percent >= 100 {
+		return fmt.Errorf("invalid jitter %d%%, expected a percentage from 0 up to 100", percent)
+	}
+	return nil
+}
+
+// Jitter perturbs the lease time d by up to plus or minus the given percentage, so clients that got
+// the same lease time do not all renew at the same time. The perturbation is derived from the key
+// of the client, so a client is given the same lease time on every request.
+// Lease times of a second or longer are rounded to whole seconds.
+func Jitter(d time.Duration, percent int, key string) time.Duration {
+	if percent == 0 || d == 0 {
+		return d
+	}
+	// keys such as MAC addresses often only differ in their last bytes, so use a hash that mixes all bits well
+	sum := sha256.Sum256([]byte(key))
+	// map the hash onto [-1, 1] and scale it to the percentage
+	offset := 2*float64(binary.BigEndian.Uint64(sum[:8]))/math.MaxUint64 - 1
+	jittered := time.Duration(float64(d) * (1 + offset*float64(percent)/100))
+	if jittered >= time.Second {
+		jittered = jittered.Round(time.Second)
+	}
+	return jittered
+}
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJitter(t *testing.T) {
+	d := time.Hour
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("02:00:00:00:00:%02x", i)
+		jittered := Jitter(d, 10, key)
+		assert.GreaterOrEqual(t, jittered, 54*time.Minute)
+		assert.LessOrEqual(t, jittered, 66*time.Minute)
+		assert.Equal(t, jittered, Jitter(d, 10, key), "the lease time is stable for a client")
+		seen[jittered] = true
+	}
+	assert.Greater(t, len(seen), 50, "the lease times are spread out")
+
+	assert.Equal(t, d, Jitter(d, 0, "02:00:00:00:00:01"))
+}
+
+func TestCheckJitter(t *testing.T) {
+	assert.NoError(t, CheckJitter(0))
+	assert.NoError(t, CheckJitter(99))
+	assert.Error(t, CheckJitter(-1))
+	assert.Error(t, CheckJitter(100))
+}
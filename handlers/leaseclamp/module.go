@@ -0,0 +1,122 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package leaseclamp
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/lion7/caddydhcp/handlers"
+	"go.uber.org/zap"
+)
+
+// Module enforces a global guardrail on the lease times handed out by the other handlers.
+// The lease time of a DHCPv4 reply (option 51) and the lifetimes of the addresses and prefixes
+// in a DHCPv6 reply are clamped into the range [min, max]. A zero min or max disables that bound.
+//
+// When a DHCPv4 Offer or Ack does not carry a lease time at all and a default is configured,
+// the default is added to the reply.
+//
+// The clamping takes place after the rest of the chain has run, so this module
+// should be placed before any handler whose lease times it should clamp.
+type Module struct {
+	Min     caddy.Duration `json:"min,omitempty"`
+	Max     caddy.Duration `json:"max,omitempty"`
+	Default caddy.Duration `json:"default,omitempty"`
+
+	logger *zap.Logger
+}
+
+// CaddyModule returns the Caddy module information.
+func (Module) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "dhcp.handlers.leaseclamp",
+		New: func() caddy.Module { return new(Module) },
+	}
+}
+
+// Provision is run immediately after this handler is being loaded.
+func (m *Module) Provision(ctx caddy.Context) error {
+	m.logger = ctx.Logger()
+	if m.Min < 0 || m.Max < 0 || m.Default < 0 {
+		return fmt.Errorf("lease times must not be negative")
+	}
+	if m.Max != 0 && m.Min > m.Max {
+		return fmt.Errorf("minimum lease time %v is larger than the maximum lease time %v", time.Duration(m.Min), time.Duration(m.Max))
+	}
+	return nil
+}
+
+// Handle4 handles DHCPv4 packets for this plugin.
+func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
+	nextErr := next()
+	if nextErr != nil && !errors.Is(nextErr, handlers.ErrStopAndReply) {
+		return nextErr
+	}
+
+	if resp.Options.Has(dhcpv4.OptionIPAddressLeaseTime) {
+		leaseTime := resp.IPAddressLeaseTime(0)
+		if clamped := m.clamp(leaseTime); clamped != leaseTime {
+			m.logger.Debug("clamped lease time", zap.Duration("leaseTime", leaseTime), zap.Duration("clamped", clamped))
+			resp.UpdateOption(dhcpv4.OptIPAddressLeaseTime(clamped))
+		}
+		return nextErr
+	}
+
+	mt := resp.MessageType()
+	if m.Default != 0 && (mt == dhcpv4.MessageTypeOffer || mt == dhcpv4.MessageTypeAck) {
+		resp.UpdateOption(dhcpv4.OptIPAddressLeaseTime(m.clamp(time.Duration(m.Default))))
+	}
+	return nextErr
+}
+
+// Handle6 handles DHCPv6 packets for this plugin.
+func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
+	nextErr := next()
+	if nextErr != nil && !errors.Is(nextErr, handlers.ErrStopAndReply) {
+		return nextErr
+	}
+
+	for _, iana := range resp.Options.IANA() {
+		for _, addr := range iana.Options.Addresses() {
+			addr.PreferredLifetime = m.clampLifetime(addr.PreferredLifetime)
+			addr.ValidLifetime = m.clampLifetime(addr.ValidLifetime)
+		}
+	}
+	for _, iapd := range resp.Options.IAPD() {
+		for _, prefix := range iapd.Options.Prefixes() {
+			prefix.PreferredLifetime = m.clampLifetime(prefix.PreferredLifetime)
+			prefix.ValidLifetime = m.clampLifetime(prefix.ValidLifetime)
+		}
+	}
+	return nextErr
+}
+
+func (m *Module) clamp(d time.Duration) time.Duration {
+	if m.Min != 0 && d < time.Duration(m.Min) {
+		return time.Duration(m.Min)
+	}
+	if m.Max != 0 && d > time.Duration(m.Max) {
+		return time.Duration(m.Max)
+	}
+	return d
+}
+
+// clampLifetime clamps a DHCPv6 lifetime, leaving a zero lifetime untouched
+// since that indicates the address or prefix is no longer valid.
+func (m *Module) clampLifetime(d time.Duration) time.Duration {
+	if d == 0 {
+		return d
+	}
+	return m.clamp(d)
+}
+
+// Interfaces guards
+var (
+	_ handlers.HandlerModule = (*Module)(nil)
+)
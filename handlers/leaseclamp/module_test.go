@@ -0,0 +1,109 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package leaseclamp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/lion7/caddydhcp/handlers/handlertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// offer4 runs the module for a DHCPv4 offer, where the rest of the chain sets the lease time unless it is zero.
+func offer4(t *testing.T, m *Module, leaseTime time.Duration) *dhcpv4.DHCPv4 {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	resp, err := handlertest.Handle4(t, m, handlers.NewDHCPv4(req), func(resp *dhcpv4.DHCPv4) error {
+		if leaseTime != 0 {
+			resp.UpdateOption(dhcpv4.OptIPAddressLeaseTime(leaseTime))
+		}
+		return nil
+	}, dhcpv4.WithMessageType(dhcpv4.MessageTypeOffer))
+	require.NoError(t, err)
+	return resp
+}
+
+func TestClamp4(t *testing.T) {
+	m := handlertest.Provision(t, &Module{Min: caddy.Duration(time.Hour), Max: caddy.Duration(24 * time.Hour)})
+
+	resp := offer4(t, m, 48*time.Hour)
+	assert.Equal(t, 24*time.Hour, resp.IPAddressLeaseTime(0), "lease time above max")
+
+	resp = offer4(t, m, time.Minute)
+	assert.Equal(t, time.Hour, resp.IPAddressLeaseTime(0), "lease time below min")
+
+	resp = offer4(t, m, 2*time.Hour)
+	assert.Equal(t, 2*time.Hour, resp.IPAddressLeaseTime(0), "lease time within bounds")
+
+	resp = offer4(t, m, 0)
+	assert.False(t, resp.Options.Has(dhcpv4.OptionIPAddressLeaseTime), "no default configured")
+}
+
+func TestDefault4(t *testing.T) {
+	m := handlertest.Provision(t, &Module{Min: caddy.Duration(time.Hour), Max: caddy.Duration(24 * time.Hour), Default: caddy.Duration(12 * time.Hour)})
+
+	resp := offer4(t, m, 0)
+	assert.Equal(t, 12*time.Hour, resp.IPAddressLeaseTime(0), "default inserted")
+
+	resp = offer4(t, m, 2*time.Hour)
+	assert.Equal(t, 2*time.Hour, resp.IPAddressLeaseTime(0), "default not applied when a lease time is present")
+}
+
+func TestClamp4StopAndReply(t *testing.T) {
+	m := handlertest.Provision(t, &Module{Min: caddy.Duration(time.Hour), Max: caddy.Duration(24 * time.Hour)})
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	next := func(resp *dhcpv4.DHCPv4) error {
+		resp.UpdateOption(dhcpv4.OptIPAddressLeaseTime(48 * time.Hour))
+		return handlers.ErrStopAndReply
+	}
+
+	// the reply is still clamped when a later handler stops the chain, and the sentinel is passed on
+	resp, err := handlertest.Handle4(t, m, handlers.NewDHCPv4(req), next, dhcpv4.WithMessageType(dhcpv4.MessageTypeOffer))
+	assert.ErrorIs(t, err, handlers.ErrStopAndReply)
+	assert.Equal(t, 24*time.Hour, resp.IPAddressLeaseTime(0))
+}
+
+func TestClamp6(t *testing.T) {
+	m := handlertest.Provision(t, &Module{Min: caddy.Duration(time.Hour), Max: caddy.Duration(24 * time.Hour)})
+
+	req, err := dhcpv6.NewMessage(dhcpv6.WithClientID(&dhcpv6.DUIDLL{
+		HWType:        iana.HWTypeEthernet,
+		LinkLayerAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+	}))
+	require.NoError(t, err)
+	req.MessageType = dhcpv6.MessageTypeRequest
+
+	addr := &dhcpv6.OptIAAddress{
+		IPv6Addr:          net.ParseIP("2001:db8::1"),
+		PreferredLifetime: time.Minute,
+		ValidLifetime:     48 * time.Hour,
+	}
+	released := &dhcpv6.OptIAAddress{
+		IPv6Addr: net.ParseIP("2001:db8::2"),
+	}
+	_, err = handlertest.Handle6(t, m, handlers.NewDHCPv6(req), func(resp *dhcpv6.Message) error {
+		resp.AddOption(&dhcpv6.OptIANA{Options: dhcpv6.IdentityOptions{Options: []dhcpv6.Option{addr, released}}})
+		return nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, time.Hour, addr.PreferredLifetime)
+	assert.Equal(t, 24*time.Hour, addr.ValidLifetime)
+	assert.Equal(t, time.Duration(0), released.ValidLifetime, "zero lifetimes are left untouched")
+}
+
+func TestProvisionInvalid(t *testing.T) {
+	m := &Module{Min: caddy.Duration(time.Hour), Max: caddy.Duration(time.Minute)}
+	assert.Error(t, m.Provision(caddy.Context{}))
+}
@@ -5,6 +5,7 @@
 package leasetime
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/caddyserver/caddy/v2"
@@ -14,7 +15,21 @@ import (
 )
 
 type Module struct {
+	handlers.Base
+
 	Time caddy.Duration `json:"time"`
+	// Jitter perturbs the lease time of every client by up to plus or minus this percentage,
+	// so clients do not all renew at the same time. A client always gets the same lease time. Disabled by default.
+	Jitter int `json:"jitter,omitempty"`
+	// Max enables honoring the lease time requested by the client (option 51 in its request),
+	// up to this maximum. Clients that do not request a lease time still get the configured time.
+	// Disabled by default, which always gives the configured time.
+	Max caddy.Duration `json:"max,omitempty"`
+	// Min is the shortest requested lease time that is honored, shorter requests get this minimum instead.
+	Min caddy.Duration `json:"min,omitempty"`
+	// Classes overrides the configured time for requests of these classes, as set by e.g. the classifier
+	// from the vendor class (option 60) or user class (option 77), like a short lease for `guest` clients.
+	Classes map[string]caddy.Duration `json:"classes,omitempty"`
 
 	logger *zap.Logger
 }
@@ -29,19 +44,43 @@ func (Module) CaddyModule() caddy.ModuleInfo {
 
 func (m *Module) Provision(ctx caddy.Context) error {
 	m.logger = ctx.Logger()
-	return nil
+	if m.Min < 0 || m.Max < 0 {
+		return fmt.Errorf("lease times must not be negative")
+	}
+	if m.Min != 0 && m.Max == 0 {
+		return fmt.Errorf("a minimum lease time requires a maximum lease time")
+	}
+	if m.Min > m.Max {
+		return fmt.Errorf("minimum lease time %v is larger than the maximum lease time %v", time.Duration(m.Min), time.Duration(m.Max))
+	}
+	for class, d := range m.Classes {
+		if d <= 0 {
+			return fmt.Errorf("lease time %v of class %q must be positive", time.Duration(d), class)
+		}
+	}
+	return handlers.CheckJitter(m.Jitter)
 }
 
 func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
-	if req.OpCode != dhcpv4.OpcodeBootRequest && req.IsOptionRequested(dhcpv4.OptionIPAddressLeaseTime) {
-		resp.UpdateOption(dhcpv4.OptIPAddressLeaseTime(time.Duration(m.Time)))
+	mt := resp.MessageType()
+	if (mt == dhcpv4.MessageTypeOffer || mt == dhcpv4.MessageTypeAck) && req.IsOptionRequested(dhcpv4.OptionIPAddressLeaseTime) {
+		resp.UpdateOption(dhcpv4.OptIPAddressLeaseTime(m.leaseTime(req)))
 	}
 	return next()
 }
 
-func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
-	// lease time does not apply to DHCPv6, so just continue the chain
-	return next()
+// leaseTime returns the lease time for the client, which is the requested lease time clamped into [min, max]
+// if the client requested one and max is set, and the configured time of its class or else the configured time otherwise.
+func (m *Module) leaseTime(req handlers.DHCPv4) time.Duration {
+	if m.Max != 0 && req.Options.Has(dhcpv4.OptionIPAddressLeaseTime) {
+		requested := req.IPAddressLeaseTime(0)
+		return min(max(requested, time.Duration(m.Min)), time.Duration(m.Max))
+	}
+	leaseTime := m.Time
+	if d, ok := m.Classes[req.Class()]; ok && req.Class() != "" {
+		leaseTime = d
+	}
+	return handlers.Jitter(time.Duration(leaseTime), m.Jitter, req.ClientHWAddr.String())
 }
 
 // Interfaces guards
@@ -0,0 +1,157 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package leasetime
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandle4(t *testing.T) {
+	m := &Module{Time: caddy.Duration(time.Hour)}
+	require.NoError(t, m.Provision(caddy.Context{}))
+
+	discover, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	offer, err := dhcpv4.NewReplyFromRequest(discover, dhcpv4.WithMessageType(dhcpv4.MessageTypeOffer))
+	require.NoError(t, err)
+	request, err := dhcpv4.NewRequestFromOffer(offer)
+	require.NoError(t, err)
+	ack, err := dhcpv4.NewReplyFromRequest(request, dhcpv4.WithMessageType(dhcpv4.MessageTypeAck))
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name      string
+		req, resp *dhcpv4.DHCPv4
+	}{
+		{"discover", discover, offer},
+		{"request", request, ack},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, dhcpv4.OpcodeBootRequest, tc.req.OpCode)
+			tc.req.UpdateOption(dhcpv4.OptParameterRequestList(dhcpv4.OptionIPAddressLeaseTime))
+
+			err := m.Handle4(handlers.DHCPv4{DHCPv4: tc.req}, handlers.DHCPv4{DHCPv4: tc.resp}, func() error { return nil })
+			require.NoError(t, err)
+			assert.Equal(t, time.Hour, tc.resp.IPAddressLeaseTime(0))
+		})
+	}
+}
+
+func TestHandle4Jitter(t *testing.T) {
+	m := &Module{Time: caddy.Duration(time.Hour), Jitter: 10}
+	require.NoError(t, m.Provision(caddy.Context{}))
+
+	leaseTime := func(mac net.HardwareAddr) time.Duration {
+		req, err := dhcpv4.NewDiscovery(mac, dhcpv4.WithRequestedOptions(dhcpv4.OptionIPAddressLeaseTime))
+		require.NoError(t, err)
+		resp, err := dhcpv4.NewReplyFromRequest(req, dhcpv4.WithMessageType(dhcpv4.MessageTypeOffer))
+		require.NoError(t, err)
+		require.NoError(t, m.Handle4(handlers.DHCPv4{DHCPv4: req}, handlers.DHCPv4{DHCPv4: resp}, func() error { return nil }))
+		return resp.IPAddressLeaseTime(0)
+	}
+	for i := byte(0); i < 20; i++ {
+		mac := net.HardwareAddr{0x02, 0, 0, 0, 0, i}
+		lt := leaseTime(mac)
+		assert.GreaterOrEqual(t, lt, 54*time.Minute)
+		assert.LessOrEqual(t, lt, 66*time.Minute)
+		assert.Equal(t, lt, leaseTime(mac))
+	}
+
+	assert.Error(t, (&Module{Jitter: 100}).Provision(caddy.Context{}))
+}
+
+func TestHandle4Requested(t *testing.T) {
+	m := &Module{Time: caddy.Duration(time.Hour), Max: caddy.Duration(4 * time.Hour), Min: caddy.Duration(10 * time.Minute)}
+	require.NoError(t, m.Provision(caddy.Context{}))
+
+	for _, tc := range []struct {
+		name      string
+		requested time.Duration
+		want      time.Duration
+	}{
+		{"no request uses the default", 0, time.Hour},
+		{"below max", 2 * time.Hour, 2 * time.Hour},
+		{"above max", 24 * time.Hour, 4 * time.Hour},
+		{"below min", time.Minute, 10 * time.Minute},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			modifiers := []dhcpv4.Modifier{dhcpv4.WithRequestedOptions(dhcpv4.OptionIPAddressLeaseTime)}
+			if tc.requested != 0 {
+				modifiers = append(modifiers, dhcpv4.WithLeaseTime(uint32(tc.requested.Seconds())))
+			}
+			req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, modifiers...)
+			require.NoError(t, err)
+			resp, err := dhcpv4.NewReplyFromRequest(req, dhcpv4.WithMessageType(dhcpv4.MessageTypeOffer))
+			require.NoError(t, err)
+
+			require.NoError(t, m.Handle4(handlers.DHCPv4{DHCPv4: req}, handlers.DHCPv4{DHCPv4: resp}, func() error { return nil }))
+			assert.Equal(t, tc.want, resp.IPAddressLeaseTime(0))
+		})
+	}
+}
+
+func TestHandle4RequestedDisabled(t *testing.T) {
+	m := &Module{Time: caddy.Duration(time.Hour)}
+	require.NoError(t, m.Provision(caddy.Context{}))
+
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+		dhcpv4.WithRequestedOptions(dhcpv4.OptionIPAddressLeaseTime), dhcpv4.WithLeaseTime(7200))
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req, dhcpv4.WithMessageType(dhcpv4.MessageTypeOffer))
+	require.NoError(t, err)
+
+	require.NoError(t, m.Handle4(handlers.DHCPv4{DHCPv4: req}, handlers.DHCPv4{DHCPv4: resp}, func() error { return nil }))
+	assert.Equal(t, time.Hour, resp.IPAddressLeaseTime(0))
+}
+
+func TestProvisionRequested(t *testing.T) {
+	for _, m := range []*Module{
+		{Time: caddy.Duration(time.Hour), Max: caddy.Duration(-time.Hour)},
+		{Time: caddy.Duration(time.Hour), Min: caddy.Duration(time.Minute)},
+		{Time: caddy.Duration(time.Hour), Min: caddy.Duration(2 * time.Hour), Max: caddy.Duration(time.Hour)},
+	} {
+		assert.Error(t, m.Provision(caddy.Context{}))
+	}
+}
+
+func TestHandle4Classes(t *testing.T) {
+	m := &Module{Time: caddy.Duration(time.Hour), Classes: map[string]caddy.Duration{
+		"guest":  caddy.Duration(15 * time.Minute),
+		"server": caddy.Duration(7 * 24 * time.Hour),
+	}}
+	require.NoError(t, m.Provision(caddy.Context{}))
+
+	for _, tc := range []struct {
+		class string
+		want  time.Duration
+	}{
+		{"guest", 15 * time.Minute},
+		{"server", 7 * 24 * time.Hour},
+		{"printer", time.Hour},
+		{"", time.Hour},
+	} {
+		t.Run(tc.class, func(t *testing.T) {
+			req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, dhcpv4.WithRequestedOptions(dhcpv4.OptionIPAddressLeaseTime))
+			require.NoError(t, err)
+			resp, err := dhcpv4.NewReplyFromRequest(req, dhcpv4.WithMessageType(dhcpv4.MessageTypeOffer))
+			require.NoError(t, err)
+			hreq := handlers.NewDHCPv4(req)
+			hreq.SetClass(tc.class)
+
+			require.NoError(t, m.Handle4(hreq, handlers.DHCPv4{DHCPv4: resp}, func() error { return nil }))
+			assert.Equal(t, tc.want, resp.IPAddressLeaseTime(0))
+		})
+	}
+
+	assert.Error(t, (&Module{Time: caddy.Duration(time.Hour), Classes: map[string]caddy.Duration{"guest": 0}}).Provision(caddy.Context{}))
+}
@@ -0,0 +1,158 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package limitpool
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/lion7/caddydhcp/handlers"
+	"go.uber.org/zap"
+)
+
+// Module guards the pools of its handlers, like range and prefix, against being exhausted by new clients.
+// Once more than the high-water mark of a pool is in use, requests from clients without a lease in the pool
+// are dropped instead of being handed a new address or prefix, while clients with a lease can still renew it.
+// Clients that an earlier handler already gave a reserved address, e.g. the file or static handler, pass as well.
+//
+//	{
+//	  "handler": "limitpool",
+//	  "highWater": 90,
+//	  "handle": [{"handler": "range", "start": "10.0.0.100", "end": "10.0.0.199"}]
+//	}
+type Module struct {
+	// The percentage of a pool in use above which new clients are refused, between 1 and 100.
+	HighWater int `json:"highWater"`
+
+	// The handlers to run for an accepted request, which must include at least one pool.
+	HandlersRaw []json.RawMessage `json:"handle,omitempty" caddy:"namespace=dhcp.handlers inline_key=handler"`
+
+	chain  handlers.Chain
+	logger *zap.Logger
+}
+
+// Pool is implemented by the handlers that allocate from a pool, like range and prefix.
+type Pool interface {
+	// Available returns the number of addresses or prefixes in the pool that are not allocated.
+	Available() int
+	// Total returns the number of addresses or prefixes in the pool.
+	Total() int
+}
+
+// Pool4 is a Pool of DHCPv4 addresses, like the range handler.
+type Pool4 interface {
+	Pool
+	// HasLease4 reports whether the client of the request has a lease in the pool.
+	HasLease4(req handlers.DHCPv4) bool
+}
+
+// Pool6 is a Pool of DHCPv6 addresses or prefixes, like the prefix handler.
+type Pool6 interface {
+	Pool
+	// HasLease6 reports whether the client of the request has a lease in the pool.
+	HasLease6(req handlers.DHCPv6) bool
+}
+
+// CaddyModule returns the Caddy module information.
+func (Module) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "dhcp.handlers.limitpool",
+		New: func() caddy.Module { return new(Module) },
+	}
+}
+
+// Provision is run immediately after this handler is being loaded.
+func (m *Module) Provision(ctx caddy.Context) error {
+	m.logger = ctx.Logger()
+	if m.HighWater < 1 || m.HighWater > 100 {
+		return fmt.Errorf("invalid high-water mark %d%%, expected a percentage between 1 and 100", m.HighWater)
+	}
+
+	mods, err := handlers.LoadModule(ctx, m, "HandlersRaw")
+	if err != nil {
+		return fmt.Errorf("loading handler modules: %v", err)
+	}
+	if m.chain, err = handlers.NewChain(mods); err != nil {
+		return err
+	}
+	if !m.hasPools() {
+		return fmt.Errorf("no pool handlers configured, like range or prefix")
+	}
+	return nil
+}
+
+// hasPools reports whether any of the handlers is a pool.
+func (m *Module) hasPools() bool {
+	for _, h := range m.chain {
+		if _, ok := h.(Pool); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// full reports whether more than the high-water mark of the pool is in use.
+func (m *Module) full(p Pool) bool {
+	total := p.Total()
+	return total > 0 && (total-p.Available())*100 > m.HighWater*total
+}
+
+// Handle4 handles DHCPv4 packets for this plugin.
+func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
+	if resp.YourIPAddr != nil && !resp.YourIPAddr.IsUnspecified() {
+		return m.chain.Handle4(req, resp, next)
+	}
+	full := false
+	for _, h := range m.chain {
+		p, ok := h.(Pool4)
+		if !ok {
+			continue
+		}
+		if p.HasLease4(req) {
+			return m.chain.Handle4(req, resp, next)
+		}
+		full = full || m.full(p)
+	}
+	if full {
+		m.logger.Warn("pool is above its high-water mark, refusing new client", zap.Stringer("mac", req.ClientHWAddr))
+		return handlers.ErrDrop
+	}
+	return m.chain.Handle4(req, resp, next)
+}
+
+// Handle6 handles DHCPv6 packets for this plugin.
+func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
+	if req.Options.OneIANA() == nil && req.Options.OneIAPD() == nil {
+		// the request does not ask for addresses or prefixes
+		return m.chain.Handle6(req, resp, next)
+	}
+	for _, iana := range resp.Options.IANA() {
+		if len(iana.Options.Addresses()) > 0 {
+			return m.chain.Handle6(req, resp, next)
+		}
+	}
+	full := false
+	for _, h := range m.chain {
+		p, ok := h.(Pool6)
+		if !ok {
+			continue
+		}
+		if p.HasLease6(req) {
+			return m.chain.Handle6(req, resp, next)
+		}
+		full = full || m.full(p)
+	}
+	if full {
+		m.logger.Warn("pool is above its high-water mark, refusing new client", zap.Stringer("client_id", req.Options.ClientID()))
+		return handlers.ErrDrop
+	}
+	return m.chain.Handle6(req, resp, next)
+}
+
+// Interfaces guards
+var (
+	_ handlers.HandlerModule = (*Module)(nil)
+)
@@ -0,0 +1,147 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package limitpool
+
+import (
+	"encoding/hex"
+	"net"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/lion7/caddydhcp/handlers/handlertest"
+	"github.com/lion7/caddydhcp/handlers/prefix"
+	rangeplugin "github.com/lion7/caddydhcp/handlers/range"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+// the range and prefix handlers are the pools guarded by this module
+var (
+	_ Pool4 = (*rangeplugin.Module)(nil)
+	_ Pool6 = (*prefix.Module)(nil)
+)
+
+// fakePool is a pool of total addresses, of which the leased clients hold one each.
+// It hands out an address to every client it handles.
+type fakePool struct {
+	total  int
+	leased map[string]bool
+}
+
+func (p *fakePool) Available() int { return p.total - len(p.leased) }
+func (p *fakePool) Total() int     { return p.total }
+
+func (p *fakePool) HasLease4(req handlers.DHCPv4) bool {
+	return p.leased[req.ClientHWAddr.String()]
+}
+
+func (p *fakePool) HasLease6(req handlers.DHCPv6) bool {
+	return p.leased[hex.EncodeToString(req.Options.ClientID().ToBytes())]
+}
+
+func (p *fakePool) Handle4(req, resp handlers.DHCPv4, next func() error) error {
+	p.leased[req.ClientHWAddr.String()] = true
+	resp.YourIPAddr = net.IPv4(10, 0, 0, byte(len(p.leased)))
+	return next()
+}
+
+func (p *fakePool) Handle6(req, resp handlers.DHCPv6, next func() error) error {
+	p.leased[hex.EncodeToString(req.Options.ClientID().ToBytes())] = true
+	return next()
+}
+
+// guard returns a module with the given high-water mark, guarding a fake pool of 10 addresses with the given leases.
+func guard(highWater int, leased ...string) (*Module, *fakePool) {
+	p := &fakePool{total: 10, leased: make(map[string]bool)}
+	for _, key := range leased {
+		p.leased[key] = true
+	}
+	return &Module{HighWater: highWater, chain: handlers.Chain{p}, logger: zap.NewNop()}, p
+}
+
+// discover returns a DHCPv4 discover of the client with the given hardware address.
+func discover(t *testing.T, mac net.HardwareAddr) handlers.DHCPv4 {
+	req, err := dhcpv4.NewDiscovery(mac)
+	require.NoError(t, err)
+	return handlers.NewDHCPv4(req)
+}
+
+func TestHandle4(t *testing.T) {
+	known := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	unknown := net.HardwareAddr{0x02, 0, 0, 0, 0, 2}
+	leased := []string{known.String(), "a", "b", "c", "d", "e"}
+
+	t.Run("below the high-water mark", func(t *testing.T) {
+		// 6 of 10 addresses in use is at the mark, but not above it
+		m, p := guard(60, leased...)
+		resp, err := handlertest.Handle4(t, m, discover(t, unknown), nil)
+		require.NoError(t, err)
+		assert.True(t, p.leased[unknown.String()])
+		assert.False(t, resp.YourIPAddr.IsUnspecified())
+	})
+
+	t.Run("new client above the high-water mark", func(t *testing.T) {
+		m, p := guard(50, leased...)
+		_, err := handlertest.Handle4(t, m, discover(t, unknown), nil)
+		assert.ErrorIs(t, err, handlers.ErrDrop)
+		assert.False(t, p.leased[unknown.String()])
+	})
+
+	t.Run("known client above the high-water mark", func(t *testing.T) {
+		m, _ := guard(50, leased...)
+		resp, err := handlertest.Handle4(t, m, discover(t, known), nil)
+		require.NoError(t, err)
+		assert.False(t, resp.YourIPAddr.IsUnspecified())
+	})
+
+	t.Run("reserved client above the high-water mark", func(t *testing.T) {
+		m, _ := guard(50, leased...)
+		_, err := handlertest.Handle4(t, m, discover(t, unknown), nil, dhcpv4.WithYourIP(net.IPv4(10, 0, 1, 10)))
+		assert.NoError(t, err)
+	})
+}
+
+func TestHandle6(t *testing.T) {
+	known := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	unknown := net.HardwareAddr{0x02, 0, 0, 0, 0, 2}
+
+	newSolicit := func(mac net.HardwareAddr) (*dhcpv6.Message, string) {
+		req, err := dhcpv6.NewSolicit(mac)
+		require.NoError(t, err)
+		return req, hex.EncodeToString(req.Options.ClientID().ToBytes())
+	}
+	knownReq, knownKey := newSolicit(known)
+	unknownReq, unknownKey := newSolicit(unknown)
+	leased := []string{knownKey, "a", "b", "c", "d", "e"}
+
+	handle6 := func(m *Module, req *dhcpv6.Message) error {
+		_, err := handlertest.Handle6(t, m, handlers.NewDHCPv6(req), nil)
+		return err
+	}
+
+	m, p := guard(50, leased...)
+	assert.ErrorIs(t, handle6(m, unknownReq), handlers.ErrDrop)
+	assert.False(t, p.leased[unknownKey])
+	assert.NoError(t, handle6(m, knownReq))
+
+	m, p = guard(60, leased...)
+	assert.NoError(t, handle6(m, unknownReq))
+	assert.True(t, p.leased[unknownKey])
+}
+
+func TestProvisionInvalid(t *testing.T) {
+	for _, m := range []*Module{
+		{HighWater: 0},
+		{HighWater: 101},
+		// a high-water mark without pools to guard
+		{HighWater: 90},
+	} {
+		assert.Error(t, m.Provision(caddy.Context{}))
+	}
+}
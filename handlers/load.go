@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// LoadModule loads the module(s) configured in the named json.RawMessage or []json.RawMessage field of the struct,
+// like caddy.Context.LoadModule, using the namespace and inline_key of the caddy struct tag of the field.
+// Like caddy.Context.LoadModule, it clears the field after loading, and it returns a []any for a list of modules.
+//
+// caddy.Context.LoadModule recognizes these fields by the name of their type. Since Go 1.27, and with
+// GOEXPERIMENT=jsonv2, json.RawMessage is an alias of jsontext.Value, so it silently loads nothing: a server
+// would start without any handlers and reply to every request without assigning an address, and the nested
+// handlers, updaters and lease stores of the handlers would be missing as well, all without an error.
+// The app and the handlers therefore load all their modules through this function.
+func LoadModule(ctx caddy.Context, structPointer any, fieldName string) (any, error) {
+	field, ok := reflect.TypeOf(structPointer).Elem().FieldByName(fieldName)
+	if !ok {
+		panic(fmt.Sprintf("field %s does not exist in %#v", fieldName, structPointer))
+	}
+	opts, err := caddy.ParseStructTag(field.Tag.Get("caddy"))
+	if err != nil {
+		panic(fmt.Sprintf("malformed tag on field %s: %v", fieldName, err))
+	}
+	namespace, inlineKey := opts["namespace"], opts["inline_key"]
+	if namespace == "" || inlineKey == "" {
+		panic(fmt.Sprintf("missing 'namespace' or 'inline_key' in struct tag on field %s", fieldName))
+	}
+
+	val := reflect.ValueOf(structPointer).Elem().FieldByName(fieldName)
+	var result any
+	switch raw := val.Interface().(type) {
+	case json.RawMessage:
+		if result, err = loadModuleInline(ctx, namespace, inlineKey, raw); err != nil {
+			return nil, err
+		}
+	case []json.RawMessage:
+		all := make([]any, 0, len(raw))
+		for i, r := range raw {
+			mod, err := loadModuleInline(ctx, namespace, inlineKey, r)
+			if err != nil {
+				return nil, fmt.Errorf("position %d: %v", i, err)
+			}
+			all = append(all, mod)
+		}
+		result = all
+	default:
+		return ctx.LoadModule(structPointer, fieldName)
+	}
+
+	// the raw configuration is no longer needed
+	val.Set(reflect.Zero(val.Type()))
+	return result, nil
+}
+
+// loadModuleInline loads the module in the namespace whose name is given by the inlineKey of its configuration.
+func loadModuleInline(ctx caddy.Context, namespace, inlineKey string, raw json.RawMessage) (any, error) {
+	var tmp map[string]any
+	if err := json.Unmarshal(raw, &tmp); err != nil {
+		return nil, err
+	}
+	name, ok := tmp[inlineKey].(string)
+	if !ok || name == "" {
+		return nil, fmt.Errorf("module name not specified with key '%s' in %+v", inlineKey, tmp)
+	}
+	// the module does not recognize its name as a field, so it must be removed before loading it
+	delete(tmp, inlineKey)
+	raw, err := json.Marshal(tmp)
+	if err != nil {
+		return nil, fmt.Errorf("re-encoding module configuration: %v", err)
+	}
+	mod, err := ctx.LoadModuleByID(namespace+"."+name, raw)
+	if err != nil {
+		return nil, fmt.Errorf("loading module '%s': %v", name, err)
+	}
+	return mod, nil
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// loadTestModule is a handler module that is only registered for the tests of LoadModule.
+type loadTestModule struct {
+	Base
+	Name string `json:"name,omitempty"`
+}
+
+func (loadTestModule) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "dhcp.handlers.loadtest",
+		New: func() caddy.Module { return new(loadTestModule) },
+	}
+}
+
+func init() {
+	caddy.RegisterModule(loadTestModule{})
+}
+
+type loadTestHost struct {
+	HandlerRaw  json.RawMessage   `json:"handler,omitempty" caddy:"namespace=dhcp.handlers inline_key=handler"`
+	HandlersRaw []json.RawMessage `json:"handlers,omitempty" caddy:"namespace=dhcp.handlers inline_key=handler"`
+}
+
+func TestLoadModule(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	host := &loadTestHost{
+		HandlerRaw: json.RawMessage(`{"handler": "loadtest", "name": "single"}`),
+		HandlersRaw: []json.RawMessage{
+			json.RawMessage(`{"handler": "loadtest", "name": "first"}`),
+			json.RawMessage(`{"handler": "loadtest", "name": "second"}`),
+		},
+	}
+	mod, err := LoadModule(ctx, host, "HandlerRaw")
+	require.NoError(t, err)
+	assert.Equal(t, &loadTestModule{Name: "single"}, mod)
+	assert.Nil(t, host.HandlerRaw)
+
+	mods, err := LoadModule(ctx, host, "HandlersRaw")
+	require.NoError(t, err)
+	assert.Equal(t, []any{&loadTestModule{Name: "first"}, &loadTestModule{Name: "second"}}, mods)
+	assert.Nil(t, host.HandlersRaw)
+
+	host.HandlersRaw = []json.RawMessage{
+		json.RawMessage(`{"handler": "loadtest"}`),
+		json.RawMessage(`{"name": "unnamed"}`),
+	}
+	_, err = LoadModule(ctx, host, "HandlersRaw")
+	assert.ErrorContains(t, err, "position 1: module name not specified")
+
+	host.HandlersRaw = []json.RawMessage{json.RawMessage(`{"handler": "loadtest", "unknown": true}`)}
+	_, err = LoadModule(ctx, host, "HandlersRaw")
+	assert.ErrorContains(t, err, "loading module 'loadtest'")
+}
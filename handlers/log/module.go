@@ -0,0 +1,168 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package logplugin
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/lion7/caddydhcp/handlers"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Module logs one structured entry per request, after the rest of the chain has handled it. Unlike messagelog,
+// which writes the summaries of the messages to a file, the entries go through the logging of Caddy,
+// so they can be encoded, sampled and sent anywhere like any other log, and their fields can be queried.
+//
+// An entry holds the message type and client of the request, the options it requested, the reply and the addresses
+// it assigns, and the outcome of the chain: `reply`, `drop`, `nak` or `error`. Put this handler first in the chain,
+// so it sees the outcome of all other handlers.
+type Module struct {
+	// The level to log the entries at: `debug`, `info` (the default), `warn` or `error`.
+	// The entries of requests that failed with an error are always logged at the error level.
+	Level string `json:"level,omitempty"`
+
+	level  zapcore.Level
+	logger *zap.Logger
+}
+
+// CaddyModule returns the Caddy module information.
+func (Module) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "dhcp.handlers.log",
+		New: func() caddy.Module { return new(Module) },
+	}
+}
+
+// Provision is run immediately after this handler is being loaded.
+func (m *Module) Provision(ctx caddy.Context) error {
+	m.logger = ctx.Logger()
+	m.level = zapcore.InfoLevel
+	if m.Level != "" {
+		level, err := zapcore.ParseLevel(m.Level)
+		if err != nil || level > zapcore.ErrorLevel {
+			return fmt.Errorf("invalid level %q", m.Level)
+		}
+		m.level = level
+	}
+	return nil
+}
+
+// Handle4 handles DHCPv4 packets for this plugin.
+func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
+	start := time.Now()
+	err := next()
+
+	fields := []zap.Field{
+		zap.Stringer("message_type", req.MessageType()),
+		zap.Stringer("xid", req.TransactionID),
+		zap.Stringer("mac", req.ClientHWAddr),
+	}
+	if cid := req.Options.Get(dhcpv4.OptionClientIdentifier); cid != nil {
+		fields = append(fields, zap.String("client_id", hex.EncodeToString(cid)))
+	}
+	if req.GatewayIPAddr != nil && !req.GatewayIPAddr.IsUnspecified() {
+		fields = append(fields, zap.Stringer("relay", req.GatewayIPAddr))
+	}
+	var requested []int
+	for _, code := range req.ParameterRequestList() {
+		requested = append(requested, int(code.Code()))
+	}
+	fields = append(fields, zap.Ints("requested_options", requested))
+	if outcome(err) == "reply" {
+		fields = append(fields, zap.Stringer("reply_type", resp.MessageType()))
+		if resp.YourIPAddr != nil && !resp.YourIPAddr.IsUnspecified() {
+			fields = append(fields, zap.Stringer("assigned_ip", resp.YourIPAddr))
+		}
+	}
+	m.log(err, start, fields)
+	return err
+}
+
+// Handle6 handles DHCPv6 packets for this plugin.
+func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
+	start := time.Now()
+	err := next()
+
+	fields := []zap.Field{
+		zap.Stringer("message_type", req.MessageType),
+		zap.Stringer("xid", req.TransactionID),
+	}
+	if duid := req.Options.ClientID(); duid != nil {
+		fields = append(fields, zap.String("client_id", hex.EncodeToString(duid.ToBytes())))
+	}
+	if mac, err := dhcpv6.ExtractMAC(req.Message); err == nil {
+		fields = append(fields, zap.Stringer("mac", mac))
+	}
+	if link := req.LinkAddress(); link != nil {
+		fields = append(fields, zap.Stringer("relay", link))
+	}
+	var requested []int
+	for _, code := range req.RequestedOptions() {
+		requested = append(requested, int(code))
+	}
+	fields = append(fields, zap.Ints("requested_options", requested))
+	if outcome(err) == "reply" {
+		fields = append(fields, zap.Stringer("reply_type", resp.MessageType))
+		var assigned []string
+		for _, iana := range resp.Options.IANA() {
+			for _, addr := range iana.Options.Addresses() {
+				assigned = append(assigned, addr.IPv6Addr.String())
+			}
+		}
+		for _, iapd := range resp.Options.IAPD() {
+			for _, prefix := range iapd.Options.Prefixes() {
+				assigned = append(assigned, prefix.Prefix.String())
+			}
+		}
+		if len(assigned) > 0 {
+			fields = append(fields, zap.Strings("assigned", assigned))
+		}
+	}
+	m.log(err, start, fields)
+	return err
+}
+
+// log writes the entry of a request, with the outcome of the chain and how long it took.
+func (m *Module) log(err error, start time.Time, fields []zap.Field) {
+	o := outcome(err)
+	fields = append(fields, zap.String("outcome", o), zap.Duration("duration", time.Since(start)))
+	level := m.level
+	if o == "error" || o == "nak" {
+		fields = append(fields, zap.Error(err))
+	}
+	if o == "error" {
+		level = zapcore.ErrorLevel
+	}
+	if ce := m.logger.Check(level, "handled request"); ce != nil {
+		ce.Write(fields...)
+	}
+}
+
+// outcome describes how the chain handled a request, given the error it returned.
+func outcome(err error) string {
+	var handlerErr handlers.HandlerError
+	switch {
+	case err == nil || errors.Is(err, handlers.ErrStopAndReply):
+		return "reply"
+	case errors.Is(err, handlers.ErrDrop):
+		return "drop"
+	case errors.As(err, &handlerErr) && handlerErr.Nak:
+		return "nak"
+	default:
+		return "error"
+	}
+}
+
+// Interfaces guards
+var (
+	_ handlers.HandlerModule = (*Module)(nil)
+)
@@ -0,0 +1,123 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package logplugin
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/lion7/caddydhcp/handlers/handlertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+var mac = net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+
+// observed returns a module logging at the given level, and the entries it logs.
+func observed(t *testing.T, level string) (*Module, *observer.ObservedLogs) {
+	m := handlertest.Provision(t, &Module{Level: level})
+	core, logs := observer.New(zapcore.DebugLevel)
+	m.logger = zap.New(core)
+	return m, logs
+}
+
+func TestHandle4(t *testing.T) {
+	m, logs := observed(t, "")
+	req, err := dhcpv4.NewDiscovery(mac,
+		dhcpv4.WithGatewayIP(net.IPv4(10, 0, 0, 254)),
+		dhcpv4.WithOption(dhcpv4.OptClientIdentifier([]byte{0x01, 0x02, 0, 0, 0, 0, 0x01})),
+	)
+	require.NoError(t, err)
+
+	_, err = handlertest.Handle4(t, m, handlers.NewDHCPv4(req), func(resp *dhcpv4.DHCPv4) error {
+		resp.YourIPAddr = net.IPv4(10, 0, 0, 10)
+		return nil
+	}, dhcpv4.WithMessageType(dhcpv4.MessageTypeOffer))
+	require.NoError(t, err)
+
+	require.Equal(t, 1, logs.Len())
+	entry := logs.All()[0]
+	assert.Equal(t, zapcore.InfoLevel, entry.Level)
+	assert.Equal(t, "handled request", entry.Message)
+	fields := entry.ContextMap()
+	assert.Equal(t, "DISCOVER", fields["message_type"])
+	assert.Equal(t, req.TransactionID.String(), fields["xid"])
+	assert.Equal(t, "02:00:00:00:00:01", fields["mac"])
+	assert.Equal(t, "01020000000001", fields["client_id"])
+	assert.Equal(t, "10.0.0.254", fields["relay"])
+	// the parameter request list of a Discovery built by the dhcpv4 package
+	assert.Equal(t, []interface{}{1, 3, 15, 6}, fields["requested_options"])
+	assert.Equal(t, "OFFER", fields["reply_type"])
+	assert.Equal(t, "10.0.0.10", fields["assigned_ip"])
+	assert.Equal(t, "reply", fields["outcome"])
+	assert.IsType(t, time.Duration(0), fields["duration"])
+}
+
+func TestHandle6(t *testing.T) {
+	m, logs := observed(t, "debug")
+	msg, err := dhcpv6.NewSolicit(mac, dhcpv6.WithRequestedOptions(dhcpv6.OptionDNSRecursiveNameServer))
+	require.NoError(t, err)
+
+	_, err = handlertest.Handle6(t, m, handlers.NewDHCPv6(msg), func(resp *dhcpv6.Message) error {
+		resp.AddOption(&dhcpv6.OptIANA{Options: dhcpv6.IdentityOptions{Options: []dhcpv6.Option{
+			&dhcpv6.OptIAAddress{IPv6Addr: net.ParseIP("2001:db8::10")},
+		}}})
+		return nil
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, 1, logs.Len())
+	entry := logs.All()[0]
+	assert.Equal(t, zapcore.DebugLevel, entry.Level)
+	fields := entry.ContextMap()
+	assert.Equal(t, "SOLICIT", fields["message_type"])
+	assert.Equal(t, "02:00:00:00:00:01", fields["mac"])
+	assert.NotEmpty(t, fields["client_id"])
+	assert.Contains(t, fields["requested_options"], int(dhcpv6.OptionDNSRecursiveNameServer))
+	assert.Equal(t, "ADVERTISE", fields["reply_type"])
+	assert.Equal(t, []interface{}{"2001:db8::10"}, fields["assigned"])
+	assert.Equal(t, "reply", fields["outcome"])
+}
+
+func TestOutcome(t *testing.T) {
+	for _, tc := range []struct {
+		err     error
+		outcome string
+		level   zapcore.Level
+	}{
+		{handlers.ErrStopAndReply, "reply", zapcore.WarnLevel},
+		{handlers.ErrDrop, "drop", zapcore.WarnLevel},
+		{handlers.Nak(errors.New("wrong subnet")), "nak", zapcore.WarnLevel},
+		{errors.New("failure"), "error", zapcore.ErrorLevel},
+	} {
+		m, logs := observed(t, "warn")
+		req, err := dhcpv4.NewDiscovery(mac)
+		require.NoError(t, err)
+
+		_, err = handlertest.Handle4(t, m, handlers.NewDHCPv4(req), func(*dhcpv4.DHCPv4) error { return tc.err })
+		assert.Equal(t, tc.err, err, "the error of the chain is returned unchanged")
+		require.Equal(t, 1, logs.Len())
+		entry := logs.All()[0]
+		assert.Equal(t, tc.level, entry.Level, tc.outcome)
+		assert.Equal(t, tc.outcome, entry.ContextMap()["outcome"])
+		_, hasReply := entry.ContextMap()["reply_type"]
+		assert.Equal(t, tc.outcome == "reply", hasReply, tc.outcome)
+	}
+}
+
+func TestProvision(t *testing.T) {
+	for _, level := range []string{"verbose", "fatal"} {
+		assert.Error(t, (&Module{Level: level}).Provision(caddy.Context{}), level)
+	}
+}
@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+)
+
+// ParseMessageType4 returns the DHCPv4 message type with the given name, like "Discover" or "DHCPACK".
+// Names are case-insensitive, and may be prefixed with "DHCP".
+func ParseMessageType4(name string) (dhcpv4.MessageType, bool) {
+	name = strings.TrimPrefix(strings.ToUpper(name), "DHCP")
+	for mt := dhcpv4.MessageType(1); mt != 0; mt++ {
+		if mt.String() == name {
+			return mt, true
+		}
+	}
+	return 0, false
+}
+
+// ParseMessageType6 returns the DHCPv6 message type with the given name, like "Solicit" or "RELAY_FORW".
+// Names are case-insensitive, and underscores may be used instead of dashes.
+func ParseMessageType6(name string) (dhcpv6.MessageType, bool) {
+	name = strings.ReplaceAll(strings.ToUpper(name), "_", "-")
+	for mt := dhcpv6.MessageType(1); mt != 0; mt++ {
+		if strings.ToUpper(mt.String()) == name {
+			return mt, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMessageType(t *testing.T) {
+	for name, expected := range map[string]dhcpv4.MessageType{
+		"Discover": dhcpv4.MessageTypeDiscover,
+		"DHCPACK":  dhcpv4.MessageTypeAck,
+		"nak":      dhcpv4.MessageTypeNak,
+	} {
+		mt, ok := ParseMessageType4(name)
+		assert.True(t, ok, name)
+		assert.Equal(t, expected, mt, name)
+	}
+	for name, expected := range map[string]dhcpv6.MessageType{
+		"Solicit":    dhcpv6.MessageTypeSolicit,
+		"REPLY":      dhcpv6.MessageTypeReply,
+		"relay_forw": dhcpv6.MessageTypeRelayForward,
+	} {
+		mt, ok := ParseMessageType6(name)
+		assert.True(t, ok, name)
+		assert.Equal(t, expected, mt, name)
+	}
+
+	_, ok := ParseMessageType4("Solicit")
+	assert.False(t, ok)
+	_, ok = ParseMessageType6("Discover")
+	assert.False(t, ok)
+}
@@ -0,0 +1,93 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package mirror
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/lion7/caddydhcp/handlers"
+	"go.uber.org/zap"
+)
+
+// Module copies options of the request into the reply unchanged, for clients that expect the server to echo them,
+// like the Client Machine Identifier option (97) in PXE or a vendor class. Options that are not in the request
+// are skipped. The options are copied before the rest of the chain runs, so later handlers can still change them.
+type Module struct {
+	// The codes of the DHCPv4 options to copy, like 97 for the Client Machine Identifier.
+	Options4 []uint16 `json:"options4,omitempty"`
+
+	// The codes of the DHCPv6 options to copy, like 16 for the Vendor Class.
+	// All instances of an option that may occur more than once are copied.
+	Options6 []uint16 `json:"options6,omitempty"`
+
+	logger *zap.Logger
+}
+
+// CaddyModule returns the Caddy module information.
+func (Module) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "dhcp.handlers.mirror",
+		New: func() caddy.Module { return new(Module) },
+	}
+}
+
+// Provision is run immediately after this handler is being loaded.
+func (m *Module) Provision(ctx caddy.Context) error {
+	m.logger = ctx.Logger()
+	if len(m.Options4) == 0 && len(m.Options6) == 0 {
+		return errors.New("no options configured")
+	}
+	for _, code := range m.Options4 {
+		if code == 0 || code > 254 || code == uint16(dhcpv4.OptionDHCPMessageType.Code()) {
+			return fmt.Errorf("invalid DHCPv4 option code %d", code)
+		}
+	}
+	for _, code := range m.Options6 {
+		if code == 0 {
+			return fmt.Errorf("invalid DHCPv6 option code %d", code)
+		}
+	}
+	return nil
+}
+
+// Handle4 handles DHCPv4 packets for this plugin.
+func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
+	for _, c := range m.Options4 {
+		code := dhcpv4.GenericOptionCode(c)
+		value := req.Options.Get(code)
+		if value == nil {
+			continue
+		}
+		m.logger.Debug("copying option into the reply", zap.Uint16("option", c), zap.Stringer("mac", req.ClientHWAddr))
+		resp.UpdateOption(dhcpv4.OptGeneric(code, append([]byte(nil), value...)))
+	}
+	return next()
+}
+
+// Handle6 handles DHCPv6 packets for this plugin.
+func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
+	for _, c := range m.Options6 {
+		code := dhcpv6.OptionCode(c)
+		opts := req.Options.Get(code)
+		if len(opts) == 0 {
+			continue
+		}
+		m.logger.Debug("copying option into the reply", zap.Uint16("option", c), zap.Stringer("client_id", req.Options.ClientID()))
+		resp.Options.Del(code)
+		for _, opt := range opts {
+			resp.AddOption(opt)
+		}
+	}
+	return next()
+}
+
+// Interfaces guards
+var (
+	_ handlers.HandlerModule = (*Module)(nil)
+)
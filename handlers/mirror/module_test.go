@@ -0,0 +1,72 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package mirror
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/lion7/caddydhcp/handlers/handlertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandle4(t *testing.T) {
+	m := handlertest.Provision(t, &Module{Options4: []uint16{97, 60}})
+	// a type byte of 0 followed by the UUID of the client
+	uuid := append([]byte{0}, bytes.Repeat([]byte{0x2a}, 16)...)
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+		dhcpv4.WithGeneric(dhcpv4.OptionClientMachineIdentifier, uuid),
+	)
+	require.NoError(t, err)
+	resp, err := handlertest.Handle4(t, m, handlers.NewDHCPv4(req), nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, uuid, resp.Options.Get(dhcpv4.OptionClientMachineIdentifier))
+	// the request has no vendor class
+	assert.Nil(t, resp.Options.Get(dhcpv4.OptionClassIdentifier))
+}
+
+func TestHandle6(t *testing.T) {
+	m := handlertest.Provision(t, &Module{Options6: []uint16{16}})
+	vendorClass := &dhcpv6.OptVendorClass{EnterpriseNumber: 343, Data: [][]byte{[]byte("PXEClient:Arch:00007")}}
+	req, err := dhcpv6.NewSolicit(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, dhcpv6.WithOption(vendorClass))
+	require.NoError(t, err)
+	resp, err := handlertest.Handle6(t, m, handlers.NewDHCPv6(req), nil)
+	require.NoError(t, err)
+
+	msg, err := dhcpv6.MessageFromBytes(resp.ToBytes())
+	require.NoError(t, err)
+	got := msg.Options.VendorClasses()
+	require.Len(t, got, 1)
+	assert.Equal(t, vendorClass.EnterpriseNumber, got[0].EnterpriseNumber)
+	assert.Equal(t, vendorClass.Data, got[0].Data)
+}
+
+func TestHandle6Missing(t *testing.T) {
+	m := handlertest.Provision(t, &Module{Options6: []uint16{16}})
+	req, err := dhcpv6.NewSolicit(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	resp, err := handlertest.Handle6(t, m, handlers.NewDHCPv6(req), nil)
+	require.NoError(t, err)
+	assert.Empty(t, resp.Options.VendorClasses())
+}
+
+func TestProvisionInvalid(t *testing.T) {
+	for _, m := range []*Module{
+		{},
+		{Options4: []uint16{0}},
+		{Options4: []uint16{53}},
+		{Options4: []uint16{255}},
+		{Options6: []uint16{0}},
+	} {
+		assert.Error(t, m.Provision(caddy.Context{}))
+	}
+}
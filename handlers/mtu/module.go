@@ -12,6 +12,9 @@ import (
 )
 
 type Module struct {
+	// DHCPv6 does not have MTU-related options
+	handlers.Base
+
 	Mtu int `json:"mtu"`
 
 	logger *zap.Logger
@@ -37,11 +40,6 @@ func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
 	return next()
 }
 
-func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
-	// DHCPv6 does not have MTU-related options, so just continue the chain
-	return next()
-}
-
 // Interfaces guards
 var (
 	_ handlers.HandlerModule = (*Module)(nil)
@@ -6,6 +6,8 @@ package nbp
 
 import (
 	"encoding/hex"
+	"fmt"
+	"net"
 	"net/url"
 	"strconv"
 
@@ -17,6 +19,19 @@ import (
 	"go.uber.org/zap"
 )
 
+const tftpPort = "69"
+
+// discoverySkip is the discovery control bit that makes a PXE client download the boot file of the reply
+// directly, instead of discovering a boot server.
+const discoverySkip = 8
+
+// Values of Module.VendorClassEcho.
+const (
+	echoNone    = "none"
+	echoMatched = "matched"
+	echoAll     = "all"
+)
+
 // Module implements handling of an NBP (Network Boot Program) using a URL,
 // e.g. http://[fe80::abcd:efff:fe12:3456]/my-nbp or tftp://10.0.0.1/my-nbp .
 // The NBP information is only added if it is requested by the client.
@@ -24,19 +39,73 @@ import (
 // Note that for DHCPv4, if the URL is prefixed with a "tftp" the URL will
 // be split into TFTP server name (option 66) and Bootfile name (option 67),
 // so the scheme will be stripped out, and it will be treated as a TFTP URL.
-// Anything other than host name and file path will be ignored (no port, no query string, etc).
+// Brackets around an IPv6 literal host are removed. A port other than the default
+// TFTP port is preserved as host:port, which is understood by e.g. iPXE.
+// Anything other than host name, port and file path will be ignored (no query string, etc).
 //
 // For DHCPv6 OPT_BOOTFILE_URL (option 59) is used, and the value is passed
 // unmodified. If the query string is specified and contains a "param" key,
 // its value is also passed as OPT_BOOTFILE_PARAM (option 60), so it will be
 // duplicated between option 59 and 60.
+//
+// When a DHCPv6 client requests the Vendor Class option (16), the vendor classes it sent
+// are echoed back depending on VendorClassEcho.
+//
+// When PXE is set, DHCPv4 clients with a class identifier starting with "PXEClient" that get a boot URL also
+// get the PXE sub-options of the Vendor Specific Information option (43), if they request it.
 type Module struct {
 	Urls map[string]string `json:"urls"`
 
+	// Which vendor class options (16) of a DHCPv6 request to echo back when the client requests them:
+	// `none` (the default), `matched` for only the vendor class that selected the boot URL, or `all`.
+	VendorClassEcho string `json:"vendorClassEcho,omitempty"`
+
+	// The PXE sub-options to send to PXE clients. None are sent by default.
+	PXE *PXE `json:"pxe,omitempty"`
+
 	urls   map[string]*url.URL
+	pxe    []byte
 	logger *zap.Logger
 }
 
+// PXE configures the PXE sub-options of the Vendor Specific Information option (43).
+// The defaults make PXE clients skip the discovery of boot servers, and boot the boot file of the reply.
+type PXE struct {
+	// The discovery control bits (sub-option 6): 1 disables broadcast discovery, 2 disables multicast
+	// discovery, 4 only accepts the boot servers listed in BootServers, and 8 downloads the boot file
+	// of the reply directly. Defaults to 8.
+	DiscoveryControl *uint8 `json:"discoveryControl,omitempty"`
+
+	// The type of the boot servers and the boot item, where 0 is the PXE bootstrap server. Defaults to 0.
+	BootServerType uint16 `json:"bootServerType,omitempty"`
+
+	// The IPv4 addresses of the boot servers of BootServerType (sub-option 8). Not sent when empty.
+	BootServers []string `json:"bootServers,omitempty"`
+
+	// Whether to send the boot item (sub-option 71) of BootServerType, with layer 0.
+	BootItem bool `json:"bootItem,omitempty"`
+}
+
+// encode returns the value of the Vendor Specific Information option holding the PXE sub-options.
+func (p *PXE) encode() ([]byte, error) {
+	options := handlers.PXEOptions{
+		DiscoveryControl: discoverySkip,
+		BootServerType:   p.BootServerType,
+		BootItem:         p.BootItem,
+	}
+	if p.DiscoveryControl != nil {
+		options.DiscoveryControl = *p.DiscoveryControl
+	}
+	for _, s := range p.BootServers {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid PXE boot server %q", s)
+		}
+		options.BootServers = append(options.BootServers, ip)
+	}
+	return options.Encode()
+}
+
 // CaddyModule returns the Caddy module information.
 func (Module) CaddyModule() caddy.ModuleInfo {
 	return caddy.ModuleInfo{
@@ -47,6 +116,11 @@ func (Module) CaddyModule() caddy.ModuleInfo {
 
 func (m *Module) Provision(ctx caddy.Context) error {
 	m.logger = ctx.Logger()
+	switch m.VendorClassEcho {
+	case "", echoNone, echoMatched, echoAll:
+	default:
+		return fmt.Errorf("invalid vendor class echo %q, expected one of %q, %q or %q", m.VendorClassEcho, echoNone, echoMatched, echoAll)
+	}
 	var urls = make(map[string]*url.URL)
 	for k, v := range m.Urls {
 		u, err := url.Parse(v)
@@ -56,6 +130,13 @@ func (m *Module) Provision(ctx caddy.Context) error {
 		urls[k] = u
 	}
 	m.urls = urls
+	if m.PXE != nil {
+		pxe, err := m.PXE.encode()
+		if err != nil {
+			return err
+		}
+		m.pxe = pxe
+	}
 	return nil
 }
 
@@ -88,7 +169,7 @@ func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
 	)
 	switch u.Scheme {
 	case "tftp":
-		resp.UpdateOption(dhcpv4.OptTFTPServerName(u.Host))
+		resp.UpdateOption(dhcpv4.OptTFTPServerName(tftpServerName(u)))
 		resp.UpdateOption(dhcpv4.OptBootFileName(u.Path))
 	default:
 		resp.UpdateOption(dhcpv4.OptBootFileName(u.String()))
@@ -98,6 +179,11 @@ func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
 		resp.UpdateOption(dhcpv4.OptClassIdentifier(classId))
 	}
 
+	// leave the vendor options to a handler like pxemenu that already set them
+	if m.pxe != nil && handlers.IsPXERequest(req) && resp.Options.Get(dhcpv4.OptionVendorSpecificInformation) == nil {
+		handlers.SetPXEOptions(resp, m.pxe)
+	}
+
 	return next()
 }
 
@@ -134,9 +220,11 @@ func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
 		resp.UpdateOption(dhcpv6.OptBootFileParam(u.Query().Get("param")))
 	}
 
-	if req.IsOptionRequested(dhcpv6.OptionVendorClass) && req.Options.VendorClasses() != nil {
+	if req.IsOptionRequested(dhcpv6.OptionVendorClass) {
 		for _, class := range req.Options.VendorClasses() {
-			resp.UpdateOption(class)
+			if m.VendorClassEcho == echoAll || (m.VendorClassEcho == echoMatched && m.selects(class, u)) {
+				resp.AddOption(class)
+			}
 		}
 	}
 
@@ -176,6 +264,25 @@ func (m *Module) findUrl(clientId string, classIds []string, archTypes iana.Arch
 	return nil
 }
 
+// selects returns whether the URL was selected by one of the class identifiers of the vendor class.
+func (m *Module) selects(class *dhcpv6.OptVendorClass, u *url.URL) bool {
+	for _, data := range class.Data {
+		if m.urls[string(data)] == u {
+			return true
+		}
+	}
+	return false
+}
+
+// tftpServerName returns the TFTP server name of a tftp URL, without brackets for an IPv6 literal.
+// The port is only included if it differs from the default TFTP port.
+func tftpServerName(u *url.URL) string {
+	if port := u.Port(); port != "" && port != tftpPort {
+		return net.JoinHostPort(u.Hostname(), port)
+	}
+	return u.Hostname()
+}
+
 func mapToClassIds(vendorClasses []*dhcpv6.OptVendorClass) []string {
 	if vendorClasses == nil {
 		return nil
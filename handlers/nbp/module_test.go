@@ -0,0 +1,133 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package nbp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandle4TFTP(t *testing.T) {
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	for _, tc := range []struct {
+		url, server, file string
+	}{
+		{"tftp://10.0.0.1/pxelinux.0", "10.0.0.1", "/pxelinux.0"},
+		{"tftp://10.0.0.1:69/pxelinux.0", "10.0.0.1", "/pxelinux.0"},
+		{"tftp://10.0.0.1:1069/pxelinux.0", "10.0.0.1:1069", "/pxelinux.0"},
+		{"tftp://[fe80::1]/pxelinux.0", "fe80::1", "/pxelinux.0"},
+		{"tftp://[fe80::1]:1069/pxelinux.0", "[fe80::1]:1069", "/pxelinux.0"},
+	} {
+		t.Run(tc.url, func(t *testing.T) {
+			m := &Module{Urls: map[string]string{mac.String(): tc.url}}
+			require.NoError(t, m.Provision(caddy.Context{}))
+
+			req, err := dhcpv4.NewDiscovery(mac, dhcpv4.WithRequestedOptions(dhcpv4.OptionBootfileName))
+			require.NoError(t, err)
+			resp, err := dhcpv4.NewReplyFromRequest(req)
+			require.NoError(t, err)
+
+			err = m.Handle4(handlers.DHCPv4{DHCPv4: req}, handlers.DHCPv4{DHCPv4: resp}, func() error { return nil })
+			require.NoError(t, err)
+			assert.Equal(t, tc.server, resp.TFTPServerName())
+			assert.Equal(t, tc.file, resp.BootFileNameOption())
+		})
+	}
+}
+
+func TestHandle6VendorClassEcho(t *testing.T) {
+	matched := &dhcpv6.OptVendorClass{EnterpriseNumber: 343, Data: [][]byte{[]byte("PXEClient:Arch:00007")}}
+	other := &dhcpv6.OptVendorClass{EnterpriseNumber: 311, Data: [][]byte{[]byte("MSFT 5.0")}}
+
+	for _, tc := range []struct {
+		echo string
+		want []*dhcpv6.OptVendorClass
+	}{
+		{"", nil},
+		{echoNone, nil},
+		{echoMatched, []*dhcpv6.OptVendorClass{matched}},
+		{echoAll, []*dhcpv6.OptVendorClass{matched, other}},
+	} {
+		t.Run(tc.echo, func(t *testing.T) {
+			m := &Module{Urls: map[string]string{"PXEClient:Arch:00007": "http://[2001:db8::1]/boot.efi"}, VendorClassEcho: tc.echo}
+			require.NoError(t, m.Provision(caddy.Context{}))
+
+			req, err := dhcpv6.NewSolicit(net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+				dhcpv6.WithRequestedOptions(dhcpv6.OptionBootfileURL, dhcpv6.OptionVendorClass))
+			require.NoError(t, err)
+			req.AddOption(matched)
+			req.AddOption(other)
+			resp, err := dhcpv6.NewAdvertiseFromSolicit(req)
+			require.NoError(t, err)
+
+			err = m.Handle6(handlers.DHCPv6{Message: req}, handlers.DHCPv6{Message: resp}, func() error { return nil })
+			require.NoError(t, err)
+			assert.Equal(t, "http://[2001:db8::1]/boot.efi", resp.Options.BootFileURL())
+			assert.Equal(t, tc.want, resp.Options.VendorClasses())
+		})
+	}
+
+	m := &Module{VendorClassEcho: "some"}
+	assert.Error(t, m.Provision(caddy.Context{}))
+}
+
+func TestHandle4PXE(t *testing.T) {
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	control := uint8(7)
+	for _, tc := range []struct {
+		name   string
+		pxe    *PXE
+		class  string
+		option []byte
+	}{
+		// skip discovery, and boot the boot file of the reply
+		{"defaults", &PXE{}, "PXEClient:Arch:00000:UNDI:002001", []byte{6, 1, 8, 255}},
+		// only use this boot server, and boot its first boot item
+		{"boot server", &PXE{DiscoveryControl: &control, BootServers: []string{"10.0.0.1"}, BootItem: true}, "PXEClient",
+			[]byte{6, 1, 7, 8, 7, 0, 0, 1, 10, 0, 0, 1, 71, 4, 0, 0, 0, 0, 255}},
+		{"boot servers of a type", &PXE{BootServerType: 0x8001, BootServers: []string{"10.0.0.1", "10.0.0.2"}}, "PXEClient",
+			[]byte{6, 1, 8, 8, 11, 0x80, 0x01, 2, 10, 0, 0, 1, 10, 0, 0, 2, 255}},
+		{"not a PXE client", &PXE{}, "iPXE", nil},
+		{"disabled", nil, "PXEClient", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &Module{Urls: map[string]string{mac.String(): "tftp://10.0.0.1/pxelinux.0"}, PXE: tc.pxe}
+			require.NoError(t, m.Provision(caddy.Context{}))
+
+			req, err := dhcpv4.NewDiscovery(mac,
+				dhcpv4.WithRequestedOptions(dhcpv4.OptionBootfileName, dhcpv4.OptionVendorSpecificInformation),
+				dhcpv4.WithOption(dhcpv4.OptClassIdentifier(tc.class)),
+			)
+			require.NoError(t, err)
+			resp, err := dhcpv4.NewReplyFromRequest(req)
+			require.NoError(t, err)
+
+			require.NoError(t, m.Handle4(handlers.DHCPv4{DHCPv4: req}, handlers.DHCPv4{DHCPv4: resp}, func() error { return nil }))
+			assert.Equal(t, tc.option, resp.Options.Get(dhcpv4.OptionVendorSpecificInformation))
+			if tc.option != nil {
+				assert.Equal(t, "PXEClient", resp.ClassIdentifier())
+			}
+		})
+	}
+}
+
+func TestProvisionPXE(t *testing.T) {
+	for _, servers := range [][]string{{"2001:db8::1"}, {"invalid"}, make([]string, 64)} {
+		for i := range servers {
+			if servers[i] == "" {
+				servers[i] = "10.0.0.1"
+			}
+		}
+		m := &Module{PXE: &PXE{BootServers: servers}}
+		assert.Error(t, m.Provision(caddy.Context{}), servers)
+	}
+}
@@ -16,6 +16,8 @@ import (
 )
 
 type Module struct {
+	handlers.Base
+
 	Netmask string `json:"netmask"`
 
 	netmask net.IPMask
@@ -33,13 +35,17 @@ func (Module) CaddyModule() caddy.ModuleInfo {
 func (m *Module) Provision(ctx caddy.Context) error {
 	m.logger = ctx.Logger()
 	ip := net.ParseIP(m.Netmask)
+	if ip == nil {
+		return fmt.Errorf("netmask is not an IP address, got: %q", m.Netmask)
+	}
+	if ip.To4() == nil {
+		return fmt.Errorf("expected an IPv4 netmask, got: %s", m.Netmask)
+	}
+	// 0.0.0.0 is a contiguous mask, but would put every address on the link of the client
 	if ip.IsUnspecified() {
 		return fmt.Errorf("netmask is not valid, got: %s", m.Netmask)
 	}
 	ip = ip.To4()
-	if ip == nil {
-		return fmt.Errorf("expected an netmask address, got: %s", m.Netmask)
-	}
 	netmask := net.IPv4Mask(ip[0], ip[1], ip[2], ip[3])
 	if !checkValidNetmask(netmask) {
 		return fmt.Errorf("netmask is not valid, got: %s", m.Netmask)
@@ -53,11 +59,6 @@ func (m *Module) Handle4(_, resp handlers.DHCPv4, next func() error) error {
 	return next()
 }
 
-func (m *Module) Handle6(_, _ handlers.DHCPv6, next func() error) error {
-	// netmask does not apply to DHCPv6, so just continue the chain
-	return next()
-}
-
 func checkValidNetmask(netmask net.IPMask) bool {
 	netmaskInt := binary.BigEndian.Uint32(netmask)
 	x := ^netmaskInt
@@ -0,0 +1,60 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package netmask
+
+import (
+	"net"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProvision(t *testing.T) {
+	for _, tc := range []struct {
+		netmask string
+		valid   bool
+	}{
+		{"255.255.255.0", true},
+		{"255.255.254.0", true},
+		{"255.255.255.255", true},
+		{"128.0.0.0", true},
+		{"::ffff:255.255.255.0", true},
+		{"", false},
+		{"garbage", false},
+		{"255.255.255", false},
+		{"255.255.255.0/24", false},
+		{"ffff:ffff::", false},
+		{"0.0.0.0", false},
+		{"255.0.255.0", false},
+		{"255.255.255.1", false},
+		{"0.255.255.255", false},
+	} {
+		t.Run(tc.netmask, func(t *testing.T) {
+			m := &Module{Netmask: tc.netmask}
+			err := m.Provision(caddy.Context{})
+			if tc.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestHandle4(t *testing.T) {
+	m := &Module{Netmask: "255.255.254.0"}
+	require.NoError(t, m.Provision(caddy.Context{}))
+
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	require.NoError(t, m.Handle4(handlers.DHCPv4{DHCPv4: req}, handlers.DHCPv4{DHCPv4: resp}, func() error { return nil }))
+	assert.Equal(t, net.IPv4Mask(255, 255, 254, 0), resp.SubnetMask())
+}
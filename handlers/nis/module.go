@@ -0,0 +1,90 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package nis
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/rfc1035label"
+	"github.com/lion7/caddydhcp/handlers"
+	"go.uber.org/zap"
+)
+
+// Module adds the Network Information Service (NIS) domain and servers.
+// For DHCPv4 the NIS domain (option 40) and NIS servers (option 41) are used,
+// for DHCPv6 the NIS domain name (option 29) and NIS servers (option 27) are used.
+// The options are only added if they are requested by the client.
+type Module struct {
+	Domain  string   `json:"domain,omitempty"`
+	Servers []string `json:"servers,omitempty"`
+
+	servers4 []net.IP
+	servers6 []net.IP
+	logger   *zap.Logger
+}
+
+// CaddyModule returns the Caddy module information.
+func (Module) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "dhcp.handlers.nis",
+		New: func() caddy.Module { return new(Module) },
+	}
+}
+
+// Provision is run immediately after this handler is being loaded.
+func (m *Module) Provision(ctx caddy.Context) error {
+	m.logger = ctx.Logger()
+	var servers4, servers6 []net.IP
+	for _, server := range m.Servers {
+		ip := net.ParseIP(server)
+		if ip == nil {
+			return fmt.Errorf("expected a NIS server IP address, got: %s", server)
+		}
+		if ip.To4() != nil {
+			servers4 = append(servers4, ip.To4())
+		} else {
+			servers6 = append(servers6, ip)
+		}
+	}
+	m.servers4 = servers4
+	m.servers6 = servers6
+	return nil
+}
+
+// Handle4 handles DHCPv4 packets for this plugin.
+func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
+	if m.Domain != "" && req.IsOptionRequested(dhcpv4.OptionNetworkInformationServiceDomain) {
+		resp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionNetworkInformationServiceDomain, []byte(m.Domain)))
+	}
+	if len(m.servers4) > 0 && req.IsOptionRequested(dhcpv4.OptionNetworkInformationServers) {
+		resp.UpdateOption(dhcpv4.Option{Code: dhcpv4.OptionNetworkInformationServers, Value: dhcpv4.IPs(m.servers4)})
+	}
+	return next()
+}
+
+// Handle6 handles DHCPv6 packets for this plugin.
+func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
+	if m.Domain != "" && req.IsOptionRequested(dhcpv6.OptionNISDomainName) {
+		domain := &rfc1035label.Labels{Labels: []string{m.Domain}}
+		resp.UpdateOption(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionNISDomainName, OptionData: domain.ToBytes()})
+	}
+	if len(m.servers6) > 0 && req.IsOptionRequested(dhcpv6.OptionNISServers) {
+		var data []byte
+		for _, server := range m.servers6 {
+			data = append(data, server.To16()...)
+		}
+		resp.UpdateOption(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionNISServers, OptionData: data})
+	}
+	return next()
+}
+
+// Interfaces guards
+var (
+	_ handlers.HandlerModule = (*Module)(nil)
+)
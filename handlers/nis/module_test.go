@@ -0,0 +1,87 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package nis
+
+import (
+	"net"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/rfc1035label"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newModule(t *testing.T) *Module {
+	m := &Module{Domain: "example.org", Servers: []string{"10.0.0.1", "2001:db8::1", "10.0.0.2"}}
+	require.NoError(t, m.Provision(caddy.Context{}))
+	return m
+}
+
+func TestHandle4(t *testing.T) {
+	m := newModule(t)
+
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, dhcpv4.WithRequestedOptions(
+		dhcpv4.OptionNetworkInformationServiceDomain,
+		dhcpv4.OptionNetworkInformationServers,
+	))
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+
+	require.NoError(t, m.Handle4(handlers.DHCPv4{DHCPv4: req}, handlers.DHCPv4{DHCPv4: resp}, func() error { return nil }))
+	assert.Equal(t, []byte("example.org"), resp.GetOneOption(dhcpv4.OptionNetworkInformationServiceDomain))
+	assert.Equal(t, []byte{10, 0, 0, 1, 10, 0, 0, 2}, resp.GetOneOption(dhcpv4.OptionNetworkInformationServers))
+}
+
+func TestHandle4NotRequested(t *testing.T) {
+	m := newModule(t)
+
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, dhcpv4.WithRequestedOptions(dhcpv4.OptionRouter))
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+
+	require.NoError(t, m.Handle4(handlers.DHCPv4{DHCPv4: req}, handlers.DHCPv4{DHCPv4: resp}, func() error { return nil }))
+	assert.False(t, resp.Options.Has(dhcpv4.OptionNetworkInformationServiceDomain))
+	assert.False(t, resp.Options.Has(dhcpv4.OptionNetworkInformationServers))
+}
+
+func TestHandle6(t *testing.T) {
+	m := newModule(t)
+
+	req, err := dhcpv6.NewMessage(dhcpv6.WithRequestedOptions(dhcpv6.OptionNISDomainName, dhcpv6.OptionNISServers))
+	require.NoError(t, err)
+	resp, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+
+	require.NoError(t, m.Handle6(handlers.DHCPv6{Message: req}, handlers.DHCPv6{Message: resp}, func() error { return nil }))
+
+	domain, err := rfc1035label.FromBytes(resp.GetOneOption(dhcpv6.OptionNISDomainName).ToBytes())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"example.org"}, domain.Labels)
+	assert.Equal(t, []byte(net.ParseIP("2001:db8::1")), resp.GetOneOption(dhcpv6.OptionNISServers).ToBytes())
+}
+
+func TestHandle6NotRequested(t *testing.T) {
+	m := newModule(t)
+
+	req, err := dhcpv6.NewMessage(dhcpv6.WithRequestedOptions(dhcpv6.OptionDNSRecursiveNameServer))
+	require.NoError(t, err)
+	resp, err := dhcpv6.NewMessage()
+	require.NoError(t, err)
+
+	require.NoError(t, m.Handle6(handlers.DHCPv6{Message: req}, handlers.DHCPv6{Message: resp}, func() error { return nil }))
+	assert.Nil(t, resp.GetOneOption(dhcpv6.OptionNISDomainName))
+	assert.Nil(t, resp.GetOneOption(dhcpv6.OptionNISServers))
+}
+
+func TestProvisionInvalidServer(t *testing.T) {
+	m := &Module{Servers: []string{"not-an-ip"}}
+	assert.Error(t, m.Provision(caddy.Context{}))
+}
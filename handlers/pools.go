@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"sort"
+	"sync"
+)
+
+// PoolStats describes the utilization of the address or prefix pool of a handler, like range or prefix.
+type PoolStats struct {
+	// The module name of the handler, e.g. `range`.
+	Handler string `json:"handler"`
+
+	// The pool, e.g. `10.0.0.100-10.0.0.199`, or `2001:db8::/48` followed by the size of the delegated prefixes.
+	Pool string `json:"pool"`
+
+	// The number of addresses or prefixes in the pool, and how many of them are allocated and free.
+	Total int `json:"total"`
+	Used  int `json:"used"`
+	Free  int `json:"free"`
+
+	// The number of addresses or prefixes in the largest contiguous block that is free.
+	// The smaller it is compared to Free, the more fragmented the pool is.
+	LargestFreeBlock int `json:"largestFreeBlock"`
+}
+
+// PoolReporter is implemented by the handlers that allocate from a pool.
+type PoolReporter interface {
+	// PoolStats returns the current utilization of the pool.
+	PoolStats() PoolStats
+}
+
+// the provisioned pools, which are reported by the admin API
+var (
+	poolsMu sync.Mutex
+	pools   = make(map[PoolReporter]struct{})
+)
+
+// RegisterPool adds the pool of a provisioned handler to the pools returned by Pools.
+func RegisterPool(p PoolReporter) {
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+	pools[p] = struct{}{}
+}
+
+// UnregisterPool removes the pool of a handler that is cleaned up.
+func UnregisterPool(p PoolReporter) {
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+	delete(pools, p)
+}
+
+// Pools returns the utilization of the registered pools, sorted by handler and pool.
+// Handlers sharing a pool, e.g. while a config reload replaces them, report it once.
+func Pools() []PoolStats {
+	poolsMu.Lock()
+	defer poolsMu.Unlock()
+	seen := make(map[[2]string]bool)
+	stats := make([]PoolStats, 0, len(pools))
+	for p := range pools {
+		s := p.PoolStats()
+		if key := [2]string{s.Handler, s.Pool}; !seen[key] {
+			seen[key] = true
+			stats = append(stats, s)
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Handler != stats[j].Handler {
+			return stats[i].Handler < stats[j].Handler
+		}
+		return stats[i].Pool < stats[j].Pool
+	})
+	return stats
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testPool is a PoolReporter returning fixed statistics.
+type testPool struct {
+	stats PoolStats
+}
+
+func (p *testPool) PoolStats() PoolStats {
+	return p.stats
+}
+
+func TestPools(t *testing.T) {
+	prefix := &testPool{PoolStats{Handler: "prefix", Pool: "2001:db8::/48/56", Total: 256, Used: 1, Free: 255, LargestFreeBlock: 255}}
+	range2 := &testPool{PoolStats{Handler: "range", Pool: "10.0.1.1-10.0.1.10", Total: 10, Free: 10, LargestFreeBlock: 10}}
+	range1 := &testPool{PoolStats{Handler: "range", Pool: "10.0.0.1-10.0.0.10", Total: 10, Used: 4, Free: 6, LargestFreeBlock: 3}}
+	// a handler provisioned by a config reload, sharing the pool of the handler it replaces
+	reloaded := &testPool{range1.stats}
+
+	for _, p := range []*testPool{prefix, range2, range1, reloaded} {
+		RegisterPool(p)
+		t.Cleanup(func() { UnregisterPool(p) })
+	}
+	assert.Equal(t, []PoolStats{prefix.stats, range1.stats, range2.stats}, Pools())
+
+	UnregisterPool(prefix)
+	UnregisterPool(range1)
+	assert.Equal(t, []PoolStats{range1.stats, range2.stats}, Pools())
+	UnregisterPool(reloaded)
+	assert.Equal(t, []PoolStats{range2.stats}, Pools())
+}
@@ -9,6 +9,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"net"
+	"slices"
 	"sync"
 	"time"
 
@@ -23,16 +24,44 @@ import (
 )
 
 type Module struct {
+	handlers.Base
+
 	Prefix         string         `json:"prefix"`
 	AllocationSize int            `json:"allocationSize"`
 	LeaseTime      caddy.Duration `json:"leaseTime,omitempty"`
+	// Jitter perturbs the lease time of every client by up to plus or minus this percentage,
+	// so clients do not all renew at the same time. A client always gets the same lease time. Disabled by default.
+	Jitter int `json:"jitter,omitempty"`
+
+	// The length of a sub-prefix to exclude from every delegated prefix using the Prefix Exclude option
+	// (RFC 6603), e.g. for the link between the requesting router and this router. Disabled by default.
+	// The option is only included for clients that request it.
+	ExcludeLength int `json:"excludeLength,omitempty"`
+	// Which sub-prefix of the exclude length to exclude, counting from the start of the delegated prefix.
+	ExcludeSubnetID uint64 `json:"excludeSubnetId,omitempty"`
+
+	logger *zap.Logger
+	key    string
+	*leases
+}
 
-	logger    *zap.Logger
+// leases is the lease state of a prefix pool. It is shared by all prefix handlers with the same pool,
+// so a handler provisioned by a config reload takes over the delegated prefixes of the handler it replaces
+// instead of delegating them again.
+type leases struct {
 	allocator allocators.Allocator
 	recLock   *sync.RWMutex
 	records   map[string][]record
 }
 
+// Destruct implements caddy.Destructor; the leases only live in memory, so there is nothing to release.
+func (l *leases) Destruct() error {
+	return nil
+}
+
+// pool holds the leases of the provisioned prefix handlers, keyed by prefix and allocation size.
+var pool = caddy.NewUsagePool()
+
 type record struct {
 	Prefix net.IPNet
 	Expire time.Time
@@ -57,18 +86,52 @@ func (m *Module) Provision(ctx caddy.Context) error {
 		return fmt.Errorf("invalid prefix length: %v", err)
 	}
 
-	// TODO: select allocators based on heuristics or user configuration
-	m.allocator, err = bitmap.NewBitmapAllocator(*prefix, m.AllocationSize)
+	if err := handlers.CheckJitter(m.Jitter); err != nil {
+		return err
+	}
+
+	if m.ExcludeLength != 0 {
+		if m.ExcludeLength <= m.AllocationSize || m.ExcludeLength > 128 {
+			return fmt.Errorf("invalid exclude length %d for allocation size %d", m.ExcludeLength, m.AllocationSize)
+		}
+		if bits := m.ExcludeLength - m.AllocationSize; bits < 64 && m.ExcludeSubnetID >= 1<<bits {
+			return fmt.Errorf("exclude subnet ID %d does not fit in %d bits", m.ExcludeSubnetID, bits)
+		}
+	}
+
+	m.key = fmt.Sprintf("%s/%d", prefix, m.AllocationSize)
+	val, loaded, err := pool.LoadOrNew(m.key, func() (caddy.Destructor, error) {
+		// TODO: select allocators based on heuristics or user configuration
+		allocator, err := bitmap.NewBitmapAllocator(*prefix, m.AllocationSize)
+		if err != nil {
+			return nil, fmt.Errorf("could not initialize prefix allocator: %v", err)
+		}
+		return &leases{
+			allocator: allocator,
+			recLock:   new(sync.RWMutex),
+			records:   make(map[string][]record),
+		}, nil
+	})
 	if err != nil {
-		return fmt.Errorf("could not initialize prefix allocator: %v", err)
+		return err
+	}
+	if loaded {
+		m.logger.Info("taking over the leases of the previous prefix pool", zap.String("prefix", m.Prefix))
 	}
+	m.leases = val.(*leases)
+	handlers.RegisterPool(m)
 
 	return nil
 }
 
-func (m *Module) Handle4(_, _ handlers.DHCPv4, next func() error) error {
-	// prefix does not apply to DHCPv4, so just continue the chain
-	return next()
+// Cleanup releases the leases, discarding them if no other prefix handler uses the same pool.
+func (m *Module) Cleanup() error {
+	handlers.UnregisterPool(m)
+	if m.leases == nil {
+		return nil
+	}
+	_, err := pool.Delete(m.key)
+	return err
 }
 
 func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
@@ -77,8 +140,21 @@ func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
 
 	// A possible simple optimization here would be to be able to lock single map values
 	// individually instead of the whole map, since we lock for some amount of time
-	m.recLock.RLock()
-	defer m.recLock.RUnlock()
+	m.recLock.Lock()
+	defer m.recLock.Unlock()
+
+	m.expire(time.Now())
+
+	leaseTime := handlers.Jitter(time.Duration(m.LeaseTime), m.Jitter, duid)
+	exclude := m.ExcludeLength != 0 && req.IsOptionRequested(dhcpv6.OptionPDExclude)
+
+	// A Renew or Rebind only extends the prefixes the client already has
+	if req.MessageType == dhcpv6.MessageTypeRenew || req.MessageType == dhcpv6.MessageTypeRebind {
+		for _, iapd := range req.Options.IAPD() {
+			resp.AddOption(m.extend(req.MessageType == dhcpv6.MessageTypeRebind, duid, iapd, leaseTime, exclude))
+		}
+		return next()
+	}
 
 	// Each request IA_PD requires an IA_PD response
 	for _, iapd := range req.Options.IAPD() {
@@ -111,13 +187,13 @@ func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
 		for hintIdx, h := range hints {
 			for leaseIdx := range knownLeases {
 				if samePrefix(h.Prefix, &knownLeases[leaseIdx].Prefix) {
-					expire := time.Now().Add(time.Duration(m.LeaseTime))
+					expire := time.Now().Add(leaseTime)
 					if knownLeases[leaseIdx].Expire.Before(expire) {
 						knownLeases[leaseIdx].Expire = expire
 					}
 					satisfied.Set(uint(hintIdx))
 					givenOut.Set(uint(leaseIdx))
-					addPrefix(iapdResp, knownLeases[leaseIdx])
+					m.addPrefix(iapdResp, knownLeases[leaseIdx], exclude)
 				}
 			}
 		}
@@ -142,13 +218,13 @@ func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
 						continue
 					}
 				}
-				expire := time.Now().Add(time.Duration(m.LeaseTime))
+				expire := time.Now().Add(leaseTime)
 				if knownLeases[leaseIdx].Expire.Before(expire) {
 					knownLeases[leaseIdx].Expire = expire
 				}
 				satisfied.Set(uint(hintIdx))
 				givenOut.Set(uint(leaseIdx))
-				addPrefix(iapdResp, knownLeases[leaseIdx])
+				m.addPrefix(iapdResp, knownLeases[leaseIdx], exclude)
 			}
 		}
 
@@ -161,7 +237,7 @@ func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
 		// with an empty, or length-only hint)
 
 		// Assign a new record to satisfy the request
-		var newLeases []record
+		allocatedAny := false
 		for i, prefix := range hints {
 			if satisfied.Test(uint(i)) {
 				continue
@@ -178,17 +254,18 @@ func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
 				continue
 			}
 			l := record{
-				Expire: time.Now().Add(time.Duration(m.LeaseTime)),
+				Expire: time.Now().Add(leaseTime),
 				Prefix: allocated,
 			}
 
-			addPrefix(iapdResp, l)
-			newLeases = append(knownLeases, l)
+			m.addPrefix(iapdResp, l, exclude)
+			knownLeases = append(knownLeases, l)
+			allocatedAny = true
 			m.logger.Debug("allocated prefix", zap.Stringer("prefix", &allocated), zap.Stringer("duid", duidOpt), zap.ByteString("iaid", iapd.IaId[:]))
 		}
 
-		if newLeases != nil {
-			m.records[duid] = newLeases
+		if allocatedAny {
+			m.records[duid] = knownLeases
 		}
 
 		if len(iapdResp.Options.Options) == 0 {
@@ -204,6 +281,84 @@ func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
 	return next()
 }
 
+// extend handles an IA_PD of a Renew or Rebind (RFC 8415 sections 18.3.4 and 18.3.5) by extending the leases
+// of the prefixes in it in place. A prefix the client has no lease for is returned with zero lifetimes,
+// except in a Rebind, where a free prefix of the pool is delegated again, since the client may have lost the server
+// that delegated it, or this server may have restarted. An IA_PD without any prefixes gets the NoBinding status.
+// The caller must hold the lock.
+func (m *Module) extend(rebind bool, duid string, iapd *dhcpv6.OptIAPD, leaseTime time.Duration, exclude bool) *dhcpv6.OptIAPD {
+	iapdResp := &dhcpv6.OptIAPD{IaId: iapd.IaId}
+	for _, p := range iapd.Options.Prefixes() {
+		known := m.records[duid]
+		idx := slices.IndexFunc(known, func(r record) bool { return samePrefix(p.Prefix, &r.Prefix) })
+		if idx < 0 && rebind && m.reclaim(p.Prefix) {
+			m.logger.Debug("delegated prefix again on rebind", zap.Stringer("prefix", p.Prefix), zap.String("duid", duid))
+			known = append(known, record{Prefix: *dup(p.Prefix)})
+			m.records[duid] = known
+			idx = len(known) - 1
+		}
+		if idx < 0 {
+			m.logger.Debug("no lease for prefix", zap.Stringer("prefix", p.Prefix), zap.String("duid", duid))
+			if p.Prefix != nil {
+				iapdResp.Options.Add(&dhcpv6.OptIAPrefix{Prefix: dup(p.Prefix)})
+			}
+			continue
+		}
+		if expire := time.Now().Add(leaseTime); known[idx].Expire.Before(expire) {
+			known[idx].Expire = expire
+		}
+		m.addPrefix(iapdResp, known[idx], exclude)
+	}
+	if len(iapdResp.Options.Options) == 0 {
+		iapdResp.Options.Add(&dhcpv6.OptStatusCode{
+			StatusCode: dhcpIana.StatusNoBinding,
+		})
+	}
+	return iapdResp
+}
+
+// reclaim allocates exactly the given prefix, if it is a free prefix of the pool with the allocation size.
+func (m *Module) reclaim(prefix *net.IPNet) bool {
+	if prefix == nil {
+		return false
+	}
+	if ones, bits := prefix.Mask.Size(); ones != m.AllocationSize || bits != 128 {
+		return false
+	}
+	allocated, err := m.allocator.Allocate(*prefix)
+	if err != nil {
+		return false
+	}
+	if !samePrefix(&allocated, prefix) {
+		_ = m.allocator.Free(allocated)
+		return false
+	}
+	return true
+}
+
+// expire removes the leases that expired before now and returns their prefixes to the pool.
+// The caller must hold the lock.
+func (m *Module) expire(now time.Time) {
+	for duid, known := range m.records {
+		kept := known[:0]
+		for _, l := range known {
+			if l.Expire.Before(now) {
+				m.logger.Debug("prefix lease expired", zap.Stringer("prefix", &l.Prefix), zap.String("duid", duid))
+				if err := m.allocator.Free(l.Prefix); err != nil {
+					m.logger.Warn("could not free expired prefix", zap.Stringer("prefix", &l.Prefix), zap.Error(err))
+				}
+				continue
+			}
+			kept = append(kept, l)
+		}
+		if len(kept) == 0 {
+			delete(m.records, duid)
+		} else {
+			m.records[duid] = kept
+		}
+	}
+}
+
 // samePrefix returns true if both prefixes are defined and equal
 // The empty prefix is equal to nothing, not even itself
 func samePrefix(a, b *net.IPNet) bool {
@@ -213,14 +368,33 @@ func samePrefix(a, b *net.IPNet) bool {
 	return a.IP.Equal(b.IP) && bytes.Equal(a.Mask, b.Mask)
 }
 
-func addPrefix(resp *dhcpv6.OptIAPD, l record) {
+func (m *Module) addPrefix(resp *dhcpv6.OptIAPD, l record, exclude bool) {
 	lifetime := time.Until(l.Expire)
 
-	resp.Options.Add(&dhcpv6.OptIAPrefix{
+	iaPrefix := &dhcpv6.OptIAPrefix{
 		PreferredLifetime: lifetime,
 		ValidLifetime:     lifetime,
 		Prefix:            dup(&l.Prefix),
-	})
+	}
+	if ones, _ := l.Prefix.Mask.Size(); exclude && m.ExcludeLength > ones {
+		iaPrefix.Options.Add(pdExclude(ones, m.ExcludeLength, m.ExcludeSubnetID))
+	}
+	resp.Options.Add(iaPrefix)
+}
+
+// pdExclude returns the Prefix Exclude option (RFC 6603 section 4.2) for the sub-prefix of the given length
+// with the given subnet ID, within a delegated prefix of length ones. The option holds the length of the
+// excluded prefix, followed by its bits after the delegated prefix, left-aligned in as few bytes as possible.
+func pdExclude(ones, length int, subnetID uint64) dhcpv6.Option {
+	bits := length - ones
+	data := make([]byte, 1+(bits+7)/8)
+	data[0] = byte(length)
+	for i := 0; i < bits; i++ {
+		if shift := bits - 1 - i; shift < 64 && subnetID>>shift&1 == 1 {
+			data[1+i/8] |= 0x80 >> (i % 8)
+		}
+	}
+	return &dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionPDExclude, OptionData: data}
 }
 
 func dup(src *net.IPNet) (dst *net.IPNet) {
@@ -233,7 +407,45 @@ func dup(src *net.IPNet) (dst *net.IPNet) {
 	return dst
 }
 
+// HasLease6 reports whether the client of the request has a delegated prefix from the pool,
+// so handling the request renews the prefix instead of allocating a new one.
+func (m *Module) HasLease6(req handlers.DHCPv6) bool {
+	duidOpt := req.Options.ClientID()
+	if duidOpt == nil {
+		return false
+	}
+	duid := hex.EncodeToString(duidOpt.ToBytes())
+	m.recLock.RLock()
+	defer m.recLock.RUnlock()
+	return len(m.records[duid]) > 0
+}
+
+// Available returns the number of prefixes in the pool that are not allocated.
+func (m *Module) Available() int {
+	return m.allocator.Available()
+}
+
+// Total returns the number of prefixes in the pool.
+func (m *Module) Total() int {
+	return m.allocator.Total()
+}
+
+// PoolStats returns the utilization of the pool.
+func (m *Module) PoolStats() handlers.PoolStats {
+	total, free := m.allocator.Total(), m.allocator.Available()
+	return handlers.PoolStats{
+		Handler:          "prefix",
+		Pool:             m.key,
+		Total:            total,
+		Used:             total - free,
+		Free:             free,
+		LargestFreeBlock: m.allocator.LargestFree(),
+	}
+}
+
 // Interfaces guards
 var (
 	_ handlers.HandlerModule = (*Module)(nil)
+	_ caddy.CleanerUpper     = (*Module)(nil)
+	_ handlers.PoolReporter  = (*Module)(nil)
 )
@@ -0,0 +1,214 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package prefix
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// solicit sends a Solicit with a single IA_PD to the module and returns the parsed IA_Prefix in the reply.
+func solicit(t *testing.T, m *Module, requestExclude bool) *dhcpv6.OptIAPrefix {
+	modifiers := []dhcpv6.Modifier{dhcpv6.WithIAPD([4]byte{0, 0, 0, 1})}
+	if requestExclude {
+		modifiers = append(modifiers, dhcpv6.WithRequestedOptions(dhcpv6.OptionPDExclude))
+	}
+	req, err := dhcpv6.NewSolicit(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, modifiers...)
+	require.NoError(t, err)
+	resp, err := dhcpv6.NewAdvertiseFromSolicit(req)
+	require.NoError(t, err)
+	require.NoError(t, m.Handle6(handlers.DHCPv6{Message: req}, handlers.DHCPv6{Message: resp}, func() error { return nil }))
+
+	parsed, err := dhcpv6.MessageFromBytes(resp.ToBytes())
+	require.NoError(t, err)
+	iapd := parsed.Options.IAPD()
+	require.Len(t, iapd, 1)
+	prefixes := iapd[0].Options.Prefixes()
+	require.Len(t, prefixes, 1)
+	return prefixes[0]
+}
+
+func TestPrefixExclude(t *testing.T) {
+	m := &Module{Prefix: "2001:db8::/40", AllocationSize: 48, ExcludeLength: 64, ExcludeSubnetID: 1}
+	require.NoError(t, m.Provision(caddy.Context{}))
+	t.Cleanup(func() { _ = m.Cleanup() })
+
+	prefix := solicit(t, m, true)
+	assert.Equal(t, "2001:db8::/48", prefix.Prefix.String())
+	// the excluded prefix is 2001:db8:0:1::/64, i.e. bits 48 to 64 hold subnet ID 1
+	assert.Equal(t, []byte{64, 0x00, 0x01}, prefix.Options.GetOne(dhcpv6.OptionPDExclude).ToBytes())
+
+	// the option is only included when the client requests it
+	prefix = solicit(t, m, false)
+	assert.Nil(t, prefix.Options.GetOne(dhcpv6.OptionPDExclude))
+}
+
+func TestHasLease6(t *testing.T) {
+	m := &Module{Prefix: "2001:db8::/40", AllocationSize: 48}
+	require.NoError(t, m.Provision(caddy.Context{}))
+	t.Cleanup(func() { _ = m.Cleanup() })
+
+	req, err := dhcpv6.NewSolicit(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	assert.False(t, m.HasLease6(handlers.DHCPv6{Message: req}))
+	solicit(t, m, false)
+	assert.True(t, m.HasLease6(handlers.DHCPv6{Message: req}))
+}
+
+func TestPDExclude(t *testing.T) {
+	for _, tc := range []struct {
+		ones, length int
+		subnetID     uint64
+		want         []byte
+	}{
+		{56, 64, 0, []byte{64, 0x00}},
+		{56, 60, 0xa, []byte{60, 0xa0}},
+		{48, 60, 0x123, []byte{60, 0x12, 0x30}},
+		{48, 49, 1, []byte{49, 0x80}},
+		{0, 128, 1, []byte{128, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}},
+	} {
+		assert.Equal(t, tc.want, pdExclude(tc.ones, tc.length, tc.subnetID).ToBytes())
+	}
+}
+
+func TestProvisionExclude(t *testing.T) {
+	for _, m := range []*Module{
+		{Prefix: "2001:db8::/40", AllocationSize: 48, ExcludeLength: 48},
+		{Prefix: "2001:db8::/40", AllocationSize: 48, ExcludeLength: 129},
+		{Prefix: "2001:db8::/40", AllocationSize: 48, ExcludeLength: 56, ExcludeSubnetID: 256},
+	} {
+		assert.Error(t, m.Provision(caddy.Context{}))
+	}
+}
+
+func TestPrefixJitter(t *testing.T) {
+	m := &Module{Prefix: "2001:db8::/40", AllocationSize: 48, LeaseTime: caddy.Duration(time.Hour), Jitter: 10}
+	require.NoError(t, m.Provision(caddy.Context{}))
+	t.Cleanup(func() { _ = m.Cleanup() })
+
+	prefix := solicit(t, m, false)
+	assert.GreaterOrEqual(t, prefix.ValidLifetime, 54*time.Minute-time.Second)
+	assert.LessOrEqual(t, prefix.ValidLifetime, 66*time.Minute)
+	// the lease time is the same when the same client solicits again
+	again := solicit(t, m, false)
+	assert.InDelta(t, prefix.ValidLifetime.Seconds(), again.ValidLifetime.Seconds(), 1)
+}
+
+func TestConfigReload(t *testing.T) {
+	old := &Module{Prefix: "2001:db8::/40", AllocationSize: 48, LeaseTime: caddy.Duration(time.Hour)}
+	require.NoError(t, old.Provision(caddy.Context{}))
+	prefix := solicit(t, old, false)
+
+	// a config reload provisions the new handler before the old one is cleaned up
+	m := &Module{Prefix: "2001:db8::/40", AllocationSize: 48, LeaseTime: caddy.Duration(time.Hour)}
+	require.NoError(t, m.Provision(caddy.Context{}))
+	t.Cleanup(func() { _ = m.Cleanup() })
+	require.NoError(t, old.Cleanup())
+
+	// the prefix delegated by the old handler is not delegated again to another client
+	req, err := dhcpv6.NewSolicit(net.HardwareAddr{0x02, 0, 0, 0, 0, 2}, dhcpv6.WithIAPD([4]byte{0, 0, 0, 1}))
+	require.NoError(t, err)
+	resp, err := dhcpv6.NewAdvertiseFromSolicit(req)
+	require.NoError(t, err)
+	require.NoError(t, m.Handle6(handlers.DHCPv6{Message: req}, handlers.DHCPv6{Message: resp}, func() error { return nil }))
+	prefixes := resp.Options.IAPD()[0].Options.Prefixes()
+	require.Len(t, prefixes, 1)
+	assert.NotEqual(t, prefix.Prefix.String(), prefixes[0].Prefix.String())
+	assert.Equal(t, 254, m.Available())
+}
+
+// extend sends a Renew or Rebind for the given prefix to the module and returns the IA_PD in the reply.
+func extend(t *testing.T, m *Module, msgType dhcpv6.MessageType, prefix *net.IPNet) *dhcpv6.OptIAPD {
+	req, err := dhcpv6.NewSolicit(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	req.MessageType = msgType
+	iapd := &dhcpv6.OptIAPD{IaId: [4]byte{0, 0, 0, 1}}
+	if prefix != nil {
+		iapd.Options.Add(&dhcpv6.OptIAPrefix{Prefix: prefix})
+	}
+	req.AddOption(iapd)
+	resp, err := dhcpv6.NewReplyFromMessage(req)
+	require.NoError(t, err)
+	require.NoError(t, m.Handle6(handlers.DHCPv6{Message: req}, handlers.DHCPv6{Message: resp}, func() error { return nil }))
+
+	iapds := resp.Options.IAPD()
+	require.Len(t, iapds, 1)
+	return iapds[0]
+}
+
+func TestRenew(t *testing.T) {
+	m := &Module{Prefix: "2001:db8::/40", AllocationSize: 48, LeaseTime: caddy.Duration(time.Hour)}
+	require.NoError(t, m.Provision(caddy.Context{}))
+	t.Cleanup(func() { _ = m.Cleanup() })
+
+	prefix := solicit(t, m, false)
+	for _, known := range m.records {
+		known[0].Expire = time.Now().Add(time.Minute)
+	}
+
+	// the lease is extended in place, without delegating another prefix
+	iapd := extend(t, m, dhcpv6.MessageTypeRenew, prefix.Prefix)
+	prefixes := iapd.Options.Prefixes()
+	require.Len(t, prefixes, 1)
+	assert.Equal(t, prefix.Prefix.String(), prefixes[0].Prefix.String())
+	assert.InDelta(t, time.Hour.Seconds(), prefixes[0].ValidLifetime.Seconds(), 1)
+	assert.Equal(t, 255, m.Available())
+
+	// a prefix the client has no lease for is returned with zero lifetimes
+	_, other, _ := net.ParseCIDR("2001:db8:1::/48")
+	iapd = extend(t, m, dhcpv6.MessageTypeRenew, other)
+	prefixes = iapd.Options.Prefixes()
+	require.Len(t, prefixes, 1)
+	assert.Zero(t, prefixes[0].ValidLifetime)
+	assert.Equal(t, 255, m.Available())
+}
+
+func TestRebindAfterRestart(t *testing.T) {
+	old := &Module{Prefix: "2001:db8::/40", AllocationSize: 48, LeaseTime: caddy.Duration(time.Hour)}
+	require.NoError(t, old.Provision(caddy.Context{}))
+	prefix := solicit(t, old, false)
+	require.NoError(t, old.Cleanup())
+
+	// the new server does not know the prefix, but delegates it again since it is free
+	m := &Module{Prefix: "2001:db8::/40", AllocationSize: 48, LeaseTime: caddy.Duration(time.Hour)}
+	require.NoError(t, m.Provision(caddy.Context{}))
+	t.Cleanup(func() { _ = m.Cleanup() })
+	iapd := extend(t, m, dhcpv6.MessageTypeRebind, prefix.Prefix)
+	prefixes := iapd.Options.Prefixes()
+	require.Len(t, prefixes, 1)
+	assert.Equal(t, prefix.Prefix.String(), prefixes[0].Prefix.String())
+	assert.InDelta(t, time.Hour.Seconds(), prefixes[0].ValidLifetime.Seconds(), 1)
+	assert.Equal(t, 255, m.Available())
+
+	// a prefix outside of the pool is not delegated
+	_, outside, _ := net.ParseCIDR("2001:db9::/48")
+	iapd = extend(t, m, dhcpv6.MessageTypeRebind, outside)
+	prefixes = iapd.Options.Prefixes()
+	require.Len(t, prefixes, 1)
+	assert.Zero(t, prefixes[0].ValidLifetime)
+
+	// a Renew for an IA_PD without prefixes has no binding
+	iapd = extend(t, m, dhcpv6.MessageTypeRenew, nil)
+	assert.Equal(t, iana.StatusNoBinding, iapd.Options.Status().StatusCode)
+}
+
+func TestExpire(t *testing.T) {
+	m := &Module{Prefix: "2001:db8::/40", AllocationSize: 48, LeaseTime: caddy.Duration(time.Hour)}
+	require.NoError(t, m.Provision(caddy.Context{}))
+	t.Cleanup(func() { _ = m.Cleanup() })
+
+	solicit(t, m, false)
+	m.expire(time.Now().Add(time.Hour + time.Minute))
+	assert.Empty(t, m.records)
+	assert.Equal(t, 256, m.Available())
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+// PXEClient is the class identifier prefix sent by PXE clients. PXE clients only use the PXE sub-options
+// of a reply whose class identifier is PXEClient.
+const PXEClient = "PXEClient"
+
+// PXE sub-options of the Vendor Specific Information option (43), see the PXE specification v2.1.
+const (
+	pxeDiscoveryControl = 6
+	pxeBootServers      = 8
+	pxeBootMenu         = 9
+	pxeMenuPrompt       = 10
+	pxeBootItem         = 71
+	pxeEnd              = 255
+)
+
+// PXEOptions are the PXE sub-options of the Vendor Specific Information option (43).
+type PXEOptions struct {
+	// The discovery control bits (sub-option 6).
+	DiscoveryControl uint8
+
+	// The type of the boot servers and the boot item, where 0 is the PXE bootstrap server.
+	BootServerType uint16
+
+	// The IPv4 addresses of the boot servers of BootServerType (sub-option 8). Not sent when empty.
+	BootServers []net.IP
+
+	// The entries of the boot menu (sub-option 9). Not sent when empty.
+	BootMenu []PXEMenuEntry
+
+	// The prompt displayed before showing the boot menu, and the number of seconds to wait
+	// for a key press (sub-option 10). Not sent when the prompt is empty.
+	MenuPrompt  string
+	MenuTimeout uint8
+
+	// Whether to send the boot item (sub-option 71) of BootServerType, with layer 0.
+	BootItem bool
+}
+
+// PXEMenuEntry is a single entry of a PXE boot menu.
+type PXEMenuEntry struct {
+	// The boot server type, where 0 is a local boot.
+	Type uint16
+
+	// The text shown in the menu.
+	Description string
+}
+
+// Encode returns the value of the Vendor Specific Information option holding the PXE sub-options.
+func (o PXEOptions) Encode() ([]byte, error) {
+	option := []byte{pxeDiscoveryControl, 1, o.DiscoveryControl}
+	if len(o.BootServers) > 0 {
+		servers := []byte{byte(o.BootServerType >> 8), byte(o.BootServerType), byte(len(o.BootServers))}
+		for _, s := range o.BootServers {
+			ip := s.To4()
+			if ip == nil {
+				return nil, fmt.Errorf("invalid PXE boot server %s, expected an IPv4 address", s)
+			}
+			servers = append(servers, ip...)
+		}
+		if len(servers) > math.MaxUint8 {
+			return nil, fmt.Errorf("too many PXE boot servers: %d", len(o.BootServers))
+		}
+		option = append(option, pxeBootServers, byte(len(servers)))
+		option = append(option, servers...)
+	}
+	if len(o.BootMenu) > 0 {
+		var menu []byte
+		for _, entry := range o.BootMenu {
+			if len(entry.Description) > math.MaxUint8 {
+				return nil, fmt.Errorf("description of menu entry %d is too long: %q", entry.Type, entry.Description)
+			}
+			menu = append(menu, byte(entry.Type>>8), byte(entry.Type), byte(len(entry.Description)))
+			menu = append(menu, entry.Description...)
+		}
+		if len(menu) > math.MaxUint8 {
+			return nil, fmt.Errorf("boot menu is too long: %d bytes", len(menu))
+		}
+		option = append(option, pxeBootMenu, byte(len(menu)))
+		option = append(option, menu...)
+	}
+	if o.MenuPrompt != "" {
+		if len(o.MenuPrompt) > math.MaxUint8-1 {
+			return nil, fmt.Errorf("prompt is too long: %q", o.MenuPrompt)
+		}
+		option = append(option, pxeMenuPrompt, byte(len(o.MenuPrompt)+1), o.MenuTimeout)
+		option = append(option, o.MenuPrompt...)
+	}
+	if o.BootItem {
+		option = append(option, pxeBootItem, 4, byte(o.BootServerType>>8), byte(o.BootServerType), 0, 0)
+	}
+	return append(option, pxeEnd), nil
+}
+
+// IsPXERequest returns whether the request is from a PXE client that requests the Vendor Specific Information option.
+func IsPXERequest(req DHCPv4) bool {
+	return strings.HasPrefix(req.ClassIdentifier(), PXEClient) && req.IsOptionRequested(dhcpv4.OptionVendorSpecificInformation)
+}
+
+// SetPXEOptions sets the Vendor Specific Information option of the reply to the encoded PXE sub-options,
+// and its class identifier to PXEClient.
+func SetPXEOptions(resp DHCPv4, option []byte) {
+	resp.UpdateOption(dhcpv4.OptClassIdentifier(PXEClient))
+	resp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionVendorSpecificInformation, option))
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPXEOptionsEncode(t *testing.T) {
+	option, err := PXEOptions{
+		DiscoveryControl: 7,
+		BootServerType:   0x8001,
+		BootServers:      []net.IP{net.IPv4(10, 0, 0, 1)},
+		BootMenu:         []PXEMenuEntry{{Type: 0x8001, Description: "Linux"}},
+		MenuPrompt:       "Boot",
+		MenuTimeout:      10,
+		BootItem:         true,
+	}.Encode()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{
+		// discovery control
+		6, 1, 7,
+		// boot servers
+		8, 7, 0x80, 0x01, 1, 10, 0, 0, 1,
+		// boot menu
+		9, 8, 0x80, 0x01, 5, 'L', 'i', 'n', 'u', 'x',
+		// menu prompt
+		10, 5, 10, 'B', 'o', 'o', 't',
+		// boot item
+		71, 4, 0x80, 0x01, 0, 0,
+		// end
+		255,
+	}, option)
+
+	option, err = PXEOptions{}.Encode()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{6, 1, 0, 255}, option)
+
+	for _, o := range []PXEOptions{
+		{BootServers: []net.IP{net.ParseIP("2001:db8::1")}},
+		{BootServers: make([]net.IP, 64)},
+		{BootMenu: []PXEMenuEntry{{Description: strings.Repeat("x", 256)}}},
+		{BootMenu: []PXEMenuEntry{{Description: strings.Repeat("x", 200)}, {Description: strings.Repeat("x", 200)}}},
+		{MenuPrompt: strings.Repeat("x", 255)},
+	} {
+		_, err := o.Encode()
+		assert.Error(t, err)
+	}
+}
+
+func TestIsPXERequest(t *testing.T) {
+	for _, tc := range []struct {
+		class     string
+		requested bool
+		expected  bool
+	}{
+		{"PXEClient:Arch:00000:UNDI:002001", true, true},
+		{"PXEClient", false, false},
+		{"HTTPClient:Arch:00016:UNDI:003001", true, false},
+	} {
+		modifiers := []dhcpv4.Modifier{dhcpv4.WithOption(dhcpv4.OptClassIdentifier(tc.class))}
+		if tc.requested {
+			modifiers = append(modifiers, dhcpv4.WithRequestedOptions(dhcpv4.OptionVendorSpecificInformation))
+		}
+		req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, modifiers...)
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, IsPXERequest(NewDHCPv4(req)), tc.class)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package pxemenu
+
+import (
+	"fmt"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/lion7/caddydhcp/handlers"
+	"go.uber.org/zap"
+)
+
+// Module offers a PXE boot menu to PXE clients, i.e. clients whose class identifier (option 60)
+// starts with "PXEClient". The menu is encoded into the PXE sub-options of the Vendor Specific
+// Information option (43) together with the discovery control and the menu prompt.
+type Module struct {
+	handlers.Base
+
+	// The entries of the boot menu.
+	Entries []MenuEntry `json:"entries"`
+
+	// The prompt displayed before showing the menu. No prompt is sent when empty.
+	Prompt string `json:"prompt,omitempty"`
+
+	// The number of seconds to wait for a key press before booting the first entry.
+	// Zero boots the first entry immediately, 255 waits for a key press forever.
+	Timeout uint8 `json:"timeout,omitempty"`
+
+	// The PXE discovery control bits (sub-option 6).
+	DiscoveryControl uint8 `json:"discoveryControl,omitempty"`
+
+	option []byte
+	logger *zap.Logger
+}
+
+// MenuEntry is a single entry of the PXE boot menu.
+type MenuEntry struct {
+	// The boot server type, where 0 is a local boot.
+	Type uint16 `json:"type"`
+
+	// The text shown in the menu.
+	Description string `json:"description"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (Module) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "dhcp.handlers.pxemenu",
+		New: func() caddy.Module { return new(Module) },
+	}
+}
+
+// Provision is run immediately after this handler is being loaded.
+func (m *Module) Provision(ctx caddy.Context) error {
+	m.logger = ctx.Logger()
+	if len(m.Entries) == 0 {
+		return fmt.Errorf("no menu entries configured")
+	}
+
+	options := handlers.PXEOptions{
+		DiscoveryControl: m.DiscoveryControl,
+		MenuPrompt:       m.Prompt,
+		MenuTimeout:      m.Timeout,
+	}
+	for _, entry := range m.Entries {
+		options.BootMenu = append(options.BootMenu, handlers.PXEMenuEntry{Type: entry.Type, Description: entry.Description})
+	}
+	var err error
+	m.option, err = options.Encode()
+	return err
+}
+
+// Handle4 handles DHCPv4 packets for this plugin.
+func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
+	if handlers.IsPXERequest(req) {
+		handlers.SetPXEOptions(resp, m.option)
+	}
+	return next()
+}
+
+// Interfaces guards
+var (
+	_ handlers.HandlerModule = (*Module)(nil)
+)
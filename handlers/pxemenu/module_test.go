@@ -0,0 +1,64 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package pxemenu
+
+import (
+	"net"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/lion7/caddydhcp/handlers/handlertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// discover runs the module for a DHCPv4 discover with the given class identifier, requesting the vendor options.
+func discover(t *testing.T, m *Module, classId string) *dhcpv4.DHCPv4 {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+		dhcpv4.WithOption(dhcpv4.OptClassIdentifier(classId)),
+		dhcpv4.WithRequestedOptions(dhcpv4.OptionVendorSpecificInformation),
+	)
+	require.NoError(t, err)
+	resp, err := handlertest.Handle4(t, m, handlers.NewDHCPv4(req), nil)
+	require.NoError(t, err)
+	return resp
+}
+
+func TestHandle4(t *testing.T) {
+	m := &Module{
+		Entries: []MenuEntry{
+			{Type: 0, Description: "Local"},
+			{Type: 0x8000, Description: "Linux"},
+		},
+		Prompt:  "Boot",
+		Timeout: 10,
+	}
+	require.NoError(t, m.Provision(caddy.Context{}))
+
+	resp := discover(t, m, "PXEClient:Arch:00000:UNDI:002001")
+	assert.Equal(t, "PXEClient", resp.ClassIdentifier())
+	assert.Equal(t, []byte{
+		// discovery control
+		6, 1, 0,
+		// boot menu
+		9, 16,
+		0x00, 0x00, 5, 'L', 'o', 'c', 'a', 'l',
+		0x80, 0x00, 5, 'L', 'i', 'n', 'u', 'x',
+		// menu prompt
+		10, 5, 10, 'B', 'o', 'o', 't',
+		// end
+		255,
+	}, resp.Options.Get(dhcpv4.OptionVendorSpecificInformation))
+}
+
+func TestHandle4NotPXE(t *testing.T) {
+	m := &Module{Entries: []MenuEntry{{Type: 0, Description: "Local"}}}
+	require.NoError(t, m.Provision(caddy.Context{}))
+
+	resp := discover(t, m, "HTTPClient:Arch:00016:UNDI:003001")
+	assert.Nil(t, resp.Options.Get(dhcpv4.OptionVendorSpecificInformation))
+}
@@ -0,0 +1,473 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package quota
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	dhcpIana "github.com/insomniacslk/dhcp/iana"
+	"github.com/lion7/caddydhcp/handlers"
+	"go.uber.org/zap"
+)
+
+// Module limits the number of active leases that the pools of its handlers, like range and prefix, hand out
+// per client key. This keeps a single client, or a client cycling through MAC addresses or DUIDs behind
+// the same relay agent port, from exhausting a pool. The leases are read from the replies of the handlers:
+// the yiaddr of DHCPv4 offers and acknowledgements, and the addresses and prefixes of DHCPv6 replies.
+//
+// A client without a lease is refused once its key holds the maximum number of leases: a DHCPDISCOVER is dropped,
+// a DHCPREQUEST is answered with a DHCPNAK, and a DHCPv6 client gets the NoAddrsAvail or NoPrefixAvail status.
+// Clients holding a lease can still renew it, but a DHCPv6 client does not get more leases than the maximum:
+// its IA_NAs and IA_PDs beyond the maximum are refused before the handlers see them, so they allocate nothing for them.
+// A lease stops counting when it expires, or when the client releases it with a DHCPRELEASE or DHCPv6 Release.
+//
+//	{
+//	  "handler": "quota",
+//	  "max": 4,
+//	  "key": "circuitId",
+//	  "handle": [{"handler": "range", "start": "10.0.0.100", "end": "10.0.0.199"}]
+//	}
+type Module struct {
+	// The maximum number of active leases of a client key, at least 1.
+	Max int `json:"max"`
+
+	// What identifies a client: `clientId` (the default) counts the leases of a client by its Client Identifier
+	// option (61), or else its MAC address, and its DUID for DHCPv6; `mac` by the MAC address of the client,
+	// which for DHCPv6 is taken from the Client Link-Layer Address option of the relay agent or the DUID;
+	// and `circuitId` by the relay agent port of the client, i.e. the Circuit ID sub-option of the Relay Agent
+	// Information option (82) or the Interface-Id option (18). Requests without a key are not limited.
+	Key string `json:"key,omitempty"`
+
+	// How long a DHCPv4 lease counts against the quota when the reply has no lease time option (51).
+	// Defaults to one hour.
+	LeaseTime caddy.Duration `json:"leaseTime,omitempty"`
+
+	// The handlers to run for an accepted request, like range and prefix.
+	HandlersRaw []json.RawMessage `json:"handle,omitempty" caddy:"namespace=dhcp.handlers inline_key=handler"`
+
+	chain  handlers.Chain
+	logger *zap.Logger
+	key    string
+	// now returns the current time, which defaults to time.Now.
+	now func() time.Time
+	*leases
+}
+
+// leases is the lease state of a quota. It is shared by all quota handlers with the same configuration,
+// so a handler provisioned by a config reload keeps counting the leases the handler it replaces counted.
+type leases struct {
+	mu *sync.Mutex
+	// byKey holds the active leases by client key, and then by address or prefix.
+	byKey map[string]map[string]lease
+}
+
+// Destruct implements caddy.Destructor; the leases only live in memory, so there is nothing to release.
+func (l *leases) Destruct() error {
+	return nil
+}
+
+// pool holds the leases of the provisioned quota handlers, keyed by configuration.
+var pool = caddy.NewUsagePool()
+
+// lease is an address or prefix handed out to a client.
+type lease struct {
+	// owner identifies the client holding the lease, by its client identifier or DUID.
+	owner string
+	// ia identifies the DHCPv6 IA_NA or IA_PD of the lease, see iaKey.
+	ia     string
+	expire time.Time
+}
+
+const (
+	keyClientID  = "clientId"
+	keyMAC       = "mac"
+	keyCircuitID = "circuitId"
+)
+
+// defaultLeaseTime is the default of LeaseTime.
+const defaultLeaseTime = time.Hour
+
+// errQuota refuses a DHCPREQUEST of a client whose key holds the maximum number of leases.
+var errQuota = errors.New("lease quota exceeded")
+
+// CaddyModule returns the Caddy module information.
+func (Module) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "dhcp.handlers.quota",
+		New: func() caddy.Module { return new(Module) },
+	}
+}
+
+// Provision is run immediately after this handler is being loaded.
+func (m *Module) Provision(ctx caddy.Context) error {
+	m.logger = ctx.Logger()
+	if m.Max < 1 {
+		return fmt.Errorf("invalid maximum of %d leases, expected at least 1", m.Max)
+	}
+	switch m.Key {
+	case "", keyClientID, keyMAC, keyCircuitID:
+	default:
+		return fmt.Errorf("invalid key %q, expected one of %q, %q or %q", m.Key, keyClientID, keyMAC, keyCircuitID)
+	}
+	if m.LeaseTime == 0 {
+		m.LeaseTime = caddy.Duration(defaultLeaseTime)
+	}
+	// loading the handlers clears their raw configuration, so the key is built first
+	m.key = fmt.Sprintf("%s|%s", m.Key, m.HandlersRaw)
+
+	mods, err := handlers.LoadModule(ctx, m, "HandlersRaw")
+	if err != nil {
+		return fmt.Errorf("loading handler modules: %v", err)
+	}
+	if m.chain, err = handlers.NewChain(mods); err != nil {
+		return err
+	}
+	m.now = time.Now
+
+	val, loaded, err := pool.LoadOrNew(m.key, func() (caddy.Destructor, error) {
+		return &leases{mu: new(sync.Mutex), byKey: make(map[string]map[string]lease)}, nil
+	})
+	if err != nil {
+		return err
+	}
+	if loaded {
+		m.logger.Info("taking over the leases of the previous quota")
+	}
+	m.leases = val.(*leases)
+	return nil
+}
+
+// Cleanup releases the leases, discarding them if no other quota handler uses them.
+func (m *Module) Cleanup() error {
+	if m.leases == nil {
+		return nil
+	}
+	_, err := pool.Delete(m.key)
+	return err
+}
+
+// owner4 returns the identity of a DHCPv4 client, by its client identifier or else its MAC address.
+func owner4(req handlers.DHCPv4) string {
+	if cid := req.ClientIdentifierKey(); cid != "" {
+		return cid
+	}
+	return req.ClientHWAddr.String()
+}
+
+// key4 returns the client key of a DHCPv4 request, or an empty string if it has none.
+func (m *Module) key4(req handlers.DHCPv4) string {
+	switch m.Key {
+	case keyMAC:
+		return req.ClientHWAddr.String()
+	case keyCircuitID:
+		if rai := req.RelayAgentInfo(); rai != nil {
+			if circuitID := rai.Get(dhcpv4.AgentCircuitIDSubOption); len(circuitID) > 0 {
+				return hex.EncodeToString(circuitID)
+			}
+		}
+		return ""
+	default:
+		return owner4(req)
+	}
+}
+
+// owner6 returns the identity of a DHCPv6 client, by its DUID.
+func owner6(req handlers.DHCPv6) string {
+	if duid := req.Options.ClientID(); duid != nil {
+		return hex.EncodeToString(duid.ToBytes())
+	}
+	return ""
+}
+
+// key6 returns the client key of a DHCPv6 request, or an empty string if it has none.
+func (m *Module) key6(req handlers.DHCPv6) string {
+	switch m.Key {
+	case keyMAC:
+		if mac := req.ClientLinkLayerAddress(); mac != nil {
+			return mac.String()
+		}
+		if mac, err := dhcpv6.ExtractMAC(req.Message); err == nil {
+			return mac.String()
+		}
+		return ""
+	case keyCircuitID:
+		return hex.EncodeToString(req.InterfaceID())
+	default:
+		return owner6(req)
+	}
+}
+
+// iaKey returns the identity of a DHCPv6 IA_NA or IA_PD option, or an empty string for other options.
+func iaKey(opt dhcpv6.Option) string {
+	switch ia := opt.(type) {
+	case *dhcpv6.OptIANA:
+		return "na:" + hex.EncodeToString(ia.IaId[:])
+	case *dhcpv6.OptIAPD:
+		return "pd:" + hex.EncodeToString(ia.IaId[:])
+	}
+	return ""
+}
+
+// active returns the active leases of a client key, removing those that expired. The caller must hold the lock.
+func (m *Module) active(key string) map[string]lease {
+	leases := m.byKey[key]
+	now := m.now()
+	for id, l := range leases {
+		if !l.expire.After(now) {
+			delete(leases, id)
+		}
+	}
+	if len(leases) == 0 {
+		delete(m.byKey, key)
+		return nil
+	}
+	return leases
+}
+
+// admits reports whether a client may get a lease: if it holds one, or its key holds less than the maximum.
+// The caller must hold the lock.
+func (m *Module) admits(key, owner string) bool {
+	leases := m.active(key)
+	if len(leases) < m.Max {
+		return true
+	}
+	for _, l := range leases {
+		if l.owner == owner {
+			return true
+		}
+	}
+	return false
+}
+
+// refuse6 returns the IA_NAs and IA_PDs of a DHCPv6 request that the client may not get a lease for, by iaKey.
+// An IA is admitted if the client holds a lease for it, or else while its key holds less than the maximum,
+// counting the IAs admitted before it. The caller must hold the lock.
+func (m *Module) refuse6(key, owner string, req handlers.DHCPv6) map[string]bool {
+	leases := m.active(key)
+	held := make(map[string]bool)
+	for _, l := range leases {
+		if l.owner == owner {
+			held[l.ia] = true
+		}
+	}
+	room := m.Max - len(leases)
+	refused := make(map[string]bool)
+	for _, opt := range req.Options.Options {
+		ia := iaKey(opt)
+		switch {
+		case ia == "" || held[ia]:
+		case room > 0:
+			room--
+		default:
+			refused[ia] = true
+		}
+	}
+	return refused
+}
+
+// record adds or extends a lease of a client key. The caller must hold the lock.
+func (m *Module) record(key, id, owner, ia string, lifetime time.Duration) {
+	if m.byKey[key] == nil {
+		m.byKey[key] = make(map[string]lease)
+	}
+	m.byKey[key][id] = lease{owner: owner, ia: ia, expire: m.now().Add(lifetime)}
+}
+
+// release removes a lease of a client, if it holds it. The caller must hold the lock.
+func (m *Module) release(key, id, owner string) {
+	if l, ok := m.byKey[key][id]; ok && l.owner == owner {
+		delete(m.byKey[key], id)
+	}
+}
+
+// Handle4 handles DHCPv4 packets for this plugin.
+func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
+	key := m.key4(req)
+	if key == "" {
+		return m.chain.Handle4(req, resp, next)
+	}
+	owner := owner4(req)
+
+	switch req.MessageType() {
+	case dhcpv4.MessageTypeDiscover, dhcpv4.MessageTypeRequest:
+		m.mu.Lock()
+		admitted := m.admits(key, owner)
+		m.mu.Unlock()
+		if !admitted {
+			m.logger.Warn("client key holds the maximum number of leases, refusing new client",
+				zap.String("key", key),
+				zap.Stringer("mac", req.ClientHWAddr),
+			)
+			if req.MessageType() == dhcpv4.MessageTypeRequest {
+				return handlers.Nak(errQuota)
+			}
+			return handlers.ErrDrop
+		}
+	case dhcpv4.MessageTypeRelease:
+		m.mu.Lock()
+		m.release(key, req.ClientIPAddr.String(), owner)
+		m.mu.Unlock()
+		return m.chain.Handle4(req, resp, next)
+	default:
+		return m.chain.Handle4(req, resp, next)
+	}
+
+	nextErr := m.chain.Handle4(req, resp, next)
+	if nextErr != nil && !errors.Is(nextErr, handlers.ErrStopAndReply) {
+		return nextErr
+	}
+	if mt := resp.MessageType(); (mt == dhcpv4.MessageTypeOffer || mt == dhcpv4.MessageTypeAck) &&
+		resp.YourIPAddr != nil && !resp.YourIPAddr.IsUnspecified() {
+		lifetime := time.Duration(m.LeaseTime)
+		if resp.Options.Has(dhcpv4.OptionIPAddressLeaseTime) {
+			lifetime = resp.IPAddressLeaseTime(lifetime)
+		}
+		m.mu.Lock()
+		// a DHCPv4 client holds a single lease, so a new address replaces the previous one
+		for id, l := range m.active(key) {
+			if l.owner == owner {
+				delete(m.byKey[key], id)
+			}
+		}
+		m.record(key, resp.YourIPAddr.String(), owner, "", lifetime)
+		m.mu.Unlock()
+	}
+	return nextErr
+}
+
+// Handle6 handles DHCPv6 packets for this plugin.
+func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
+	key := m.key6(req)
+	owner := owner6(req)
+	if key == "" || owner == "" || req.Options.OneIANA() == nil && req.Options.OneIAPD() == nil {
+		return m.chain.Handle6(req, resp, next)
+	}
+
+	switch req.MessageType {
+	case dhcpv6.MessageTypeSolicit, dhcpv6.MessageTypeRequest, dhcpv6.MessageTypeRenew, dhcpv6.MessageTypeRebind:
+	case dhcpv6.MessageTypeRelease:
+		m.mu.Lock()
+		for _, iana := range req.Options.IANA() {
+			for _, addr := range iana.Options.Addresses() {
+				m.release(key, addr.IPv6Addr.String(), owner)
+			}
+		}
+		for _, iapd := range req.Options.IAPD() {
+			for _, prefix := range iapd.Options.Prefixes() {
+				if prefix.Prefix != nil {
+					m.release(key, prefix.Prefix.String(), owner)
+				}
+			}
+		}
+		m.mu.Unlock()
+		return m.chain.Handle6(req, resp, next)
+	default:
+		return m.chain.Handle6(req, resp, next)
+	}
+
+	m.mu.Lock()
+	refused := m.refuse6(key, owner, req)
+	m.mu.Unlock()
+	if len(refused) > 0 {
+		m.logger.Warn("client key holds the maximum number of leases, refusing new leases",
+			zap.String("key", key),
+			zap.String("duid", owner),
+			zap.Int("refused", len(refused)),
+		)
+		// the handlers only see the admitted IAs, so they allocate nothing for the refused ones
+		admitted := *req.Message
+		admitted.Options = dhcpv6.MessageOptions{}
+		for _, opt := range req.Options.Options {
+			if !refused[iaKey(opt)] {
+				admitted.AddOption(opt)
+				continue
+			}
+			switch ia := opt.(type) {
+			case *dhcpv6.OptIANA:
+				resp.AddOption(&dhcpv6.OptIANA{IaId: ia.IaId, Options: dhcpv6.IdentityOptions{Options: []dhcpv6.Option{
+					&dhcpv6.OptStatusCode{StatusCode: dhcpIana.StatusNoAddrsAvail, StatusMessage: errQuota.Error()},
+				}}})
+			case *dhcpv6.OptIAPD:
+				resp.AddOption(&dhcpv6.OptIAPD{IaId: ia.IaId, Options: dhcpv6.PDOptions{Options: []dhcpv6.Option{
+					&dhcpv6.OptStatusCode{StatusCode: dhcpIana.StatusNoPrefixAvail, StatusMessage: errQuota.Error()},
+				}}})
+			}
+		}
+		if admitted.Options.OneIANA() == nil && admitted.Options.OneIAPD() == nil {
+			return next()
+		}
+		req.Message = &admitted
+	}
+
+	nextErr := m.chain.Handle6(req, resp, next)
+	if nextErr != nil && !errors.Is(nextErr, handlers.ErrStopAndReply) {
+		return nextErr
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, iana := range resp.Options.IANA() {
+		kept := iana.Options.Options[:0]
+		for _, opt := range iana.Options.Options {
+			if addr, ok := opt.(*dhcpv6.OptIAAddress); ok && !m.grant(key, addr.IPv6Addr.String(), owner, iaKey(iana), addr.ValidLifetime) {
+				continue
+			}
+			kept = append(kept, opt)
+		}
+		iana.Options.Options = kept
+		if len(iana.Options.Addresses()) == 0 && iana.Options.Status() == nil {
+			iana.Options.Add(&dhcpv6.OptStatusCode{StatusCode: dhcpIana.StatusNoAddrsAvail, StatusMessage: errQuota.Error()})
+		}
+	}
+	for _, iapd := range resp.Options.IAPD() {
+		kept := iapd.Options.Options[:0]
+		for _, opt := range iapd.Options.Options {
+			if prefix, ok := opt.(*dhcpv6.OptIAPrefix); ok && prefix.Prefix != nil && !m.grant(key, prefix.Prefix.String(), owner, iaKey(iapd), prefix.ValidLifetime) {
+				continue
+			}
+			kept = append(kept, opt)
+		}
+		iapd.Options.Options = kept
+		if len(iapd.Options.Prefixes()) == 0 && iapd.Options.Status() == nil {
+			iapd.Options.Add(&dhcpv6.OptStatusCode{StatusCode: dhcpIana.StatusNoPrefixAvail, StatusMessage: errQuota.Error()})
+		}
+	}
+	return nextErr
+}
+
+// grant records an address or prefix in a DHCPv6 reply, and reports whether it may be kept in the reply.
+// A lease the client already holds is extended, and a new one is refused when its key holds the maximum number
+// of leases, which only happens when a handler hands out more than one lease for an IA. Addresses and prefixes
+// with a zero lifetime, which tell the client to stop using them, are kept. The caller must hold the lock.
+func (m *Module) grant(key, id, owner, ia string, lifetime time.Duration) bool {
+	if lifetime == 0 {
+		m.release(key, id, owner)
+		return true
+	}
+	leases := m.active(key)
+	if _, ok := leases[id]; !ok && len(leases) >= m.Max {
+		m.logger.Warn("client key holds the maximum number of leases, refusing lease",
+			zap.String("key", key),
+			zap.String("lease", id),
+			zap.String("duid", owner),
+		)
+		return false
+	}
+	m.record(key, id, owner, ia, lifetime)
+	return true
+}
+
+// Interfaces guards
+var (
+	_ handlers.HandlerModule = (*Module)(nil)
+	_ caddy.CleanerUpper     = (*Module)(nil)
+)
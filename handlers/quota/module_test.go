@@ -0,0 +1,214 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package quota
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/lion7/caddydhcp/handlers/handlertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePool hands out an address to every client, and to every IA_NA of a DHCPv6 client.
+type fakePool struct {
+	leased map[string]net.IP
+	next   byte
+}
+
+func (p *fakePool) Handle4(req, resp handlers.DHCPv4, next func() error) error {
+	ip, ok := p.leased[req.ClientHWAddr.String()]
+	if !ok {
+		p.next++
+		ip = net.IPv4(10, 0, 0, p.next).To4()
+		p.leased[req.ClientHWAddr.String()] = ip
+	}
+	resp.YourIPAddr = ip
+	return next()
+}
+
+func (p *fakePool) Handle6(req, resp handlers.DHCPv6, next func() error) error {
+	for _, ia := range req.Options.IANA() {
+		key := fmt.Sprintf("%x/%x", req.Options.ClientID().ToBytes(), ia.IaId)
+		ip, ok := p.leased[key]
+		if !ok {
+			p.next++
+			ip = net.ParseIP("2001:db8::")
+			ip[15] = p.next
+			p.leased[key] = ip
+		}
+		resp.AddOption(&dhcpv6.OptIANA{IaId: ia.IaId, Options: dhcpv6.IdentityOptions{Options: []dhcpv6.Option{
+			&dhcpv6.OptIAAddress{IPv6Addr: ip, ValidLifetime: time.Hour},
+		}}})
+	}
+	return next()
+}
+
+// withPool provisions the module, and makes it guard a fake pool.
+func withPool(t *testing.T, m *Module) *Module {
+	handlertest.Provision(t, m)
+	m.chain = handlers.Chain{&fakePool{leased: make(map[string]net.IP)}}
+	return m
+}
+
+// send4 sends a DHCPv4 request of the given type from the client with the given MAC address
+// on the relay agent port with the given circuit ID, and returns the reply and the error of the handler.
+func send4(t *testing.T, m *Module, mt dhcpv4.MessageType, mac byte, circuitID string) (*dhcpv4.DHCPv4, error) {
+	req, err := dhcpv4.New(
+		dhcpv4.WithHwAddr(net.HardwareAddr{0x02, 0, 0, 0, 0, mac}),
+		dhcpv4.WithMessageType(mt),
+		dhcpv4.WithOption(dhcpv4.OptRelayAgentInfo(dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte(circuitID)))),
+	)
+	require.NoError(t, err)
+	reply := dhcpv4.MessageTypeOffer
+	if mt != dhcpv4.MessageTypeDiscover {
+		reply = dhcpv4.MessageTypeAck
+	}
+	return handlertest.Handle4(t, m, handlers.NewDHCPv4(req), nil, dhcpv4.WithMessageType(reply))
+}
+
+func TestHandle4(t *testing.T) {
+	m := withPool(t, &Module{Max: 2, Key: "circuitId"})
+
+	// two clients on the same port get a lease
+	for mac := byte(1); mac <= 2; mac++ {
+		resp, err := send4(t, m, dhcpv4.MessageTypeDiscover, mac, "port1")
+		require.NoError(t, err)
+		assert.False(t, resp.YourIPAddr.IsUnspecified())
+	}
+
+	// a third client on the same port is refused, on another port it is not
+	_, err := send4(t, m, dhcpv4.MessageTypeDiscover, 3, "port1")
+	assert.ErrorIs(t, err, handlers.ErrDrop)
+	_, err = send4(t, m, dhcpv4.MessageTypeRequest, 3, "port1")
+	var herr handlers.HandlerError
+	require.ErrorAs(t, err, &herr)
+	assert.True(t, herr.Nak)
+	resp, err := send4(t, m, dhcpv4.MessageTypeDiscover, 4, "port2")
+	require.NoError(t, err)
+	assert.False(t, resp.YourIPAddr.IsUnspecified())
+
+	// the clients holding a lease can still renew it
+	resp, err = send4(t, m, dhcpv4.MessageTypeRequest, 1, "port1")
+	require.NoError(t, err)
+	assert.Equal(t, net.IPv4(10, 0, 0, 1).To4(), resp.YourIPAddr.To4())
+
+	// a released lease makes room for another client
+	req, err := dhcpv4.New(
+		dhcpv4.WithHwAddr(net.HardwareAddr{0x02, 0, 0, 0, 0, 2}),
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeRelease),
+		dhcpv4.WithClientIP(net.IPv4(10, 0, 0, 2)),
+		dhcpv4.WithOption(dhcpv4.OptRelayAgentInfo(dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte("port1")))),
+	)
+	require.NoError(t, err)
+	// the server runs the handlers for a DHCPRELEASE with a reply without a message type, which it does not send
+	_, err = handlertest.Handle4(t, m, handlers.NewDHCPv4(req), nil)
+	require.NoError(t, err)
+	_, err = send4(t, m, dhcpv4.MessageTypeDiscover, 3, "port1")
+	assert.NoError(t, err)
+}
+
+func TestExpire(t *testing.T) {
+	m := withPool(t, &Module{Max: 1, Key: "circuitId", LeaseTime: caddy.Duration(time.Minute)})
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	_, err := send4(t, m, dhcpv4.MessageTypeDiscover, 1, "port1")
+	require.NoError(t, err)
+	_, err = send4(t, m, dhcpv4.MessageTypeDiscover, 2, "port1")
+	assert.ErrorIs(t, err, handlers.ErrDrop)
+
+	// once the lease of the first client expires, the second client gets one
+	now = now.Add(2 * time.Minute)
+	_, err = send4(t, m, dhcpv4.MessageTypeDiscover, 2, "port1")
+	assert.NoError(t, err)
+}
+
+func TestConfigReload(t *testing.T) {
+	old := &Module{Max: 1, Key: "circuitId"}
+	require.NoError(t, old.Provision(caddy.Context{}))
+	old.chain = handlers.Chain{&fakePool{leased: make(map[string]net.IP)}}
+	_, err := send4(t, old, dhcpv4.MessageTypeDiscover, 1, "port1")
+	require.NoError(t, err)
+
+	// a config reload provisions the new handler before the old one is cleaned up
+	m := &Module{Max: 1, Key: "circuitId"}
+	require.NoError(t, m.Provision(caddy.Context{}))
+	t.Cleanup(func() { _ = m.Cleanup() })
+	m.chain = old.chain
+	require.NoError(t, old.Cleanup())
+
+	// the lease counted by the old handler still counts against the quota
+	_, err = send4(t, m, dhcpv4.MessageTypeDiscover, 2, "port1")
+	assert.ErrorIs(t, err, handlers.ErrDrop)
+}
+
+// solicit6 sends a DHCPv6 Solicit with the given number of IA_NAs from the client with the given MAC address,
+// and returns the IA_NAs of the reply by IAID.
+func solicit6(t *testing.T, m *Module, mac byte, iaNAs int) map[byte]*dhcpv6.OptIANA {
+	req, err := dhcpv6.NewSolicit(net.HardwareAddr{0x02, 0, 0, 0, 0, mac})
+	require.NoError(t, err)
+	// a DUID-LL, since the DUID-LLT of NewSolicit changes every second
+	req.UpdateOption(dhcpv6.OptClientID(&dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, mac}}))
+	req.Options.Del(dhcpv6.OptionIANA)
+	for i := 0; i < iaNAs; i++ {
+		req.AddOption(&dhcpv6.OptIANA{IaId: [4]byte{0, 0, 0, byte(i)}})
+	}
+	resp, err := handlertest.Handle6(t, m, handlers.NewDHCPv6(req), nil)
+	require.NoError(t, err)
+	ianas := make(map[byte]*dhcpv6.OptIANA)
+	for _, iana := range resp.Options.IANA() {
+		ianas[iana.IaId[3]] = iana
+	}
+	return ianas
+}
+
+func TestHandle6(t *testing.T) {
+	m := withPool(t, &Module{Max: 2})
+	pool := m.chain[0].(*fakePool)
+
+	// a client asking for three addresses gets two of them
+	ianas := solicit6(t, m, 1, 3)
+	require.Len(t, ianas, 3)
+	assert.Len(t, ianas[0].Options.Addresses(), 1)
+	assert.Len(t, ianas[1].Options.Addresses(), 1)
+	assert.Empty(t, ianas[2].Options.Addresses())
+	assert.Equal(t, iana.StatusNoAddrsAvail, ianas[2].Options.Status().StatusCode)
+	// the pool only sees the admitted IA_NAs, so it allocates no address for the refused one
+	assert.Equal(t, byte(2), pool.next)
+
+	// asking again, the client keeps its two addresses and the third IA_NA is still refused
+	ianas = solicit6(t, m, 1, 3)
+	require.Len(t, ianas, 3)
+	assert.Len(t, ianas[0].Options.Addresses(), 1)
+	assert.Len(t, ianas[1].Options.Addresses(), 1)
+	assert.Empty(t, ianas[2].Options.Addresses())
+	assert.Equal(t, byte(2), pool.next)
+
+	// another client is unaffected
+	ianas = solicit6(t, m, 2, 2)
+	require.Len(t, ianas, 2)
+	assert.Len(t, ianas[0].Options.Addresses(), 1)
+	assert.Len(t, ianas[1].Options.Addresses(), 1)
+}
+
+func TestProvision(t *testing.T) {
+	for name, m := range map[string]*Module{
+		"no maximum":  {},
+		"invalid key": {Max: 1, Key: "hostname"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Error(t, m.Provision(caddy.Context{}))
+		})
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// the provisioned range handlers, which are served by the admin API
+var (
+	modulesMu sync.Mutex
+	modules   = make(map[*Module]struct{})
+)
+
+func register(m *Module) {
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+	modules[m] = struct{}{}
+}
+
+func unregister(m *Module) {
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+	delete(modules, m)
+}
+
+// registered returns the provisioned range handlers, optionally limited to those using the given lease database.
+func registered(filename string) []*Module {
+	modulesMu.Lock()
+	defer modulesMu.Unlock()
+	var ms []*Module
+	for m := range modules {
+		if filename == "" || m.Filename == filename {
+			ms = append(ms, m)
+		}
+	}
+	return ms
+}
+
+// AdminAPI is a module that serves the endpoints of the range handlers in the admin API:
+//
+//   - `GET /dhcp/range/dump` returns the leases of every range, keyed by the lease database filename.
+//   - `POST /dhcp/range/reload` re-reads the leases of every range from its lease database.
+//
+// Both endpoints accept a `filename` query parameter to select a single range.
+type AdminAPI struct{}
+
+// CaddyModule returns the Caddy module information.
+func (AdminAPI) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.dhcp_range",
+		New: func() caddy.Module { return new(AdminAPI) },
+	}
+}
+
+// Routes returns the admin routes for the range handlers.
+func (a *AdminAPI) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{
+		{
+			Pattern: "/dhcp/range/dump",
+			Handler: caddy.AdminHandlerFunc(a.handleDump),
+		},
+		{
+			Pattern: "/dhcp/range/reload",
+			Handler: caddy.AdminHandlerFunc(a.handleReload),
+		},
+	}
+}
+
+// handleDump writes the leases of the selected ranges as JSON.
+func (a *AdminAPI) handleDump(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed: %v", r.Method),
+		}
+	}
+
+	leases := make(map[string][]Lease)
+	for _, m := range registered(r.URL.Query().Get("filename")) {
+		leases[m.Filename] = append(leases[m.Filename], m.Leases()...)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(leases)
+}
+
+// handleReload reloads the leases of the selected ranges from their lease databases.
+func (a *AdminAPI) handleReload(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed: %v", r.Method),
+		}
+	}
+
+	ms := registered(r.URL.Query().Get("filename"))
+	if len(ms) == 0 {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("no range found"),
+		}
+	}
+	var errs []error
+	for _, m := range ms {
+		if err := m.Reload(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", m.Filename, err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusInternalServerError,
+			Err:        err,
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// Interfaces guards
+var (
+	_ caddy.AdminRouter = (*AdminAPI)(nil)
+)
@@ -5,17 +5,22 @@
 package rangeplugin
 
 import (
-	"database/sql"
+	"bytes"
 	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/lion7/caddydhcp/handlers/allocators"
 	"github.com/lion7/caddydhcp/handlers/allocators/bitmap"
+	"io"
 	"net"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
 	"github.com/lion7/caddydhcp/handlers"
 	"go.uber.org/zap"
@@ -30,19 +35,65 @@ func (Module) CaddyModule() caddy.ModuleInfo {
 }
 
 type Module struct {
-	Filename  string         `json:"filename"`
-	StartIP   string         `json:"startIP"`
-	EndIP     string         `json:"endIP"`
-	LeaseTime caddy.Duration `json:"leaseTime,omitempty"`
+	// Filename is the path of the SQLite database holding the leases, used when no store is configured.
+	Filename string `json:"filename,omitempty"`
+	// StoreRaw configures the store holding the leases, which is a module in the `dhcp.leasestores` namespace.
+	// Defaults to a SQLite database at Filename.
+	StoreRaw  json.RawMessage `json:"store,omitempty" caddy:"namespace=dhcp.leasestores inline_key=store"`
+	StartIP   string          `json:"startIP"`
+	EndIP     string          `json:"endIP"`
+	LeaseTime caddy.Duration  `json:"leaseTime,omitempty"`
+	// ClientIdentifier keys the leases on the Client Identifier option (61) when the client sends one,
+	// instead of on the MAC address. Leases keyed on the MAC address of the client are still honored.
+	ClientIdentifier bool `json:"clientIdentifier,omitempty"`
+	// Jitter perturbs the lease time of every client by up to plus or minus this percentage,
+	// so clients do not all renew at the same time. A client always gets the same lease time. Disabled by default.
+	Jitter int `json:"jitter,omitempty"`
+	// RenewalTime and RebindingTime are the times after which a client extends its lease with this server,
+	// or with any server respectively. They are sent as options 58 and 59 for DHCPv4 and as T1 and T2 for DHCPv6,
+	// and are perturbed like the lease time. When unset, clients use 0.5 and 0.875 times the lease time.
+	RenewalTime   caddy.Duration `json:"renewalTime,omitempty"`
+	RebindingTime caddy.Duration `json:"rebindingTime,omitempty"`
+	// GratuitousARP announces the address assigned in a DHCPv4 Ack with a gratuitous ARP on the interface
+	// with a subnet containing it, which requires the CAP_NET_RAW capability. Disabled by default.
+	GratuitousARP bool `json:"gratuitousARP,omitempty"`
+	// Retention is how long an expired lease is kept, so a returning client still gets its previous address.
+	// Leases that expired longer ago are removed from the lease store and their addresses freed,
+	// by a sweep that runs every minute. Active leases are never removed. By default leases are kept forever.
+	Retention caddy.Duration `json:"retention,omitempty"`
 
-	logger    *zap.Logger
+	logger *zap.Logger
+	garp   *handlers.GratuitousARP
+	start  net.IP
+	end    net.IP
+	key    string
+	// stop stops the sweep pruning old leases, if Retention is set
+	stop chan struct{}
+	*leases
+}
+
+// leases is the lease state of a range. It is shared by all range handlers with the same lease store
+// and addresses, so a handler provisioned by a config reload takes over the leases of the handler it
+// replaces, instead of allocating addresses from a stale copy while both are running.
+type leases struct {
 	allocator allocators.Allocator
-	leaseDb   *sql.DB
+	store     LeaseStore
 	recLock   *sync.RWMutex
 	records4  map[string]record
 	records6  map[string]record
 }
 
+// Destruct closes the lease store, if it can be closed, once the last range handler using it is cleaned up.
+func (l *leases) Destruct() error {
+	if closer, ok := l.store.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// pool holds the leases of the provisioned range handlers, keyed by lease store and addresses.
+var pool = caddy.NewUsagePool()
+
 // record holds an IP lease record
 type record struct {
 	IP       net.IP
@@ -51,37 +102,166 @@ type record struct {
 }
 
 func (m *Module) Provision(ctx caddy.Context) error {
-	var err error
 	m.logger = ctx.Logger()
-	m.recLock = &sync.RWMutex{}
-	ipRangeStart := net.ParseIP(m.StartIP)
-	if ipRangeStart.To4() == nil {
+	m.start = net.ParseIP(m.StartIP)
+	if m.start.To4() == nil {
 		return fmt.Errorf("invalid IPv4 address: %v", m.StartIP)
 	}
-	ipRangeEnd := net.ParseIP(m.EndIP)
-	if ipRangeEnd.To4() == nil {
+	m.end = net.ParseIP(m.EndIP)
+	if m.end.To4() == nil {
 		return fmt.Errorf("invalid IPv4 address: %v", m.EndIP)
 	}
-	if binary.BigEndian.Uint32(ipRangeStart.To4()) >= binary.BigEndian.Uint32(ipRangeEnd.To4()) {
+	if binary.BigEndian.Uint32(m.start.To4()) >= binary.BigEndian.Uint32(m.end.To4()) {
 		return fmt.Errorf("start of IP range has to be lower than the end of an IP range")
 	}
+	if err := handlers.CheckJitter(m.Jitter); err != nil {
+		return err
+	}
+	if err := m.checkTimers(); err != nil {
+		return err
+	}
+	if m.Retention < 0 {
+		return fmt.Errorf("invalid retention %s", time.Duration(m.Retention))
+	}
+	if m.GratuitousARP {
+		m.garp = handlers.NewGratuitousARP(m.logger)
+	}
 
-	m.allocator, err = bitmap.NewIPv4Allocator(ipRangeStart, ipRangeEnd)
+	m.key = fmt.Sprintf("%s|%s|%s-%s", m.Filename, m.StoreRaw, m.start, m.end)
+	val, loaded, err := pool.LoadOrNew(m.key, func() (caddy.Destructor, error) {
+		store, err := m.openStore(ctx)
+		if err != nil {
+			return nil, err
+		}
+		m.leases = &leases{store: store, recLock: &sync.RWMutex{}}
+		if err := m.Reload(); err != nil {
+			_ = m.leases.Destruct()
+			return nil, err
+		}
+		return m.leases, nil
+	})
 	if err != nil {
-		return fmt.Errorf("could not create an allocator: %w", err)
+		m.leases = nil
+		return err
 	}
-	m.leaseDb, err = loadDB(m.Filename)
-	if err != nil {
-		return fmt.Errorf("failed to load lease database %s: %w", m.Filename, err)
+	if loaded {
+		m.logger.Info("taking over the leases of the previous range", zap.String("filename", m.Filename))
+	}
+	m.leases = val.(*leases)
+	register(m)
+	handlers.RegisterPool(m)
+	if m.Retention > 0 {
+		m.stop = make(chan struct{})
+		go m.pruneLoop(m.stop)
+	}
+	return nil
+}
+
+// pruneInterval is the interval of the sweep pruning old leases.
+const pruneInterval = time.Minute
+
+// pruneLoop prunes the old leases every pruneInterval, until stop is closed.
+func (m *Module) pruneLoop(stop chan struct{}) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			if _, err := m.prune(now); err != nil {
+				m.logger.Error("failed to prune old leases", zap.Error(err))
+			}
+		}
+	}
+}
+
+// prune removes the leases that expired longer than Retention before now from the lease store, and frees
+// their addresses. It returns the number of removed leases.
+func (m *Module) prune(now time.Time) (int, error) {
+	m.recLock.Lock()
+	defer m.recLock.Unlock()
+	cutoff := now.Add(-time.Duration(m.Retention)).Unix()
+	pruned := 0
+	for key, rec := range m.records4 {
+		if int64(rec.expires) >= cutoff {
+			continue
+		}
+		if err := m.store.Delete(key); err != nil {
+			return pruned, fmt.Errorf("deleting lease of %s: %w", key, err)
+		}
+		delete(m.records4, key)
+		if err := m.allocator.Free(net.IPNet{IP: rec.IP}); err != nil {
+			m.logger.Warn("failed to free the address of a pruned lease", zap.Stringer("ip", rec.IP), zap.Error(err))
+		}
+		m.logger.Debug("pruned old lease", zap.String("key", key), zap.Stringer("ip", rec.IP))
+		pruned++
+	}
+	if pruned > 0 {
+		m.logger.Info("pruned old leases", zap.Int("count", pruned))
+	}
+	return pruned, nil
+}
+
+// openStore loads the configured lease store, or opens the SQLite database at Filename if none is configured.
+func (m *Module) openStore(ctx caddy.Context) (LeaseStore, error) {
+	if m.StoreRaw != nil {
+		mod, err := handlers.LoadModule(ctx, m, "StoreRaw")
+		if err != nil {
+			return nil, fmt.Errorf("loading lease store: %v", err)
+		}
+		store, ok := mod.(LeaseStore)
+		if !ok {
+			return nil, fmt.Errorf("module %T is not a lease store", mod)
+		}
+		return store, nil
+	}
+	if m.Filename == "" {
+		return nil, fmt.Errorf("no lease store or filename configured")
+	}
+	store := &SQLiteStore{Filename: m.Filename}
+	if err := store.Provision(ctx); err != nil {
+		return nil, err
 	}
+	return store, nil
+}
+
+// Cleanup releases the leases, closing the lease store if no other range handler uses it.
+func (m *Module) Cleanup() error {
+	if m.stop != nil {
+		close(m.stop)
+		m.stop = nil
+	}
+	unregister(m)
+	handlers.UnregisterPool(m)
+	if m.leases == nil {
+		return nil
+	}
+	_, err := pool.Delete(m.key)
+	return err
+}
+
+// Reload re-reads the leases from the lease store and rebuilds the allocator from them,
+// which picks up any changes made to the store while the server is running.
+func (m *Module) Reload() error {
+	// hold the lock while reading the store, so no lease written by a concurrent lookup gets lost
 	m.recLock.Lock()
 	defer m.recLock.Unlock()
-	m.records4, err = loadRecords4(m.leaseDb)
+
+	leases, err := m.store.All()
+	if err != nil {
+		return fmt.Errorf("failed to load DHCPv4 records: %w", err)
+	}
+	records4, err := recordsFromLeases(leases)
 	if err != nil {
 		return fmt.Errorf("failed to load DHCPv4 records: %w", err)
 	}
-	for _, v := range m.records4 {
-		ipNet, err := m.allocator.Allocate(net.IPNet{IP: v.IP})
+	allocator, err := bitmap.NewIPv4Allocator(m.start, m.end)
+	if err != nil {
+		return fmt.Errorf("could not create an allocator: %w", err)
+	}
+	for _, v := range records4 {
+		ipNet, err := allocator.Allocate(net.IPNet{IP: v.IP})
 		if err != nil {
 			return fmt.Errorf("failed to re-allocate leased ip %v: %v", v.IP.String(), err)
 		}
@@ -89,20 +269,71 @@ func (m *Module) Provision(ctx caddy.Context) error {
 			return fmt.Errorf("allocator did not re-allocate requested leased ip %v: %v", v.IP.String(), ipNet.String())
 		}
 	}
+
+	m.records4 = records4
+	m.allocator = allocator
 	return nil
 }
 
+// Lease is a single DHCPv4 lease as returned by Leases.
+// The MAC holds the client identifier key instead for leases that are keyed on the client identifier.
+type Lease struct {
+	MAC      string    `json:"mac"`
+	IP       string    `json:"ip"`
+	Expires  time.Time `json:"expires"`
+	Hostname string    `json:"hostname,omitempty"`
+}
+
+// Leases returns the current DHCPv4 leases, ordered by IP address.
+func (m *Module) Leases() []Lease {
+	m.recLock.RLock()
+	defer m.recLock.RUnlock()
+	leases := make([]Lease, 0, len(m.records4))
+	for key, rec := range m.records4 {
+		leases = append(leases, rec.lease(key))
+	}
+	sort.Slice(leases, func(i, j int) bool {
+		return bytes.Compare(net.ParseIP(leases[i].IP).To16(), net.ParseIP(leases[j].IP).To16()) < 0
+	})
+	return leases
+}
+
 func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
-	m.logger.Debug("looking up an IP address for MAC", zap.Stringer("mac", req.ClientHWAddr))
-	ip, err := m.lookup4(req.ClientHWAddr, req.HostName())
+	var cid string
+	if m.ClientIdentifier {
+		cid = req.ClientIdentifierKey()
+	}
+	if req.MessageType() == dhcpv4.MessageTypeDecline {
+		if err := m.decline4(req.ClientHWAddr, cid, req.RequestedIPAddress()); err != nil {
+			m.logger.Error("could not quarantine declined address", zap.Stringer("mac", req.ClientHWAddr), zap.Error(err))
+		}
+		return next()
+	}
+	if req.MessageType() == dhcpv4.MessageTypeRelease {
+		// the lease is kept until it expires, so the client gets the same address back
+		return next()
+	}
+	m.logger.Debug("looking up an IP address for MAC", zap.Stringer("mac", req.ClientHWAddr), zap.String("client_id", cid))
+	ip, key, err := m.lookup4(req.ClientHWAddr, cid, req.HostName())
+	if errors.Is(err, allocators.ErrNoAddrAvail) {
+		// a DHCPREQUEST is answered with a DHCPNAK, so the client restarts, and a DHCPDISCOVER is dropped
+		m.logger.Warn("no address available for a new client, the range is exhausted", zap.Stringer("mac", req.ClientHWAddr))
+		return handlers.Nak(errors.New("no address available"))
+	}
 	if err != nil {
 		m.logger.Warn("MAC address is unknown", zap.Stringer("mac", req.ClientHWAddr))
 		return next()
 	}
 
 	resp.YourIPAddr = ip
+	if m.RenewalTime != 0 {
+		resp.UpdateOption(dhcpv4.OptRenewTimeValue(handlers.Jitter(time.Duration(m.RenewalTime), m.Jitter, key)))
+	}
+	if m.RebindingTime != 0 {
+		resp.UpdateOption(dhcpv4.OptRebindingTimeValue(handlers.Jitter(time.Duration(m.RebindingTime), m.Jitter, key)))
+	}
 	m.logger.Info("found IP address for MAC", zap.Stringer("mac", req.ClientHWAddr), zap.Stringer("ip", ip))
-	return next()
+	return m.garp.Next(req, resp, next)
 }
 
 func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
@@ -114,8 +345,9 @@ func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
 	duidOpt := req.Options.ClientID()
 	duid := hex.EncodeToString(duidOpt.ToBytes())
 
-	m.logger.Info("looking up an IP address for DUID", zap.String("duid", duid))
-	ip, err := m.lookup6(duid)
+	mac := req.ClientLinkLayerAddress()
+	m.logger.Info("looking up an IP address for DUID", zap.String("duid", duid), zap.Stringer("mac", mac))
+	ip, err := m.lookup6(mac, duid)
 	if err != nil {
 		m.logger.Warn("DUID is unknown", zap.String("duid", duid))
 		return next()
@@ -123,11 +355,13 @@ func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
 
 	resp.AddOption(&dhcpv6.OptIANA{
 		IaId: req.Options.OneIANA().IaId,
+		T1:   handlers.Jitter(time.Duration(m.RenewalTime), m.Jitter, duid),
+		T2:   handlers.Jitter(time.Duration(m.RebindingTime), m.Jitter, duid),
 		Options: dhcpv6.IdentityOptions{Options: []dhcpv6.Option{
 			&dhcpv6.OptIAAddress{
 				IPv6Addr:          ip,
-				PreferredLifetime: 3600 * time.Second,
-				ValidLifetime:     3600 * time.Second,
+				PreferredLifetime: handlers.Jitter(3600*time.Second, m.Jitter, duid),
+				ValidLifetime:     handlers.Jitter(3600*time.Second, m.Jitter, duid),
 			},
 		}},
 	})
@@ -135,51 +369,222 @@ func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
 	return next()
 }
 
-func (m *Module) lookup4(addr net.HardwareAddr, hostname string) (net.IP, error) {
-	m.recLock.RLock()
-	defer m.recLock.RUnlock()
-	rec, ok := m.records4[addr.String()]
+// lookup4 looks up the lease of a client by its client identifier key, if any, and falls back to its MAC address.
+// A new lease is allocated when neither is found, which is keyed on the client identifier if present.
+// It returns the leased address and the key of the lease.
+func (m *Module) lookup4(addr net.HardwareAddr, cid string, hostname string) (net.IP, string, error) {
+	m.recLock.Lock()
+	defer m.recLock.Unlock()
+	key := addr.String()
+	rec, ok := m.records4[key]
+	if cid != "" {
+		if cidRec, cidOk := m.records4[cid]; cidOk || !ok {
+			key, rec, ok = cid, cidRec, cidOk
+		}
+	}
+	leaseTime := handlers.Jitter(time.Duration(m.LeaseTime), m.Jitter, key)
 	if !ok {
 		// Allocating new address since there isn't one allocated
 		m.logger.Info("leasing new IPv4 address", zap.Stringer("mac", addr))
-		ip, err := m.allocator.Allocate(net.IPNet{})
-		if err != nil {
-			return nil, fmt.Errorf("could not allocate IP for MAC %s: %v", addr.String(), err)
-		}
-		newRec := record{
-			IP:       ip.IP.To4(),
-			expires:  int(time.Now().Add(time.Duration(m.LeaseTime)).Unix()),
-			hostname: hostname,
-		}
-		err = saveIPAddress(m.leaseDb, addr, newRec)
+		newRec, err := m.allocate4(key, hostname, leaseTime)
 		if err != nil {
-			return nil, fmt.Errorf("SaveIPAddress for MAC %s failed: %v", addr.String(), err)
+			return nil, "", fmt.Errorf("could not allocate IP for MAC %s: %w", addr.String(), err)
 		}
-		m.records4[addr.String()] = newRec
+		m.records4[key] = newRec
 		rec = newRec
 	} else {
 		// Ensure we extend the existing lease at least past when the one we're giving expires
 		expiry := time.Unix(int64(rec.expires), 0)
-		if expiry.Before(time.Now().Add(time.Duration(m.LeaseTime))) {
-			rec.expires = int(time.Now().Add(time.Duration(m.LeaseTime)).Round(time.Second).Unix())
+		if expiry.Before(time.Now().Add(leaseTime)) {
+			rec.expires = int(time.Now().Add(leaseTime).Round(time.Second).Unix())
 			rec.hostname = hostname
-			err := saveIPAddress(m.leaseDb, addr, rec)
+			err := m.store.Save(rec.lease(key))
 			if err != nil {
-				return nil, fmt.Errorf("could not persist lease for MAC %s: %v", addr.String(), err)
+				return nil, "", fmt.Errorf("could not persist lease for MAC %s: %v", addr.String(), err)
 			}
 		}
 	}
-	return rec.IP, nil
+	return rec.IP, key, nil
+}
+
+// allocate4 allocates an address for a new lease with the given key, and saves the lease.
+// When the lease store is shared with other servers, a lease they made for the same key is taken over,
+// and addresses they leased to other clients are skipped. The caller must hold the write lock.
+func (m *Module) allocate4(key string, hostname string, leaseTime time.Duration) (record, error) {
+	rec := record{
+		expires:  int(time.Now().Add(leaseTime).Unix()),
+		hostname: hostname,
+	}
+	lease, found, err := m.store.Load(key)
+	if err != nil {
+		return record{}, err
+	}
+	if found {
+		if ip := net.ParseIP(lease.IP).To4(); ip != nil && m.takeOver4(ip) {
+			rec.IP = ip
+			return rec, m.store.Save(rec.lease(key))
+		}
+	}
+	for {
+		ip, err := m.allocator.Allocate(net.IPNet{})
+		if err != nil {
+			return record{}, err
+		}
+		rec.IP = ip.IP.To4()
+		err = m.store.Save(rec.lease(key))
+		if errors.Is(err, ErrAddressInUse) {
+			// leave the address allocated, as another server leased it
+			m.logger.Debug("address is leased by another server", zap.Stringer("ip", rec.IP))
+			continue
+		}
+		if err != nil {
+			return record{}, fmt.Errorf("saving lease failed: %v", err)
+		}
+		return rec, nil
+	}
+}
+
+// takeOver4 allocates an address that another server sharing the lease store leased to a client, unless it is
+// outside the range or leased to another client. The address may already be allocated, if it was skipped
+// while allocating an address for another client.
+func (m *Module) takeOver4(ip net.IP) bool {
+	n := binary.BigEndian.Uint32(ip)
+	if n < binary.BigEndian.Uint32(m.start.To4()) || n > binary.BigEndian.Uint32(m.end.To4()) {
+		return false
+	}
+	for _, rec := range m.records4 {
+		if rec.IP.Equal(ip) {
+			return false
+		}
+	}
+	if allocated, err := m.allocator.Allocate(net.IPNet{IP: ip}); err == nil && !allocated.IP.Equal(ip) {
+		_ = m.allocator.Free(allocated)
+	}
+	return true
+}
+
+// declinedPrefix is the prefix of the keys of the leases that quarantine declined addresses.
+const declinedPrefix = "declined:"
+
+// decline4 quarantines an address that the client found to be in use by another host, e.g. by ARP,
+// so it is not leased to the next client. The lease of the client is replaced by a lease of the address
+// to the key "declined:" followed by the address, which expires after the lease time like any other lease.
+// It does nothing if the address is not leased to the client.
+func (m *Module) decline4(addr net.HardwareAddr, cid string, ip net.IP) error {
+	if ip == nil {
+		return nil
+	}
+	m.recLock.Lock()
+	defer m.recLock.Unlock()
+	key := addr.String()
+	if rec, ok := m.records4[cid]; cid != "" && ok && rec.IP.Equal(ip) {
+		key = cid
+	}
+	rec, ok := m.records4[key]
+	if !ok || !rec.IP.Equal(ip) {
+		m.logger.Debug("ignoring decline of an address that is not leased to the client", zap.Stringer("mac", addr), zap.Stringer("ip", ip))
+		return nil
+	}
+
+	quarantine := record{
+		IP:      rec.IP,
+		expires: int(time.Now().Add(time.Duration(m.LeaseTime)).Unix()),
+	}
+	declinedKey := declinedPrefix + ip.String()
+	if err := m.store.Delete(key); err != nil {
+		return err
+	}
+	delete(m.records4, key)
+	if err := m.store.Save(quarantine.lease(declinedKey)); err != nil {
+		return err
+	}
+	m.records4[declinedKey] = quarantine
+	m.logger.Warn("client declined its address, which is in use by another host; quarantined the address",
+		zap.Stringer("mac", addr), zap.Stringer("ip", ip))
+	return nil
 }
 
-func (m *Module) lookup6(encodedDuid string) (net.IP, error) {
+// checkTimers validates that the renewal time is shorter than the rebinding time, which is shorter than the lease time.
+func (m *Module) checkTimers() error {
+	if m.RenewalTime < 0 || m.RebindingTime < 0 {
+		return fmt.Errorf("invalid renewal time %s or rebinding time %s", time.Duration(m.RenewalTime), time.Duration(m.RebindingTime))
+	}
+	if m.RenewalTime != 0 && m.RebindingTime != 0 && m.RenewalTime >= m.RebindingTime {
+		return fmt.Errorf("renewal time %s must be shorter than the rebinding time %s", time.Duration(m.RenewalTime), time.Duration(m.RebindingTime))
+	}
+	if m.LeaseTime != 0 && max(m.RenewalTime, m.RebindingTime) >= m.LeaseTime {
+		return fmt.Errorf("renewal time %s and rebinding time %s must be shorter than the lease time %s",
+			time.Duration(m.RenewalTime), time.Duration(m.RebindingTime), time.Duration(m.LeaseTime))
+	}
+	return nil
+}
+
+// lookup6 looks up the lease of a client by the MAC address that a relay agent included in the request, if any,
+// and falls back to its DUID. It returns an error if the client has no lease.
+func (m *Module) lookup6(addr net.HardwareAddr, encodedDuid string) (net.IP, error) {
 	m.recLock.RLock()
 	defer m.recLock.RUnlock()
-	rec, _ := m.records6[encodedDuid]
+	if addr != nil {
+		if rec, ok := m.records6[addr.String()]; ok {
+			return rec.IP, nil
+		}
+	}
+	rec, ok := m.records6[encodedDuid]
+	if !ok {
+		return nil, fmt.Errorf("no lease found for DUID %s", encodedDuid)
+	}
 	return rec.IP, nil
 }
 
+// HasLease4 reports whether the client of the request has a lease in the range,
+// so handling the request renews the lease instead of allocating a new address.
+func (m *Module) HasLease4(req handlers.DHCPv4) bool {
+	m.recLock.RLock()
+	defer m.recLock.RUnlock()
+	if _, ok := m.records4[req.ClientHWAddr.String()]; ok {
+		return true
+	}
+	if m.ClientIdentifier {
+		if cid := req.ClientIdentifierKey(); cid != "" {
+			_, ok := m.records4[cid]
+			return ok
+		}
+	}
+	return false
+}
+
+// Available returns the number of addresses in the range that are not allocated.
+func (m *Module) Available() int {
+	m.recLock.RLock()
+	defer m.recLock.RUnlock()
+	return m.allocator.Available()
+}
+
+// Total returns the number of addresses in the range.
+func (m *Module) Total() int {
+	m.recLock.RLock()
+	defer m.recLock.RUnlock()
+	return m.allocator.Total()
+}
+
+// PoolStats returns the utilization of the range.
+func (m *Module) PoolStats() handlers.PoolStats {
+	m.recLock.RLock()
+	defer m.recLock.RUnlock()
+	total, free := m.allocator.Total(), m.allocator.Available()
+	return handlers.PoolStats{
+		Handler:          "range",
+		Pool:             fmt.Sprintf("%s-%s", m.start, m.end),
+		Total:            total,
+		Used:             total - free,
+		Free:             free,
+		LargestFreeBlock: m.allocator.LargestFree(),
+	}
+}
+
 // Interfaces guards
 var (
 	_ handlers.HandlerModule = (*Module)(nil)
+	_ caddy.CleanerUpper     = (*Module)(nil)
+	_ handlers.PoolReporter  = (*Module)(nil)
 )
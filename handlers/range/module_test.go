@@ -0,0 +1,495 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/lion7/caddydhcp/handlers/handlertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testModule returns the configuration of a module leasing 100 addresses for the tests.
+func testModule(t *testing.T) *Module {
+	return &Module{
+		Filename:  filepath.Join(t.TempDir(), "leases.sqlite3"),
+		StartIP:   "10.0.0.1",
+		EndIP:     "10.0.0.100",
+		LeaseTime: caddy.Duration(time.Hour),
+	}
+}
+
+func TestReload(t *testing.T) {
+	m := handlertest.Provision(t, testModule(t))
+	assert.Empty(t, m.Leases())
+	assert.Equal(t, 100, m.Available())
+
+	// a lease written to the database by someone else is picked up on reload
+	mac, _ := net.ParseMAC("02:00:00:00:00:01")
+	require.NoError(t, m.store.Save(record{IP: net.IPv4(10, 0, 0, 42), expires: expire, hostname: "one"}.lease(mac.String())))
+	require.NoError(t, m.Reload())
+	assert.Equal(t, []Lease{{
+		MAC:      "02:00:00:00:00:01",
+		IP:       "10.0.0.42",
+		Expires:  time.Unix(int64(expire), 0).UTC(),
+		Hostname: "one",
+	}}, m.Leases())
+	assert.Equal(t, 99, m.Available())
+
+	ip, _, err := m.lookup4(mac, "", "one")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.42", ip.String())
+}
+
+func TestReloadConcurrent(t *testing.T) {
+	m := handlertest.Provision(t, testModule(t))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			mac := net.HardwareAddr{0x02, 0, 0, 0, 0, byte(i)}
+			_, _, err := m.lookup4(mac, "", "")
+			assert.NoError(t, err)
+		}()
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, m.Reload())
+		}()
+	}
+	wg.Wait()
+
+	// every lease handed out must have survived the reloads, and be accounted for in the allocator
+	assert.Len(t, m.Leases(), 50)
+	assert.Equal(t, 50, m.Available())
+	require.NoError(t, m.Reload())
+	assert.Len(t, m.Leases(), 50)
+	assert.Equal(t, 50, m.Available())
+}
+
+func TestPrune(t *testing.T) {
+	m := handlertest.Provision(t, testModule(t))
+	m.Retention = caddy.Duration(24 * time.Hour)
+	now := time.Now()
+
+	leases := map[string]record{
+		// an active lease
+		"02:00:00:00:00:01": {IP: net.IPv4(10, 0, 0, 1), expires: int(now.Add(time.Hour).Unix())},
+		// a lease that expired within the retention period
+		"02:00:00:00:00:02": {IP: net.IPv4(10, 0, 0, 2), expires: int(now.Add(-time.Hour).Unix())},
+		// leases that expired before the retention period, including a quarantined address
+		"02:00:00:00:00:03":         {IP: net.IPv4(10, 0, 0, 3), expires: int(now.Add(-48 * time.Hour).Unix())},
+		declinedPrefix + "10.0.0.4": {IP: net.IPv4(10, 0, 0, 4), expires: int(now.Add(-25 * time.Hour).Unix())},
+	}
+	for key, rec := range leases {
+		require.NoError(t, m.store.Save(rec.lease(key)))
+	}
+	require.NoError(t, m.Reload())
+	require.Equal(t, 96, m.Available())
+
+	pruned, err := m.prune(now)
+	require.NoError(t, err)
+	assert.Equal(t, 2, pruned)
+
+	var keys []string
+	for _, lease := range m.Leases() {
+		keys = append(keys, lease.MAC)
+	}
+	assert.Equal(t, []string{"02:00:00:00:00:01", "02:00:00:00:00:02"}, keys)
+	stored, err := m.store.All()
+	require.NoError(t, err)
+	assert.Len(t, stored, 2)
+	// the addresses of the pruned leases can be allocated again
+	assert.Equal(t, 98, m.Available())
+
+	// nothing is left to prune
+	pruned, err = m.prune(now)
+	require.NoError(t, err)
+	assert.Zero(t, pruned)
+
+	assert.Error(t, (&Module{StartIP: "10.0.0.1", EndIP: "10.0.0.100", Retention: -1}).Provision(caddy.Context{}))
+}
+
+func TestAdminAPI(t *testing.T) {
+	m := handlertest.Provision(t, testModule(t))
+	mac, _ := net.ParseMAC("02:00:00:00:00:02")
+	_, _, err := m.lookup4(mac, "", "two")
+	require.NoError(t, err)
+
+	a := &AdminAPI{}
+	routes := make(map[string]caddy.AdminHandler)
+	for _, route := range a.Routes() {
+		routes[route.Pattern] = route.Handler
+	}
+
+	query := "?filename=" + m.Filename
+	rec := httptest.NewRecorder()
+	require.NoError(t, routes["/dhcp/range/dump"].ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/dhcp/range/dump"+query, nil)))
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	var dump map[string][]map[string]any
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &dump))
+	require.Len(t, dump[m.Filename], 1)
+	lease := dump[m.Filename][0]
+	assert.Equal(t, "02:00:00:00:00:02", lease["mac"])
+	assert.Equal(t, "10.0.0.1", lease["ip"])
+	assert.Equal(t, "two", lease["hostname"])
+	_, err = time.Parse(time.RFC3339, fmt.Sprint(lease["expires"]))
+	assert.NoError(t, err)
+
+	rec = httptest.NewRecorder()
+	require.NoError(t, routes["/dhcp/range/reload"].ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/dhcp/range/reload"+query, nil)))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	var apiErr caddy.APIError
+	err = routes["/dhcp/range/reload"].ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/dhcp/range/reload?filename=unknown", nil))
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusNotFound, apiErr.HTTPStatus)
+	err = routes["/dhcp/range/dump"].ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/dhcp/range/dump", nil))
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, http.StatusMethodNotAllowed, apiErr.HTTPStatus)
+}
+
+func TestPoolStats(t *testing.T) {
+	m := &Module{
+		Filename:  filepath.Join(t.TempDir(), "leases.sqlite3"),
+		StartIP:   "10.0.0.1",
+		EndIP:     "10.0.0.8",
+		LeaseTime: caddy.Duration(time.Hour),
+	}
+	require.NoError(t, m.Provision(caddy.Context{}))
+	assert.Equal(t, handlers.PoolStats{Handler: "range", Pool: "10.0.0.1-10.0.0.8", Total: 8, Free: 8, LargestFreeBlock: 8}, m.PoolStats())
+	assert.Contains(t, handlers.Pools(), m.PoolStats())
+
+	for i := byte(1); i <= 5; i++ {
+		_, _, err := m.lookup4(net.HardwareAddr{0x02, 0, 0, 0, 0, i}, "", "")
+		require.NoError(t, err)
+	}
+	assert.Equal(t, handlers.PoolStats{Handler: "range", Pool: "10.0.0.1-10.0.0.8", Total: 8, Used: 5, Free: 3, LargestFreeBlock: 3}, m.PoolStats())
+
+	// freeing .2 and .4 fragments the pool
+	for _, ip := range []net.IP{net.IPv4(10, 0, 0, 2), net.IPv4(10, 0, 0, 4)} {
+		require.NoError(t, m.allocator.Free(net.IPNet{IP: ip}))
+	}
+	assert.Equal(t, handlers.PoolStats{Handler: "range", Pool: "10.0.0.1-10.0.0.8", Total: 8, Used: 3, Free: 5, LargestFreeBlock: 3}, m.PoolStats())
+
+	require.NoError(t, m.Cleanup())
+	assert.NotContains(t, handlers.Pools(), m.PoolStats())
+}
+
+func TestLookup4ClientIdentifier(t *testing.T) {
+	m := handlertest.Provision(t, testModule(t))
+	mac, _ := net.ParseMAC("02:00:00:00:00:01")
+
+	// without a client identifier the lease is keyed on the MAC address
+	ip, _, err := m.lookup4(mac, "", "")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", ip.String())
+
+	// an existing MAC-keyed lease is still honored for a client that sends a client identifier
+	ip, _, err = m.lookup4(mac, "cid:01020000000001", "")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", ip.String())
+
+	// a new client gets a lease keyed on its client identifier, which survives a change of MAC address
+	other, _ := net.ParseMAC("02:00:00:00:00:02")
+	ip, _, err = m.lookup4(other, "cid:ff01", "")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.2", ip.String())
+	changed, _ := net.ParseMAC("02:00:00:00:00:03")
+	ip, _, err = m.lookup4(changed, "cid:ff01", "")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.2", ip.String())
+
+	// client identifier keys are persisted in the lease database
+	require.NoError(t, m.Reload())
+	leases := m.Leases()
+	require.Len(t, leases, 2)
+	assert.Equal(t, "cid:ff01", leases[1].MAC)
+}
+
+func TestHasLease4(t *testing.T) {
+	m := handlertest.Provision(t, testModule(t))
+	m.ClientIdentifier = true
+	mac, _ := net.ParseMAC("02:00:00:00:00:01")
+	req, err := dhcpv4.NewDiscovery(mac)
+	require.NoError(t, err)
+	assert.False(t, m.HasLease4(handlers.DHCPv4{DHCPv4: req}))
+
+	_, _, err = m.lookup4(mac, "", "")
+	require.NoError(t, err)
+	assert.True(t, m.HasLease4(handlers.DHCPv4{DHCPv4: req}))
+
+	// a lease keyed on the client identifier is found for another MAC address
+	other, _ := net.ParseMAC("02:00:00:00:00:02")
+	req, err = dhcpv4.NewDiscovery(other, dhcpv4.WithOption(dhcpv4.OptClientIdentifier([]byte{0xff, 0x01})))
+	require.NoError(t, err)
+	assert.False(t, m.HasLease4(handlers.DHCPv4{DHCPv4: req}))
+	_, _, err = m.lookup4(net.HardwareAddr{0x02, 0, 0, 0, 0, 3}, handlers.DHCPv4{DHCPv4: req}.ClientIdentifierKey(), "")
+	require.NoError(t, err)
+	assert.True(t, m.HasLease4(handlers.DHCPv4{DHCPv4: req}))
+}
+
+func TestDecline4(t *testing.T) {
+	m := handlertest.Provision(t, testModule(t))
+	mac, _ := net.ParseMAC("02:00:00:00:00:01")
+	ip, _, err := m.lookup4(mac, "", "")
+	require.NoError(t, err)
+	require.Equal(t, "10.0.0.1", ip.String())
+
+	decline := func(mac net.HardwareAddr, ip net.IP) {
+		req, err := dhcpv4.New(
+			dhcpv4.WithHwAddr(mac),
+			dhcpv4.WithMessageType(dhcpv4.MessageTypeDecline),
+			dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(ip)),
+		)
+		require.NoError(t, err)
+		_, err = handlertest.Handle4(t, m, handlers.NewDHCPv4(req), nil)
+		require.NoError(t, err)
+	}
+
+	// declining an address that is not leased to the client is ignored
+	other, _ := net.ParseMAC("02:00:00:00:00:02")
+	decline(other, ip)
+	decline(mac, net.IPv4(10, 0, 0, 2))
+	require.Len(t, m.Leases(), 1)
+	assert.Equal(t, mac.String(), m.Leases()[0].MAC)
+
+	// the declined address is quarantined, and the client gets another address
+	decline(mac, ip)
+	leases := m.Leases()
+	require.Len(t, leases, 1)
+	assert.Equal(t, "declined:10.0.0.1", leases[0].MAC)
+	assert.Equal(t, "10.0.0.1", leases[0].IP)
+	ip, _, err = m.lookup4(mac, "", "")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.2", ip.String())
+
+	// the quarantine survives reloading the leases
+	require.NoError(t, m.Reload())
+	ip, _, err = m.lookup4(other, "", "")
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.3", ip.String())
+}
+
+func TestRelease4(t *testing.T) {
+	m := handlertest.Provision(t, testModule(t))
+	mac, _ := net.ParseMAC("02:00:00:00:00:01")
+	req, err := dhcpv4.New(
+		dhcpv4.WithHwAddr(mac),
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeRelease),
+		dhcpv4.WithClientIP(net.IPv4(10, 0, 0, 1)),
+	)
+	require.NoError(t, err)
+
+	// a release does not allocate an address
+	resp, err := handlertest.Handle4(t, m, handlers.NewDHCPv4(req), nil)
+	require.NoError(t, err)
+	assert.Empty(t, m.Leases())
+	assert.True(t, resp.YourIPAddr.IsUnspecified())
+}
+
+func TestHandle4Exhausted(t *testing.T) {
+	m := handlertest.Provision(t, &Module{
+		Filename:  filepath.Join(t.TempDir(), "leases.sqlite3"),
+		StartIP:   "10.0.0.1",
+		EndIP:     "10.0.0.2",
+		LeaseTime: caddy.Duration(time.Hour),
+	})
+
+	handle := func(mac net.HardwareAddr) (*dhcpv4.DHCPv4, error) {
+		req, err := dhcpv4.NewDiscovery(mac)
+		require.NoError(t, err)
+		req.UpdateOption(dhcpv4.OptMessageType(dhcpv4.MessageTypeRequest))
+		return handlertest.Handle4(t, m, handlers.NewDHCPv4(req), nil)
+	}
+
+	first, _ := net.ParseMAC("02:00:00:00:00:01")
+	resp, err := handle(first)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", resp.YourIPAddr.String())
+	second, _ := net.ParseMAC("02:00:00:00:00:02")
+	_, err = handle(second)
+	require.NoError(t, err)
+
+	// all addresses are leased, so the next client is refused
+	third, _ := net.ParseMAC("02:00:00:00:00:03")
+	resp, err = handle(third)
+	var herr handlers.HandlerError
+	require.ErrorAs(t, err, &herr)
+	assert.True(t, herr.Nak)
+	assert.True(t, resp.YourIPAddr.IsUnspecified())
+
+	// the client with the lease can still renew it
+	resp, err = handle(first)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1", resp.YourIPAddr.String())
+}
+
+func TestHandle6Unknown(t *testing.T) {
+	m := handlertest.Provision(t, testModule(t))
+	req, err := dhcpv6.NewSolicit(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+
+	// a client without a lease is left to the rest of the chain, without an IA_NA for it
+	var called bool
+	resp, err := handlertest.Handle6(t, m, handlers.NewDHCPv6(req), func(*dhcpv6.Message) error {
+		called = true
+		return nil
+	})
+	require.NoError(t, err)
+	assert.True(t, called)
+	assert.Nil(t, resp.Options.OneIANA())
+}
+
+func TestLookup4Jitter(t *testing.T) {
+	m := handlertest.Provision(t, &Module{
+		Filename:  filepath.Join(t.TempDir(), "leases.sqlite3"),
+		StartIP:   "10.0.0.1",
+		EndIP:     "10.0.0.100",
+		LeaseTime: caddy.Duration(time.Hour),
+		Jitter:    10,
+	})
+
+	now := time.Now()
+	for i := byte(1); i <= 20; i++ {
+		_, _, err := m.lookup4(net.HardwareAddr{0x02, 0, 0, 0, 0, i}, "", "")
+		require.NoError(t, err)
+	}
+	leases := m.Leases()
+	require.Len(t, leases, 20)
+	for _, lease := range leases {
+		assert.WithinRange(t, lease.Expires, now.Add(54*time.Minute-time.Second), now.Add(66*time.Minute+time.Second))
+	}
+
+	// the lease of a client is not shortened when it renews
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	_, _, err := m.lookup4(mac, "", "")
+	require.NoError(t, err)
+	assert.Equal(t, leases, m.Leases())
+}
+
+func TestTimers(t *testing.T) {
+	m := handlertest.Provision(t, &Module{
+		Filename:      filepath.Join(t.TempDir(), "leases.sqlite3"),
+		StartIP:       "10.0.0.1",
+		EndIP:         "10.0.0.100",
+		LeaseTime:     caddy.Duration(time.Hour),
+		RenewalTime:   caddy.Duration(20 * time.Minute),
+		RebindingTime: caddy.Duration(40 * time.Minute),
+	})
+
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	resp, err := handlertest.Handle4(t, m, handlers.NewDHCPv4(req), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 20*time.Minute, resp.IPAddressRenewalTime(0))
+	assert.Equal(t, 40*time.Minute, resp.IPAddressRebindingTime(0))
+
+	req6, err := dhcpv6.NewSolicit(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	m.records6 = map[string]record{hex.EncodeToString(req6.Options.ClientID().ToBytes()): {IP: net.ParseIP("2001:db8::1")}}
+	resp6, err := handlertest.Handle6(t, m, handlers.NewDHCPv6(req6), nil)
+	require.NoError(t, err)
+	iana := resp6.Options.OneIANA()
+	require.NotNil(t, iana)
+	assert.Equal(t, 20*time.Minute, iana.T1)
+	assert.Equal(t, 40*time.Minute, iana.T2)
+}
+
+func TestTimersUnset(t *testing.T) {
+	m := handlertest.Provision(t, testModule(t))
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	resp, err := handlertest.Handle4(t, m, handlers.NewDHCPv4(req), nil)
+	require.NoError(t, err)
+	assert.False(t, resp.Options.Has(dhcpv4.OptionRenewTimeValue))
+	assert.False(t, resp.Options.Has(dhcpv4.OptionRebindingTimeValue))
+}
+
+func TestTimersValidation(t *testing.T) {
+	for _, tc := range []struct {
+		name               string
+		renewal, rebinding time.Duration
+		valid              bool
+	}{
+		{"ordered", 20 * time.Minute, 40 * time.Minute, true},
+		{"renewal only", 20 * time.Minute, 0, true},
+		{"rebinding only", 0, 40 * time.Minute, true},
+		{"renewal after rebinding", 40 * time.Minute, 20 * time.Minute, false},
+		{"renewal equals rebinding", 20 * time.Minute, 20 * time.Minute, false},
+		{"rebinding after lease", 20 * time.Minute, 2 * time.Hour, false},
+		{"renewal after lease", 2 * time.Hour, 0, false},
+		{"negative", -time.Minute, 0, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := &Module{LeaseTime: caddy.Duration(time.Hour), RenewalTime: caddy.Duration(tc.renewal), RebindingTime: caddy.Duration(tc.rebinding)}
+			assert.Equal(t, tc.valid, m.checkTimers() == nil)
+		})
+	}
+}
+
+func TestConfigReload(t *testing.T) {
+	old := handlertest.Provision(t, testModule(t))
+	first, _, err := old.lookup4(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, "", "")
+	require.NoError(t, err)
+
+	// a config reload provisions the new handler while the old one is still running
+	m := handlertest.Provision(t, &Module{Filename: old.Filename, StartIP: old.StartIP, EndIP: old.EndIP, LeaseTime: old.LeaseTime})
+	second, _, err := old.lookup4(net.HardwareAddr{0x02, 0, 0, 0, 0, 2}, "", "")
+	require.NoError(t, err)
+	require.NoError(t, old.Cleanup())
+
+	// the new handler knows the leases handed out by the old one, also after it was provisioned
+	ip, _, err := m.lookup4(net.HardwareAddr{0x02, 0, 0, 0, 0, 2}, "", "")
+	require.NoError(t, err)
+	assert.Equal(t, second, ip)
+	ip, _, err = m.lookup4(net.HardwareAddr{0x02, 0, 0, 0, 0, 3}, "", "")
+	require.NoError(t, err)
+	assert.NotEqual(t, first, ip)
+	assert.NotEqual(t, second, ip)
+	assert.Len(t, m.Leases(), 3)
+	assert.Equal(t, 97, m.Available())
+}
+
+func TestGratuitousARP(t *testing.T) {
+	m := handlertest.Provision(t, &Module{
+		Filename:      filepath.Join(t.TempDir(), "leases.sqlite3"),
+		StartIP:       "10.0.0.1",
+		EndIP:         "10.0.0.100",
+		LeaseTime:     caddy.Duration(time.Hour),
+		GratuitousARP: true,
+	})
+	var targets []string
+	m.garp.Send = func(ip net.IP, mac net.HardwareAddr) error {
+		targets = append(targets, ip.String()+" "+mac.String())
+		return nil
+	}
+
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	for _, mt := range []dhcpv4.MessageType{dhcpv4.MessageTypeOffer, dhcpv4.MessageTypeAck} {
+		req, err := dhcpv4.NewDiscovery(mac)
+		require.NoError(t, err)
+		resp, err := handlertest.Handle4(t, m, handlers.NewDHCPv4(req), nil, dhcpv4.WithMessageType(mt))
+		require.NoError(t, err)
+		require.Equal(t, "10.0.0.1", resp.YourIPAddr.String())
+	}
+	// only the assignment in the Ack is announced
+	assert.Equal(t, []string{"10.0.0.1 02:00:00:00:00:01"}, targets)
+}
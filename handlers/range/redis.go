@@ -0,0 +1,215 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisStore is a LeaseStore that keeps the leases in Redis, so several caddydhcp instances serving the same
+// range share their leases. Every address is claimed in Redis by the client leasing it, so two instances never
+// lease the same address to different clients.
+//
+// The keys expire together with the leases, so Redis removes expired leases by itself.
+// Ranges sharing a Redis database must use different key prefixes.
+type RedisStore struct {
+	// The address of the Redis server. Defaults to localhost:6379.
+	Address string `json:"address,omitempty"`
+	// The username and password to authenticate with, if any.
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	// The number of the Redis database to use. Defaults to 0.
+	DB int `json:"db,omitempty"`
+	// KeyPrefix is prepended to all keys of this store. Defaults to "caddydhcp:".
+	KeyPrefix string `json:"keyPrefix,omitempty"`
+
+	client redisClient
+}
+
+// redisClient are the commands of a Redis client used by the RedisStore.
+type redisClient interface {
+	Get(ctx context.Context, key string) *redis.StringCmd
+	Set(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.StatusCmd
+	SetNX(ctx context.Context, key string, value interface{}, expiration time.Duration) *redis.BoolCmd
+	Del(ctx context.Context, keys ...string) *redis.IntCmd
+	Scan(ctx context.Context, cursor uint64, match string, count int64) *redis.ScanCmd
+	Close() error
+}
+
+// CaddyModule returns the Caddy module information.
+func (*RedisStore) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "dhcp.leasestores.redis",
+		New: func() caddy.Module { return new(RedisStore) },
+	}
+}
+
+// Provision connects to the Redis server.
+func (s *RedisStore) Provision(caddy.Context) error {
+	if s.Address == "" {
+		s.Address = "localhost:6379"
+	}
+	if s.KeyPrefix == "" {
+		s.KeyPrefix = "caddydhcp:"
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr:     s.Address,
+		Username: s.Username,
+		Password: s.Password,
+		DB:       s.DB,
+	})
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		_ = client.Close()
+		return fmt.Errorf("failed to connect to Redis at %s: %w", s.Address, err)
+	}
+	s.client = client
+	return nil
+}
+
+// Close closes the connections to the Redis server.
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
+
+func (s *RedisStore) leaseKey(key string) string {
+	return s.KeyPrefix + "lease:" + key
+}
+
+func (s *RedisStore) ipKey(ip string) string {
+	return s.KeyPrefix + "ip:" + ip
+}
+
+// Load returns the lease with the given key, and whether it exists.
+func (s *RedisStore) Load(key string) (Lease, bool, error) {
+	data, err := s.client.Get(context.Background(), s.leaseKey(key)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return Lease{}, false, nil
+	}
+	if err != nil {
+		return Lease{}, false, fmt.Errorf("failed to get lease %s: %w", key, err)
+	}
+	var lease Lease
+	if err := json.Unmarshal(data, &lease); err != nil {
+		return Lease{}, false, fmt.Errorf("malformed lease %s: %w", key, err)
+	}
+	return lease, true, nil
+}
+
+// Save claims the address of the lease and writes out the lease, both expiring with the lease.
+// It returns ErrAddressInUse if the address is claimed by a lease with another key,
+// and releases the address of a previous lease with the same key.
+// An expired lease is deleted instead.
+func (s *RedisStore) Save(lease Lease) error {
+	ttl := time.Until(lease.Expires)
+	if ttl <= 0 {
+		return s.Delete(lease.MAC)
+	}
+	previous, found, err := s.Load(lease.MAC)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	ipKey := s.ipKey(lease.IP)
+	claimed, err := s.client.SetNX(ctx, ipKey, lease.MAC, ttl).Result()
+	if err != nil {
+		return fmt.Errorf("failed to claim address %s: %w", lease.IP, err)
+	}
+	if !claimed {
+		owner, err := s.client.Get(ctx, ipKey).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return fmt.Errorf("failed to get the owner of address %s: %w", lease.IP, err)
+		}
+		if err == nil && owner != lease.MAC {
+			return fmt.Errorf("%w: %s is leased to %s", ErrAddressInUse, lease.IP, owner)
+		}
+		if err := s.client.Set(ctx, ipKey, lease.MAC, ttl).Err(); err != nil {
+			return fmt.Errorf("failed to claim address %s: %w", lease.IP, err)
+		}
+	}
+
+	data, err := json.Marshal(lease)
+	if err != nil {
+		return err
+	}
+	if err := s.client.Set(ctx, s.leaseKey(lease.MAC), data, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to set lease %s: %w", lease.MAC, err)
+	}
+	if found && previous.IP != lease.IP {
+		return s.release(previous)
+	}
+	return nil
+}
+
+// Delete removes the lease with the given key, if it exists, and releases its address.
+func (s *RedisStore) Delete(key string) error {
+	lease, found, err := s.Load(key)
+	if err != nil || !found {
+		return err
+	}
+	if err := s.client.Del(context.Background(), s.leaseKey(key)).Err(); err != nil {
+		return fmt.Errorf("failed to delete lease %s: %w", key, err)
+	}
+	return s.release(lease)
+}
+
+// release removes the claim of the lease on its address, unless another lease has claimed it since.
+func (s *RedisStore) release(lease Lease) error {
+	ctx := context.Background()
+	ipKey := s.ipKey(lease.IP)
+	owner, err := s.client.Get(ctx, ipKey).Result()
+	if errors.Is(err, redis.Nil) || (err == nil && owner != lease.MAC) {
+		return nil
+	}
+	if err == nil {
+		err = s.client.Del(ctx, ipKey).Err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to release address %s: %w", lease.IP, err)
+	}
+	return nil
+}
+
+// All returns all leases.
+func (s *RedisStore) All() ([]Lease, error) {
+	var (
+		leases []Lease
+		cursor uint64
+		prefix = s.leaseKey("")
+	)
+	for {
+		keys, next, err := s.client.Scan(context.Background(), cursor, prefix+"*", 100).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan leases: %w", err)
+		}
+		for _, key := range keys {
+			// the lease may have expired after the scan
+			lease, found, err := s.Load(key[len(prefix):])
+			if err != nil {
+				return nil, err
+			}
+			if found {
+				leases = append(leases, lease)
+			}
+		}
+		if next == 0 {
+			return leases, nil
+		}
+		cursor = next
+	}
+}
+
+// Interfaces guards
+var (
+	_ LeaseStore  = (*RedisStore)(nil)
+	_ redisClient = (*redis.Client)(nil)
+)
@@ -0,0 +1,69 @@
+//go:build redis
+
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newRedisStore connects to the Redis server at $REDIS_ADDR, or localhost:6379,
+// with a key prefix unique to the test.
+func newRedisStore(t *testing.T) *RedisStore {
+	store := &RedisStore{
+		Address:   os.Getenv("REDIS_ADDR"),
+		KeyPrefix: "caddydhcp-test:" + t.Name() + ":",
+	}
+	require.NoError(t, store.Provision(caddy.Context{}))
+	t.Cleanup(func() {
+		leases, _ := store.All()
+		for _, lease := range leases {
+			_ = store.Delete(lease.MAC)
+		}
+		_ = store.Close()
+	})
+	return store
+}
+
+func TestRedisIntegration(t *testing.T) {
+	store := newRedisStore(t)
+	testStoreSetup(t, store)
+
+	leases, err := store.All()
+	require.NoError(t, err)
+	parsedRec, err := recordsFromLeases(leases)
+	require.NoError(t, err)
+	assert.Len(t, parsedRec, len(records))
+
+	err = store.Save(Lease{MAC: "02:00:00:00:00:0a", IP: "10.0.0.1", Expires: time.Now().Add(time.Hour)})
+	assert.ErrorIs(t, err, ErrAddressInUse)
+}
+
+func TestRedisIntegrationExpiry(t *testing.T) {
+	store := newRedisStore(t)
+	require.NoError(t, store.Save(Lease{MAC: "02:00:00:00:00:01", IP: "10.0.0.1", Expires: time.Now().Add(time.Hour)}))
+
+	ctx := context.Background()
+	for _, key := range []string{store.leaseKey("02:00:00:00:00:01"), store.ipKey("10.0.0.1")} {
+		ttl, err := store.client.(*redis.Client).TTL(ctx, key).Result()
+		require.NoError(t, err)
+		assert.InDelta(t, time.Hour.Seconds(), ttl.Seconds(), 5, key)
+	}
+
+	require.NoError(t, store.Save(Lease{MAC: "02:00:00:00:00:01", IP: "10.0.0.1", Expires: time.Now().Add(time.Second)}))
+	time.Sleep(1500 * time.Millisecond)
+	_, found, err := store.Load("02:00:00:00:00:01")
+	require.NoError(t, err)
+	assert.False(t, found, "Redis must expire the lease")
+}
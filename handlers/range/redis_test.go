@@ -0,0 +1,171 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lion7/caddydhcp/handlers/handlertest"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRedis is an in-memory redisClient, which expires keys like Redis.
+type fakeRedis struct {
+	mu      sync.Mutex
+	values  map[string]string
+	expires map[string]time.Time
+}
+
+func newFakeRedisStore() *RedisStore {
+	return &RedisStore{
+		KeyPrefix: "test:",
+		client:    &fakeRedis{values: make(map[string]string), expires: make(map[string]time.Time)},
+	}
+}
+
+func (f *fakeRedis) get(key string) (string, bool) {
+	if expires, ok := f.expires[key]; ok && !time.Now().Before(expires) {
+		delete(f.values, key)
+		delete(f.expires, key)
+	}
+	value, ok := f.values[key]
+	return value, ok
+}
+
+func (f *fakeRedis) set(key string, value interface{}, expiration time.Duration) {
+	f.values[key] = fmt.Sprint(value)
+	if b, ok := value.([]byte); ok {
+		f.values[key] = string(b)
+	}
+	delete(f.expires, key)
+	if expiration > 0 {
+		f.expires[key] = time.Now().Add(expiration)
+	}
+}
+
+func (f *fakeRedis) Get(_ context.Context, key string) *redis.StringCmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if value, ok := f.get(key); ok {
+		return redis.NewStringResult(value, nil)
+	}
+	return redis.NewStringResult("", redis.Nil)
+}
+
+func (f *fakeRedis) Set(_ context.Context, key string, value interface{}, expiration time.Duration) *redis.StatusCmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.set(key, value, expiration)
+	return redis.NewStatusResult("OK", nil)
+}
+
+func (f *fakeRedis) SetNX(_ context.Context, key string, value interface{}, expiration time.Duration) *redis.BoolCmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.get(key); ok {
+		return redis.NewBoolResult(false, nil)
+	}
+	f.set(key, value, expiration)
+	return redis.NewBoolResult(true, nil)
+}
+
+func (f *fakeRedis) Del(_ context.Context, keys ...string) *redis.IntCmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var n int64
+	for _, key := range keys {
+		if _, ok := f.get(key); ok {
+			delete(f.values, key)
+			delete(f.expires, key)
+			n++
+		}
+	}
+	return redis.NewIntResult(n, nil)
+}
+
+func (f *fakeRedis) Scan(_ context.Context, _ uint64, match string, _ int64) *redis.ScanCmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var keys []string
+	for key := range f.values {
+		if _, ok := f.get(key); ok && strings.HasPrefix(key, strings.TrimSuffix(match, "*")) {
+			keys = append(keys, key)
+		}
+	}
+	return redis.NewScanCmdResult(keys, 0, nil)
+}
+
+func (f *fakeRedis) Close() error {
+	return nil
+}
+
+func TestRedisExpiry(t *testing.T) {
+	store := newFakeRedisStore()
+	fake := store.client.(*fakeRedis)
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	require.NoError(t, store.Save(Lease{MAC: "02:00:00:00:00:01", IP: "10.0.0.1", Expires: expires}))
+
+	// both the lease and the claim on its address expire with the lease
+	for _, key := range []string{"test:lease:02:00:00:00:00:01", "test:ip:10.0.0.1"} {
+		assert.WithinDuration(t, expires, fake.expires[key], time.Second, key)
+	}
+
+	// an expired lease is removed
+	require.NoError(t, store.Save(Lease{MAC: "02:00:00:00:00:01", IP: "10.0.0.1", Expires: time.Now().Add(-time.Second)}))
+	assert.Empty(t, fake.values)
+}
+
+func TestRedisAddressInUse(t *testing.T) {
+	store := newFakeRedisStore()
+	expires := time.Now().Add(time.Hour)
+	require.NoError(t, store.Save(Lease{MAC: "02:00:00:00:00:01", IP: "10.0.0.1", Expires: expires}))
+
+	err := store.Save(Lease{MAC: "02:00:00:00:00:02", IP: "10.0.0.1", Expires: expires})
+	assert.ErrorIs(t, err, ErrAddressInUse)
+	_, found, err := store.Load("02:00:00:00:00:02")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	// moving a lease to another address releases the previous one
+	require.NoError(t, store.Save(Lease{MAC: "02:00:00:00:00:01", IP: "10.0.0.2", Expires: expires}))
+	require.NoError(t, store.Save(Lease{MAC: "02:00:00:00:00:02", IP: "10.0.0.1", Expires: expires}))
+
+	// deleting a lease releases its address
+	require.NoError(t, store.Delete("02:00:00:00:00:02"))
+	assert.NoError(t, store.Save(Lease{MAC: "02:00:00:00:00:03", IP: "10.0.0.1", Expires: expires}))
+}
+
+func TestRedisSharedRange(t *testing.T) {
+	store := newFakeRedisStore()
+	newRange := func() *Module {
+		m := handlertest.Provision(t, testModule(t))
+		m.leases = &leases{store: store, recLock: &sync.RWMutex{}}
+		require.NoError(t, m.Reload())
+		return m
+	}
+	m1, m2 := newRange(), newRange()
+
+	mac1 := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	ip1, _, err := m1.lookup4(mac1, "", "")
+	require.NoError(t, err)
+
+	// the second server skips the address leased by the first one
+	ip2, _, err := m2.lookup4(net.HardwareAddr{0x02, 0, 0, 0, 0, 2}, "", "")
+	require.NoError(t, err)
+	assert.NotEqual(t, ip1.String(), ip2.String())
+
+	// and takes over the lease of a client that moves to it
+	ip, _, err := m2.lookup4(mac1, "", "")
+	require.NoError(t, err)
+	assert.Equal(t, ip1.String(), ip.String())
+}
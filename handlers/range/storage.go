@@ -6,13 +6,47 @@ package rangeplugin
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
-	"net"
+	"time"
 
+	"github.com/caddyserver/caddy/v2"
 	_ "github.com/ncruces/go-sqlite3/driver"
 	_ "github.com/ncruces/go-sqlite3/embed"
 )
 
+// SQLiteStore is a LeaseStore that keeps the leases in a SQLite database.
+// It is the store of a range that only configures the filename of its lease database.
+type SQLiteStore struct {
+	// The path of the database, which is created if it does not exist.
+	Filename string `json:"filename"`
+
+	db *sql.DB
+}
+
+// CaddyModule returns the Caddy module information.
+func (*SQLiteStore) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "dhcp.leasestores.sqlite",
+		New: func() caddy.Module { return new(SQLiteStore) },
+	}
+}
+
+// Provision opens the database.
+func (s *SQLiteStore) Provision(caddy.Context) error {
+	db, err := loadDB(s.Filename)
+	if err != nil {
+		return fmt.Errorf("failed to load lease database %s: %w", s.Filename, err)
+	}
+	s.db = db
+	return nil
+}
+
+// Close closes the database.
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
 func loadDB(path string) (*sql.DB, error) {
 	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s", path))
 	if err != nil {
@@ -24,54 +58,78 @@ func loadDB(path string) (*sql.DB, error) {
 	return db, nil
 }
 
-// loadRecords4 loads the DHCPv4 Records global map with records stored on
-// the specified file. The records have to be one per line, a mac address and an
-// IP address.
-func loadRecords4(db *sql.DB) (map[string]record, error) {
-	rows, err := db.Query("select mac, ip, expiry, hostname from leases4")
-	if err != nil {
-		return nil, fmt.Errorf("failed to query leases database: %w", err)
-	}
-	defer rows.Close()
+// Load returns the lease with the given key, and whether it exists.
+func (s *SQLiteStore) Load(key string) (Lease, bool, error) {
 	var (
-		mac, ip, hostname string
-		expiry            int
-		records           = make(map[string]record)
+		lease  = Lease{MAC: key}
+		expiry int64
 	)
-	for rows.Next() {
-		if err := rows.Scan(&mac, &ip, &expiry, &hostname); err != nil {
-			return nil, fmt.Errorf("failed to scan row: %w", err)
-		}
-		hwaddr, err := net.ParseMAC(mac)
-		if err != nil {
-			return nil, fmt.Errorf("malformed hardware address: %s", mac)
-		}
-		ipaddr := net.ParseIP(ip)
-		if ipaddr.To4() == nil {
-			return nil, fmt.Errorf("expected an IPv4 address, got: %v", ipaddr)
-		}
-		records[hwaddr.String()] = record{IP: ipaddr, expires: expiry, hostname: hostname}
+	err := s.db.QueryRow("select ip, expiry, hostname from leases4 where mac = ?", key).Scan(&lease.IP, &expiry, &lease.Hostname)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Lease{}, false, nil
 	}
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("failed lease database row scanning: %w", err)
+	if err != nil {
+		return Lease{}, false, fmt.Errorf("failed to query leases database: %w", err)
 	}
-	return records, nil
+	lease.Expires = time.Unix(expiry, 0).UTC()
+	return lease, true, nil
 }
 
-// saveIPAddress writes out a lease to storage
-func saveIPAddress(db *sql.DB, mac net.HardwareAddr, record record) error {
-	stmt, err := db.Prepare(`insert or replace into leases4(mac, ip, expiry, hostname) values (?, ?, ?, ?)`)
+// Save writes out a lease to storage, keyed on either a MAC address or a client identifier key.
+// It replaces any lease with the same key, also if that one is for another IP address.
+func (s *SQLiteStore) Save(lease Lease) error {
+	tx, err := s.db.Begin()
 	if err != nil {
-		return fmt.Errorf("statement preparation failed: %w", err)
+		return fmt.Errorf("transaction start failed: %w", err)
 	}
-	defer stmt.Close()
-	if _, err := stmt.Exec(
-		mac.String(),
-		record.IP.String(),
-		record.expires,
-		record.hostname,
+	defer tx.Rollback()
+	if _, err := tx.Exec("delete from leases4 where mac = ?", lease.MAC); err != nil {
+		return fmt.Errorf("record deletion failed: %w", err)
+	}
+	if _, err := tx.Exec(
+		`insert or replace into leases4(mac, ip, expiry, hostname) values (?, ?, ?, ?)`,
+		lease.MAC,
+		lease.IP,
+		lease.Expires.Unix(),
+		lease.Hostname,
 	); err != nil {
 		return fmt.Errorf("record insert/update failed: %w", err)
 	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("transaction commit failed: %w", err)
+	}
 	return nil
 }
+
+// Delete removes the lease with the given key, if it exists.
+func (s *SQLiteStore) Delete(key string) error {
+	if _, err := s.db.Exec("delete from leases4 where mac = ?", key); err != nil {
+		return fmt.Errorf("record deletion failed: %w", err)
+	}
+	return nil
+}
+
+// All returns all leases.
+func (s *SQLiteStore) All() ([]Lease, error) {
+	rows, err := s.db.Query("select mac, ip, expiry, hostname from leases4")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query leases database: %w", err)
+	}
+	defer rows.Close()
+	var leases []Lease
+	for rows.Next() {
+		var (
+			lease  Lease
+			expiry int64
+		)
+		if err := rows.Scan(&lease.MAC, &lease.IP, &expiry, &lease.Hostname); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		lease.Expires = time.Unix(expiry, 0).UTC()
+		leases = append(leases, lease)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed lease database row scanning: %w", err)
+	}
+	return leases, nil
+}
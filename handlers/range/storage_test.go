@@ -5,34 +5,34 @@
 package rangeplugin
 
 import (
-	"database/sql"
-	"fmt"
 	"net"
 	"testing"
 	"time"
 
+	"github.com/caddyserver/caddy/v2"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func testDBSetup() (*sql.DB, error) {
-	db, err := loadDB(":memory:")
-	if err != nil {
-		return nil, err
+// testStores returns a fresh instance of every LeaseStore implementation, by name.
+func testStores(t *testing.T) map[string]LeaseStore {
+	sqlite := &SQLiteStore{Filename: ":memory:"}
+	require.NoError(t, sqlite.Provision(caddy.Context{}))
+	t.Cleanup(func() { _ = sqlite.Close() })
+	return map[string]LeaseStore{
+		"memory": &MemoryStore{},
+		"redis":  newFakeRedisStore(),
+		"sqlite": sqlite,
 	}
-	for _, record := range records {
-		stmt, err := db.Prepare("insert into leases4(mac, ip, expiry, hostname) values (?, ?, ?, ?)")
-		if err != nil {
-			return nil, fmt.Errorf("failed to prepare insert statement: %w", err)
-		}
-		defer stmt.Close()
-		if _, err := stmt.Exec(record.mac, record.ip.IP.String(), record.ip.expires, record.ip.hostname); err != nil {
-			return nil, fmt.Errorf("failed to insert record into test db: %w", err)
-		}
+}
+
+func testStoreSetup(t *testing.T, store LeaseStore) {
+	for _, rec := range records {
+		require.NoError(t, store.Save(rec.ip.lease(rec.mac)))
 	}
-	return db, nil
 }
 
-var expire = int(time.Date(2000, 01, 01, 00, 00, 00, 00, time.UTC).Unix())
+var expire = int(time.Date(2100, 01, 01, 00, 00, 00, 00, time.UTC).Unix())
 var records = []struct {
 	mac string
 	ip  record
@@ -45,55 +45,79 @@ var records = []struct {
 	{"02:00:00:00:00:05", record{IP: net.IPv4(10, 0, 0, 5), expires: expire, hostname: "five"}},
 }
 
-func TestLoadRecords(t *testing.T) {
-	db, err := testDBSetup()
-	if err != nil {
-		t.Fatalf("Failed to set up test DB: %v", err)
-	}
+func TestLeaseStore(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			_, ok, err := store.Load("02:00:00:00:00:01")
+			require.NoError(t, err)
+			assert.False(t, ok)
 
-	parsedRec, err := loadRecords4(db)
-	if err != nil {
-		t.Fatalf("Failed to load records from file: %v", err)
-	}
+			testStoreSetup(t, store)
+			lease, ok, err := store.Load("02:00:00:00:00:01")
+			require.NoError(t, err)
+			require.True(t, ok)
+			assert.Equal(t, Lease{MAC: "02:00:00:00:00:01", IP: "10.0.0.1", Expires: time.Unix(int64(expire), 0).UTC(), Hostname: "one"}, lease)
 
-	mapRec := make(map[string]record)
-	for _, rec := range records {
-		var (
-			ip, mac, hostname string
-			expiry            int
-		)
-		if err := db.QueryRow("select mac, ip, expiry, hostname from leases4 where mac = ?", rec.mac).Scan(&mac, &ip, &expiry, &hostname); err != nil {
-			t.Fatalf("record not found for mac=%s: %v", rec.mac, err)
-		}
-		mapRec[mac] = record{IP: net.ParseIP(ip), expires: expiry, hostname: hostname}
-	}
+			// saving a lease with the same key replaces it
+			lease.IP = "10.0.0.42"
+			require.NoError(t, store.Save(lease))
+			replaced, ok, err := store.Load("02:00:00:00:00:01")
+			require.NoError(t, err)
+			require.True(t, ok)
+			assert.Equal(t, lease, replaced)
 
-	assert.Equal(t, mapRec, parsedRec, "Loaded records differ from what's in the DB")
-}
+			leases, err := store.All()
+			require.NoError(t, err)
+			assert.Len(t, leases, len(records))
+			assert.Contains(t, leases, lease)
 
-func TestWriteRecords(t *testing.T) {
-	db, err := loadDB(":memory:")
-	if err != nil {
-		t.Fatalf("Failed to set up test DB: %v", err)
-	}
+			require.NoError(t, store.Delete("02:00:00:00:00:01"))
+			_, ok, err = store.Load("02:00:00:00:00:01")
+			require.NoError(t, err)
+			assert.False(t, ok)
+			leases, err = store.All()
+			require.NoError(t, err)
+			assert.Len(t, leases, len(records)-1)
 
-	mapRec := make(map[string]record)
-	for _, rec := range records {
-		hwaddr, err := net.ParseMAC(rec.mac)
-		if err != nil {
-			// bug in testdata
-			panic(err)
-		}
-		if err := saveIPAddress(db, hwaddr, rec.ip); err != nil {
-			t.Errorf("Failed to save ip for %s: %v", hwaddr, err)
-		}
-		mapRec[hwaddr.String()] = record{IP: rec.ip.IP, expires: rec.ip.expires, hostname: rec.ip.hostname}
+			// deleting a missing lease is not an error
+			assert.NoError(t, store.Delete("02:00:00:00:00:01"))
+		})
 	}
+}
+
+func TestLoadRecords(t *testing.T) {
+	for name, store := range testStores(t) {
+		t.Run(name, func(t *testing.T) {
+			testStoreSetup(t, store)
+			leases, err := store.All()
+			require.NoError(t, err)
+			parsedRec, err := recordsFromLeases(leases)
+			if err != nil {
+				t.Fatalf("Failed to load records from store: %v", err)
+			}
 
-	parsedRec, err := loadRecords4(db)
-	if err != nil {
-		t.Fatal(err)
+			mapRec := make(map[string]record)
+			for _, rec := range records {
+				mapRec[rec.mac] = rec.ip
+			}
+			assert.Equal(t, mapRec, parsedRec, "Loaded records differ from what's in the store")
+		})
 	}
+}
+
+func TestLoadRecordsMalformed(t *testing.T) {
+	_, err := recordsFromLeases([]Lease{{MAC: "not a mac", IP: "10.0.0.1"}})
+	assert.Error(t, err)
+	_, err = recordsFromLeases([]Lease{{MAC: "02:00:00:00:00:01", IP: "2001:db8::1"}})
+	assert.Error(t, err)
+
+	// MAC addresses are normalized
+	parsedRec, err := recordsFromLeases([]Lease{{MAC: "02-00-00-00-00-0A", IP: "10.0.0.1"}})
+	require.NoError(t, err)
+	assert.Contains(t, parsedRec, "02:00:00:00:00:0a")
+}
 
-	assert.Equal(t, mapRec, parsedRec, "Loaded records differ from what's in the DB")
+func TestProvisionStore(t *testing.T) {
+	m := &Module{StartIP: "10.0.0.1", EndIP: "10.0.0.100"}
+	assert.Error(t, m.Provision(caddy.Context{}), "a range without a store or filename must be rejected")
 }
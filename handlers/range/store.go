@@ -0,0 +1,129 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package rangeplugin
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/lion7/caddydhcp/handlers"
+)
+
+// LeaseStore persists the DHCPv4 leases of a range. A lease is keyed on its MAC field,
+// which holds the MAC address of the client, or its client identifier key.
+//
+// Lease stores are Caddy modules in the `dhcp.leasestores` namespace. A store is shared by all range handlers
+// with the same configuration, also across config reloads, so it must not release its resources in Cleanup.
+// Instead, a store that implements io.Closer is closed when no range handler uses it anymore.
+type LeaseStore interface {
+	// Load returns the lease with the given key, and whether it exists.
+	Load(key string) (Lease, bool, error)
+	// Save adds or replaces the lease with the key of the given lease.
+	// A store shared by several servers returns ErrAddressInUse if another server leased the address to another client.
+	Save(lease Lease) error
+	// Delete removes the lease with the given key, if it exists.
+	Delete(key string) error
+	// All returns all leases.
+	All() ([]Lease, error)
+}
+
+// ErrAddressInUse is returned by a LeaseStore when saving a lease for an address that is leased to another client.
+var ErrAddressInUse = errors.New("address is leased to another client")
+
+// MemoryStore is a LeaseStore that keeps the leases in memory, so they are lost when the server stops.
+// They are kept across config reloads though, like the leases of any store.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	leases map[string]Lease
+}
+
+// CaddyModule returns the Caddy module information.
+func (*MemoryStore) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "dhcp.leasestores.memory",
+		New: func() caddy.Module { return new(MemoryStore) },
+	}
+}
+
+// Load returns the lease with the given key, and whether it exists.
+func (s *MemoryStore) Load(key string) (Lease, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	lease, ok := s.leases[key]
+	return lease, ok, nil
+}
+
+// Save adds or replaces the lease with the key of the given lease.
+func (s *MemoryStore) Save(lease Lease) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.leases == nil {
+		s.leases = make(map[string]Lease)
+	}
+	s.leases[lease.MAC] = lease
+	return nil
+}
+
+// Delete removes the lease with the given key, if it exists.
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.leases, key)
+	return nil
+}
+
+// All returns all leases.
+func (s *MemoryStore) All() ([]Lease, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	leases := make([]Lease, 0, len(s.leases))
+	for _, lease := range s.leases {
+		leases = append(leases, lease)
+	}
+	return leases, nil
+}
+
+// lease returns the record as a Lease with the given key.
+func (r record) lease(key string) Lease {
+	return Lease{
+		MAC:      key,
+		IP:       r.IP.String(),
+		Expires:  time.Unix(int64(r.expires), 0).UTC(),
+		Hostname: r.hostname,
+	}
+}
+
+// recordsFromLeases converts the leases read from a store into records, keyed on their normalized MAC address,
+// client identifier key or the key of a quarantined address.
+func recordsFromLeases(leases []Lease) (map[string]record, error) {
+	records := make(map[string]record, len(leases))
+	for _, lease := range leases {
+		key := lease.MAC
+		if !strings.HasPrefix(key, handlers.ClientIdentifierPrefix) && !strings.HasPrefix(key, declinedPrefix) {
+			hwaddr, err := net.ParseMAC(key)
+			if err != nil {
+				return nil, fmt.Errorf("malformed hardware address: %s", key)
+			}
+			key = hwaddr.String()
+		}
+		ip := net.ParseIP(lease.IP)
+		if ip.To4() == nil {
+			return nil, fmt.Errorf("expected an IPv4 address, got: %v", lease.IP)
+		}
+		records[key] = record{IP: ip, expires: int(lease.Expires.Unix()), hostname: lease.Hostname}
+	}
+	return records, nil
+}
+
+// Interfaces guards
+var (
+	_ LeaseStore = (*MemoryStore)(nil)
+	_ LeaseStore = (*SQLiteStore)(nil)
+)
@@ -0,0 +1,262 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package replace
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/lion7/caddydhcp/handlers"
+	"go.uber.org/zap"
+)
+
+// Module rewrites the values of options that the other handlers have put in the reply, e.g. to hand out
+// another DNS server to a class of clients than the dns handler configured for everyone.
+// The rules are applied in order after the rest of the chain has run, so this module should be placed
+// before the handlers whose options it should rewrite. Options that are not in the reply are never added.
+type Module struct {
+	// The rules for DHCPv4 and DHCPv6 replies respectively, since the option codes differ per family.
+	Rules4 []Rule `json:"rules4,omitempty"`
+	Rules6 []Rule `json:"rules6,omitempty"`
+
+	logger *zap.Logger
+}
+
+// Rule rewrites the value of an option.
+type Rule struct {
+	// The code of the option to rewrite.
+	Option uint16 `json:"option"`
+
+	// How Old and New are written: `text` (the default) for the literal value, `hex` for
+	// the value in hexadecimal, or `ip` for an IP address of the family of the rules.
+	// The text and hex values are compared with the whole value of the option. An option with the ip
+	// format is treated as a list of addresses, and every address in it equal to Old is replaced,
+	// so a single server can be swapped out of a list like that of the DNS servers option.
+	Format string `json:"format,omitempty"`
+
+	// The value to replace. If omitted, any value of the option is replaced.
+	Old string `json:"old,omitempty"`
+
+	// The value to replace it with.
+	New string `json:"new"`
+
+	// If set, the rule only applies to requests with one of these classes, as set by e.g. the classifier,
+	// or from one of these MAC addresses. For DHCPv6 the MAC address is taken from the Client Link-Layer
+	// Address option of the relay agent, or else from the DUID, if it contains one.
+	Classes []string `json:"classes,omitempty"`
+	MACs    []string `json:"macs,omitempty"`
+
+	old  []byte
+	new  []byte
+	size int
+	macs []net.HardwareAddr
+}
+
+// Values of Rule.Format.
+const (
+	formatText = "text"
+	formatHex  = "hex"
+	formatIP   = "ip"
+)
+
+// CaddyModule returns the Caddy module information.
+func (Module) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "dhcp.handlers.replace",
+		New: func() caddy.Module { return new(Module) },
+	}
+}
+
+// Provision is run immediately after this handler is being loaded.
+func (m *Module) Provision(ctx caddy.Context) error {
+	m.logger = ctx.Logger()
+	if len(m.Rules4) == 0 && len(m.Rules6) == 0 {
+		return errors.New("need at least one rule")
+	}
+	for i := range m.Rules4 {
+		r := &m.Rules4[i]
+		if r.Option == 0 || r.Option > 254 {
+			return fmt.Errorf("DHCPv4 rule %d: invalid option code %d", i, r.Option)
+		}
+		if err := r.provision(net.IPv4len); err != nil {
+			return fmt.Errorf("DHCPv4 rule %d: %w", i, err)
+		}
+	}
+	for i := range m.Rules6 {
+		r := &m.Rules6[i]
+		if r.Option == 0 {
+			return fmt.Errorf("DHCPv6 rule %d: invalid option code %d", i, r.Option)
+		}
+		if err := r.provision(net.IPv6len); err != nil {
+			return fmt.Errorf("DHCPv6 rule %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// provision parses the values and MAC addresses of the rule, using ipLen as the length of addresses.
+func (r *Rule) provision(ipLen int) error {
+	var err error
+	r.size = 0
+	switch r.Format {
+	case "", formatText:
+		r.old, r.new = []byte(r.Old), []byte(r.New)
+	case formatHex:
+		if r.old, err = hex.DecodeString(r.Old); err != nil {
+			return fmt.Errorf("invalid old value %q: %v", r.Old, err)
+		}
+		if r.new, err = hex.DecodeString(r.New); err != nil {
+			return fmt.Errorf("invalid new value %q: %v", r.New, err)
+		}
+	case formatIP:
+		r.size = ipLen
+		if r.Old != "" {
+			if r.old, err = parseIP(r.Old, ipLen); err != nil {
+				return fmt.Errorf("invalid old value: %w", err)
+			}
+		}
+		if r.new, err = parseIP(r.New, ipLen); err != nil {
+			return fmt.Errorf("invalid new value: %w", err)
+		}
+	default:
+		return fmt.Errorf("invalid format %q, expected one of %q, %q or %q", r.Format, formatText, formatHex, formatIP)
+	}
+	if r.Old == "" {
+		r.old = nil
+	}
+
+	r.macs = nil
+	for _, s := range r.MACs {
+		mac, err := net.ParseMAC(s)
+		if err != nil {
+			return fmt.Errorf("invalid MAC address %q: %v", s, err)
+		}
+		r.macs = append(r.macs, mac)
+	}
+	return nil
+}
+
+// parseIP parses an IP address of the family with addresses of length ipLen.
+func parseIP(s string, ipLen int) ([]byte, error) {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("%q is not an IP address", s)
+	}
+	if ipLen == net.IPv4len {
+		if ip = ip.To4(); ip == nil {
+			return nil, fmt.Errorf("%q is not an IPv4 address", s)
+		}
+		return ip, nil
+	}
+	if ip.To4() != nil {
+		return nil, fmt.Errorf("%q is not an IPv6 address", s)
+	}
+	return ip, nil
+}
+
+// applies reports whether the rule applies to a request with the given class and MAC address.
+func (r *Rule) applies(class string, mac net.HardwareAddr) bool {
+	if len(r.Classes) == 0 && len(r.macs) == 0 {
+		return true
+	}
+	if class != "" && slices.Contains(r.Classes, class) {
+		return true
+	}
+	for _, m := range r.macs {
+		if bytes.Equal(m, mac) {
+			return true
+		}
+	}
+	return false
+}
+
+// rewrite returns the rewritten value, and whether the rule matched the value.
+func (r *Rule) rewrite(value []byte) ([]byte, bool) {
+	if r.size == 0 {
+		if r.old != nil && !bytes.Equal(value, r.old) {
+			return nil, false
+		}
+		return append([]byte(nil), r.new...), true
+	}
+	if len(value)%r.size != 0 {
+		return nil, false
+	}
+	rewritten := append([]byte(nil), value...)
+	matched := false
+	for i := 0; i < len(rewritten); i += r.size {
+		if r.old == nil || bytes.Equal(rewritten[i:i+r.size], r.old) {
+			copy(rewritten[i:i+r.size], r.new)
+			matched = true
+		}
+	}
+	return rewritten, matched
+}
+
+// Handle4 handles DHCPv4 packets for this plugin.
+func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
+	nextErr := next()
+	if nextErr != nil && !errors.Is(nextErr, handlers.ErrStopAndReply) {
+		return nextErr
+	}
+
+	for i := range m.Rules4 {
+		r := &m.Rules4[i]
+		if !r.applies(req.Class(), req.ClientHWAddr) {
+			continue
+		}
+		code := dhcpv4.GenericOptionCode(r.Option)
+		value := resp.Options.Get(code)
+		if value == nil {
+			continue
+		}
+		if rewritten, ok := r.rewrite(value); ok {
+			m.logger.Debug("rewrote option", zap.Uint16("option", r.Option), zap.String("old", hex.EncodeToString(value)), zap.String("new", hex.EncodeToString(rewritten)))
+			resp.UpdateOption(dhcpv4.OptGeneric(code, rewritten))
+		}
+	}
+	return nextErr
+}
+
+// Handle6 handles DHCPv6 packets for this plugin.
+func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
+	nextErr := next()
+	if nextErr != nil && !errors.Is(nextErr, handlers.ErrStopAndReply) {
+		return nextErr
+	}
+
+	mac := req.ClientLinkLayerAddress()
+	if mac == nil {
+		mac, _ = dhcpv6.ExtractMAC(req.Message)
+	}
+	for i := range m.Rules6 {
+		r := &m.Rules6[i]
+		if !r.applies(req.Class(), mac) {
+			continue
+		}
+		code := dhcpv6.OptionCode(r.Option)
+		opt := resp.GetOneOption(code)
+		if opt == nil {
+			continue
+		}
+		value := opt.ToBytes()
+		if rewritten, ok := r.rewrite(value); ok {
+			m.logger.Debug("rewrote option", zap.Uint16("option", r.Option), zap.String("old", hex.EncodeToString(value)), zap.String("new", hex.EncodeToString(rewritten)))
+			resp.UpdateOption(&dhcpv6.OptionGeneric{OptionCode: code, OptionData: rewritten})
+		}
+	}
+	return nextErr
+}
+
+// Interfaces guards
+var (
+	_ handlers.HandlerModule = (*Module)(nil)
+)
@@ -0,0 +1,142 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package replace
+
+import (
+	"net"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/lion7/caddydhcp/handlers/handlertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	mac      = net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	otherMAC = net.HardwareAddr{0x02, 0, 0, 0, 0, 2}
+)
+
+// replace4 runs the module for a request from hwaddr with the given class,
+// with the rest of the chain setting the given options.
+func replace4(t *testing.T, m *Module, hwaddr net.HardwareAddr, class string, options ...dhcpv4.Option) *dhcpv4.DHCPv4 {
+	req, err := dhcpv4.NewDiscovery(hwaddr)
+	require.NoError(t, err)
+	r := handlers.NewDHCPv4(req)
+	r.SetClass(class)
+	resp, err := handlertest.Handle4(t, m, r, func(resp *dhcpv4.DHCPv4) error {
+		for _, opt := range options {
+			resp.UpdateOption(opt)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	return resp
+}
+
+func TestHandle4DNS(t *testing.T) {
+	m := handlertest.Provision(t, &Module{Rules4: []Rule{{
+		Option:  uint16(dhcpv4.OptionDomainNameServer.Code()),
+		Format:  "ip",
+		Old:     "10.0.0.53",
+		New:     "10.0.1.53",
+		Classes: []string{"guests"},
+		MACs:    []string{otherMAC.String()},
+	}}})
+	dns := dhcpv4.OptDNS(net.IPv4(10, 0, 0, 53), net.IPv4(10, 0, 0, 54))
+
+	for _, tc := range []struct {
+		name   string
+		hwaddr net.HardwareAddr
+		class  string
+		want   []net.IP
+	}{
+		{"matched class", mac, "guests", []net.IP{net.IPv4(10, 0, 1, 53), net.IPv4(10, 0, 0, 54)}},
+		{"matched MAC", otherMAC, "", []net.IP{net.IPv4(10, 0, 1, 53), net.IPv4(10, 0, 0, 54)}},
+		{"other class", mac, "staff", []net.IP{net.IPv4(10, 0, 0, 53), net.IPv4(10, 0, 0, 54)}},
+		{"unclassified", mac, "", []net.IP{net.IPv4(10, 0, 0, 53), net.IPv4(10, 0, 0, 54)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := replace4(t, m, tc.hwaddr, tc.class, dns)
+			got := resp.DNS()
+			require.Len(t, got, len(tc.want))
+			for i := range tc.want {
+				assert.True(t, tc.want[i].Equal(got[i]), got[i].String())
+			}
+		})
+	}
+}
+
+func TestHandle4Text(t *testing.T) {
+	m := handlertest.Provision(t, &Module{Rules4: []Rule{
+		{Option: uint16(dhcpv4.OptionDomainName.Code()), Old: "example.com", New: "guests.example.com"},
+		{Option: uint16(dhcpv4.OptionBootfileName.Code()), New: "ipxe.efi"},
+		{Option: uint16(dhcpv4.OptionTFTPServerName.Code()), Format: "hex", New: "7466747000"},
+	}})
+
+	resp := replace4(t, m, mac, "",
+		dhcpv4.OptDomainName("example.com"),
+		dhcpv4.OptBootFileName("pxelinux.0"),
+	)
+	assert.Equal(t, "guests.example.com", resp.DomainName())
+	assert.Equal(t, "ipxe.efi", resp.BootFileNameOption())
+	// options that are not in the reply are not added
+	assert.False(t, resp.Options.Has(dhcpv4.OptionTFTPServerName))
+
+	resp = replace4(t, m, mac, "", dhcpv4.OptDomainName("example.org"))
+	assert.Equal(t, "example.org", resp.DomainName())
+}
+
+func TestHandle6DNS(t *testing.T) {
+	m := handlertest.Provision(t, &Module{Rules6: []Rule{{
+		Option: uint16(dhcpv6.OptionDNSRecursiveNameServer),
+		Format: "ip",
+		Old:    "2001:db8::53",
+		New:    "2001:db8:1::53",
+		MACs:   []string{mac.String()},
+	}}})
+
+	for _, tc := range []struct {
+		hwaddr net.HardwareAddr
+		want   net.IP
+	}{
+		{mac, net.ParseIP("2001:db8:1::53")},
+		{otherMAC, net.ParseIP("2001:db8::53")},
+	} {
+		req, err := dhcpv6.NewMessage(dhcpv6.WithClientID(&dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: tc.hwaddr}))
+		require.NoError(t, err)
+		req.MessageType = dhcpv6.MessageTypeRequest
+		resp, err := handlertest.Handle6(t, m, handlers.NewDHCPv6(req), func(resp *dhcpv6.Message) error {
+			resp.UpdateOption(dhcpv6.OptDNS(net.ParseIP("2001:db8::53")))
+			return nil
+		})
+		require.NoError(t, err)
+
+		// round-trip through the wire format like a client would receive it
+		msg, err := dhcpv6.MessageFromBytes(resp.ToBytes())
+		require.NoError(t, err)
+		assert.Equal(t, []net.IP{tc.want}, msg.Options.DNS(), tc.hwaddr.String())
+	}
+}
+
+func TestProvisionInvalid(t *testing.T) {
+	for _, m := range []*Module{
+		{},
+		{Rules4: []Rule{{Option: 0, New: "x"}}},
+		{Rules4: []Rule{{Option: 255, New: "x"}}},
+		{Rules4: []Rule{{Option: 6, Format: "ip", New: "2001:db8::53"}}},
+		{Rules4: []Rule{{Option: 6, Format: "ip", Old: "garbage", New: "10.0.0.53"}}},
+		{Rules4: []Rule{{Option: 6, Format: "hex", New: "xyz"}}},
+		{Rules4: []Rule{{Option: 6, Format: "base64", New: "x"}}},
+		{Rules4: []Rule{{Option: 6, New: "x", MACs: []string{"garbage"}}}},
+		{Rules6: []Rule{{Option: 23, Format: "ip", New: "10.0.0.53"}}},
+	} {
+		assert.Error(t, m.Provision(caddy.Context{}))
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package resolver
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/rfc1035label"
+	"github.com/lion7/caddydhcp/handlers"
+	"go.uber.org/zap"
+)
+
+// Module adds the DHCPv6 DNS recursive name servers (option 23) and domain search list (option 24) as one bundle,
+// so a class of clients, as set by e.g. the classifier, gets a coherent set of resolvers and search domains:
+//
+//	{
+//	  "handler": "resolver",
+//	  "servers": ["2001:db8::53"],
+//	  "domains": ["corp.example.com"],
+//	  "classes": {
+//	    "guest": {"servers": ["2001:db8:1::53"], "domains": ["guest.example.com"]}
+//	  }
+//	}
+//
+// A class with a bundle gets only that bundle, never a mix with the default bundle or with the options of
+// other handlers like dns and searchdomains: the bundle is applied after the rest of the chain has run,
+// replacing the options of those handlers, and an empty list in a bundle removes the option from the reply. The options are only added if they are
+// requested by the client.
+type Module struct {
+	// the dns and searchdomains handlers serve DHCPv4
+	handlers.Base
+
+	Bundle
+
+	// The bundles for classes of clients, keyed by class.
+	Classes map[string]Bundle `json:"classes,omitempty"`
+
+	bundle  bundle
+	classes map[string]bundle
+	logger  *zap.Logger
+}
+
+// Bundle is a set of resolvers and search domains.
+type Bundle struct {
+	// The IPv6 addresses of the recursive DNS servers.
+	Servers []string `json:"servers,omitempty"`
+	// The search domains, with or without the trailing dot of the root domain.
+	Domains []string `json:"domains,omitempty"`
+}
+
+// bundle is a parsed Bundle.
+type bundle struct {
+	servers []net.IP
+	domains []string
+}
+
+// CaddyModule returns the Caddy module information.
+func (Module) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "dhcp.handlers.resolver",
+		New: func() caddy.Module { return new(Module) },
+	}
+}
+
+// Provision is run immediately after this handler is being loaded.
+func (m *Module) Provision(ctx caddy.Context) error {
+	m.logger = ctx.Logger()
+	var err error
+	if m.bundle, err = m.Bundle.parse(); err != nil {
+		return err
+	}
+	m.classes = make(map[string]bundle, len(m.Classes))
+	for class, b := range m.Classes {
+		if class == "" {
+			return fmt.Errorf("empty class name")
+		}
+		if m.classes[class], err = b.parse(); err != nil {
+			return fmt.Errorf("class %s: %w", class, err)
+		}
+	}
+	return nil
+}
+
+// parse validates the servers and domains of the bundle.
+func (b Bundle) parse() (bundle, error) {
+	var parsed bundle
+	for _, server := range b.Servers {
+		ip := net.ParseIP(server)
+		if ip == nil || ip.To4() != nil {
+			return bundle{}, fmt.Errorf("invalid server %q: not an IPv6 address", server)
+		}
+		parsed.servers = append(parsed.servers, ip)
+	}
+	for _, domain := range b.Domains {
+		name := strings.TrimSuffix(domain, ".")
+		for _, label := range strings.Split(name, ".") {
+			if label == "" || len(label) > 63 {
+				return bundle{}, fmt.Errorf("invalid search domain %q", domain)
+			}
+		}
+		parsed.domains = append(parsed.domains, name)
+	}
+	return parsed, nil
+}
+
+// Handle6 handles DHCPv6 packets for this plugin.
+func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
+	b := m.bundle
+	if class, ok := m.classes[req.Class()]; ok {
+		b = class
+	}
+	err := next()
+	resp.Options.Del(dhcpv6.OptionDNSRecursiveNameServer)
+	resp.Options.Del(dhcpv6.OptionDomainSearchList)
+	if len(b.servers) > 0 && req.IsOptionRequested(dhcpv6.OptionDNSRecursiveNameServer) {
+		resp.UpdateOption(dhcpv6.OptDNS(b.servers...))
+	}
+	if len(b.domains) > 0 && req.IsOptionRequested(dhcpv6.OptionDomainSearchList) {
+		resp.UpdateOption(dhcpv6.OptDomainSearchList(&rfc1035label.Labels{Labels: append([]string(nil), b.domains...)}))
+	}
+	return err
+}
+
+// Interfaces guards
+var (
+	_ handlers.HandlerModule = (*Module)(nil)
+)
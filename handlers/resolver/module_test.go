@@ -0,0 +1,88 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package resolver
+
+import (
+	"net"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/rfc1035label"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/lion7/caddydhcp/handlers/handlertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// resolvers6 runs the module for a request with the given class, with the rest of the chain
+// adding the DNS servers and search domains of other handlers.
+func resolvers6(t *testing.T, m *Module, class string) *dhcpv6.Message {
+	req, err := dhcpv6.NewSolicit(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	req.UpdateOption(dhcpv6.OptRequestedOption(dhcpv6.OptionDNSRecursiveNameServer, dhcpv6.OptionDomainSearchList))
+	r := handlers.NewDHCPv6(req)
+	r.SetClass(class)
+	resp, err := handlertest.Handle6(t, m, r, func(resp *dhcpv6.Message) error {
+		resp.UpdateOption(dhcpv6.OptDNS(net.ParseIP("2001:db8:ff::53")))
+		resp.UpdateOption(dhcpv6.OptDomainSearchList(&rfc1035label.Labels{Labels: []string{"other.example.com"}}))
+		return nil
+	})
+	require.NoError(t, err)
+
+	// round-trip through the wire format like a client would receive it
+	msg, err := dhcpv6.MessageFromBytes(resp.ToBytes())
+	require.NoError(t, err)
+	return msg
+}
+
+func TestHandle6Classes(t *testing.T) {
+	m := handlertest.Provision(t, &Module{
+		Bundle: Bundle{Servers: []string{"2001:db8::53"}, Domains: []string{"example.com"}},
+		Classes: map[string]Bundle{
+			"corp":  {Servers: []string{"2001:db8:1::53", "2001:db8:1::54"}, Domains: []string{"corp.example.com."}},
+			"guest": {Servers: []string{"2001:db8:2::53"}},
+		},
+	})
+
+	resp := resolvers6(t, m, "corp")
+	assert.Equal(t, []net.IP{net.ParseIP("2001:db8:1::53"), net.ParseIP("2001:db8:1::54")}, resp.Options.DNS())
+	assert.Equal(t, []string{"corp.example.com"}, resp.Options.DomainSearchList().Labels)
+
+	// the guest bundle has no search domains, so none are sent rather than those of another handler
+	resp = resolvers6(t, m, "guest")
+	assert.Equal(t, []net.IP{net.ParseIP("2001:db8:2::53")}, resp.Options.DNS())
+	assert.Nil(t, resp.GetOneOption(dhcpv6.OptionDomainSearchList))
+
+	// other classes and unclassified clients get the default bundle
+	for _, class := range []string{"", "iot"} {
+		resp = resolvers6(t, m, class)
+		assert.Equal(t, []net.IP{net.ParseIP("2001:db8::53")}, resp.Options.DNS())
+		assert.Equal(t, []string{"example.com"}, resp.Options.DomainSearchList().Labels)
+	}
+}
+
+func TestHandle6NotRequested(t *testing.T) {
+	m := handlertest.Provision(t, &Module{Bundle: Bundle{Servers: []string{"2001:db8::53"}, Domains: []string{"example.com"}}})
+	req, err := dhcpv6.NewSolicit(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	req.UpdateOption(dhcpv6.OptRequestedOption(dhcpv6.OptionDomainSearchList))
+	resp, err := handlertest.Handle6(t, m, handlers.NewDHCPv6(req), nil)
+	require.NoError(t, err)
+
+	assert.Nil(t, resp.GetOneOption(dhcpv6.OptionDNSRecursiveNameServer))
+	assert.NotNil(t, resp.GetOneOption(dhcpv6.OptionDomainSearchList))
+}
+
+func TestProvisionInvalid(t *testing.T) {
+	for _, m := range []*Module{
+		{Bundle: Bundle{Servers: []string{"10.0.0.53"}}},
+		{Bundle: Bundle{Domains: []string{"example..com"}}},
+		{Classes: map[string]Bundle{"guest": {Servers: []string{"dns.example.com"}}}},
+		{Classes: map[string]Bundle{"": {Servers: []string{"2001:db8::53"}}}},
+	} {
+		assert.Error(t, m.Provision(caddy.Context{}))
+	}
+}
@@ -7,6 +7,9 @@ package router
 import (
 	"fmt"
 	"net"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/caddyserver/caddy/v2"
 	"github.com/insomniacslk/dhcp/dhcpv4"
@@ -14,9 +17,22 @@ import (
 	"go.uber.org/zap"
 )
 
+// Module sets the routers (option 3) of DHCPv4 replies.
+//
+// DHCPv6 has no router option, since IPv6 hosts learn their default route from router advertisements (RAs).
+// To keep the RA daemon in sync with the DHCP configuration, the routers can be written to RAExportFile,
+// from which an external RA integration can read them. IPv6 routers are only accepted in that case,
+// and are only exported.
 type Module struct {
+	// DHCPv6 clients learn their routers from router advertisements, see RAExportFile
+	handlers.Base
+
 	Routers []string `json:"routers"`
 
+	// Path of a file to which the configured routers are written, one per line, when the handler is loaded.
+	// Nothing is written when empty, which is the default.
+	RAExportFile string `json:"raExportFile,omitempty"`
+
 	routers []net.IP
 	logger  *zap.Logger
 }
@@ -34,22 +50,55 @@ func (m *Module) Provision(ctx caddy.Context) error {
 	var routers []net.IP
 	for _, r := range m.Routers {
 		router := net.ParseIP(r)
-		if router.To4() == nil {
-			return fmt.Errorf("expected an router IP address, got: %s", router)
+		if router == nil || (router.To4() == nil && m.RAExportFile == "") {
+			return fmt.Errorf("expected an router IP address, got: %s", r)
+		}
+		if router.To4() != nil {
+			routers = append(routers, router)
 		}
-		routers = append(routers, router)
 	}
 	m.routers = routers
+	if m.RAExportFile != "" {
+		if err := m.exportRouters(); err != nil {
+			return fmt.Errorf("exporting routers: %v", err)
+		}
+	}
 	return nil
 }
 
-func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
-	resp.UpdateOption(dhcpv4.OptRouter(m.routers...))
-	return next()
+// exportRouters writes the configured routers to RAExportFile. The file is replaced atomically,
+// so a reader never sees a partially written file.
+func (m *Module) exportRouters() error {
+	var b strings.Builder
+	for _, r := range m.Routers {
+		b.WriteString(net.ParseIP(r).String())
+		b.WriteByte('\n')
+	}
+	f, err := os.CreateTemp(filepath.Dir(m.RAExportFile), filepath.Base(m.RAExportFile)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(b.String()); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Chmod(0o644); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), m.RAExportFile); err != nil {
+		return err
+	}
+	m.logger.Info("exported routers for router advertisements", zap.String("file", m.RAExportFile), zap.Strings("routers", m.Routers))
+	return nil
 }
 
-func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
-	// router does not apply to DHCPv6, so just continue the chain
+func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
+	resp.UpdateOption(dhcpv4.OptRouter(m.routers...))
 	return next()
 }
 
@@ -0,0 +1,55 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package router
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRAExport(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "routers")
+	require.NoError(t, os.WriteFile(filename, []byte("stale\n"), 0o644))
+
+	m := &Module{Routers: []string{"10.0.0.1", "fe80::1"}, RAExportFile: filename}
+	require.NoError(t, m.Provision(caddy.Context{}))
+
+	b, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "10.0.0.1\nfe80::1\n", string(b))
+	// no temporary files are left behind
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	// only the IPv4 routers are sent to DHCPv4 clients
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	require.NoError(t, m.Handle4(handlers.DHCPv4{DHCPv4: req}, handlers.DHCPv4{DHCPv4: resp}, func() error { return nil }))
+	assert.Equal(t, []net.IP{net.IPv4(10, 0, 0, 1).To4()}, resp.Router())
+}
+
+func TestRAExportDisabled(t *testing.T) {
+	m := &Module{Routers: []string{"10.0.0.1"}}
+	require.NoError(t, m.Provision(caddy.Context{}))
+
+	// IPv6 routers are only accepted when they are exported
+	m = &Module{Routers: []string{"10.0.0.1", "fe80::1"}}
+	assert.Error(t, m.Provision(caddy.Context{}))
+
+	m = &Module{Routers: []string{"10.0.0.1"}, RAExportFile: filepath.Join(t.TempDir(), "missing", "routers")}
+	assert.Error(t, m.Provision(caddy.Context{}))
+}
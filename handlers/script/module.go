@@ -0,0 +1,309 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package script
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/lion7/caddydhcp/handlers"
+	"go.uber.org/zap"
+)
+
+// Module runs an external command for every request, after the rest of the chain has handled it,
+// so custom logic can be added without writing a handler in Go. Wrap it in the when handler to only
+// run it for some message types, e.g. for Discover and Request.
+//
+// The command receives the request and the reply as a JSON object on its standard input:
+//
+//	{
+//	  "family": 4,
+//	  "message_type": "DISCOVER",
+//	  "mac": "02:00:00:00:00:01",
+//	  "class": "voip",
+//	  "reply_type": "OFFER",
+//	  "assigned": ["10.0.0.10"],
+//	  "options": {"12": "686f7374"}
+//	}
+//
+// along with the fields `xid`, `client_id` and `relay` if present, and the options of the request as hex values
+// keyed by their code. The most important fields are also set in the environment, as DHCP_FAMILY,
+// DHCP_MESSAGE_TYPE, DHCP_MAC, DHCP_CLIENT_ID, DHCP_CLASS, DHCP_REPLY_TYPE and DHCP_ASSIGNED.
+//
+// The command may write a JSON object to its standard output to change the reply, with the hex values of the
+// options to set keyed by their code, where an empty value removes the option, and whether to drop the request:
+//
+//	{"options": {"6": "0a000035", "15": ""}, "drop": false}
+//
+// If the command fails, times out or writes invalid output, the failure is logged and the reply is left as is.
+type Module struct {
+	// The command to run, and its arguments.
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+
+	// How long the command may run before it is killed. Waiting for a free worker counts as well.
+	// Defaults to 2 seconds.
+	Timeout caddy.Duration `json:"timeout,omitempty"`
+
+	// The maximum number of commands that run at the same time, so a burst of requests
+	// does not fork a process for each of them. Defaults to 4.
+	Workers int `json:"workers,omitempty"`
+
+	workers chan struct{}
+	logger  *zap.Logger
+}
+
+// input is the JSON object written to the standard input of the command.
+type input struct {
+	Family      int               `json:"family"`
+	MessageType string            `json:"message_type"`
+	XID         string            `json:"xid,omitempty"`
+	MAC         string            `json:"mac,omitempty"`
+	ClientID    string            `json:"client_id,omitempty"`
+	Relay       string            `json:"relay,omitempty"`
+	Class       string            `json:"class,omitempty"`
+	ReplyType   string            `json:"reply_type"`
+	Assigned    []string          `json:"assigned,omitempty"`
+	Options     map[string]string `json:"options,omitempty"`
+}
+
+// output is the JSON object read from the standard output of the command.
+type output struct {
+	Options map[string]string `json:"options,omitempty"`
+	Drop    bool              `json:"drop,omitempty"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (Module) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "dhcp.handlers.script",
+		New: func() caddy.Module { return new(Module) },
+	}
+}
+
+// Provision is run immediately after this handler is being loaded.
+func (m *Module) Provision(ctx caddy.Context) error {
+	m.logger = ctx.Logger()
+	if m.Command == "" {
+		return fmt.Errorf("no command configured")
+	}
+	if m.Timeout < 0 {
+		return fmt.Errorf("invalid timeout %s", time.Duration(m.Timeout))
+	}
+	if m.Timeout == 0 {
+		m.Timeout = caddy.Duration(2 * time.Second)
+	}
+	if m.Workers < 0 {
+		return fmt.Errorf("invalid number of workers %d", m.Workers)
+	}
+	if m.Workers == 0 {
+		m.Workers = 4
+	}
+	m.workers = make(chan struct{}, m.Workers)
+	return nil
+}
+
+// Handle4 handles DHCPv4 packets for this plugin.
+func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
+	nextErr := next()
+	if nextErr != nil && !errors.Is(nextErr, handlers.ErrStopAndReply) {
+		return nextErr
+	}
+
+	in := input{
+		Family:      4,
+		MessageType: req.MessageType().String(),
+		XID:         req.TransactionID.String(),
+		MAC:         req.ClientHWAddr.String(),
+		Class:       req.Class(),
+		ReplyType:   resp.MessageType().String(),
+		Options:     make(map[string]string),
+	}
+	if cid := req.Options.Get(dhcpv4.OptionClientIdentifier); cid != nil {
+		in.ClientID = hex.EncodeToString(cid)
+	}
+	if req.GatewayIPAddr != nil && !req.GatewayIPAddr.IsUnspecified() {
+		in.Relay = req.GatewayIPAddr.String()
+	}
+	if resp.YourIPAddr != nil && !resp.YourIPAddr.IsUnspecified() {
+		in.Assigned = []string{resp.YourIPAddr.String()}
+	}
+	for code, value := range req.Options {
+		in.Options[strconv.Itoa(int(code))] = hex.EncodeToString(value)
+	}
+
+	out, err := m.run(in)
+	if err != nil {
+		m.logger.Warn("script failed", zap.Stringer("mac", req.ClientHWAddr), zap.Error(err))
+		return nextErr
+	}
+	if out.Drop {
+		m.logger.Debug("script dropped the request", zap.Stringer("mac", req.ClientHWAddr))
+		return handlers.ErrDrop
+	}
+	options, err := parseOptions(out.Options, 254)
+	if err != nil {
+		m.logger.Warn("script wrote invalid options", zap.Stringer("mac", req.ClientHWAddr), zap.Error(err))
+		return nextErr
+	}
+	for code, value := range options {
+		if len(value) == 0 {
+			resp.Options.Del(dhcpv4.GenericOptionCode(code))
+			continue
+		}
+		resp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.GenericOptionCode(code), value))
+	}
+	return nextErr
+}
+
+// Handle6 handles DHCPv6 packets for this plugin.
+func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
+	nextErr := next()
+	if nextErr != nil && !errors.Is(nextErr, handlers.ErrStopAndReply) {
+		return nextErr
+	}
+
+	in := input{
+		Family:      6,
+		MessageType: req.MessageType.String(),
+		XID:         req.TransactionID.String(),
+		Class:       req.Class(),
+		ReplyType:   resp.MessageType.String(),
+		Options:     make(map[string]string),
+	}
+	if mac := req.ClientLinkLayerAddress(); mac != nil {
+		in.MAC = mac.String()
+	} else if mac, err := dhcpv6.ExtractMAC(req.Message); err == nil {
+		in.MAC = mac.String()
+	}
+	if duid := req.Options.ClientID(); duid != nil {
+		in.ClientID = hex.EncodeToString(duid.ToBytes())
+	}
+	if link := req.LinkAddress(); link != nil {
+		in.Relay = link.String()
+	}
+	for _, iana := range resp.Options.IANA() {
+		for _, addr := range iana.Options.Addresses() {
+			in.Assigned = append(in.Assigned, addr.IPv6Addr.String())
+		}
+	}
+	for _, iapd := range resp.Options.IAPD() {
+		for _, prefix := range iapd.Options.Prefixes() {
+			in.Assigned = append(in.Assigned, prefix.Prefix.String())
+		}
+	}
+	for _, opt := range req.Options.Options {
+		// only the first of the options that may occur multiple times, like IA_NA
+		key := strconv.Itoa(int(opt.Code()))
+		if _, ok := in.Options[key]; !ok {
+			in.Options[key] = hex.EncodeToString(opt.ToBytes())
+		}
+	}
+
+	out, err := m.run(in)
+	if err != nil {
+		m.logger.Warn("script failed", zap.String("client_id", in.ClientID), zap.Error(err))
+		return nextErr
+	}
+	if out.Drop {
+		m.logger.Debug("script dropped the request", zap.String("client_id", in.ClientID))
+		return handlers.ErrDrop
+	}
+	options, err := parseOptions(out.Options, 65535)
+	if err != nil {
+		m.logger.Warn("script wrote invalid options", zap.String("client_id", in.ClientID), zap.Error(err))
+		return nextErr
+	}
+	for code, value := range options {
+		if len(value) == 0 {
+			resp.Options.Del(dhcpv6.OptionCode(code))
+			continue
+		}
+		resp.UpdateOption(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionCode(code), OptionData: value})
+	}
+	return nextErr
+}
+
+// run runs the command with the input, once a worker is free, and parses its output.
+func (m *Module) run(in input) (output, error) {
+	var out output
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(m.Timeout))
+	defer cancel()
+
+	select {
+	case m.workers <- struct{}{}:
+		defer func() { <-m.workers }()
+	case <-ctx.Done():
+		return out, fmt.Errorf("no free worker: %w", ctx.Err())
+	}
+
+	stdin, err := json.Marshal(in)
+	if err != nil {
+		return out, err
+	}
+	cmd := exec.CommandContext(ctx, m.Command, m.Args...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Env = append(os.Environ(),
+		"DHCP_FAMILY="+strconv.Itoa(in.Family),
+		"DHCP_MESSAGE_TYPE="+in.MessageType,
+		"DHCP_MAC="+in.MAC,
+		"DHCP_CLIENT_ID="+in.ClientID,
+		"DHCP_CLASS="+in.Class,
+		"DHCP_REPLY_TYPE="+in.ReplyType,
+		"DHCP_ASSIGNED="+strings.Join(in.Assigned, " "),
+	)
+	// do not wait for children of the command that keep its output open after it was killed
+	cmd.WaitDelay = 100 * time.Millisecond
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.Output()
+	if ctx.Err() != nil {
+		return out, fmt.Errorf("timed out after %s", time.Duration(m.Timeout))
+	}
+	if err != nil {
+		return out, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if len(bytes.TrimSpace(stdout)) == 0 {
+		return out, nil
+	}
+	if err := json.Unmarshal(stdout, &out); err != nil {
+		return out, fmt.Errorf("invalid output: %v", err)
+	}
+	return out, nil
+}
+
+// parseOptions parses the hex values of the options written by the command, keyed by their code up to maxCode.
+func parseOptions(options map[string]string, maxCode uint64) (map[uint16][]byte, error) {
+	parsed := make(map[uint16][]byte, len(options))
+	for key, value := range options {
+		code, err := strconv.ParseUint(key, 10, 16)
+		if err != nil || code == 0 || code > maxCode {
+			return nil, fmt.Errorf("invalid option code %q", key)
+		}
+		b, err := hex.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of option %d: %v", code, err)
+		}
+		parsed[uint16(code)] = b
+	}
+	return parsed, nil
+}
+
+// Interfaces guards
+var (
+	_ handlers.HandlerModule = (*Module)(nil)
+)
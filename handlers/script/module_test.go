@@ -0,0 +1,146 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package script
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/lion7/caddydhcp/handlers/handlertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newScript writes a shell script with the given body and returns a module running it, and the directory
+// passed to the script as its argument.
+func newScript(t *testing.T, body string, timeout time.Duration) (*Module, string) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hook.sh")
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o755))
+	return handlertest.Provision(t, &Module{Command: path, Args: []string{dir}, Timeout: caddy.Duration(timeout)}), dir
+}
+
+// offer4 runs the module for an offer of 10.0.0.10 to a DHCPv4 client.
+func offer4(t *testing.T, m *Module) (*dhcpv4.DHCPv4, error) {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, dhcpv4.WithOption(dhcpv4.OptHostName("host")))
+	require.NoError(t, err)
+	return handlertest.Handle4(t, m, handlers.NewDHCPv4(req), nil,
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeOffer),
+		dhcpv4.WithYourIP(net.IPv4(10, 0, 0, 10)),
+		dhcpv4.WithOption(dhcpv4.OptDomainName("example.com")),
+	)
+}
+
+func TestHandle4SetsOptions(t *testing.T) {
+	m, dir := newScript(t, `
+cat > "$1/input.json"
+echo "$DHCP_MESSAGE_TYPE $DHCP_MAC $DHCP_ASSIGNED" > "$1/env"
+echo '{"options": {"6": "0a000035", "15": ""}}'
+`, 5*time.Second)
+
+	resp, err := offer4(t, m)
+	require.NoError(t, err)
+	assert.Equal(t, []net.IP{net.IPv4(10, 0, 0, 53).To4()}, resp.DNS())
+	assert.Nil(t, resp.Options.Get(dhcpv4.OptionDomainName))
+
+	b, err := os.ReadFile(filepath.Join(dir, "input.json"))
+	require.NoError(t, err)
+	var in input
+	require.NoError(t, json.Unmarshal(b, &in))
+	assert.Equal(t, 4, in.Family)
+	assert.Equal(t, "DISCOVER", in.MessageType)
+	assert.Equal(t, "OFFER", in.ReplyType)
+	assert.Equal(t, "02:00:00:00:00:01", in.MAC)
+	assert.Equal(t, []string{"10.0.0.10"}, in.Assigned)
+	assert.Equal(t, "686f7374", in.Options["12"])
+
+	env, err := os.ReadFile(filepath.Join(dir, "env"))
+	require.NoError(t, err)
+	assert.Equal(t, "DISCOVER 02:00:00:00:00:01 10.0.0.10\n", string(env))
+}
+
+func TestHandle4Drop(t *testing.T) {
+	m, _ := newScript(t, `echo '{"drop": true}'`, 5*time.Second)
+	_, err := offer4(t, m)
+	assert.ErrorIs(t, err, handlers.ErrDrop)
+}
+
+func TestHandle4Timeout(t *testing.T) {
+	m, _ := newScript(t, `sleep 10; echo '{"drop": true}'`, 100*time.Millisecond)
+	start := time.Now()
+	resp, err := offer4(t, m)
+	require.NoError(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	// the reply is left as is
+	assert.Equal(t, "example.com", resp.DomainName())
+}
+
+func TestHandle4Failure(t *testing.T) {
+	for _, body := range []string{
+		"exit 1",
+		"echo 'not json'",
+		`echo '{"options": {"256": "00"}}'`,
+		`echo '{"options": {"6": "xyz"}}'`,
+	} {
+		m, _ := newScript(t, body, 5*time.Second)
+		resp, err := offer4(t, m)
+		require.NoError(t, err, body)
+		assert.Equal(t, "example.com", resp.DomainName(), body)
+	}
+}
+
+func TestHandle6SetsOptions(t *testing.T) {
+	m, dir := newScript(t, `
+cat > "$1/input.json"
+echo '{"options": {"24": "076578616d706c6503636f6d00"}}'
+`, 5*time.Second)
+
+	req, err := dhcpv6.NewSolicit(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	resp, err := handlertest.Handle6(t, m, handlers.NewDHCPv6(req), nil)
+	require.NoError(t, err)
+
+	msg, err := dhcpv6.MessageFromBytes(resp.ToBytes())
+	require.NoError(t, err)
+	require.NotNil(t, msg.Options.DomainSearchList())
+	assert.Equal(t, []string{"example.com"}, msg.Options.DomainSearchList().Labels)
+
+	b, err := os.ReadFile(filepath.Join(dir, "input.json"))
+	require.NoError(t, err)
+	var in input
+	require.NoError(t, json.Unmarshal(b, &in))
+	assert.Equal(t, 6, in.Family)
+	assert.Equal(t, "SOLICIT", in.MessageType)
+	assert.Equal(t, "ADVERTISE", in.ReplyType)
+	assert.Equal(t, "02:00:00:00:00:01", in.MAC)
+	assert.NotEmpty(t, in.ClientID)
+}
+
+func TestWorkers(t *testing.T) {
+	m, _ := newScript(t, "exit 0", 100*time.Millisecond)
+	// occupy the only worker, so the command cannot run before the timeout
+	m.workers = make(chan struct{}, 1)
+	m.workers <- struct{}{}
+	_, err := m.run(input{Family: 4})
+	assert.ErrorContains(t, err, "no free worker")
+}
+
+func TestProvisionInvalid(t *testing.T) {
+	for _, m := range []*Module{
+		{},
+		{Command: "/bin/true", Timeout: -1},
+		{Command: "/bin/true", Workers: -1},
+	} {
+		assert.Error(t, m.Provision(caddy.Context{}))
+	}
+}
@@ -5,6 +5,9 @@
 package searchdomains
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/caddyserver/caddy/v2"
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv6"
@@ -14,12 +17,31 @@ import (
 )
 
 // Module adds default DNS search domains.
+// The domains may be written with or without the trailing dot of the root domain.
+//
+// The DHCPv4 Domain Search option (119) is encoded using the label compression of RFC 1035 section 4.1.4,
+// which RFC 3397 allows to shrink long lists with common suffixes. It can be disabled for clients that
+// do not support it. The DHCPv6 Domain Search List option is never compressed, as required by RFC 8415.
 type Module struct {
-	Domains []string `json:"domains,omitempty"`
+	Domains            []string `json:"domains,omitempty"`
+	DisableCompression bool     `json:"disableCompression,omitempty"`
 
-	logger *zap.Logger
+	domains  []string
+	encoded4 []byte
+	logger   *zap.Logger
 }
 
+const (
+	// maxLabelLen is the maximum length of a single label of a domain name as per RFC 1035 section 2.3.4.
+	maxLabelLen = 63
+
+	// maxNameLen is the maximum length of an encoded domain name as per RFC 1035 section 2.3.4.
+	maxNameLen = 255
+
+	// maxPointer is the largest offset that a compression pointer can refer to.
+	maxPointer = 0x3fff
+)
+
 // CaddyModule returns the Caddy module information.
 func (Module) CaddyModule() caddy.ModuleInfo {
 	return caddy.ModuleInfo{
@@ -31,13 +53,75 @@ func (Module) CaddyModule() caddy.ModuleInfo {
 // Provision is run immediately after this handler is being loaded.
 func (m *Module) Provision(ctx caddy.Context) error {
 	m.logger = ctx.Logger()
+	m.domains = nil
+	for _, domain := range m.Domains {
+		name, err := validateDomain(domain)
+		if err != nil {
+			return err
+		}
+		m.domains = append(m.domains, name)
+	}
+	m.encoded4 = encodeDomains(m.domains, !m.DisableCompression)
 	return nil
 }
 
+// encodeDomains encodes a list of validated domain names as described in RFC 1035 section 3.1.
+// If compress is set, a suffix that occurred before is replaced by a pointer to it.
+func encodeDomains(domains []string, compress bool) []byte {
+	var b []byte
+	offsets := make(map[string]int)
+	for _, domain := range domains {
+		labels := strings.Split(domain, ".")
+		pointer := false
+		for i, label := range labels {
+			if compress {
+				suffix := strings.Join(labels[i:], ".")
+				if offset, ok := offsets[suffix]; ok {
+					b = append(b, 0xc0|byte(offset>>8), byte(offset))
+					pointer = true
+					break
+				}
+				if len(b) <= maxPointer {
+					offsets[suffix] = len(b)
+				}
+			}
+			b = append(b, byte(len(label)))
+			b = append(b, label...)
+		}
+		if !pointer {
+			b = append(b, 0)
+		}
+	}
+	return b
+}
+
+// validateDomain checks that the domain can be encoded as described in RFC 1035 section 3.1,
+// and returns it without the trailing dot, which would otherwise be encoded as an extra empty label.
+func validateDomain(domain string) (string, error) {
+	name := strings.TrimSuffix(domain, ".")
+	if name == "" {
+		return "", fmt.Errorf("invalid search domain %q: empty domain", domain)
+	}
+	size := 1 // the terminating root label
+	for _, label := range strings.Split(name, ".") {
+		if label == "" {
+			return "", fmt.Errorf("invalid search domain %q: empty label", domain)
+		}
+		if len(label) > maxLabelLen {
+			return "", fmt.Errorf("invalid search domain %q: label %q exceeds %d octets", domain, label, maxLabelLen)
+		}
+		size += 1 + len(label)
+	}
+	if size > maxNameLen {
+		return "", fmt.Errorf("invalid search domain %q: encoded name of %d octets exceeds %d octets", domain, size, maxNameLen)
+	}
+	return name, nil
+}
+
 // Handle4 handles DHCPv4 packets for this plugin.
 func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
 	if req.IsOptionRequested(dhcpv4.OptionDNSDomainSearchList) {
-		resp.UpdateOption(dhcpv4.OptDomainSearch(&rfc1035label.Labels{Labels: copySlice(m.Domains)}))
+		resp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionDNSDomainSearchList, copyBytes(m.encoded4)))
 	}
 	return next()
 }
@@ -45,7 +129,7 @@ func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
 // Handle6 handles DHCPv6 packets for this plugin.
 func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
 	if req.IsOptionRequested(dhcpv6.OptionDomainSearchList) {
-		resp.UpdateOption(dhcpv6.OptDomainSearchList(&rfc1035label.Labels{Labels: copySlice(m.Domains)}))
+		resp.UpdateOption(dhcpv6.OptDomainSearchList(&rfc1035label.Labels{Labels: copySlice(m.domains)}))
 	}
 	return next()
 }
@@ -59,6 +143,11 @@ func copySlice(original []string) []string {
 	return copied
 }
 
+// copyBytes creates a new copy of a byte slice in memory, for the same reason as copySlice.
+func copyBytes(original []byte) []byte {
+	return append([]byte(nil), original...)
+}
+
 // Interfaces guards
 var (
 	_ handlers.HandlerModule = (*Module)(nil)
@@ -0,0 +1,91 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package searchdomains
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/rfc1035label"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProvisionInvalid(t *testing.T) {
+	for _, domain := range []string{
+		strings.Repeat("a", 64) + ".example.com",
+		"example..com",
+		".",
+		"",
+		strings.Repeat(strings.Repeat("a", 63)+".", 4) + "com",
+	} {
+		m := &Module{Domains: []string{"example.com", domain}}
+		assert.Error(t, m.Provision(caddy.Context{}), domain)
+	}
+}
+
+func TestHandle4(t *testing.T) {
+	m := &Module{Domains: []string{"example.com.", "corp.example.com", strings.Repeat("a", 63) + ".org"}}
+	require.NoError(t, m.Provision(caddy.Context{}))
+
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	req.UpdateOption(dhcpv4.OptParameterRequestList(dhcpv4.OptionDNSDomainSearchList))
+	resp, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	require.NoError(t, m.Handle4(handlers.DHCPv4{DHCPv4: req}, handlers.DHCPv4{DHCPv4: resp}, func() error { return nil }))
+
+	// the trailing dot does not produce an extra empty label
+	data := resp.Options.Get(dhcpv4.OptionDNSDomainSearchList)
+	assert.Equal(t, []byte("\x07example\x03com\x00\x04corp"), data[:18])
+	assert.Equal(t, []string{"example.com", "corp.example.com", strings.Repeat("a", 63) + ".org"}, resp.DomainSearch().Labels)
+}
+
+func TestEncodeDomains(t *testing.T) {
+	domains := []string{"eng.example.com", "sales.example.com", "example.com", "example.org"}
+	compressed := encodeDomains(domains, true)
+	uncompressed := encodeDomains(domains, false)
+
+	assert.Equal(t, []byte("\x03eng\x07example\x03com\x00\x05sales\xc0\x04\xc0\x04\x07example\x03org\x00"), compressed)
+	assert.Less(t, len(compressed), len(uncompressed))
+	assert.Equal(t, (&rfc1035label.Labels{Labels: domains}).ToBytes(), uncompressed)
+
+	for _, b := range [][]byte{compressed, uncompressed} {
+		labels, err := rfc1035label.FromBytes(b)
+		require.NoError(t, err)
+		assert.Equal(t, domains, labels.Labels)
+	}
+}
+
+func TestHandleCompression(t *testing.T) {
+	domains := []string{"eng.example.com", "sales.example.com"}
+	for _, disabled := range []bool{false, true} {
+		m := &Module{Domains: domains, DisableCompression: disabled}
+		require.NoError(t, m.Provision(caddy.Context{}))
+
+		req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+		require.NoError(t, err)
+		req.UpdateOption(dhcpv4.OptParameterRequestList(dhcpv4.OptionDNSDomainSearchList))
+		resp, err := dhcpv4.NewReplyFromRequest(req)
+		require.NoError(t, err)
+		require.NoError(t, m.Handle4(handlers.DHCPv4{DHCPv4: req}, handlers.DHCPv4{DHCPv4: resp}, func() error { return nil }))
+		assert.Equal(t, encodeDomains(domains, !disabled), resp.Options.Get(dhcpv4.OptionDNSDomainSearchList))
+		assert.Equal(t, domains, resp.DomainSearch().Labels)
+
+		// DHCPv6 never uses compression
+		req6, err := dhcpv6.NewMessage()
+		require.NoError(t, err)
+		req6.AddOption(dhcpv6.OptRequestedOption(dhcpv6.OptionDomainSearchList))
+		resp6, err := dhcpv6.NewMessage()
+		require.NoError(t, err)
+		require.NoError(t, m.Handle6(handlers.DHCPv6{Message: req6}, handlers.DHCPv6{Message: resp6}, func() error { return nil }))
+		assert.Equal(t, encodeDomains(domains, false), resp6.Options.DomainSearchList().ToBytes())
+	}
+}
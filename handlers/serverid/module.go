@@ -21,12 +21,20 @@ import (
 type Module struct {
 	Id   string `json:"id,omitempty"`
 	Duid string `json:"duid,omitempty"`
+	// ServerName is put in the sname field of DHCPv4 replies, so clients can show which server served them.
+	// It may contain placeholders, like {system.hostname} for the hostname of the OS. A name that does not fit
+	// the 64 byte field is truncated to as many of its leading labels as fit. Disabled by default.
+	ServerName string `json:"serverName,omitempty"`
 
-	id     net.IP
-	duid   dhcpv6.DUID
-	logger *zap.Logger
+	id         net.IP
+	duid       dhcpv6.DUID
+	serverName string
+	logger     *zap.Logger
 }
 
+// maxServerNameLen is the maximum length of the sname field, which must be terminated with a zero byte.
+const maxServerNameLen = 63
+
 // CaddyModule returns the Caddy module information.
 func (Module) CaddyModule() caddy.ModuleInfo {
 	return caddy.ModuleInfo{
@@ -46,59 +54,98 @@ func (m *Module) Provision(ctx caddy.Context) error {
 		}
 		m.id = ip
 	}
+	if m.ServerName != "" {
+		name, err := serverName(caddy.NewReplacer().ReplaceAll(m.ServerName, ""))
+		if err != nil {
+			return err
+		}
+		if name != m.ServerName {
+			m.logger.Info("using server name", zap.String("server_name", name))
+		}
+		m.serverName = name
+	}
 	if m.Duid != "" {
-		split := strings.SplitN(m.Duid, " ", 2)
-		if len(split) < 2 {
-			return fmt.Errorf("need a DUID type and value")
+		duid, err := ParseDUID(m.Duid)
+		if err != nil {
+			return err
 		}
-		duidType := strings.ToLower(split[0])
-		if duidType == "" {
-			return fmt.Errorf("got empty DUID type")
+		m.duid = duid
+	}
+
+	return nil
+}
+
+// ParseDUID parses a DUID given as a type and a value separated by a space: `ll` or `llt` with a
+// hardware address, or `uuid` with a UUID.
+func ParseDUID(s string) (dhcpv6.DUID, error) {
+	split := strings.SplitN(s, " ", 2)
+	if len(split) < 2 {
+		return nil, fmt.Errorf("need a DUID type and value")
+	}
+	duidType := strings.ToLower(split[0])
+	if duidType == "" {
+		return nil, fmt.Errorf("got empty DUID type")
+	}
+	duidValue := split[1]
+	if duidValue == "" {
+		return nil, fmt.Errorf("got empty DUID value")
+	}
+	switch duidType {
+	case "ll", "duid-ll", "duid_ll":
+		hwaddr, err := net.ParseMAC(duidValue)
+		if err != nil {
+			return nil, err
 		}
-		duidValue := split[1]
-		if duidValue == "" {
-			return fmt.Errorf("got empty DUID value")
+		return &dhcpv6.DUIDLL{
+			// sorry, only ethernet for now
+			HWType:        iana.HWTypeEthernet,
+			LinkLayerAddr: hwaddr,
+		}, nil
+	case "llt", "duid-llt", "duid_llt":
+		hwaddr, err := net.ParseMAC(duidValue)
+		if err != nil {
+			return nil, err
 		}
-		switch duidType {
-		case "ll", "duid-ll", "duid_ll":
-			hwaddr, err := net.ParseMAC(duidValue)
-			if err != nil {
-				return err
-			}
-			m.duid = &dhcpv6.DUIDLL{
-				// sorry, only ethernet for now
-				HWType:        iana.HWTypeEthernet,
-				LinkLayerAddr: hwaddr,
-			}
-		case "llt", "duid-llt", "duid_llt":
-			hwaddr, err := net.ParseMAC(duidValue)
-			if err != nil {
-				return err
-			}
-			m.duid = &dhcpv6.DUIDLLT{
-				// sorry, only ethernet for now
-				HWType:        iana.HWTypeEthernet,
-				Time:          dhcpv6.GetTime(),
-				LinkLayerAddr: hwaddr,
-			}
-		case "uuid":
-			parsedUuid, err := uuid.Parse(duidValue)
-			if err != nil {
-				return err
-			}
-			m.duid = &dhcpv6.DUIDUUID{
-				UUID: parsedUuid,
-			}
-		default:
-			return fmt.Errorf("opaque DUID type not supported yet")
+		return &dhcpv6.DUIDLLT{
+			// sorry, only ethernet for now
+			HWType:        iana.HWTypeEthernet,
+			Time:          dhcpv6.GetTime(),
+			LinkLayerAddr: hwaddr,
+		}, nil
+	case "uuid":
+		parsedUuid, err := uuid.Parse(duidValue)
+		if err != nil {
+			return nil, err
 		}
+		return &dhcpv6.DUIDUUID{
+			UUID: parsedUuid,
+		}, nil
+	default:
+		return nil, fmt.Errorf("opaque DUID type not supported yet")
 	}
+}
 
-	return nil
+// serverName returns the given name, truncated to the leading labels that fit the sname field.
+func serverName(name string) (string, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" {
+		return "", fmt.Errorf("empty server name")
+	}
+	for len(name) > maxServerNameLen {
+		i := strings.LastIndexByte(name, '.')
+		if i < 0 {
+			return "", fmt.Errorf("server name %s is longer than %d bytes", name, maxServerNameLen)
+		}
+		name = name[:i]
+	}
+	return name, nil
 }
 
 // Handle4 handles DHCPv4 packets for this plugin.
 func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
+	if m.serverName != "" {
+		resp.ServerHostName = m.serverName
+	}
 	if m.id == nil {
 		return next()
 	}
@@ -111,7 +158,12 @@ func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
 		!req.ServerIPAddr.Equal(m.id) {
 		// This request is not for us, drop it.
 		m.logger.Info(fmt.Sprintf("requested server ID does not match this server'm ID. Got %v, want %v", req.ServerIPAddr, m.id))
-		return nil
+		return handlers.ErrDrop
+	}
+	if sid := req.ServerIdentifier(); sid != nil && !sid.Equal(m.id) {
+		// The client selected another server, drop it.
+		m.logger.Info(fmt.Sprintf("server identifier does not match this server's ID. Got %v, want %v", sid, m.id))
+		return handlers.ErrDrop
 	}
 	resp.UpdateOption(dhcpv4.OptServerIdentifier(m.id))
 	return next()
@@ -0,0 +1,107 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package serverid
+
+import (
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerIdentifier4(t *testing.T) {
+	m := &Module{Id: "10.0.0.1"}
+	require.NoError(t, m.Provision(caddy.Context{}))
+
+	for _, tc := range []struct {
+		name    string
+		sid     net.IP
+		dropped bool
+	}{
+		{"matching", net.IPv4(10, 0, 0, 1), false},
+		{"mismatching", net.IPv4(10, 0, 0, 2), true},
+		{"absent", nil, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := dhcpv4.New(dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest))
+			require.NoError(t, err)
+			if tc.sid != nil {
+				req.UpdateOption(dhcpv4.OptServerIdentifier(tc.sid))
+			}
+			resp, err := dhcpv4.NewReplyFromRequest(req)
+			require.NoError(t, err)
+
+			called := false
+			err = m.Handle4(handlers.DHCPv4{DHCPv4: req}, handlers.DHCPv4{DHCPv4: resp}, func() error {
+				called = true
+				return nil
+			})
+			if tc.dropped {
+				assert.ErrorIs(t, err, handlers.ErrDrop)
+				assert.False(t, called)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, called)
+			assert.True(t, resp.ServerIdentifier().Equal(net.IPv4(10, 0, 0, 1)))
+		})
+	}
+}
+
+func TestServerName(t *testing.T) {
+	long := strings.Repeat("a", 40)
+	for _, tc := range []struct {
+		name     string
+		expected string
+	}{
+		{"dhcp1.example.com", "dhcp1.example.com"},
+		{"dhcp1.example.com.", "dhcp1.example.com"},
+		// names that do not fit are truncated to the leading labels that fit
+		{long + "." + long, long},
+		{long + ".example.com." + long, long + ".example.com"},
+		{strings.Repeat("a", 63), strings.Repeat("a", 63)},
+	} {
+		m := &Module{ServerName: tc.name}
+		require.NoError(t, m.Provision(caddy.Context{}))
+		assert.Equal(t, tc.expected, m.serverName, tc.name)
+	}
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+	m := &Module{ServerName: "{system.hostname}"}
+	require.NoError(t, m.Provision(caddy.Context{}))
+	assert.Equal(t, strings.Split(hostname, ".")[0], strings.Split(m.serverName, ".")[0])
+
+	for _, name := range []string{strings.Repeat("a", 64), "."} {
+		assert.Error(t, (&Module{ServerName: name}).Provision(caddy.Context{}), name)
+	}
+}
+
+func TestServerNameField(t *testing.T) {
+	m := &Module{ServerName: "dhcp1.example.com"}
+	require.NoError(t, m.Provision(caddy.Context{}))
+
+	req, err := dhcpv4.New(dhcpv4.WithMessageType(dhcpv4.MessageTypeDiscover))
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	require.NoError(t, m.Handle4(handlers.DHCPv4{DHCPv4: req}, handlers.DHCPv4{DHCPv4: resp}, func() error { return nil }))
+	assert.Equal(t, "dhcp1.example.com", resp.ServerHostName)
+
+	// the name is placed in the zero-terminated sname field at offset 44, not in an option
+	b := resp.ToBytes()
+	sname := b[44 : 44+64]
+	assert.Equal(t, append([]byte("dhcp1.example.com"), make([]byte, 64-len("dhcp1.example.com"))...), sname)
+	parsed, err := dhcpv4.FromBytes(b)
+	require.NoError(t, err)
+	assert.Equal(t, "dhcp1.example.com", parsed.ServerHostName)
+	assert.Nil(t, parsed.Options.Get(dhcpv4.OptionTFTPServerName))
+}
@@ -0,0 +1,190 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package site
+
+import (
+	"fmt"
+	"net"
+	"sort"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/lion7/caddydhcp/handlers"
+	"go.uber.org/zap"
+)
+
+// Module sets the options of the site a client is on, for networks spanning several sites.
+// The site of a client is the one with the most specific subnet containing the link address of its relay agent
+// (see handlers.DHCPv4.LinkAddress and handlers.DHCPv6.LinkAddress). Clients on none of the subnets,
+// or that are not relayed, get the options of the default site, if any:
+//
+//	{
+//	  "handler": "site",
+//	  "sites": {
+//	    "amsterdam": {"subnets": ["10.1.0.0/16", "2001:db8:1::/48"], "dns": ["10.1.0.53", "2001:db8:1::53"], "routers": ["10.1.0.1"], "domain": "ams.example.com"},
+//	    "berlin": {"subnets": ["10.2.0.0/16"], "dns": ["10.2.0.53"], "routers": ["10.2.0.1"], "domain": "ber.example.com"}
+//	  },
+//	  "default": "amsterdam"
+//	}
+//
+// This is like combining the subnets of the dns handler with the giaddr handler, with the options of a site in one place.
+type Module struct {
+	// The sites, keyed by name.
+	Sites map[string]Site `json:"sites,omitempty"`
+
+	// The name of the site for clients on none of the subnets of the sites. By default, these clients get no options.
+	Default string `json:"default,omitempty"`
+
+	subnets []subnet
+	def     *site
+	logger  *zap.Logger
+}
+
+// Site is the option bundle of the clients on a set of subnets.
+type Site struct {
+	// The subnets of the site in CIDR notation, either IPv4 or IPv6, matched against the link address of a request.
+	Subnets []string `json:"subnets,omitempty"`
+
+	// The DNS servers of the site, either IPv4 or IPv6; the servers of a family are given to the clients of that family.
+	DNS []string `json:"dns,omitempty"`
+
+	// The IPv4 routers of the site.
+	Routers []string `json:"routers,omitempty"`
+
+	// The domain name (option 15) of the site, for DHCPv4 clients.
+	Domain string `json:"domain,omitempty"`
+}
+
+// site holds the parsed options of a site.
+type site struct {
+	name    string
+	dns4    []net.IP
+	dns6    []net.IP
+	routers []net.IP
+	domain  string
+}
+
+// subnet maps a subnet to the site it belongs to.
+type subnet struct {
+	prefix *net.IPNet
+	site   *site
+}
+
+// CaddyModule returns the Caddy module information.
+func (Module) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "dhcp.handlers.site",
+		New: func() caddy.Module { return new(Module) },
+	}
+}
+
+// Provision is run immediately after this handler is being loaded.
+func (m *Module) Provision(ctx caddy.Context) error {
+	m.logger = ctx.Logger()
+	if len(m.Sites) == 0 {
+		return fmt.Errorf("no sites configured")
+	}
+	// sort the names, so subnets that are configured for several sites always select the same site
+	names := make([]string, 0, len(m.Sites))
+	for name := range m.Sites {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	m.subnets = nil
+	m.def = nil
+	for _, name := range names {
+		cfg := m.Sites[name]
+		s := &site{name: name, domain: cfg.Domain}
+		for _, server := range cfg.DNS {
+			ip := net.ParseIP(server)
+			switch {
+			case ip == nil:
+				return fmt.Errorf("site %s: invalid DNS server %q", name, server)
+			case ip.To4() != nil:
+				s.dns4 = append(s.dns4, ip.To4())
+			default:
+				s.dns6 = append(s.dns6, ip)
+			}
+		}
+		for _, router := range cfg.Routers {
+			ip := net.ParseIP(router).To4()
+			if ip == nil {
+				return fmt.Errorf("site %s: invalid IPv4 router %q", name, router)
+			}
+			s.routers = append(s.routers, ip)
+		}
+		for _, cidr := range cfg.Subnets {
+			_, prefix, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return fmt.Errorf("site %s: invalid subnet: %v", name, err)
+			}
+			m.subnets = append(m.subnets, subnet{prefix: prefix, site: s})
+		}
+		if name == m.Default {
+			m.def = s
+		}
+	}
+	if m.Default != "" && m.def == nil {
+		return fmt.Errorf("unknown default site %q", m.Default)
+	}
+	return nil
+}
+
+// lookup returns the site of the most specific subnet containing the link address,
+// or the default site if there is none.
+func (m *Module) lookup(linkAddr net.IP) *site {
+	if linkAddr == nil {
+		return m.def
+	}
+	best := m.def
+	bestLen := -1
+	for _, s := range m.subnets {
+		if ones, _ := s.prefix.Mask.Size(); ones > bestLen && s.prefix.Contains(linkAddr) {
+			best, bestLen = s.site, ones
+		}
+	}
+	return best
+}
+
+// Handle4 handles DHCPv4 packets for this plugin.
+func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
+	s := m.lookup(req.LinkAddress())
+	if s == nil {
+		m.logger.Debug("no site for request", zap.Stringer("link", req.LinkAddress()))
+		return next()
+	}
+	m.logger.Debug("request matches site", zap.String("site", s.name), zap.Stringer("link", req.LinkAddress()))
+	if len(s.routers) > 0 {
+		resp.UpdateOption(dhcpv4.OptRouter(s.routers...))
+	}
+	if len(s.dns4) > 0 && req.IsOptionRequested(dhcpv4.OptionDomainNameServer) {
+		resp.UpdateOption(dhcpv4.OptDNS(s.dns4...))
+	}
+	if s.domain != "" && req.IsOptionRequested(dhcpv4.OptionDomainName) {
+		resp.UpdateOption(dhcpv4.OptDomainName(s.domain))
+	}
+	return next()
+}
+
+// Handle6 handles DHCPv6 packets for this plugin.
+func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
+	s := m.lookup(req.LinkAddress())
+	if s == nil {
+		m.logger.Debug("no site for request", zap.Stringer("link", req.LinkAddress()))
+		return next()
+	}
+	m.logger.Debug("request matches site", zap.String("site", s.name), zap.Stringer("link", req.LinkAddress()))
+	if len(s.dns6) > 0 && req.IsOptionRequested(dhcpv6.OptionDNSRecursiveNameServer) {
+		resp.UpdateOption(dhcpv6.OptDNS(s.dns6...))
+	}
+	return next()
+}
+
+// Interfaces guards
+var (
+	_ handlers.HandlerModule = (*Module)(nil)
+)
@@ -0,0 +1,119 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package site
+
+import (
+	"net"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/lion7/caddydhcp/handlers/handlertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandle4(t *testing.T) {
+	sites := map[string]Site{
+		"amsterdam": {
+			Subnets: []string{"10.1.0.0/16"},
+			DNS:     []string{"10.1.0.53"},
+			Routers: []string{"10.1.0.1"},
+			Domain:  "ams.example.com",
+		},
+		"berlin": {
+			Subnets: []string{"10.2.0.0/16"},
+			DNS:     []string{"10.2.0.53"},
+			Routers: []string{"10.2.0.1"},
+			Domain:  "ber.example.com",
+		},
+		// a more specific subnet within the subnet of another site
+		"berlin-lab": {
+			Subnets: []string{"10.2.99.0/24"},
+			Routers: []string{"10.2.99.1"},
+		},
+	}
+	for _, tc := range []struct {
+		name   string
+		def    string
+		giaddr net.IP
+		router string
+		dns    string
+		domain string
+	}{
+		{"first site", "", net.IPv4(10, 1, 0, 254), "10.1.0.1", "10.1.0.53", "ams.example.com"},
+		{"second site", "", net.IPv4(10, 2, 0, 254), "10.2.0.1", "10.2.0.53", "ber.example.com"},
+		{"most specific subnet", "", net.IPv4(10, 2, 99, 254), "10.2.99.1", "", ""},
+		{"unknown subnet", "", net.IPv4(10, 9, 0, 254), "", "", ""},
+		{"unknown subnet with default", "berlin", net.IPv4(10, 9, 0, 254), "10.2.0.1", "10.2.0.53", "ber.example.com"},
+		{"not relayed with default", "amsterdam", nil, "10.1.0.1", "10.1.0.53", "ams.example.com"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := handlertest.Provision(t, &Module{Sites: sites, Default: tc.def})
+			req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+				dhcpv4.WithGatewayIP(tc.giaddr),
+				dhcpv4.WithRequestedOptions(dhcpv4.OptionDomainNameServer, dhcpv4.OptionDomainName),
+			)
+			require.NoError(t, err)
+			resp, err := handlertest.Handle4(t, m, handlers.NewDHCPv4(req), nil)
+			require.NoError(t, err)
+
+			if tc.router == "" {
+				assert.Empty(t, resp.Router())
+			} else {
+				assert.Equal(t, []net.IP{net.ParseIP(tc.router).To4()}, resp.Router())
+			}
+			if tc.dns == "" {
+				assert.Empty(t, resp.DNS())
+			} else {
+				assert.Equal(t, []net.IP{net.ParseIP(tc.dns).To4()}, resp.DNS())
+			}
+			assert.Equal(t, tc.domain, resp.DomainName())
+		})
+	}
+}
+
+func TestHandle6(t *testing.T) {
+	m := handlertest.Provision(t, &Module{Sites: map[string]Site{
+		"amsterdam": {Subnets: []string{"10.1.0.0/16", "2001:db8:1::/48"}, DNS: []string{"10.1.0.53", "2001:db8:1::53"}},
+		"berlin":    {Subnets: []string{"10.2.0.0/16"}, DNS: []string{"10.2.0.53"}},
+	}})
+	for _, tc := range []struct {
+		name     string
+		linkAddr net.IP
+		dns      []net.IP
+	}{
+		{"site", net.ParseIP("2001:db8:1::1"), []net.IP{net.ParseIP("2001:db8:1::53")}},
+		{"site without IPv6 servers", net.ParseIP("10.2.0.1"), nil},
+		{"unknown subnet", net.ParseIP("2001:db8:9::1"), nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, err := dhcpv6.NewSolicit(net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+				dhcpv6.WithRequestedOptions(dhcpv6.OptionDNSRecursiveNameServer))
+			require.NoError(t, err)
+			relay, err := dhcpv6.EncapsulateRelay(msg, dhcpv6.MessageTypeRelayForward, tc.linkAddr, net.ParseIP("fe80::1"))
+			require.NoError(t, err)
+			resp, err := handlertest.Handle6(t, m, handlers.NewRelayedDHCPv6(relay, msg), nil)
+			require.NoError(t, err)
+			assert.Equal(t, tc.dns, resp.Options.DNS())
+		})
+	}
+}
+
+func TestProvision(t *testing.T) {
+	for name, m := range map[string]*Module{
+		"no sites":        {},
+		"invalid subnet":  {Sites: map[string]Site{"a": {Subnets: []string{"10.0.0.0"}}}},
+		"invalid dns":     {Sites: map[string]Site{"a": {DNS: []string{"dns"}}}},
+		"IPv6 router":     {Sites: map[string]Site{"a": {Routers: []string{"2001:db8::1"}}}},
+		"unknown default": {Sites: map[string]Site{"a": {}}, Default: "b"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Error(t, m.Provision(caddy.Context{}))
+		})
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package sntp
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/lion7/caddydhcp/handlers"
+	"go.uber.org/zap"
+)
+
+// Module implements RFC4075: it adds the SNTP Servers option (31) with the IPv6 addresses of the servers.
+// Some clients only support this option instead of the NTP Server option (56) of RFC5908.
+// The option is only added if it is requested by the client.
+type Module struct {
+	// DHCPv4 clients use the NTP servers option (42) instead
+	handlers.Base
+
+	Servers []string `json:"servers,omitempty"`
+
+	encoded []byte
+	logger  *zap.Logger
+}
+
+// CaddyModule returns the Caddy module information.
+func (Module) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "dhcp.handlers.sntp",
+		New: func() caddy.Module { return new(Module) },
+	}
+}
+
+// Provision is run immediately after this handler is being loaded.
+func (m *Module) Provision(ctx caddy.Context) error {
+	m.logger = ctx.Logger()
+	if len(m.Servers) == 0 {
+		return fmt.Errorf("need at least one SNTP server")
+	}
+	m.encoded = nil
+	for _, server := range m.Servers {
+		ip := net.ParseIP(server)
+		if ip == nil || ip.To4() != nil {
+			return fmt.Errorf("invalid SNTP server %q: not an IPv6 address", server)
+		}
+		// the option is a list of 16-octet addresses
+		m.encoded = append(m.encoded, ip.To16()...)
+	}
+	return nil
+}
+
+// Handle6 handles DHCPv6 packets for this plugin.
+func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
+	if req.IsOptionRequested(dhcpv6.OptionSNTPServerList) {
+		resp.UpdateOption(&dhcpv6.OptionGeneric{
+			OptionCode: dhcpv6.OptionSNTPServerList,
+			OptionData: append([]byte(nil), m.encoded...),
+		})
+	}
+	return next()
+}
+
+// Interfaces guards
+var (
+	_ handlers.HandlerModule = (*Module)(nil)
+)
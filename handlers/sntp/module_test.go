@@ -0,0 +1,72 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package sntp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/lion7/caddydhcp/handlers/handlertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// inform6 runs the module for a DHCPv6 Information-request requesting the given options.
+func inform6(t *testing.T, m *Module, requested ...dhcpv6.OptionCode) *dhcpv6.Message {
+	req, err := dhcpv6.NewMessage(dhcpv6.WithClientID(&dhcpv6.DUIDLL{
+		HWType:        iana.HWTypeEthernet,
+		LinkLayerAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+	}))
+	require.NoError(t, err)
+	req.MessageType = dhcpv6.MessageTypeInformationRequest
+	req.UpdateOption(dhcpv6.OptRequestedOption(requested...))
+	resp, err := handlertest.Handle6(t, m, handlers.NewDHCPv6(req), nil)
+	require.NoError(t, err)
+	return resp
+}
+
+func TestHandle6(t *testing.T) {
+	m := handlertest.Provision(t, &Module{Servers: []string{"2001:db8::123", "fe80::1"}})
+
+	resp := inform6(t, m, dhcpv6.OptionDNSRecursiveNameServer, dhcpv6.OptionSNTPServerList)
+	b := resp.ToBytes()
+	// the option is added last, with the addresses in order
+	want := []byte{0, 31, 0, 32,
+		0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01, 0x23,
+		0xfe, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01,
+	}
+	assert.Equal(t, want, b[len(b)-len(want):])
+
+	// the wire format parses back into the addresses
+	msg, err := dhcpv6.MessageFromBytes(b)
+	require.NoError(t, err)
+	opt := msg.GetOneOption(dhcpv6.OptionSNTPServerList)
+	require.NotNil(t, opt)
+	assert.Equal(t, append(net.ParseIP("2001:db8::123").To16(), net.ParseIP("fe80::1").To16()...), net.IP(opt.ToBytes()))
+}
+
+func TestHandle6NotRequested(t *testing.T) {
+	m := handlertest.Provision(t, &Module{Servers: []string{"2001:db8::123"}})
+
+	resp := inform6(t, m, dhcpv6.OptionDNSRecursiveNameServer)
+	assert.Nil(t, resp.GetOneOption(dhcpv6.OptionSNTPServerList))
+}
+
+func TestProvisionInvalid(t *testing.T) {
+	for _, servers := range [][]string{
+		nil,
+		{"192.0.2.123"},
+		{"::ffff:192.0.2.123"},
+		{"sntp.example.com"},
+		{"2001:db8::123", ""},
+	} {
+		m := &Module{Servers: servers}
+		assert.Error(t, m.Provision(caddy.Context{}), servers)
+	}
+}
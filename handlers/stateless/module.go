@@ -0,0 +1,143 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package stateless
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/rfc1035label"
+	"github.com/lion7/caddydhcp/handlers"
+	"go.uber.org/zap"
+)
+
+// Module bundles the options of stateless DHCPv6 (RFC 8415 section 6.1), which clients request with an
+// Information-Request when the router advertisement has the "other configuration" flag set.
+// It replaces a chain of separate handlers for the DNS servers, domain search list, NTP servers
+// and SNTP servers, and only answers Information-Requests; other messages are passed on unchanged.
+// As with the separate handlers, an option is only added if the client requested it.
+type Module struct {
+	handlers.Base
+
+	// The IPv6 addresses of the recursive DNS servers (RFC 3646).
+	DNSServers []string `json:"dnsServers,omitempty"`
+	// The domain search list (RFC 3646), with or without the trailing dot of the root domain.
+	SearchDomains []string `json:"searchDomains,omitempty"`
+	// The NTP servers (RFC 5908), each either an IPv6 address or a fully qualified domain name.
+	NTPServers []string `json:"ntpServers,omitempty"`
+	// The IPv6 addresses of the SNTP servers (RFC 4075).
+	SNTPServers []string `json:"sntpServers,omitempty"`
+
+	dnsServers    []net.IP
+	searchDomains []string
+	ntpServers    []dhcpv6.Option
+	sntpServers   []net.IP
+	logger        *zap.Logger
+}
+
+// CaddyModule returns the Caddy module information.
+func (Module) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "dhcp.handlers.stateless",
+		New: func() caddy.Module { return new(Module) },
+	}
+}
+
+// Provision is run immediately after this handler is being loaded.
+func (m *Module) Provision(ctx caddy.Context) error {
+	m.logger = ctx.Logger()
+	var err error
+	if m.dnsServers, err = parseIPv6("DNS server", m.DNSServers); err != nil {
+		return err
+	}
+	if m.sntpServers, err = parseIPv6("SNTP server", m.SNTPServers); err != nil {
+		return err
+	}
+	m.searchDomains = nil
+	for _, domain := range m.SearchDomains {
+		name, err := validateDomain(domain)
+		if err != nil {
+			return fmt.Errorf("invalid search domain: %w", err)
+		}
+		m.searchDomains = append(m.searchDomains, name)
+	}
+	m.ntpServers = nil
+	for _, server := range m.NTPServers {
+		if ip := net.ParseIP(server); ip != nil {
+			if ip.To4() != nil {
+				return fmt.Errorf("invalid NTP server %q: not an IPv6 address", server)
+			}
+			srvAddr := dhcpv6.NTPSuboptionSrvAddr(ip)
+			m.ntpServers = append(m.ntpServers, &srvAddr)
+			continue
+		}
+		name, err := validateDomain(server)
+		if err != nil {
+			return fmt.Errorf("invalid NTP server: %w", err)
+		}
+		m.ntpServers = append(m.ntpServers, &dhcpv6.NTPSuboptionSrvFQDN{Labels: rfc1035label.Labels{Labels: []string{name}}})
+	}
+	return nil
+}
+
+// parseIPv6 parses a list of IPv6 addresses, using what to describe them in errors.
+func parseIPv6(what string, addresses []string) ([]net.IP, error) {
+	var ips []net.IP
+	for _, address := range addresses {
+		ip := net.ParseIP(address)
+		if ip == nil || ip.To4() != nil {
+			return nil, fmt.Errorf("invalid %s %q: not an IPv6 address", what, address)
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+// validateDomain checks that the domain has no empty or overlong labels,
+// and returns it without the trailing dot.
+func validateDomain(domain string) (string, error) {
+	name := strings.TrimSuffix(domain, ".")
+	if name == "" {
+		return "", fmt.Errorf("%q: empty domain", domain)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return "", fmt.Errorf("%q: invalid label %q", domain, label)
+		}
+	}
+	return name, nil
+}
+
+// Handle6 handles DHCPv6 packets for this plugin.
+func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
+	if req.Type() != dhcpv6.MessageTypeInformationRequest {
+		return next()
+	}
+	if len(m.dnsServers) > 0 && req.IsOptionRequested(dhcpv6.OptionDNSRecursiveNameServer) {
+		resp.UpdateOption(dhcpv6.OptDNS(m.dnsServers...))
+	}
+	if len(m.searchDomains) > 0 && req.IsOptionRequested(dhcpv6.OptionDomainSearchList) {
+		resp.UpdateOption(dhcpv6.OptDomainSearchList(&rfc1035label.Labels{Labels: append([]string(nil), m.searchDomains...)}))
+	}
+	if len(m.ntpServers) > 0 && req.IsOptionRequested(dhcpv6.OptionNTPServer) {
+		resp.UpdateOption(&dhcpv6.OptNTPServer{Suboptions: append(dhcpv6.Options(nil), m.ntpServers...)})
+	}
+	if len(m.sntpServers) > 0 && req.IsOptionRequested(dhcpv6.OptionSNTPServerList) {
+		var b []byte
+		for _, ip := range m.sntpServers {
+			b = append(b, ip.To16()...)
+		}
+		resp.UpdateOption(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionSNTPServerList, OptionData: b})
+	}
+	return next()
+}
+
+// Interfaces guards
+var (
+	_ handlers.HandlerModule = (*Module)(nil)
+)
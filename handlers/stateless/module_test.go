@@ -0,0 +1,114 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package stateless
+
+import (
+	"net"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/lion7/caddydhcp/handlers/handlertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// allOptions are all the options that the module can add.
+var allOptions = []dhcpv6.OptionCode{
+	dhcpv6.OptionDNSRecursiveNameServer,
+	dhcpv6.OptionDomainSearchList,
+	dhcpv6.OptionNTPServer,
+	dhcpv6.OptionSNTPServerList,
+}
+
+// exchange6 runs the module for a DHCPv6 message of the type requesting the given options,
+// and returns the reply as received by the client.
+func exchange6(t *testing.T, m *Module, messageType dhcpv6.MessageType, requested ...dhcpv6.OptionCode) *dhcpv6.Message {
+	req, err := dhcpv6.NewMessage(dhcpv6.WithClientID(&dhcpv6.DUIDLL{
+		HWType:        iana.HWTypeEthernet,
+		LinkLayerAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+	}))
+	require.NoError(t, err)
+	req.MessageType = messageType
+	req.UpdateOption(dhcpv6.OptRequestedOption(requested...))
+	resp, err := handlertest.Handle6(t, m, handlers.NewDHCPv6(req), nil)
+	require.NoError(t, err)
+
+	// round-trip through the wire format like a client would receive it
+	msg, err := dhcpv6.MessageFromBytes(resp.ToBytes())
+	require.NoError(t, err)
+	return msg
+}
+
+func TestHandle6InformationRequest(t *testing.T) {
+	m := handlertest.Provision(t, &Module{
+		DNSServers:    []string{"2001:db8::53"},
+		SearchDomains: []string{"example.com.", "corp.example.com"},
+		NTPServers:    []string{"2001:db8::123", "ntp.example.com"},
+		SNTPServers:   []string{"2001:db8::124", "2001:db8::125"},
+	})
+	resp := exchange6(t, m, dhcpv6.MessageTypeInformationRequest, allOptions...)
+
+	assert.Equal(t, []net.IP{net.ParseIP("2001:db8::53")}, resp.Options.DNS())
+	assert.Equal(t, []string{"example.com", "corp.example.com"}, resp.Options.DomainSearchList().Labels)
+
+	ntp, ok := resp.GetOneOption(dhcpv6.OptionNTPServer).(*dhcpv6.OptNTPServer)
+	require.True(t, ok)
+	require.Len(t, ntp.Suboptions, 2)
+	srvAddr, ok := ntp.Suboptions[0].(*dhcpv6.NTPSuboptionSrvAddr)
+	require.True(t, ok)
+	assert.True(t, net.IP(*srvAddr).Equal(net.ParseIP("2001:db8::123")))
+	fqdn, ok := ntp.Suboptions[1].(*dhcpv6.NTPSuboptionSrvFQDN)
+	require.True(t, ok)
+	assert.Equal(t, []string{"ntp.example.com"}, fqdn.Labels.Labels)
+
+	sntp := resp.GetOneOption(dhcpv6.OptionSNTPServerList)
+	require.NotNil(t, sntp)
+	want := append(net.ParseIP("2001:db8::124").To16(), net.ParseIP("2001:db8::125").To16()...)
+	assert.Equal(t, []byte(want), sntp.ToBytes())
+}
+
+func TestHandle6Solicit(t *testing.T) {
+	m := handlertest.Provision(t, &Module{
+		DNSServers:    []string{"2001:db8::53"},
+		SearchDomains: []string{"example.com"},
+		NTPServers:    []string{"2001:db8::123"},
+		SNTPServers:   []string{"2001:db8::124"},
+	})
+	// only Information-Requests are answered, stateful clients get their options from the separate handlers
+	resp := exchange6(t, m, dhcpv6.MessageTypeSolicit, allOptions...)
+	for _, code := range allOptions {
+		assert.Nil(t, resp.GetOneOption(code), code.String())
+	}
+}
+
+func TestHandle6NotRequested(t *testing.T) {
+	m := handlertest.Provision(t, &Module{
+		DNSServers:    []string{"2001:db8::53"},
+		SearchDomains: []string{"example.com"},
+		NTPServers:    []string{"2001:db8::123"},
+		SNTPServers:   []string{"2001:db8::124"},
+	})
+	resp := exchange6(t, m, dhcpv6.MessageTypeInformationRequest, dhcpv6.OptionDNSRecursiveNameServer)
+	assert.NotNil(t, resp.GetOneOption(dhcpv6.OptionDNSRecursiveNameServer))
+	assert.Nil(t, resp.GetOneOption(dhcpv6.OptionDomainSearchList))
+	assert.Nil(t, resp.GetOneOption(dhcpv6.OptionNTPServer))
+	assert.Nil(t, resp.GetOneOption(dhcpv6.OptionSNTPServerList))
+}
+
+func TestProvisionInvalid(t *testing.T) {
+	for _, m := range []*Module{
+		{DNSServers: []string{"192.0.2.53"}},
+		{DNSServers: []string{"dns.example.com"}},
+		{SNTPServers: []string{"192.0.2.123"}},
+		{NTPServers: []string{"192.0.2.123"}},
+		{NTPServers: []string{"ntp..example.com"}},
+		{SearchDomains: []string{"."}},
+	} {
+		assert.Error(t, m.Provision(caddy.Context{}))
+	}
+}
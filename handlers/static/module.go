@@ -0,0 +1,270 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package static
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/lion7/caddydhcp/handlers"
+	"go.uber.org/zap"
+)
+
+// Module assigns reserved addresses and options to clients that match the criteria of a reservation.
+// Unlike the file handler, which only keys on the MAC address, client identifier or DUID, a reservation
+// can match on any combination of the MAC address, client identifier, hostname, vendor class and
+// circuit ID of the relay agent.
+//
+// A request gets the reservation that matches the most criteria, so a specific reservation can refine a
+// more general one. When several reservations match the same number of criteria, the first one wins.
+// Requests that match no reservation are passed on unchanged.
+type Module struct {
+	Reservations []Reservation `json:"reservations"`
+
+	logger *zap.Logger
+}
+
+// Reservation is a reserved address and options for the requests that match all of its criteria.
+// At least one criterion is required.
+type Reservation struct {
+	// Matches the MAC address of the client. For DHCPv6 the MAC address is taken from the DUID, if it contains one.
+	MAC string `json:"mac,omitempty"`
+
+	// Matches the Client Identifier option (61) for DHCPv4, or the DUID for DHCPv6, in hex.
+	ClientID string `json:"clientId,omitempty"`
+
+	// Matches the Host Name option (12) for DHCPv4, or the first label of the Client FQDN option (39)
+	// for DHCPv6, ignoring case.
+	Hostname string `json:"hostname,omitempty"`
+
+	// Matches requests with a vendor class (option 60 for DHCPv4, option 16 for DHCPv6) starting with this prefix.
+	VendorClass string `json:"vendorClass,omitempty"`
+
+	// Matches the Agent Circuit ID sub-option of the Relay Agent Information option (82) for DHCPv4,
+	// or the Interface-Id option (18) of the relay agent closest to the client for DHCPv6.
+	CircuitID string `json:"circuitId,omitempty"`
+
+	// The reserved address. An IPv4 address is assigned to DHCPv4 clients, and an IPv6 address
+	// to DHCPv6 clients that request a non-temporary address.
+	IP string `json:"ip,omitempty"`
+
+	// DHCPv4 options to set in the reply, keyed by option code, with their values in hex.
+	Options map[uint8]string `json:"options,omitempty"`
+
+	// DHCPv6 options to set in the reply, keyed by option code, with their values in hex.
+	Options6 map[uint16]string `json:"options6,omitempty"`
+
+	mac      net.HardwareAddr
+	clientID []byte
+	ip       net.IP
+	options4 []dhcpv4.Option
+	options6 []dhcpv6.Option
+}
+
+// CaddyModule returns the Caddy module information.
+func (Module) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "dhcp.handlers.static",
+		New: func() caddy.Module { return new(Module) },
+	}
+}
+
+// Provision is run immediately after this handler is being loaded.
+func (m *Module) Provision(ctx caddy.Context) error {
+	m.logger = ctx.Logger()
+	if len(m.Reservations) == 0 {
+		return fmt.Errorf("no reservations configured")
+	}
+	for i := range m.Reservations {
+		if err := m.Reservations[i].provision(); err != nil {
+			return fmt.Errorf("reservation %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// provision parses and validates the reservation.
+func (r *Reservation) provision() error {
+	if r.MAC == "" && r.ClientID == "" && r.Hostname == "" && r.VendorClass == "" && r.CircuitID == "" {
+		return fmt.Errorf("no criteria configured")
+	}
+	if r.MAC != "" {
+		mac, err := net.ParseMAC(r.MAC)
+		if err != nil {
+			return fmt.Errorf("invalid MAC address %q: %v", r.MAC, err)
+		}
+		r.mac = mac
+	}
+	if r.ClientID != "" {
+		clientID, err := hex.DecodeString(r.ClientID)
+		if err != nil {
+			return fmt.Errorf("invalid client identifier %q: %v", r.ClientID, err)
+		}
+		r.clientID = clientID
+	}
+	if r.IP != "" {
+		r.ip = net.ParseIP(r.IP)
+		if r.ip == nil {
+			return fmt.Errorf("invalid IP address %q", r.IP)
+		}
+	}
+	for code, value := range r.Options {
+		data, err := hex.DecodeString(value)
+		if err != nil {
+			return fmt.Errorf("invalid value of DHCPv4 option %d: %v", code, err)
+		}
+		r.options4 = append(r.options4, dhcpv4.OptGeneric(dhcpv4.GenericOptionCode(code), data))
+	}
+	for code, value := range r.Options6 {
+		data, err := hex.DecodeString(value)
+		if err != nil {
+			return fmt.Errorf("invalid value of DHCPv6 option %d: %v", code, err)
+		}
+		r.options6 = append(r.options6, &dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionCode(code), OptionData: data})
+	}
+	return nil
+}
+
+// Handle4 handles DHCPv4 packets for this plugin.
+func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
+	c := criteria{
+		mac:           req.ClientHWAddr,
+		clientID:      req.Options.Get(dhcpv4.OptionClientIdentifier),
+		hostname:      req.HostName(),
+		vendorClasses: []string{req.ClassIdentifier()},
+	}
+	if info := req.RelayAgentInfo(); info != nil {
+		c.circuitID = info.Get(dhcpv4.AgentCircuitIDSubOption)
+	}
+	r := m.lookup(c)
+	if r == nil {
+		return next()
+	}
+
+	if ip := r.ip.To4(); ip != nil {
+		resp.YourIPAddr = ip
+	}
+	for _, opt := range r.options4 {
+		resp.UpdateOption(opt)
+	}
+	m.logger.Info("found reservation for MAC", zap.Stringer("mac", req.ClientHWAddr), zap.Stringer("ip", resp.YourIPAddr))
+	return next()
+}
+
+// Handle6 handles DHCPv6 packets for this plugin.
+func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
+	c := criteria{circuitID: req.InterfaceID()}
+	c.mac, _ = dhcpv6.ExtractMAC(req.Message)
+	if duid := req.Options.ClientID(); duid != nil {
+		c.clientID = duid.ToBytes()
+	}
+	if fqdn := req.Options.FQDN(); fqdn != nil && fqdn.DomainName != nil && len(fqdn.DomainName.Labels) > 0 {
+		c.hostname = strings.SplitN(fqdn.DomainName.Labels[0], ".", 2)[0]
+	}
+	for _, vendorClass := range req.Options.VendorClasses() {
+		for _, data := range vendorClass.Data {
+			c.vendorClasses = append(c.vendorClasses, string(data))
+		}
+	}
+	r := m.lookup(c)
+	if r == nil {
+		return next()
+	}
+
+	if iana := req.Options.OneIANA(); iana != nil && r.ip != nil && r.ip.To4() == nil {
+		resp.AddOption(&dhcpv6.OptIANA{
+			IaId: iana.IaId,
+			Options: dhcpv6.IdentityOptions{Options: []dhcpv6.Option{
+				&dhcpv6.OptIAAddress{
+					IPv6Addr:          r.ip,
+					PreferredLifetime: 3600 * time.Second,
+					ValidLifetime:     3600 * time.Second,
+				},
+			}},
+		})
+	}
+	for _, opt := range r.options6 {
+		resp.UpdateOption(opt)
+	}
+	m.logger.Info("found reservation for DUID", zap.Stringer("duid", req.Options.ClientID()), zap.Stringer("ip", r.ip))
+	return next()
+}
+
+// criteria are the properties of a request that reservations match on.
+type criteria struct {
+	mac           net.HardwareAddr
+	clientID      []byte
+	hostname      string
+	vendorClasses []string
+	circuitID     []byte
+}
+
+// lookup returns the reservation that matches the most criteria of the request, or nil if none matches.
+func (m *Module) lookup(c criteria) *Reservation {
+	var best *Reservation
+	bestScore := 0
+	for i := range m.Reservations {
+		r := &m.Reservations[i]
+		if score, ok := r.match(c); ok && score > bestScore {
+			best, bestScore = r, score
+		}
+	}
+	return best
+}
+
+// match returns whether the request matches all criteria of the reservation, and the number of those criteria.
+func (r *Reservation) match(c criteria) (int, bool) {
+	score := 0
+	if r.mac != nil {
+		if !bytes.Equal(r.mac, c.mac) {
+			return 0, false
+		}
+		score++
+	}
+	if r.clientID != nil {
+		if !bytes.Equal(r.clientID, c.clientID) {
+			return 0, false
+		}
+		score++
+	}
+	if r.Hostname != "" {
+		if !strings.EqualFold(r.Hostname, c.hostname) {
+			return 0, false
+		}
+		score++
+	}
+	if r.VendorClass != "" {
+		matched := false
+		for _, vendorClass := range c.vendorClasses {
+			if strings.HasPrefix(vendorClass, r.VendorClass) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return 0, false
+		}
+		score++
+	}
+	if r.CircuitID != "" {
+		if r.CircuitID != string(c.circuitID) {
+			return 0, false
+		}
+		score++
+	}
+	return score, true
+}
+
+// Interfaces guards
+var (
+	_ handlers.HandlerModule = (*Module)(nil)
+)
@@ -0,0 +1,112 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package static
+
+import (
+	"encoding/hex"
+	"net"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/lion7/caddydhcp/handlers/handlertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var mac = net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+
+// discover runs the module for a DHCPv4 discover from mac, created with the modifiers.
+func discover(t *testing.T, m *Module, modifiers ...dhcpv4.Modifier) *dhcpv4.DHCPv4 {
+	req, err := dhcpv4.NewDiscovery(mac, modifiers...)
+	require.NoError(t, err)
+	resp, err := handlertest.Handle4(t, m, handlers.NewDHCPv4(req), nil)
+	require.NoError(t, err)
+	return resp
+}
+
+func TestPrecedence(t *testing.T) {
+	m := handlertest.Provision(t, &Module{Reservations: []Reservation{
+		{MAC: mac.String(), IP: "10.0.0.1"},
+		{VendorClass: "PXEClient", IP: "10.0.0.2"},
+		{MAC: mac.String(), Hostname: "printer", IP: "10.0.0.3"},
+		{MAC: mac.String(), Hostname: "printer", CircuitID: "eth1", IP: "10.0.0.4"},
+		{MAC: mac.String(), ClientID: "01aabbccddeeff", IP: "10.0.0.5"},
+	}})
+
+	// a reservation matching a single criterion
+	assert.Equal(t, "10.0.0.1", discover(t, m).YourIPAddr.String())
+	// the first reservation wins when several match the same number of criteria
+	assert.Equal(t, "10.0.0.1", discover(t, m, dhcpv4.WithOption(dhcpv4.OptClassIdentifier("PXEClient:Arch:00000"))).YourIPAddr.String())
+	// a reservation matching more criteria wins, ignoring the case of the hostname
+	assert.Equal(t, "10.0.0.3", discover(t, m, dhcpv4.WithOption(dhcpv4.OptHostName("Printer"))).YourIPAddr.String())
+	assert.Equal(t, "10.0.0.4", discover(t, m,
+		dhcpv4.WithOption(dhcpv4.OptHostName("printer")),
+		dhcpv4.WithOption(dhcpv4.OptRelayAgentInfo(dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte("eth1")))),
+	).YourIPAddr.String())
+	// all criteria of a reservation must match
+	assert.Equal(t, "10.0.0.3", discover(t, m,
+		dhcpv4.WithOption(dhcpv4.OptHostName("printer")),
+		dhcpv4.WithOption(dhcpv4.OptRelayAgentInfo(dhcpv4.OptGeneric(dhcpv4.AgentCircuitIDSubOption, []byte("eth2")))),
+	).YourIPAddr.String())
+	assert.Equal(t, "10.0.0.5", discover(t, m, dhcpv4.WithOption(dhcpv4.OptClientIdentifier([]byte{0x01, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff}))).YourIPAddr.String())
+}
+
+func TestNoMatch(t *testing.T) {
+	m := handlertest.Provision(t, &Module{Reservations: []Reservation{{MAC: "02:00:00:00:00:02", IP: "10.0.0.1"}}})
+	resp := discover(t, m)
+	assert.True(t, resp.YourIPAddr.IsUnspecified())
+}
+
+func TestOptions4(t *testing.T) {
+	m := handlertest.Provision(t, &Module{Reservations: []Reservation{{
+		MAC:     mac.String(),
+		Options: map[uint8]string{66: hex.EncodeToString([]byte("tftp.example.com")), 42: "0a000001"},
+	}}})
+	resp := discover(t, m)
+	assert.True(t, resp.YourIPAddr.IsUnspecified())
+	assert.Equal(t, "tftp.example.com", resp.TFTPServerName())
+	assert.Equal(t, []net.IP{net.IPv4(10, 0, 0, 1).To4()}, resp.NTPServers())
+}
+
+func TestHandle6(t *testing.T) {
+	m := handlertest.Provision(t, &Module{Reservations: []Reservation{
+		{MAC: mac.String(), IP: "2001:db8::1"},
+		{MAC: mac.String(), CircuitID: "eth1", IP: "2001:db8::2", Options6: map[uint16]string{uint16(dhcpv6.OptionBootfileURL): hex.EncodeToString([]byte("http://boot"))}},
+	}})
+	msg, err := dhcpv6.NewSolicit(mac)
+	require.NoError(t, err)
+
+	resp, err := handlertest.Handle6(t, m, handlers.NewDHCPv6(msg), nil)
+	require.NoError(t, err)
+	require.NotNil(t, resp.Options.OneIANA())
+	assert.Equal(t, "2001:db8::1", resp.Options.OneIANA().Options.OneAddress().IPv6Addr.String())
+	assert.Nil(t, resp.Options.GetOne(dhcpv6.OptionBootfileURL))
+
+	relay, err := dhcpv6.EncapsulateRelay(msg, dhcpv6.MessageTypeRelayForward, net.ParseIP("2001:db8::ff"), net.ParseIP("fe80::1"))
+	require.NoError(t, err)
+	relay.Options.Add(dhcpv6.OptInterfaceID([]byte("eth1")))
+	resp, err = handlertest.Handle6(t, m, handlers.NewRelayedDHCPv6(relay, msg), nil)
+	require.NoError(t, err)
+	assert.Equal(t, "2001:db8::2", resp.Options.OneIANA().Options.OneAddress().IPv6Addr.String())
+	assert.Equal(t, []byte("http://boot"), resp.Options.GetOne(dhcpv6.OptionBootfileURL).ToBytes())
+}
+
+func TestProvision(t *testing.T) {
+	for _, r := range []Reservation{
+		{IP: "10.0.0.1"},
+		{MAC: "not a mac"},
+		{ClientID: "zz"},
+		{MAC: mac.String(), IP: "10.0.0"},
+		{MAC: mac.String(), Options: map[uint8]string{66: "zz"}},
+		{MAC: mac.String(), Options6: map[uint16]string{59: "zz"}},
+	} {
+		m := &Module{Reservations: []Reservation{r}}
+		assert.Error(t, m.Provision(caddy.Context{}), r)
+	}
+	assert.Error(t, (&Module{}).Provision(caddy.Context{}))
+}
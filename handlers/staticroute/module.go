@@ -5,21 +5,42 @@
 package staticroute
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"math"
 	"net"
 	"strings"
 
 	"github.com/caddyserver/caddy/v2"
 	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
 	"github.com/lion7/caddydhcp/handlers"
 	"go.uber.org/zap"
 )
 
+// Option codes of the DHCPv6 route options from the expired draft-ietf-mif-dhcpv6-route-option-05.
+// These codes were never assigned by IANA, but are still understood by some vendors.
+const (
+	optionNextHop  dhcpv6.OptionCode = 242
+	optionRTPrefix dhcpv6.OptionCode = 243
+)
+
 type Module struct {
 	Routes []string `json:"routes,omitempty"`
 
-	routes dhcpv4.Routes
-	logger *zap.Logger
+	// IPv6 routes as destination/gateway pairs, e.g. `2001:db8:1::/48,fe80::1`.
+	// These are only sent when DraftRoutes6 is enabled.
+	Routes6 []string `json:"routes6,omitempty"`
+
+	// Sends the IPv6 routes to clients requesting them using the non-standard NEXT_HOP (242) and
+	// RTPREFIX (243) options from draft-ietf-mif-dhcpv6-route-option. Routes are normally
+	// distributed through router advertisements instead, so this is disabled by default.
+	DraftRoutes6 bool `json:"draftRoutes6,omitempty"`
+
+	routes  dhcpv4.Routes
+	routes6 []dhcpv6.Option
+	logger  *zap.Logger
 }
 
 // CaddyModule returns the Caddy module information.
@@ -59,9 +80,62 @@ func (m *Module) Provision(ctx caddy.Context) error {
 	}
 	m.logger.Info(fmt.Sprintf("loaded %d static routes.", len(routes)))
 	m.routes = routes
+
+	routes6, err := parseRoutes6(m.Routes6)
+	if err != nil {
+		return err
+	}
+	m.routes6 = routes6
 	return nil
 }
 
+// parseRoutes6 parses the given destination/gateway pairs into NEXT_HOP options,
+// one per gateway, each holding an RTPREFIX sub-option for every destination.
+func parseRoutes6(args []string) ([]dhcpv6.Option, error) {
+	var gateways []net.IP
+	prefixes := make(map[string]*bytes.Buffer)
+	for _, arg := range args {
+		fields := strings.Split(arg, ",")
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("expected a destination/gateway pair, got: %s", arg)
+		}
+
+		_, dest, err := net.ParseCIDR(fields[0])
+		if err != nil || dest.IP.To4() != nil {
+			return nil, fmt.Errorf("expected an IPv6 destination subnet, got: %s", fields[0])
+		}
+
+		router := net.ParseIP(fields[1])
+		if router == nil || router.To4() != nil {
+			return nil, fmt.Errorf("expected an IPv6 gateway address, got: %s", fields[1])
+		}
+
+		buf, ok := prefixes[router.String()]
+		if !ok {
+			buf = &bytes.Buffer{}
+			prefixes[router.String()] = buf
+			gateways = append(gateways, router)
+		}
+		ones, _ := dest.Mask.Size()
+		// RTPREFIX: route lifetime, prefix length, metric and prefix
+		_ = binary.Write(buf, binary.BigEndian, uint16(optionRTPrefix))
+		_ = binary.Write(buf, binary.BigEndian, uint16(22))
+		_ = binary.Write(buf, binary.BigEndian, uint32(math.MaxUint32))
+		buf.WriteByte(uint8(ones))
+		buf.WriteByte(0)
+		buf.Write(dest.IP.To16())
+	}
+
+	var options []dhcpv6.Option
+	for _, gateway := range gateways {
+		options = append(options, &dhcpv6.OptionGeneric{
+			OptionCode: optionNextHop,
+			OptionData: append(gateway.To16(), prefixes[gateway.String()].Bytes()...),
+		})
+	}
+	return options, nil
+}
+
 // Handle4 handles DHCPv4 packets for this plugin.
 func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
 	if req.IsOptionRequested(dhcpv4.OptionDomainNameServer) {
@@ -72,7 +146,11 @@ func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
 
 // Handle6 handles DHCPv6 packets for this plugin.
 func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
-	// staticroute does not apply to DHCPv6, so just continue the chain
+	if m.DraftRoutes6 && req.IsOptionRequested(optionNextHop) {
+		for _, opt := range m.routes6 {
+			resp.AddOption(opt)
+		}
+	}
 	return next()
 }
 
@@ -0,0 +1,77 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package staticroute
+
+import (
+	"net"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRequest6(t *testing.T) (*dhcpv6.Message, *dhcpv6.Message) {
+	req, err := dhcpv6.NewMessage(
+		dhcpv6.WithClientID(&dhcpv6.DUIDLL{
+			HWType:        iana.HWTypeEthernet,
+			LinkLayerAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 1},
+		}),
+		dhcpv6.WithRequestedOptions(optionNextHop),
+	)
+	require.NoError(t, err)
+	req.MessageType = dhcpv6.MessageTypeRequest
+	resp, err := dhcpv6.NewReplyFromMessage(req)
+	require.NoError(t, err)
+	return req, resp
+}
+
+func TestHandle6(t *testing.T) {
+	m := &Module{
+		Routes6:      []string{"2001:db8:1::/48,fe80::1", "2001:db8:2::/64,fe80::1"},
+		DraftRoutes6: true,
+	}
+	require.NoError(t, m.Provision(caddy.Context{}))
+
+	req, resp := newRequest6(t)
+	err := m.Handle6(handlers.DHCPv6{Message: req}, handlers.DHCPv6{Message: resp}, func() error { return nil })
+	require.NoError(t, err)
+
+	opts := resp.Options.Get(optionNextHop)
+	require.Len(t, opts, 1)
+	assert.Equal(t, []byte{
+		// next hop
+		0xfe, 0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x01,
+		// RTPREFIX: code, length, lifetime, prefix length, metric, prefix
+		0, 243, 0, 22, 0xff, 0xff, 0xff, 0xff, 48, 0,
+		0x20, 0x01, 0x0d, 0xb8, 0, 0x01, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 243, 0, 22, 0xff, 0xff, 0xff, 0xff, 64, 0,
+		0x20, 0x01, 0x0d, 0xb8, 0, 0x02, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	}, opts[0].ToBytes())
+}
+
+func TestHandle6Disabled(t *testing.T) {
+	m := &Module{Routes6: []string{"2001:db8:1::/48,fe80::1"}}
+	require.NoError(t, m.Provision(caddy.Context{}))
+
+	req, resp := newRequest6(t)
+	err := m.Handle6(handlers.DHCPv6{Message: req}, handlers.DHCPv6{Message: resp}, func() error { return nil })
+	require.NoError(t, err)
+	assert.Empty(t, resp.Options.Get(optionNextHop))
+}
+
+func TestProvisionRoutes6(t *testing.T) {
+	for _, routes := range [][]string{
+		{"2001:db8:1::/48"},
+		{"10.0.0.0/8,fe80::1"},
+		{"2001:db8:1::/48,10.0.0.1"},
+	} {
+		m := &Module{Routes6: routes, DraftRoutes6: true}
+		assert.Error(t, m.Provision(caddy.Context{}), routes)
+	}
+}
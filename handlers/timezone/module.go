@@ -0,0 +1,65 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package timezone
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/lion7/caddydhcp/handlers"
+	"go.uber.org/zap"
+)
+
+// Module implements RFC4833: it adds the POSIX timezone string (option 100)
+// and/or the name of the timezone in the TZ database (option 101),
+// e.g. "CET-1CEST,M3.5.0,M10.5.0/3" and "Europe/Amsterdam" respectively.
+// The options are only added if they are requested by the client.
+type Module struct {
+	// timezone is not implemented for DHCPv6
+	handlers.Base
+
+	PosixString    string `json:"posixString,omitempty"`
+	TzDatabaseName string `json:"tzDatabaseName,omitempty"`
+
+	logger *zap.Logger
+}
+
+// CaddyModule returns the Caddy module information.
+func (Module) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "dhcp.handlers.timezone",
+		New: func() caddy.Module { return new(Module) },
+	}
+}
+
+// Provision is run immediately after this handler is being loaded.
+func (m *Module) Provision(ctx caddy.Context) error {
+	m.logger = ctx.Logger()
+	if m.PosixString == "" && m.TzDatabaseName == "" {
+		return fmt.Errorf("need a POSIX timezone string and/or a TZ database name")
+	}
+	if m.TzDatabaseName != "" && strings.TrimSpace(m.TzDatabaseName) == "" {
+		return fmt.Errorf("got empty TZ database name")
+	}
+	return nil
+}
+
+// Handle4 handles DHCPv4 packets for this plugin.
+func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
+	if m.PosixString != "" && req.IsOptionRequested(dhcpv4.OptionIEEE10031TZString) {
+		resp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionIEEE10031TZString, []byte(m.PosixString)))
+	}
+	if m.TzDatabaseName != "" && req.IsOptionRequested(dhcpv4.OptionReferenceToTZDatabase) {
+		resp.UpdateOption(dhcpv4.OptGeneric(dhcpv4.OptionReferenceToTZDatabase, []byte(m.TzDatabaseName)))
+	}
+	return next()
+}
+
+// Interfaces guards
+var (
+	_ handlers.HandlerModule = (*Module)(nil)
+)
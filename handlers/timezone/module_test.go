@@ -0,0 +1,51 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package timezone
+
+import (
+	"net"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/lion7/caddydhcp/handlers/handlertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// discover runs the module for a DHCPv4 discover requesting the given options.
+func discover(t *testing.T, m *Module, requested ...dhcpv4.OptionCode) *dhcpv4.DHCPv4 {
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}, dhcpv4.WithRequestedOptions(requested...))
+	require.NoError(t, err)
+	resp, err := handlertest.Handle4(t, m, handlers.NewDHCPv4(req), nil)
+	require.NoError(t, err)
+	return resp
+}
+
+func TestPosixString(t *testing.T) {
+	m := handlertest.Provision(t, &Module{PosixString: "CET-1CEST,M3.5.0,M10.5.0/3"})
+
+	resp := discover(t, m, dhcpv4.OptionIEEE10031TZString)
+	assert.Equal(t, []byte("CET-1CEST,M3.5.0,M10.5.0/3"), resp.GetOneOption(dhcpv4.OptionIEEE10031TZString))
+	assert.False(t, resp.Options.Has(dhcpv4.OptionReferenceToTZDatabase))
+
+	resp = discover(t, m, dhcpv4.OptionRouter)
+	assert.False(t, resp.Options.Has(dhcpv4.OptionIEEE10031TZString), "not requested")
+}
+
+func TestTzDatabaseName(t *testing.T) {
+	m := handlertest.Provision(t, &Module{TzDatabaseName: "Europe/Amsterdam"})
+
+	resp := discover(t, m, dhcpv4.OptionReferenceToTZDatabase, dhcpv4.OptionIEEE10031TZString)
+	assert.Equal(t, []byte("Europe/Amsterdam"), resp.GetOneOption(dhcpv4.OptionReferenceToTZDatabase))
+	assert.Equal(t, "Europe/Amsterdam", dhcpv4.GetString(dhcpv4.OptionReferenceToTZDatabase, resp.Options))
+	assert.False(t, resp.Options.Has(dhcpv4.OptionIEEE10031TZString))
+}
+
+func TestProvisionInvalid(t *testing.T) {
+	assert.Error(t, (&Module{}).Provision(caddy.Context{}))
+	assert.Error(t, (&Module{TzDatabaseName: "  "}).Provision(caddy.Context{}))
+}
@@ -0,0 +1,102 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package when
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/lion7/caddydhcp/handlers"
+	"go.uber.org/zap"
+)
+
+// Module runs its handlers only for requests of the configured message types, after which the chain continues
+// with the handlers following this module. A request matches if either its own message type or that of the reply
+// is configured, so handlers that only make sense for offers and acknowledgements can be skipped for e.g. a Release:
+//
+//	{
+//	  "handler": "when",
+//	  "messageTypes": ["Offer", "Ack"],
+//	  "handle": [{"handler": "dns", "servers": ["10.0.0.53"]}]
+//	}
+type Module struct {
+	// The DHCPv4 and DHCPv6 message types to run the handlers for, like "Discover", "Offer", "Ack",
+	// "Solicit" or "Reply". Names are case-insensitive, and may be prefixed with "DHCP" for DHCPv4.
+	// A name like "Request" that is both a DHCPv4 and a DHCPv6 message type matches both.
+	MessageTypes []string `json:"messageTypes"`
+
+	// The handlers to run for a matching request.
+	HandlersRaw []json.RawMessage `json:"handle,omitempty" caddy:"namespace=dhcp.handlers inline_key=handler"`
+
+	types4 map[dhcpv4.MessageType]bool
+	types6 map[dhcpv6.MessageType]bool
+	chain  handlers.Chain
+	logger *zap.Logger
+}
+
+// CaddyModule returns the Caddy module information.
+func (Module) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "dhcp.handlers.when",
+		New: func() caddy.Module { return new(Module) },
+	}
+}
+
+// Provision is run immediately after this handler is being loaded.
+func (m *Module) Provision(ctx caddy.Context) error {
+	m.logger = ctx.Logger()
+	if len(m.MessageTypes) == 0 {
+		return fmt.Errorf("no message types configured")
+	}
+	m.types4 = make(map[dhcpv4.MessageType]bool)
+	m.types6 = make(map[dhcpv6.MessageType]bool)
+	for _, name := range m.MessageTypes {
+		found := false
+		if mt, ok := handlers.ParseMessageType4(name); ok {
+			m.types4[mt] = true
+			found = true
+		}
+		if mt, ok := handlers.ParseMessageType6(name); ok {
+			m.types6[mt] = true
+			found = true
+		}
+		if !found {
+			return fmt.Errorf("unknown message type %q", name)
+		}
+	}
+
+	mods, err := handlers.LoadModule(ctx, m, "HandlersRaw")
+	if err != nil {
+		return fmt.Errorf("loading handler modules: %v", err)
+	}
+	m.chain, err = handlers.NewChain(mods)
+	return err
+}
+
+// Handle4 handles DHCPv4 packets for this plugin.
+func (m *Module) Handle4(req, resp handlers.DHCPv4, next func() error) error {
+	if !m.types4[req.MessageType()] && !m.types4[resp.MessageType()] {
+		m.logger.Debug("skipping handlers for message type", zap.Stringer("message_type", req.MessageType()))
+		return next()
+	}
+	return m.chain.Handle4(req, resp, next)
+}
+
+// Handle6 handles DHCPv6 packets for this plugin.
+func (m *Module) Handle6(req, resp handlers.DHCPv6, next func() error) error {
+	if !m.types6[req.MessageType] && !m.types6[resp.MessageType] {
+		m.logger.Debug("skipping handlers for message type", zap.Stringer("message_type", req.MessageType))
+		return next()
+	}
+	return m.chain.Handle6(req, resp, next)
+}
+
+// Interfaces guards
+var (
+	_ handlers.HandlerModule = (*Module)(nil)
+)
@@ -0,0 +1,109 @@
+// Copyright 2018-present the CoreDHCP Authors. All rights reserved
+// This source code is licensed under the MIT license found in the
+// LICENSE file in the root directory of this source tree.
+
+package when
+
+import (
+	"net"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/lion7/caddydhcp/handlers/handlertest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder is a handler recording whether it was called.
+type recorder struct {
+	called bool
+}
+
+func (r *recorder) Handle4(_, _ handlers.DHCPv4, next func() error) error {
+	r.called = true
+	return next()
+}
+
+func (r *recorder) Handle6(_, _ handlers.DHCPv6, next func() error) error {
+	r.called = true
+	return next()
+}
+
+// withRecorder returns a module matching the given message types, and the recorder that is its nested handler.
+func withRecorder(t *testing.T, types ...string) (*Module, *recorder) {
+	m := handlertest.Provision(t, &Module{MessageTypes: types})
+	r := &recorder{}
+	m.chain = handlers.Chain{r}
+	return m, r
+}
+
+func TestHandle4(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		req     dhcpv4.MessageType
+		resp    dhcpv4.MessageType
+		matches bool
+	}{
+		{"offer", dhcpv4.MessageTypeDiscover, dhcpv4.MessageTypeOffer, true},
+		{"ack", dhcpv4.MessageTypeRequest, dhcpv4.MessageTypeAck, true},
+		{"nak", dhcpv4.MessageTypeRequest, dhcpv4.MessageTypeNak, false},
+		{"release", dhcpv4.MessageTypeRelease, dhcpv4.MessageTypeNone, false},
+		{"decline", dhcpv4.MessageTypeDecline, dhcpv4.MessageTypeNone, false},
+		{"inform", dhcpv4.MessageTypeInform, dhcpv4.MessageTypeAck, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m, r := withRecorder(t, "Offer", "DHCPACK")
+			req, err := dhcpv4.New(dhcpv4.WithHwAddr(net.HardwareAddr{0x02, 0, 0, 0, 0, 1}), dhcpv4.WithMessageType(tc.req))
+			require.NoError(t, err)
+
+			called := false
+			_, err = handlertest.Handle4(t, m, handlers.NewDHCPv4(req), func(*dhcpv4.DHCPv4) error {
+				called = true
+				return nil
+			}, dhcpv4.WithMessageType(tc.resp))
+			require.NoError(t, err)
+			assert.True(t, called, "the chain continues after the nested handlers")
+			assert.Equal(t, tc.matches, r.called)
+		})
+	}
+}
+
+func TestHandle6(t *testing.T) {
+	m, r := withRecorder(t, "solicit", "information-request")
+	for _, tc := range []struct {
+		mt      dhcpv6.MessageType
+		matches bool
+	}{
+		{dhcpv6.MessageTypeSolicit, true},
+		{dhcpv6.MessageTypeInformationRequest, true},
+		{dhcpv6.MessageTypeRelease, false},
+		{dhcpv6.MessageTypeRenew, false},
+	} {
+		r.called = false
+		req := &dhcpv6.Message{MessageType: tc.mt}
+		resp := &dhcpv6.Message{MessageType: dhcpv6.MessageTypeReply}
+		require.NoError(t, m.Handle6(handlers.NewDHCPv6(req), handlers.DHCPv6{Message: resp}, func() error { return nil }))
+		assert.Equal(t, tc.matches, r.called, tc.mt.String())
+	}
+
+	// a reply type matches too
+	m, r = withRecorder(t, "reply")
+	req := &dhcpv6.Message{MessageType: dhcpv6.MessageTypeRenew}
+	resp := &dhcpv6.Message{MessageType: dhcpv6.MessageTypeReply}
+	require.NoError(t, m.Handle6(handlers.NewDHCPv6(req), handlers.DHCPv6{Message: resp}, func() error { return nil }))
+	assert.True(t, r.called)
+}
+
+func TestProvision(t *testing.T) {
+	m := &Module{MessageTypes: []string{"Request"}}
+	require.NoError(t, m.Provision(caddy.Context{}))
+	assert.True(t, m.types4[dhcpv4.MessageTypeRequest])
+	assert.True(t, m.types6[dhcpv6.MessageTypeRequest])
+
+	for _, types := range [][]string{nil, {"Offer", "bogus"}} {
+		assert.Error(t, (&Module{MessageTypes: types}).Provision(caddy.Context{}), types)
+	}
+}
@@ -0,0 +1,131 @@
+package caddydhcp
+
+import (
+	"errors"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricsNamespace = "caddy"
+	metricsSubsystem = "dhcp"
+)
+
+// newHandlerDurations creates the histogram holding the durations of the individual handlers
+// and registers it in the metrics registry of ctx. An already registered histogram is reused.
+func newHandlerDurations(ctx caddy.Context) (*prometheus.HistogramVec, error) {
+	durations := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "handler_duration_seconds",
+		Help:      "Time spent in a single handler, excluding the handlers after it in the chain.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"server", "handler", "family"})
+	return registerCollector(ctx, durations)
+}
+
+// newParseErrors creates the counter of requests that could not be parsed
+// and registers it in the metrics registry of ctx. An already registered counter is reused.
+func newParseErrors(ctx caddy.Context) (*prometheus.CounterVec, error) {
+	parseErrors := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "parse_errors_total",
+		Help:      "Number of received packets that could not be parsed as a DHCP message.",
+	}, []string{"server", "family"})
+	return registerCollector(ctx, parseErrors)
+}
+
+// newReplyErrors creates the counter of requests that no reply could be built for
+// and registers it in the metrics registry of ctx. An already registered counter is reused.
+func newReplyErrors(ctx caddy.Context) (*prometheus.CounterVec, error) {
+	replyErrors := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "reply_errors_total",
+		Help:      "Number of requests that no reply could be built for.",
+	}, []string{"server", "family"})
+	return registerCollector(ctx, replyErrors)
+}
+
+// newRequestedOptions creates the counter of the options that clients requested
+// and registers it in the metrics registry of ctx. An already registered counter is reused.
+func newRequestedOptions(ctx caddy.Context) (*prometheus.CounterVec, error) {
+	requestedOptions := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "requested_options_total",
+		Help:      "Number of requests that asked for an option in their Parameter Request List or Option Request option.",
+	}, []string{"server", "family", "option"})
+	return registerCollector(ctx, requestedOptions)
+}
+
+// registerCollector registers c in the metrics registry of ctx, returning the existing
+// collector if an identical one was registered before, e.g. by a previous config.
+func registerCollector[C prometheus.Collector](ctx caddy.Context, c C) (C, error) {
+	registry := ctx.GetMetricsRegistry()
+	if registry == nil {
+		return c, nil
+	}
+	if err := registry.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			if existing, ok := are.ExistingCollector.(C); ok {
+				return existing, nil
+			}
+		}
+		return c, err
+	}
+	return c, nil
+}
+
+// profileHandlers wraps every handler so its duration is observed in durations,
+// labeled with the server name and the module name of the handler.
+func profileHandlers(server string, hs []handlers.Handler, durations *prometheus.HistogramVec) []handlers.Handler {
+	profiled := make([]handlers.Handler, len(hs))
+	for i, h := range hs {
+		name := "unknown"
+		if mod, ok := h.(caddy.Module); ok {
+			name = mod.CaddyModule().ID.Name()
+		}
+		profiled[i] = profiledHandler{
+			handler:  h,
+			observe4: durations.WithLabelValues(server, name, "4"),
+			observe6: durations.WithLabelValues(server, name, "6"),
+		}
+	}
+	return profiled
+}
+
+// profiledHandler observes the time spent in a handler, without the time spent in the rest of the chain.
+type profiledHandler struct {
+	handler            handlers.Handler
+	observe4, observe6 prometheus.Observer
+}
+
+func (h profiledHandler) Handle4(req, resp handlers.DHCPv4, next func() error) error {
+	var inner time.Duration
+	start := time.Now()
+	err := h.handler.Handle4(req, resp, func() error {
+		innerStart := time.Now()
+		defer func() { inner += time.Since(innerStart) }()
+		return next()
+	})
+	h.observe4.Observe((time.Since(start) - inner).Seconds())
+	return err
+}
+
+func (h profiledHandler) Handle6(req, resp handlers.DHCPv6, next func() error) error {
+	var inner time.Duration
+	start := time.Now()
+	err := h.handler.Handle6(req, resp, func() error {
+		innerStart := time.Now()
+		defer func() { inner += time.Since(innerStart) }()
+		return next()
+	})
+	h.observe6.Observe((time.Since(start) - inner).Seconds())
+	return err
+}
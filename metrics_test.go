@@ -0,0 +1,97 @@
+package caddydhcp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/lion7/caddydhcp/handlers/mtu"
+	"github.com/lion7/caddydhcp/handlers/sleep"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestProfileHandlers(t *testing.T) {
+	const delay = 20 * time.Millisecond
+
+	fast := &mtu.Module{Mtu: 1500}
+	slow := &sleep.Module{Duration: caddy.Duration(delay)}
+	require.NoError(t, fast.Provision(caddy.Context{}))
+	require.NoError(t, slow.Provision(caddy.Context{}))
+
+	durations, err := newHandlerDurations(caddy.Context{})
+	require.NoError(t, err)
+	chain := handlers.Chain(profileHandlers("srv0", []handlers.Handler{fast, slow}, durations))
+
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	resp, err := dhcpv4.NewReplyFromRequest(req)
+	require.NoError(t, err)
+	err = chain.Handle4(handlers.DHCPv4{DHCPv4: req}, handlers.DHCPv4{DHCPv4: resp}, func() error { return nil })
+	require.NoError(t, err)
+
+	histogram := func(handler string) *dto.Histogram {
+		var m dto.Metric
+		require.NoError(t, durations.WithLabelValues("srv0", handler, "4").(prometheus.Metric).Write(&m))
+		return m.GetHistogram()
+	}
+
+	// the duration of the first handler excludes the time spent in the second handler
+	assert.Equal(t, uint64(1), histogram("mtu").GetSampleCount())
+	assert.Less(t, histogram("mtu").GetSampleSum(), delay.Seconds())
+	assert.Equal(t, uint64(1), histogram("sleep").GetSampleCount())
+	assert.GreaterOrEqual(t, histogram("sleep").GetSampleSum(), delay.Seconds())
+}
+
+func TestRequestedOptions(t *testing.T) {
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	core, logs := observer.New(zapcore.InfoLevel)
+	requestedOptions, err := newRequestedOptions(caddy.Context{})
+	require.NoError(t, err)
+	s := &dhcpServer{
+		handler:          handlers.Chain{},
+		logger:           zap.NewNop(),
+		accessLog:        zap.New(core),
+		requestedOptions: requestedOptions.MustCurryWith(prometheus.Labels{"server": "srv0"}),
+	}
+	conn := &testConn{}
+
+	req, err := dhcpv4.NewDiscovery(mac)
+	require.NoError(t, err)
+	// the list replaces the options that NewDiscovery requests itself
+	req.UpdateOption(dhcpv4.OptParameterRequestList(dhcpv4.OptionSubnetMask, dhcpv4.OptionRouter, dhcpv4.OptionDomainNameServer, dhcpv4.OptionRouter))
+	s.handle4(conn, &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}, packetInfo{}, req)
+	s.handle4(conn, &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}, packetInfo{}, req)
+
+	req6, err := dhcpv6.NewSolicit(mac)
+	require.NoError(t, err)
+	req6.UpdateOption(dhcpv6.OptRequestedOption(dhcpv6.OptionDNSRecursiveNameServer, dhcpv6.OptionDomainSearchList))
+	s.handle6(conn, &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: dhcpv6.DefaultClientPort}, packetInfo{}, req6, nil)
+
+	entries := logs.FilterMessage("handled request").AllUntimed()
+	require.Len(t, entries, 3)
+	assert.Equal(t, []interface{}{"Subnet Mask", "Router", "Domain Name Server"}, entries[0].ContextMap()["requested_options"])
+	assert.Equal(t, []interface{}{"DNS", "Domain Search List"}, entries[2].ContextMap()["requested_options"])
+
+	count := func(family, option string) float64 {
+		var m dto.Metric
+		require.NoError(t, requestedOptions.WithLabelValues("srv0", family, option).Write(&m))
+		return m.GetCounter().GetValue()
+	}
+	// a duplicate code is counted once per request
+	assert.Equal(t, 2.0, count("4", "1"))
+	assert.Equal(t, 2.0, count("4", "3"))
+	assert.Equal(t, 2.0, count("4", "6"))
+	assert.Equal(t, 0.0, count("4", "15"))
+	assert.Equal(t, 1.0, count("6", "23"))
+	assert.Equal(t, 1.0, count("6", "24"))
+}
@@ -0,0 +1,108 @@
+package caddydhcp
+
+import (
+	"net"
+	"strconv"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// packetInfo is the local address and network interface on which a packet arrived.
+// Its zero value means they are unknown.
+type packetInfo struct {
+	dst     net.IP
+	ifIndex int
+}
+
+// infoConn is a listener socket that reads packets together with their packetInfo,
+// which it reads from IP_PKTINFO or IPV6_PKTINFO control messages.
+//
+// It is also used for the sockets of the additional read workers of a listener address.
+// The kernel spreads the unicast packets over all sockets bound to the same address using SO_REUSEPORT,
+// but delivers broadcast and multicast packets to every one of them. To handle every request once,
+// the sockets of additional workers set unicastOnly to skip those packets and leave them to the first socket.
+//
+// The socket it wraps is also used to write replies from a source address, see writeFromSource.
+type infoConn struct {
+	net.PacketConn
+	sock        net.PacketConn
+	read        func(b []byte) (int, packetInfo, net.Addr, error)
+	unicastOnly bool
+}
+
+// newInfoConn wraps conn, which listens on the given network, to read the packetInfo of every packet.
+func newInfoConn(conn net.PacketConn, network string) (*infoConn, error) {
+	inner := conn
+	// unwrap the connection of the listener pool of Caddy, which does not expose the socket
+	if u, ok := conn.(interface{ Unwrap() net.PacketConn }); ok {
+		inner = u.Unwrap()
+	}
+	if network == "udp4" {
+		pc := ipv4.NewPacketConn(inner)
+		if err := pc.SetControlMessage(ipv4.FlagDst|ipv4.FlagInterface, true); err != nil {
+			return nil, err
+		}
+		return &infoConn{PacketConn: conn, sock: inner, read: func(b []byte) (int, packetInfo, net.Addr, error) {
+			n, cm, peer, err := pc.ReadFrom(b)
+			if cm == nil {
+				return n, packetInfo{}, peer, err
+			}
+			return n, packetInfo{dst: cm.Dst, ifIndex: cm.IfIndex}, peer, err
+		}}, nil
+	}
+	pc := ipv6.NewPacketConn(inner)
+	if err := pc.SetControlMessage(ipv6.FlagDst|ipv6.FlagInterface, true); err != nil {
+		return nil, err
+	}
+	return &infoConn{PacketConn: conn, sock: inner, read: func(b []byte) (int, packetInfo, net.Addr, error) {
+		n, cm, peer, err := pc.ReadFrom(b)
+		if cm == nil {
+			return n, packetInfo{}, peer, err
+		}
+		return n, packetInfo{dst: cm.Dst, ifIndex: cm.IfIndex}, peer, err
+	}}, nil
+}
+
+// readInfo reads the next packet and its packetInfo, skipping broadcast and multicast packets if unicastOnly is set.
+func (c *infoConn) readInfo(b []byte) (int, packetInfo, net.Addr, error) {
+	for {
+		n, info, peer, err := c.read(b)
+		if err != nil || !c.unicastOnly || info.dst == nil || !(info.dst.IsMulticast() || info.dst.Equal(net.IPv4bcast)) {
+			return n, info, peer, err
+		}
+	}
+}
+
+// ReadFrom reads the next packet like readInfo, discarding its packetInfo.
+func (c *infoConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, _, peer, err := c.readInfo(b)
+	return n, peer, err
+}
+
+// localFields returns the access log fields describing the local address and interface on which a request arrived.
+func (s *dhcpServer) localFields(local packetInfo) []zap.Field {
+	var fields []zap.Field
+	if local.dst != nil {
+		fields = append(fields, zap.Stringer("local_ip", local.dst))
+	}
+	if local.ifIndex != 0 {
+		interfaceName := s.interfaceName
+		if interfaceName == nil {
+			interfaceName = interfaceNameByIndex
+		}
+		fields = append(fields, zap.String("interface", interfaceName(local.ifIndex)))
+	}
+	return fields
+}
+
+// interfaceNameByIndex returns the name of the network interface with the given index,
+// or the index itself if there is no such interface.
+func interfaceNameByIndex(index int) string {
+	iface, err := net.InterfaceByIndex(index)
+	if err != nil {
+		return strconv.Itoa(index)
+	}
+	return iface.Name
+}
@@ -0,0 +1,103 @@
+package caddydhcp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestAccessLogLocal(t *testing.T) {
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	for _, logLocal := range []bool{false, true} {
+		t.Run(fmt.Sprintf("logLocal=%v", logLocal), func(t *testing.T) {
+			core, logs := observer.New(zapcore.InfoLevel)
+			s := &dhcpServer{
+				handler:   handlers.Chain{},
+				logger:    zap.NewNop(),
+				accessLog: zap.New(core),
+				logLocal:  logLocal,
+				interfaceName: func(index int) string {
+					return fmt.Sprintf("eth%d", index)
+				},
+			}
+			conn := &testConn{}
+
+			req, err := dhcpv4.NewDiscovery(mac)
+			require.NoError(t, err)
+			s.handle4(conn, &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}, packetInfo{dst: net.IPv4bcast, ifIndex: 2}, req)
+			req6, err := dhcpv6.NewSolicit(mac)
+			require.NoError(t, err)
+			s.handle6(conn, &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: dhcpv6.DefaultClientPort}, packetInfo{dst: net.ParseIP("ff02::1:2"), ifIndex: 3}, req6, nil)
+
+			entries := logs.FilterMessage("handled request").AllUntimed()
+			require.Len(t, entries, 2)
+			fields4, fields6 := entries[0].ContextMap(), entries[1].ContextMap()
+			if !logLocal {
+				assert.NotContains(t, fields4, "local_ip")
+				assert.NotContains(t, fields4, "interface")
+				return
+			}
+			assert.Equal(t, "255.255.255.255", fields4["local_ip"])
+			assert.Equal(t, "eth2", fields4["interface"])
+			assert.Equal(t, "ff02::1:2", fields6["local_ip"])
+			assert.Equal(t, "eth3", fields6["interface"])
+		})
+	}
+
+	// the fields are omitted when the local address and interface are unknown
+	s := &dhcpServer{logLocal: true}
+	assert.Empty(t, s.localFields(packetInfo{}))
+}
+
+func TestInfoConn(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skip("no loopback interface")
+	}
+	for _, tc := range []struct {
+		network string
+		ip      net.IP
+	}{
+		{"udp4", net.IPv4(127, 0, 0, 1)},
+		{"udp6", net.IPv6loopback},
+	} {
+		t.Run(tc.network, func(t *testing.T) {
+			var lc net.ListenConfig
+			conn, err := lc.ListenPacket(context.Background(), tc.network, net.JoinHostPort("", "0"))
+			if err != nil {
+				t.Skipf("cannot listen on %s: %v", tc.network, err)
+			}
+			defer conn.Close()
+			ic, err := newInfoConn(conn, tc.network)
+			require.NoError(t, err)
+
+			sender, err := net.DialUDP(tc.network, nil, &net.UDPAddr{IP: tc.ip, Port: conn.LocalAddr().(*net.UDPAddr).Port})
+			if err != nil {
+				t.Skipf("cannot send to %s: %v", tc.ip, err)
+			}
+			defer sender.Close()
+			_, err = sender.Write([]byte("request"))
+			require.NoError(t, err)
+
+			s := &dhcpServer{logger: zap.NewNop()}
+			require.NoError(t, ic.SetReadDeadline(time.Now().Add(time.Second)))
+			b, _, local, err := s.read(ic)
+			require.NoError(t, err)
+			assert.Equal(t, "request", string(b))
+			assert.True(t, tc.ip.Equal(local.dst), local.dst)
+			assert.Equal(t, lo.Index, local.ifIndex)
+			assert.Equal(t, "lo", interfaceNameByIndex(local.ifIndex))
+		})
+	}
+}
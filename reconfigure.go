@@ -0,0 +1,153 @@
+package caddydhcp
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sync"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"go.uber.org/zap"
+)
+
+// Fields of the Authentication option (11) as used by the Reconfigure Key Authentication Protocol,
+// see RFC 8415 sections 20.4 and 21.11.
+const (
+	authProtocolReconfigureKey = 3
+	authAlgorithmHMACMD5       = 1
+	authRDMMonotonicCounter    = 0
+
+	reconfigureKeyValue  = 1
+	reconfigureKeyDigest = 2
+
+	reconfigureKeyLen = 16
+)
+
+// reconfigureClient holds what is needed to send a Reconfigure message to a client.
+type reconfigureClient struct {
+	conn     net.PacketConn
+	peer     *net.UDPAddr
+	serverID dhcpv6.DUID
+	clientID dhcpv6.DUID
+	key      []byte
+}
+
+// reconfigureClients tracks the clients that accepted Reconfigure messages, keyed by their hex encoded DUID.
+type reconfigureClients struct {
+	mu      sync.Mutex
+	clients map[string]*reconfigureClient
+	// replay is the replay detection counter, which must increase with every Reconfigure message.
+	replay uint64
+}
+
+// offerReconfigure adds the reconfigure key of the client to a reply if the client included
+// the Reconfigure Accept option in its request. Only clients that sent the request directly,
+// i.e. not through a relay agent, are remembered, since the Reconfigure message is unicast to them.
+func (s *dhcpServer) offerReconfigure(conn net.PacketConn, peer *net.UDPAddr, req, resp *dhcpv6.Message) {
+	if resp.MessageType != dhcpv6.MessageTypeReply || req.GetOneOption(dhcpv6.OptionReconfAccept) == nil {
+		return
+	}
+	clientID, serverID := req.Options.ClientID(), resp.Options.ServerID()
+	if clientID == nil || serverID == nil {
+		return
+	}
+
+	s.reconfigure.mu.Lock()
+	defer s.reconfigure.mu.Unlock()
+	id := hex.EncodeToString(clientID.ToBytes())
+	client, ok := s.reconfigure.clients[id]
+	if !ok {
+		key := make([]byte, reconfigureKeyLen)
+		if _, err := rand.Read(key); err != nil {
+			s.logger.Error("failed to generate reconfigure key", zap.Error(err))
+			return
+		}
+		client = &reconfigureClient{key: key}
+		s.reconfigure.clients[id] = client
+	}
+	client.conn, client.peer, client.serverID, client.clientID = conn, peer, serverID, clientID
+
+	s.reconfigure.replay++
+	resp.UpdateOption(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionReconfAccept})
+	resp.UpdateOption(authOption(s.reconfigure.replay, reconfigureKeyValue, client.key))
+}
+
+// sendReconfigure sends a Reconfigure message to the client with the given hex encoded DUID,
+// asking it to initiate a transaction of the given type.
+func (s *dhcpServer) sendReconfigure(clientID string, msgType dhcpv6.MessageType) error {
+	s.reconfigure.mu.Lock()
+	client, ok := s.reconfigure.clients[clientID]
+	s.reconfigure.replay++
+	replay := s.reconfigure.replay
+	s.reconfigure.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("client %s did not accept reconfigure messages", clientID)
+	}
+
+	m, err := buildReconfigure(client.serverID, client.clientID, msgType, client.key, replay)
+	if err != nil {
+		return err
+	}
+	peer := &net.UDPAddr{IP: client.peer.IP, Port: dhcpv6.DefaultClientPort, Zone: client.peer.Zone}
+	if _, err := client.conn.WriteTo(m.ToBytes(), peer); err != nil {
+		return err
+	}
+	s.logger.Info("sent reconfigure message", zap.String("clientId", clientID), zap.Stringer("peer", peer), zap.Stringer("messageType", msgType))
+	return nil
+}
+
+// buildReconfigure builds a Reconfigure message authenticated with the reconfigure key of the client,
+// as described in RFC 8415 sections 18.3.11 and 20.4.
+func buildReconfigure(serverID, clientID dhcpv6.DUID, msgType dhcpv6.MessageType, key []byte, replay uint64) (*dhcpv6.Message, error) {
+	switch msgType {
+	case dhcpv6.MessageTypeRenew, dhcpv6.MessageTypeRebind, dhcpv6.MessageTypeInformationRequest:
+	default:
+		return nil, fmt.Errorf("cannot reconfigure a client using message type %s", msgType)
+	}
+
+	m, err := dhcpv6.NewMessage(dhcpv6.WithServerID(serverID), dhcpv6.WithClientID(clientID))
+	if err != nil {
+		return nil, err
+	}
+	m.MessageType = dhcpv6.MessageTypeReconfigure
+	// a Reconfigure message has a transaction ID of zero
+	m.TransactionID = dhcpv6.TransactionID{}
+	m.AddOption(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionReconfMessage, OptionData: []byte{byte(msgType)}})
+
+	// the digest is calculated over the message with a zeroed digest field
+	auth := authOption(replay, reconfigureKeyDigest, make([]byte, md5.Size))
+	m.AddOption(auth)
+	mac := hmac.New(md5.New, key)
+	mac.Write(m.ToBytes())
+	copy(auth.OptionData[len(auth.OptionData)-md5.Size:], mac.Sum(nil))
+	return m, nil
+}
+
+// authOption builds an Authentication option for the Reconfigure Key Authentication Protocol.
+func authOption(replay uint64, infoType byte, value []byte) *dhcpv6.OptionGeneric {
+	data := []byte{authProtocolReconfigureKey, authAlgorithmHMACMD5, authRDMMonotonicCounter}
+	data = binary.BigEndian.AppendUint64(data, replay)
+	data = append(data, infoType)
+	data = append(data, value...)
+	return &dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionAuth, OptionData: data}
+}
+
+// Reconfigure sends a Reconfigure message from the given server to the client with the given hex encoded DUID,
+// asking it to initiate a transaction of the given type (Renew, Rebind or Information-request).
+// The client must have accepted Reconfigure messages in a previous request to the server.
+func (app *App) Reconfigure(server, clientID string, msgType dhcpv6.MessageType) error {
+	for _, s := range app.servers {
+		if s.name != server {
+			continue
+		}
+		if s.reconfigure == nil {
+			return fmt.Errorf("server %s does not support reconfigure messages", server)
+		}
+		return s.sendReconfigure(clientID, msgType)
+	}
+	return fmt.Errorf("unknown server %s", server)
+}
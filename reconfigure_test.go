@@ -0,0 +1,112 @@
+package caddydhcp
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"encoding/hex"
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap/zapcore"
+)
+
+var (
+	testServerID = &dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 0xff}}
+	testClientID = &dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 1}}
+)
+
+func TestBuildReconfigure(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	m, err := buildReconfigure(testServerID, testClientID, dhcpv6.MessageTypeRenew, key, 42)
+	require.NoError(t, err)
+
+	b := m.ToBytes()
+	parsed, err := dhcpv6.FromBytes(b)
+	require.NoError(t, err)
+	msg := parsed.(*dhcpv6.Message)
+	assert.Equal(t, dhcpv6.MessageTypeReconfigure, msg.MessageType)
+	assert.Equal(t, dhcpv6.TransactionID{}, msg.TransactionID)
+	assert.True(t, msg.Options.ServerID().Equal(testServerID))
+	assert.True(t, msg.Options.ClientID().Equal(testClientID))
+	assert.Equal(t, []byte{byte(dhcpv6.MessageTypeRenew)}, msg.GetOneOption(dhcpv6.OptionReconfMessage).ToBytes())
+
+	auth := msg.GetOneOption(dhcpv6.OptionAuth).ToBytes()
+	require.Len(t, auth, 3+8+1+md5.Size)
+	assert.Equal(t, []byte{authProtocolReconfigureKey, authAlgorithmHMACMD5, authRDMMonotonicCounter}, auth[:3])
+	assert.Equal(t, []byte{0, 0, 0, 0, 0, 0, 0, 42}, auth[3:11])
+	assert.Equal(t, byte(reconfigureKeyDigest), auth[11])
+
+	// the client verifies the digest over the message with a zeroed digest field
+	digest := append([]byte(nil), auth[12:]...)
+	copy(b[len(b)-md5.Size:], make([]byte, md5.Size))
+	mac := hmac.New(md5.New, key)
+	mac.Write(b)
+	assert.Equal(t, mac.Sum(nil), digest)
+
+	_, err = buildReconfigure(testServerID, testClientID, dhcpv6.MessageTypeSolicit, key, 42)
+	assert.Error(t, err)
+}
+
+func TestReconfigure(t *testing.T) {
+	s := &dhcpServer{
+		name: "srv0",
+		handler: handlers.Chain{testHandler{
+			handle6: func(req, resp handlers.DHCPv6) {
+				dhcpv6.WithServerID(testServerID)(resp.Message)
+			},
+		}},
+		logger:      newTestLogger(zapcore.InfoLevel),
+		reconfigure: &reconfigureClients{clients: make(map[string]*reconfigureClient)},
+	}
+	app := &App{servers: []*dhcpServer{s}}
+	clientID := hex.EncodeToString(testClientID.ToBytes())
+	peer := &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: dhcpv6.DefaultClientPort, Zone: "eth0"}
+
+	request := func(accept bool) *dhcpv6.Message {
+		req, err := dhcpv6.NewMessage(dhcpv6.WithClientID(testClientID))
+		require.NoError(t, err)
+		req.MessageType = dhcpv6.MessageTypeRequest
+		if accept {
+			req.AddOption(&dhcpv6.OptionGeneric{OptionCode: dhcpv6.OptionReconfAccept})
+		}
+		conn := &testConn{}
+		s.handle6(conn, peer, packetInfo{}, req, nil)
+		require.Len(t, conn.packets, 1)
+		resp, err := dhcpv6.MessageFromBytes(conn.packets[0])
+		require.NoError(t, err)
+		return resp
+	}
+
+	// a client that does not accept reconfigure messages does not get a key
+	resp := request(false)
+	assert.Nil(t, resp.GetOneOption(dhcpv6.OptionReconfAccept))
+	assert.Nil(t, resp.GetOneOption(dhcpv6.OptionAuth))
+	assert.Error(t, app.Reconfigure("srv0", clientID, dhcpv6.MessageTypeRenew))
+
+	resp = request(true)
+	assert.NotNil(t, resp.GetOneOption(dhcpv6.OptionReconfAccept))
+	auth := resp.GetOneOption(dhcpv6.OptionAuth).ToBytes()
+	require.Len(t, auth, 3+8+1+reconfigureKeyLen)
+	assert.Equal(t, byte(reconfigureKeyValue), auth[11])
+	key := auth[12:]
+
+	// the key is retained across requests
+	resp = request(true)
+	assert.Equal(t, key, resp.GetOneOption(dhcpv6.OptionAuth).ToBytes()[12:])
+
+	conn := s.reconfigure.clients[clientID].conn.(*testConn)
+	require.NoError(t, app.Reconfigure("srv0", clientID, dhcpv6.MessageTypeRebind))
+	require.Len(t, conn.packets, 2)
+	assert.Equal(t, peer, conn.addrs[1])
+	m, err := dhcpv6.MessageFromBytes(conn.packets[1])
+	require.NoError(t, err)
+	assert.Equal(t, dhcpv6.MessageTypeReconfigure, m.MessageType)
+	assert.Equal(t, []byte{byte(dhcpv6.MessageTypeRebind)}, m.GetOneOption(dhcpv6.OptionReconfMessage).ToBytes())
+
+	assert.Error(t, app.Reconfigure("srv1", clientID, dhcpv6.MessageTypeRenew))
+}
@@ -0,0 +1,267 @@
+//go:build linux
+
+package caddydhcp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// sockoptInt returns the value of a socket option of conn.
+func sockoptInt(t *testing.T, conn net.PacketConn, opt int) int {
+	raw, err := conn.(syscall.Conn).SyscallConn()
+	require.NoError(t, err)
+	var value int
+	var sockErr error
+	require.NoError(t, raw.Control(func(fd uintptr) {
+		value, sockErr = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, opt)
+	}))
+	require.NoError(t, sockErr)
+	return value
+}
+
+// reusePort sets SO_REUSEPORT on a socket, like Caddy does on every listener socket.
+func reusePort(_, _ string, c syscall.RawConn) error {
+	var sockErr error
+	if err := c.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	}); err != nil {
+		return err
+	}
+	return sockErr
+}
+
+func TestControlReuse(t *testing.T) {
+	for _, reuseAddr := range []bool{false, true} {
+		t.Run(fmt.Sprintf("reuseAddr=%v", reuseAddr), func(t *testing.T) {
+			s := &dhcpServer{reuseAddr: reuseAddr}
+			lc := net.ListenConfig{Control: s.control}
+			conn, err := lc.ListenPacket(context.Background(), "udp4", "127.0.0.1:0")
+			require.NoError(t, err)
+			defer conn.Close()
+			assert.Equal(t, reuseAddr, sockoptInt(t, conn, unix.SO_REUSEADDR) != 0)
+
+			// for UDP sockets, the option allows a second socket to listen on the same address
+			other, err := lc.ListenPacket(context.Background(), "udp4", conn.LocalAddr().String())
+			if reuseAddr {
+				require.NoError(t, err)
+				require.NoError(t, other.Close())
+			} else {
+				assert.ErrorIs(t, err, unix.EADDRINUSE)
+			}
+		})
+	}
+}
+
+func TestListenReusePort(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	addr, err := caddy.ParseNetworkAddress("udp4/127.0.0.1:0")
+	require.NoError(t, err)
+	s := &dhcpServer{}
+	ln, err := addr.Listen(ctx, 0, net.ListenConfig{Control: s.control})
+	require.NoError(t, err)
+	defer ln.(net.PacketConn).Close()
+
+	// the read workers of a listener address rely on Caddy setting SO_REUSEPORT
+	conn := ln.(interface{ Unwrap() net.PacketConn }).Unwrap()
+	assert.NotZero(t, sockoptInt(t, conn, unix.SO_REUSEPORT))
+}
+
+func TestControlReuseError(t *testing.T) {
+	s := &dhcpServer{
+		reuseAddr: true,
+		iface:     "eth0",
+		setsockoptInt: func(fd, level, opt, value int) error {
+			return unix.ENOPROTOOPT
+		},
+		bindToDevice: func(fd int, iface string) error {
+			t.Fatal("bound to an interface after failing to set a socket option")
+			return nil
+		},
+	}
+	assert.ErrorIs(t, s.control("udp4", "0.0.0.0:67", testRawConn{fd: 3}), unix.ENOPROTOOPT)
+}
+
+func TestControlHopLimit(t *testing.T) {
+	type sockopt struct{ level, opt, value int }
+	for _, tc := range []struct {
+		network  string
+		expected []sockopt
+	}{
+		{"udp6", []sockopt{{unix.IPPROTO_IPV6, unix.IPV6_UNICAST_HOPS, 8}, {unix.IPPROTO_IPV6, unix.IPV6_MULTICAST_HOPS, 8}}},
+		{"udp4", []sockopt{{unix.IPPROTO_IP, unix.IP_TTL, 16}}},
+	} {
+		t.Run(tc.network, func(t *testing.T) {
+			var set []sockopt
+			s := &dhcpServer{
+				hopLimit: 8,
+				ttl:      16,
+				setsockoptInt: func(fd, level, opt, value int) error {
+					set = append(set, sockopt{level, opt, value})
+					return nil
+				},
+			}
+			require.NoError(t, s.control(tc.network, "", testRawConn{fd: 3}))
+			assert.Equal(t, tc.expected, set)
+		})
+	}
+
+	// without a hop limit or TTL, the defaults of the operating system are kept
+	s := &dhcpServer{setsockoptInt: func(fd, level, opt, value int) error {
+		t.Fatal("set a socket option without a hop limit or TTL")
+		return nil
+	}}
+	require.NoError(t, s.control("udp6", "", testRawConn{fd: 3}))
+
+	// the options are set on a real socket
+	s = &dhcpServer{ttl: 16}
+	lc := net.ListenConfig{Control: s.control}
+	conn, err := lc.ListenPacket(context.Background(), "udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+	raw, err := conn.(syscall.Conn).SyscallConn()
+	require.NoError(t, err)
+	var ttl int
+	var sockErr error
+	require.NoError(t, raw.Control(func(fd uintptr) {
+		ttl, sockErr = unix.GetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_TTL)
+	}))
+	require.NoError(t, sockErr)
+	assert.Equal(t, 16, ttl)
+}
+
+// broadcast sends b to the limited broadcast address on the given port.
+func broadcast(t *testing.T, b []byte, port int) {
+	sender, err := net.ListenUDP("udp4", nil)
+	require.NoError(t, err)
+	defer sender.Close()
+	raw, err := sender.SyscallConn()
+	require.NoError(t, err)
+	var sockErr error
+	require.NoError(t, raw.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_BROADCAST, 1)
+	}))
+	require.NoError(t, sockErr)
+	_, err = sender.WriteTo(b, &net.UDPAddr{IP: net.IPv4bcast, Port: port})
+	if err != nil {
+		t.Skipf("cannot send broadcast packets: %v", err)
+	}
+}
+
+func TestInfoConnUnicastOnly(t *testing.T) {
+	lc := net.ListenConfig{Control: reusePort}
+	first, err := lc.ListenPacket(context.Background(), "udp4", "0.0.0.0:0")
+	require.NoError(t, err)
+	defer first.Close()
+	port := first.LocalAddr().(*net.UDPAddr).Port
+	second, err := lc.ListenPacket(context.Background(), "udp4", first.LocalAddr().String())
+	require.NoError(t, err)
+	defer second.Close()
+	worker, err := newInfoConn(second, "udp4")
+	require.NoError(t, err)
+	worker.unicastOnly = true
+
+	// the kernel delivers a broadcast to both sockets, but only the first one returns it
+	broadcast(t, []byte("broadcast"), port)
+	buf := make([]byte, 16)
+	require.NoError(t, first.SetReadDeadline(time.Now().Add(time.Second)))
+	n, _, err := first.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "broadcast", string(buf[:n]))
+
+	// the worker skips the broadcast and returns the next unicast packet
+	var unicast net.PacketConn = worker
+	sender, err := net.DialUDP("udp4", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	require.NoError(t, err)
+	defer sender.Close()
+	require.NoError(t, first.Close())
+	_, err = sender.Write([]byte("unicast"))
+	require.NoError(t, err)
+	require.NoError(t, unicast.SetReadDeadline(time.Now().Add(time.Second)))
+	n, _, err = unicast.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "unicast", string(buf[:n]))
+}
+
+func TestProvisionReadWorkers(t *testing.T) {
+	app := &App{Servers: map[string]*Server{"srv0": {ReadWorkers: -1}}}
+	assert.Error(t, app.Provision(caddy.Context{}))
+}
+
+// BenchmarkReadWorkers measures how fast DHCPv4 requests are read and parsed from a listener address,
+// when they are spread over one or more sockets by the kernel using SO_REUSEPORT.
+func BenchmarkReadWorkers(b *testing.B) {
+	discover, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(b, err)
+	packet := discover.ToBytes()
+
+	for _, workers := range []int{1, 2, 4} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			s := &dhcpServer{}
+			lc := net.ListenConfig{Control: reusePort}
+			addr := "127.0.0.1:0"
+			var received atomic.Int64
+			done := make(chan struct{})
+			var readers sync.WaitGroup
+			for i := 0; i < workers; i++ {
+				conn, err := lc.ListenPacket(context.Background(), "udp4", addr)
+				require.NoError(b, err)
+				defer conn.Close()
+				addr = conn.LocalAddr().String()
+				readers.Add(1)
+				go func() {
+					defer readers.Done()
+					for {
+						buf, _, _, err := s.read(conn)
+						if err != nil {
+							return
+						}
+						if _, err := dhcpv4.FromBytes(buf); err == nil && received.Add(1) == int64(b.N) {
+							close(done)
+						}
+					}
+				}()
+			}
+
+			// many senders, so the kernel spreads the packets over the sockets by their source port
+			var senders sync.WaitGroup
+			stop := make(chan struct{})
+			for i := 0; i < 16; i++ {
+				sender, err := net.Dial("udp4", addr)
+				require.NoError(b, err)
+				defer sender.Close()
+				senders.Add(1)
+				go func() {
+					defer senders.Done()
+					for {
+						select {
+						case <-stop:
+							return
+						default:
+							_, _ = sender.Write(packet)
+						}
+					}
+				}()
+			}
+
+			b.ResetTimer()
+			<-done
+			b.StopTimer()
+			close(stop)
+			senders.Wait()
+		})
+	}
+}
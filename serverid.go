@@ -0,0 +1,114 @@
+package caddydhcp
+
+import (
+	"net"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"go.uber.org/zap"
+)
+
+// ensureServerID4 adds a Server Identifier option (54) to a DHCPv4 reply that has none, holding the address
+// the reply is sent from: the configured source address, the unicast address the request was received on,
+// the address the listener is bound to, or else the first IPv4 address of the interface the request arrived on.
+func (s *dhcpServer) ensureServerID4(conn net.PacketConn, local packetInfo, resp *dhcpv4.DHCPv4) {
+	if resp.Options.Has(dhcpv4.OptionServerIdentifier) {
+		return
+	}
+	if id := s.serverID4(conn, local); id != nil {
+		resp.UpdateOption(dhcpv4.OptServerIdentifier(id))
+		return
+	}
+	s.logger.Debug("no address found for the server identifier of the reply", zap.Int("interface_index", local.ifIndex))
+}
+
+// serverID4 returns the address to use as the server identifier of a reply, or nil if there is none.
+func (s *dhcpServer) serverID4(conn net.PacketConn, local packetInfo) net.IP {
+	if s.sourceAddr4 != nil {
+		return s.sourceAddr4
+	}
+	if ip := local.dst.To4(); ip != nil && isUnicast4(ip) {
+		return ip
+	}
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		if ip := addr.IP.To4(); ip != nil && isUnicast4(ip) {
+			return ip
+		}
+	}
+	if local.ifIndex == 0 {
+		return nil
+	}
+	iface, err := net.InterfaceByIndex(local.ifIndex)
+	if err != nil {
+		return nil
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			if ip := ipNet.IP.To4(); ip != nil && isUnicast4(ip) {
+				return ip
+			}
+		}
+	}
+	return nil
+}
+
+// isUnicast4 reports whether ip is an IPv4 address that identifies a single host.
+func isUnicast4(ip net.IP) bool {
+	return !ip.IsUnspecified() && !ip.IsMulticast() && !ip.Equal(net.IPv4bcast)
+}
+
+// ensureServerID6 adds a Server Identifier option (2) holding the DUID of the server to a DHCPv6 reply that has none.
+func (s *dhcpServer) ensureServerID6(resp *dhcpv6.Message) {
+	if resp.Options.ServerID() != nil {
+		return
+	}
+	duid := s.duid()
+	if duid == nil {
+		s.logger.Debug("no hardware address found for the server identifier of the reply", zap.String("interface", s.iface))
+		return
+	}
+	resp.AddOption(dhcpv6.OptServerID(duid))
+}
+
+// duid returns the DUID of the server: the configured one, or else a DUID-LL with the hardware address
+// of the interface of the server, which is derived once so it is the same for every reply.
+// It returns nil if no hardware address is found.
+func (s *dhcpServer) duid() dhcpv6.DUID {
+	s.serverDUIDLock.Lock()
+	defer s.serverDUIDLock.Unlock()
+	if s.serverDUID == nil {
+		hardwareAddr := s.hardwareAddr
+		if hardwareAddr == nil {
+			hardwareAddr = interfaceHardwareAddr
+		}
+		if mac := hardwareAddr(s.iface); mac != nil {
+			s.serverDUID = &dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: mac}
+		}
+	}
+	return s.serverDUID
+}
+
+// interfaceHardwareAddr returns the hardware address of the interface with the given name, or else
+// of the first interface that has one. It returns nil if no interface has one.
+func interfaceHardwareAddr(name string) net.HardwareAddr {
+	if name != "" {
+		if iface, err := net.InterfaceByName(name); err == nil && len(iface.HardwareAddr) > 0 {
+			return iface.HardwareAddr
+		}
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	for _, iface := range ifaces {
+		if len(iface.HardwareAddr) == 6 {
+			return iface.HardwareAddr
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package caddydhcp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestEnsureServerID4(t *testing.T) {
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	lo, err := net.InterfaceByName("lo")
+	require.NoError(t, err)
+
+	for name, tc := range map[string]struct {
+		source   net.IP
+		local    packetInfo
+		conn     *testConn
+		handler  func(req, resp handlers.DHCPv4)
+		expected net.IP
+	}{
+		"source address": {
+			source:   net.IPv4(192, 0, 2, 1).To4(),
+			local:    packetInfo{dst: net.IPv4(192, 0, 2, 2).To4()},
+			conn:     &testConn{},
+			expected: net.IPv4(192, 0, 2, 1).To4(),
+		},
+		"unicast destination": {
+			local:    packetInfo{dst: net.IPv4(192, 0, 2, 2).To4()},
+			conn:     &testConn{},
+			expected: net.IPv4(192, 0, 2, 2).To4(),
+		},
+		"listener address": {
+			local:    packetInfo{dst: net.IPv4bcast},
+			conn:     &testConn{local: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 3), Port: dhcpv4.ServerPort}},
+			expected: net.IPv4(192, 0, 2, 3).To4(),
+		},
+		"interface address": {
+			local:    packetInfo{dst: net.IPv4bcast, ifIndex: lo.Index},
+			conn:     &testConn{local: &net.UDPAddr{IP: net.IPv4zero, Port: dhcpv4.ServerPort}},
+			expected: net.IPv4(127, 0, 0, 1).To4(),
+		},
+		"set by a handler": {
+			local: packetInfo{dst: net.IPv4(192, 0, 2, 2).To4()},
+			conn:  &testConn{},
+			handler: func(_, resp handlers.DHCPv4) {
+				resp.UpdateOption(dhcpv4.OptServerIdentifier(net.IPv4(192, 0, 2, 4)))
+			},
+			expected: net.IPv4(192, 0, 2, 4).To4(),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			handle4 := tc.handler
+			if handle4 == nil {
+				handle4 = func(_, _ handlers.DHCPv4) {}
+			}
+			s := &dhcpServer{
+				handler:        handlers.Chain{testHandler{handle4: handle4}},
+				logger:         zap.NewNop(),
+				sourceAddr4:    tc.source,
+				ensureServerID: true,
+			}
+			req, err := dhcpv4.NewDiscovery(mac)
+			require.NoError(t, err)
+			s.handle4(tc.conn, &net.UDPAddr{IP: net.IPv4zero, Port: dhcpv4.ClientPort}, tc.local, req)
+			require.Len(t, tc.conn.packets, 1)
+			offer, err := dhcpv4.FromBytes(tc.conn.packets[0])
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, offer.ServerIdentifier().To4())
+		})
+	}
+
+	// without ensureServerID, a reply without serverid handler has no server identifier
+	s := &dhcpServer{handler: handlers.Chain{}, logger: zap.NewNop()}
+	req, err := dhcpv4.NewDiscovery(mac)
+	require.NoError(t, err)
+	conn := &testConn{}
+	s.handle4(conn, &net.UDPAddr{IP: net.IPv4zero, Port: dhcpv4.ClientPort}, packetInfo{dst: net.IPv4(192, 0, 2, 2).To4()}, req)
+	require.Len(t, conn.packets, 1)
+	offer, err := dhcpv4.FromBytes(conn.packets[0])
+	require.NoError(t, err)
+	assert.Nil(t, offer.ServerIdentifier())
+}
+
+func TestEnsureServerID6(t *testing.T) {
+	serverMAC := net.HardwareAddr{0x02, 0, 0, 0, 0, 0xaa}
+	// reply returns the server identifier of the reply to a request received on the interface with the given index
+	reply := func(s *dhcpServer, ifIndex int) dhcpv6.DUID {
+		req, err := dhcpv6.NewMessage(dhcpv6.WithIAID([4]byte{0, 0, 0, 1}))
+		require.NoError(t, err)
+		req.MessageType = dhcpv6.MessageTypeRequest
+		req.AddOption(dhcpv6.OptClientID(&dhcpv6.DUIDLL{HWType: 1, LinkLayerAddr: net.HardwareAddr{0x02, 0, 0, 0, 0, 1}}))
+		conn := &testConn{}
+		s.handle6(conn, &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: dhcpv6.DefaultClientPort}, packetInfo{ifIndex: ifIndex}, req, nil)
+		require.Len(t, conn.packets, 1)
+		resp, err := dhcpv6.MessageFromBytes(conn.packets[0])
+		require.NoError(t, err)
+		return resp.Options.ServerID()
+	}
+
+	t.Run("derived", func(t *testing.T) {
+		var lookedUp []string
+		s := &dhcpServer{
+			handler:        handlers.Chain{},
+			logger:         zap.NewNop(),
+			iface:          "eth0",
+			ensureServerID: true,
+			hardwareAddr: func(name string) net.HardwareAddr {
+				lookedUp = append(lookedUp, name)
+				return serverMAC
+			},
+		}
+		// the server presents the same DUID on every link, derived once from its own interface
+		want := &dhcpv6.DUIDLL{HWType: 1, LinkLayerAddr: serverMAC}
+		assert.Equal(t, want, reply(s, 7))
+		assert.Equal(t, want, reply(s, 8))
+		assert.Equal(t, []string{"eth0"}, lookedUp)
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		duid := &dhcpv6.DUIDUUID{UUID: [16]byte{1, 2, 3}}
+		s := &dhcpServer{
+			handler:        handlers.Chain{},
+			logger:         zap.NewNop(),
+			ensureServerID: true,
+			serverDUID:     duid,
+			hardwareAddr: func(string) net.HardwareAddr {
+				t.Error("the configured DUID is used")
+				return nil
+			},
+		}
+		assert.Equal(t, duid, reply(s, 7))
+	})
+}
@@ -0,0 +1,51 @@
+package caddydhcp
+
+import (
+	"net"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"go.uber.org/zap"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// sourceServerID is the value of Server.SourceAddress that sends every DHCPv4 reply
+// from the address in its Server Identifier option (54).
+const sourceServerID = "serverid"
+
+// writeFromSource writes b to dst on conn, using src as the source address of the packet.
+// The source address is passed to the kernel in an IP_PKTINFO or IPV6_PKTINFO control message,
+// so it must be assigned to the host, but not necessarily to the address conn is bound to.
+// conn must be the socket itself, not a connection wrapping it.
+func writeFromSource(conn net.PacketConn, b []byte, dst net.Addr, src net.IP) (int, error) {
+	if src4 := src.To4(); src4 != nil {
+		return ipv4.NewPacketConn(conn).WriteTo(b, &ipv4.ControlMessage{Src: src4}, dst)
+	}
+	return ipv6.NewPacketConn(conn).WriteTo(b, &ipv6.ControlMessage{Src: src}, dst)
+}
+
+// write writes a reply to dst on conn, from the source address src if it is not nil.
+func (s *dhcpServer) write(conn net.PacketConn, b []byte, dst net.Addr, src net.IP) (int, error) {
+	if src == nil || s.writeFrom == nil {
+		return conn.WriteTo(b, dst)
+	}
+	if ic, ok := conn.(*infoConn); ok {
+		conn = ic.sock
+	}
+	return s.writeFrom(conn, b, dst, src)
+}
+
+// source4 returns the source address of a DHCPv4 reply, or nil to leave it to the kernel.
+func (s *dhcpServer) source4(resp *dhcpv4.DHCPv4) net.IP {
+	serverID := resp.ServerIdentifier()
+	if s.sourceFromServerID {
+		return serverID
+	}
+	if s.sourceAddr4 != nil && serverID != nil && !serverID.Equal(s.sourceAddr4) {
+		s.logger.Warn("server identifier of reply does not match the source address",
+			zap.Stringer("server_id", serverID),
+			zap.Stringer("source", s.sourceAddr4),
+		)
+	}
+	return s.sourceAddr4
+}
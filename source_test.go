@@ -0,0 +1,159 @@
+package caddydhcp
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv6"
+	"github.com/insomniacslk/dhcp/iana"
+	"github.com/lion7/caddydhcp/handlers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestSourceAddress(t *testing.T) {
+	serverID := testHandler{
+		handle4: func(req, resp handlers.DHCPv4) {
+			resp.UpdateOption(dhcpv4.OptServerIdentifier(net.IPv4(10, 0, 0, 2)))
+		},
+	}
+	mac := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	peer4 := &net.UDPAddr{IP: net.IPv4bcast, Port: dhcpv4.ClientPort}
+	peer6 := &net.UDPAddr{IP: net.ParseIP("fe80::1"), Port: dhcpv6.DefaultClientPort}
+
+	for _, tc := range []struct {
+		name               string
+		handler            handlers.Handler
+		sourceAddr4        net.IP
+		sourceAddr6        net.IP
+		sourceFromServerID bool
+		source4, source6   net.IP
+		warned             bool
+	}{
+		{"default", serverID, nil, nil, false, nil, nil, false},
+		{"configured", testHandler{}, net.IPv4(10, 0, 0, 3), net.ParseIP("2001:db8::3"), false, net.IPv4(10, 0, 0, 3), net.ParseIP("2001:db8::3"), false},
+		{"configured mismatching server identifier", serverID, net.IPv4(10, 0, 0, 3), nil, false, net.IPv4(10, 0, 0, 3), nil, true},
+		{"server identifier", serverID, nil, nil, true, net.IPv4(10, 0, 0, 2), nil, false},
+		{"server identifier absent", testHandler{}, nil, nil, true, nil, nil, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := observer.New(zapcore.WarnLevel)
+			var sources []net.IP
+			s := &dhcpServer{
+				handler:            handlers.Chain{tc.handler},
+				logger:             zap.New(core),
+				sourceAddr4:        tc.sourceAddr4,
+				sourceAddr6:        tc.sourceAddr6,
+				sourceFromServerID: tc.sourceFromServerID,
+				writeFrom: func(conn net.PacketConn, b []byte, dst net.Addr, src net.IP) (int, error) {
+					sources = append(sources, src)
+					return conn.WriteTo(b, dst)
+				},
+			}
+
+			req4, err := dhcpv4.NewDiscovery(mac)
+			require.NoError(t, err)
+			conn := &testConn{}
+			s.handle4(conn, peer4, packetInfo{}, req4)
+			require.Len(t, conn.packets, 1)
+
+			req6, err := dhcpv6.NewMessage(dhcpv6.WithClientID(&dhcpv6.DUIDLL{HWType: iana.HWTypeEthernet, LinkLayerAddr: mac}))
+			require.NoError(t, err)
+			req6.MessageType = dhcpv6.MessageTypeRequest
+			s.handle6(conn, peer6, packetInfo{}, req6, nil)
+			require.Len(t, conn.packets, 2)
+
+			var expected []net.IP
+			for _, ip := range []net.IP{tc.source4, tc.source6} {
+				if ip != nil {
+					expected = append(expected, ip)
+				}
+			}
+			assert.Equal(t, len(expected), len(sources))
+			for i := range expected {
+				assert.True(t, expected[i].Equal(sources[i]), "expected %v, got %v", expected[i], sources[i])
+			}
+			assert.Equal(t, tc.warned, logs.FilterMessage("server identifier of reply does not match the source address").Len() > 0)
+		})
+	}
+}
+
+func TestWriteFromSource(t *testing.T) {
+	// the loopback interface has all of 127.0.0.0/8 assigned, so any of those can be used as source
+	receiver, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer receiver.Close()
+	sender, err := net.ListenUDP("udp4", &net.UDPAddr{})
+	require.NoError(t, err)
+	defer sender.Close()
+
+	for _, src := range []net.IP{net.IPv4(127, 0, 0, 2), net.IPv4(127, 0, 0, 3)} {
+		_, err = writeFromSource(sender, []byte("reply"), receiver.LocalAddr(), src)
+		require.NoError(t, err)
+
+		require.NoError(t, receiver.SetReadDeadline(time.Now().Add(time.Second)))
+		b := make([]byte, 16)
+		n, from, err := receiver.ReadFromUDP(b)
+		require.NoError(t, err)
+		assert.Equal(t, "reply", string(b[:n]))
+		assert.True(t, src.Equal(from.IP), "expected %v, got %v", src, from.IP)
+	}
+}
+
+func TestWriteFromSourceListener(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	addr, err := caddy.ParseNetworkAddress("udp4/127.0.0.1:0")
+	require.NoError(t, err)
+	var local net.Addr
+	s := &dhcpServer{
+		ctx:         ctx,
+		addresses:   []caddy.NetworkAddress{addr},
+		handler:     handlers.Chain{testHandler{}},
+		logger:      zap.NewNop(),
+		sourceAddr4: net.IPv4(127, 0, 0, 2),
+		writeFrom:   writeFromSource,
+		// listen through the listener pool of Caddy, which wraps the socket
+		listen: func(addr caddy.NetworkAddress) (net.PacketConn, error) {
+			ln, err := addr.Listen(ctx, 0, net.ListenConfig{})
+			if err != nil {
+				return nil, err
+			}
+			conn := ln.(net.PacketConn)
+			local = conn.LocalAddr()
+			return conn, nil
+		},
+	}
+	app := &App{servers: []*dhcpServer{s}}
+	require.NoError(t, app.Start())
+	defer app.Stop()
+
+	client, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer client.Close()
+	req, err := dhcpv4.NewDiscovery(net.HardwareAddr{0x02, 0, 0, 0, 0, 1})
+	require.NoError(t, err)
+	_, err = client.WriteTo(req.ToBytes(), local)
+	require.NoError(t, err)
+
+	require.NoError(t, client.SetReadDeadline(time.Now().Add(time.Second)))
+	b := make([]byte, 1500)
+	n, from, err := client.ReadFromUDP(b)
+	require.NoError(t, err)
+	resp, err := dhcpv4.FromBytes(b[:n])
+	require.NoError(t, err)
+	assert.Equal(t, req.TransactionID, resp.TransactionID)
+	assert.True(t, net.IPv4(127, 0, 0, 2).Equal(from.IP), "expected 127.0.0.2, got %v", from.IP)
+}
+
+func TestProvisionSourceAddress(t *testing.T) {
+	app := &App{Servers: map[string]*Server{"test": {SourceAddress: "invalid"}}}
+	assert.ErrorContains(t, app.Provision(caddy.Context{}), "invalid source address")
+}